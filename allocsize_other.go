@@ -0,0 +1,10 @@
+//go:build !unix && !windows
+
+package main
+
+import "os"
+
+// allocatedSize is not implemented on this platform
+func allocatedSize(fn string, fi os.FileInfo) (int64, bool) {
+	return 0, false
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// writeSparse writes a file of size bytes with a few bytes of data at the start
+func writeSparse(t *testing.T, dir, name string, size int64) string {
+	t.Helper()
+	fn := filepath.Join(dir, name)
+	f, err := os.Create(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString("data"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	return fn
+}
+
+func TestAllocatedSize(t *testing.T) {
+	dir := t.TempDir()
+	const size = 64 << 20
+	sparse := writeSparse(t, dir, "sparse.raw", size)
+	fi, err := os.Stat(sparse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, ok := allocatedSize(sparse, fi)
+	if !ok {
+		t.Skip("allocated size is not implemented on", runtime.GOOS)
+	}
+	if n >= size {
+		t.Skipf("the filesystem of %s doesn't support sparse files", dir)
+	}
+
+	dense := writeTestFile(t, dir, "dense.raw", []byte(strings.Repeat("x", 100000)))
+	fi, err = os.Stat(dense)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without compression, whole blocks are allocated
+	if n, _ := allocatedSize(dense, fi); n < fi.Size() {
+		t.Logf("the filesystem of %s compresses files: %d bytes allocated for %d", dir, n, fi.Size())
+	}
+
+	stdout, stderr, code := runMsfile(t, dir, nil, "-format", "ndjson", "sparse.raw")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	var inf FileInfo
+	if err := json.Unmarshal([]byte(stdout), &inf); err != nil {
+		t.Fatal(err)
+	}
+	if inf.Size != size || inf.AllocatedSize != n {
+		t.Errorf("size %d, allocated %d, want %d, %d", inf.Size, inf.AllocatedSize, int64(size), n)
+	}
+
+	// The root summary has both sizes
+	_, stderr, code = runMsfile(t, dir, nil, "-root", "data="+dir)
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	fiDense, _ := os.Stat(dense)
+	nDense, _ := allocatedSize(dense, fiDense)
+	want := fmt.Sprintf("Root data (%s): 2 files, %d bytes (%d allocated), 0 errors", dir, size+fiDense.Size(), n+nDense)
+	if !strings.Contains(stderr, want) {
+		t.Errorf("summary %q, want %q", stderr, want)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var procGetCompressedFileSizeW = kernel32.NewProc("GetCompressedFileSizeW")
+
+// allocatedSize returns the disk space used by a file, which is less than the
+// size for sparse or NTFS compressed files
+func allocatedSize(fn string, fi os.FileInfo) (int64, bool) {
+	p, err := syscall.UTF16PtrFromString(fn)
+	if err != nil {
+		return 0, false
+	}
+	var high uint32
+	low, _, err := procGetCompressedFileSizeW.Call(uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&high)))
+	// INVALID_FILE_SIZE is also a valid low word, so the error decides
+	if uint32(low) == 0xFFFFFFFF && err != syscall.Errno(0) {
+		return 0, false
+	}
+	return int64(high)<<32 | int64(uint32(low)), true
+}
@@ -0,0 +1,305 @@
+package main
+
+// audit.go - Audit log of every file msfile opens and every byte range it reads
+// The ranges are recorded where the file is read, see fcompare.FileObserver.
+// The audit log is written as JSON lines, one record per line, and is opened in
+// append mode so that multiple runs can share a log. Every record is written as
+// soon as it is known, so the log is complete up to the point where a run aborts.
+// The log never contains file content, only names, sizes, and byte ranges.
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/524D/msfile/fcompare"
+	"github.com/524D/msfile/msfileio"
+)
+
+// Record types in the audit log
+const (
+	auditTypeRun   = "run"   // header, written once at the start of each run
+	auditTypeProbe = "probe" // temporary file created to test if atime can be preserved
+	auditTypeFile  = "file"  // a processed file
+	auditTypeEnd   = "end"   // trailer, written when a run completes normally
+)
+
+type auditRecord = msfileio.AuditRecord
+
+type auditLog struct {
+	f *os.File
+
+	// What was done to each file since its last record, as reported at the read site
+	mu      sync.Mutex
+	ranges  map[string][]fcompare.Range
+	chtimes map[string]bool
+	xattr   map[string]bool
+}
+
+// The audit log of the current run, nil if no audit log is requested
+var audit *auditLog
+
+// The context for the functions of fcompare and msinfo that read files.
+// With an audit log, it reports what they read to the log.
+var readCtx = context.Background()
+
+// openAuditLog opens the audit log for appending and writes the run header
+func openAuditLog(fn string) (*auditLog, error) {
+	f, err := os.OpenFile(fn, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	a := &auditLog{f: f, ranges: make(map[string][]fcompare.Range),
+		chtimes: make(map[string]bool), xattr: make(map[string]bool)}
+	hdr := auditRecord{Type: auditTypeRun, RunID: runID, Method: par.method, ReadOnly: readOnlyRun(), Outcome: "started"}
+	if !par.noRecordCmdline {
+		hdr.Args = recordedArgs
+		hdr.Env = envOptions
+	}
+	err = a.write(hdr)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	readCtx = fcompare.ContextWithObserver(context.Background(), &fcompare.FileObserver{Read: a.readRange, Chtimes: a.setTimes})
+	return a, nil
+}
+
+// readOnlyRun reports whether the run doesn't write to the files it processes,
+// or their directories: no file times are restored, no atime probes are created,
+// and the checksum cache is not stored in extended attributes
+func readOnlyRun() bool {
+	return par.fast && (!par.checksums || !useCache() || checksumCache != nil)
+}
+
+// readRange is called for every region that is read from a file
+func (a *auditLog) readRange(fn string, r fcompare.Range) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.ranges[fn] = append(a.ranges[fn], r)
+}
+
+// setTimes is called when the file times of a file are set
+func (a *auditLog) setTimes(fn string) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.chtimes[fn] = true
+}
+
+// setXattr is called when the checksum cache is written to an extended attribute of a file
+func (a *auditLog) setXattr(fn string) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.xattr[fn] = true
+}
+
+// take fills in what was done to the file of rec since its last record
+func (a *auditLog) take(rec *auditRecord) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	fn := rec.Filename
+	rec.Ranges = mergeRanges(a.ranges[fn])
+	if len(rec.Ranges) > 0 {
+		rec.OpenMode = "read-only"
+	}
+	rec.Chtimes = a.chtimes[fn]
+	rec.Xattr = a.xattr[fn]
+	delete(a.ranges, fn)
+	delete(a.chtimes, fn)
+	delete(a.xattr, fn)
+}
+
+// mergeRanges sorts ranges, and merges those that overlap or touch
+func mergeRanges(ranges []fcompare.Range) []fcompare.Range {
+	if len(ranges) == 0 {
+		return nil
+	}
+	sorted := slices.Clone(ranges)
+	slices.SortFunc(sorted, func(a, b fcompare.Range) int { return cmp.Compare(a.Start, b.Start) })
+	merged := sorted[:1]
+	for _, r := range sorted[1:] {
+		last := &merged[len(merged)-1]
+		if r.Start <= last.Start+last.Length {
+			last.Length = max(last.Length, r.Start+r.Length-last.Start)
+		} else {
+			merged = append(merged, r)
+		}
+	}
+	return merged
+}
+
+// write appends a single record to the audit log.
+// Records are written unbuffered and synced, so they survive an abort of the run.
+func (a *auditLog) write(rec auditRecord) error {
+	if a == nil {
+		return nil
+	}
+	if !par.reproducible {
+		rec.Time = time.Now().Unix()
+	}
+	j, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := a.f.Write(append(j, '\n')); err != nil {
+		return err
+	}
+	return a.f.Sync()
+}
+
+// probe records the temporary file that is created to test if atime can be preserved
+func (a *auditLog) probe(dir string, err error) {
+	rec := auditRecord{Type: auditTypeProbe, Dir: dir, TempFile: true, Outcome: "ok"}
+	if err != nil {
+		rec.Outcome = "error"
+		rec.Error = err.Error()
+		rec.ErrorCode = errorCode(err)
+	}
+	a.mustWrite(rec)
+}
+
+// file records the processing of a single file
+func (a *auditLog) file(inf FileInfo, err error) {
+	if a == nil {
+		return
+	}
+	rec := auditRecord{Type: auditTypeFile, RecordID: inf.RecordID, Filename: inf.Filename, Size: inf.Size, Outcome: "ok"}
+	a.take(&rec)
+	if err != nil {
+		rec.Outcome = "error"
+		rec.Error = err.Error()
+		rec.ErrorCode = errorCode(err)
+	} else if inf.Error != nil {
+		// The file was read, but failed verification
+		rec.Error = inf.Error.Message
+		rec.ErrorCode = inf.Error.Code
+	}
+	a.mustWrite(rec)
+}
+
+// read records a file that was read outside of processFile
+func (a *auditLog) read(fn string, size int64, err error) {
+	if a == nil {
+		return
+	}
+	rec := auditRecord{Type: auditTypeFile, Filename: fn, Size: size, Outcome: "ok"}
+	a.take(&rec)
+	if err != nil {
+		rec.Outcome = "error"
+		rec.Error = err.Error()
+		rec.ErrorCode = errorCode(err)
+	}
+	a.mustWrite(rec)
+}
+
+// close writes the run trailer and closes the audit log
+func (a *auditLog) close() {
+	if a == nil {
+		return
+	}
+	a.mustWrite(auditRecord{Type: auditTypeEnd, Outcome: "completed"})
+	a.f.Close()
+}
+
+// mustWrite writes a record, and aborts the run if that fails,
+// because continuing without an audit trail would defeat its purpose
+func (a *auditLog) mustWrite(rec auditRecord) {
+	if err := a.write(rec); err != nil {
+		fmt.Fprintln(os.Stderr, codedError(ErrCodeAuditLog, err))
+		os.Exit(1)
+	}
+}
+
+// verifyAuditLog checks the internal consistency of an audit log,
+// and returns a list of problems found
+func verifyAuditLog(fn string) ([]string, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var problems []string
+	report := func(line int, format string, a ...any) {
+		problems = append(problems, fmt.Sprintf("line %d: ", line)+fmt.Sprintf(format, a...))
+	}
+
+	inRun, readOnly := false, false
+	line := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line++
+		var rec auditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			report(line, "invalid record: %v", err)
+			continue
+		}
+		switch rec.Type {
+		case auditTypeRun:
+			if inRun {
+				report(line, "previous run did not complete")
+			}
+			inRun, readOnly = true, rec.ReadOnly
+			continue
+		case auditTypeProbe, auditTypeFile, auditTypeEnd:
+			if !inRun {
+				report(line, "%s record without run header", rec.Type)
+			}
+		default:
+			report(line, "unknown record type %q", rec.Type)
+			continue
+		}
+		if rec.Type == auditTypeEnd {
+			inRun = false
+			continue
+		}
+		for _, r := range rec.Ranges {
+			if r.Start < 0 || r.Length < 0 || r.Start+r.Length > rec.Size {
+				report(line, "range %d+%d outside file size %d of %s", r.Start, r.Length, rec.Size, rec.Filename)
+			}
+		}
+		if len(rec.Ranges) > 0 && rec.OpenMode == "" {
+			report(line, "ranges read from %s without open mode", rec.Filename)
+		}
+		// A read-only run may not write to the files or their directories
+		if readOnly {
+			name := rec.Filename
+			if name == "" {
+				name = rec.Dir
+			}
+			switch {
+			case rec.Chtimes:
+				report(line, "file times of %s set in a read-only run", name)
+			case rec.Xattr:
+				report(line, "extended attribute of %s written in a read-only run", name)
+			case rec.TempFile:
+				report(line, "temporary file created in %s in a read-only run", name)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return problems, err
+	}
+	if inRun {
+		report(line, "last run did not complete")
+	}
+	return problems, nil
+}
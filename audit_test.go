@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/524D/msfile/fcompare"
+)
+
+func TestMergeRanges(t *testing.T) {
+	got := mergeRanges([]fcompare.Range{{Start: 100, Length: 10}, {Start: 0, Length: 50}, {Start: 50, Length: 10}, {Start: 105, Length: 2}, {Start: 200, Length: 1}})
+	want := []fcompare.Range{{Start: 0, Length: 60}, {Start: 100, Length: 10}, {Start: 200, Length: 1}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeRanges = %v, want %v", got, want)
+	}
+	if mergeRanges(nil) != nil {
+		t.Error("mergeRanges(nil) is not nil")
+	}
+}
+
+// The ranges of a record are those reported at the read site, and are
+// cleared for the next record of the same file
+func TestAuditRecordsReads(t *testing.T) {
+	a := &auditLog{ranges: make(map[string][]fcompare.Range), chtimes: make(map[string]bool), xattr: make(map[string]bool)}
+	a.readRange("f", fcompare.Range{Start: 4096, Length: 4096})
+	a.readRange("f", fcompare.Range{Start: 0, Length: 4096})
+	a.setTimes("f")
+	a.readRange("g", fcompare.Range{Start: 0, Length: 1})
+	rec := auditRecord{Filename: "f"}
+	a.take(&rec)
+	if !reflect.DeepEqual(rec.Ranges, []fcompare.Range{{Start: 0, Length: 8192}}) || rec.OpenMode != "read-only" || !rec.Chtimes || rec.Xattr {
+		t.Errorf("record %+v", rec)
+	}
+	rec = auditRecord{Filename: "f"}
+	a.take(&rec)
+	if rec.Ranges != nil || rec.OpenMode != "" || rec.Chtimes {
+		t.Errorf("second record of the same file %+v", rec)
+	}
+	// Without an audit log, nothing is recorded
+	var none *auditLog
+	none.readRange("f", fcompare.Range{Start: 0, Length: 1})
+	none.setTimes("f")
+	none.setXattr("f")
+}
+
+func writeAuditLog(t *testing.T, lines ...string) string {
+	t.Helper()
+	fn := filepath.Join(t.TempDir(), "audit.log")
+	if err := os.WriteFile(fn, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return fn
+}
+
+func TestVerifyAuditLogReadOnly(t *testing.T) {
+	tests := []struct {
+		name string
+		rec  string
+		want string
+	}{
+		{"read", `{"type":"file","filename":"a","size":10,"open_mode":"read-only","ranges":[{"start":0,"length":10}],"outcome":"ok"}`, ""},
+		{"chtimes", `{"type":"file","filename":"a","size":10,"chtimes":true,"outcome":"ok"}`, "file times of a set in a read-only run"},
+		{"xattr", `{"type":"file","filename":"a","size":10,"xattr":true,"outcome":"ok"}`, "extended attribute of a written"},
+		{"probe", `{"type":"probe","dir":"d","temp_file":true,"outcome":"ok"}`, "temporary file created in d"},
+	}
+	for _, tt := range tests {
+		fn := writeAuditLog(t, `{"type":"run","read_only":true,"outcome":"started"}`, tt.rec, `{"type":"end","outcome":"completed"}`)
+		problems, err := verifyAuditLog(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.want == "" {
+			if len(problems) != 0 {
+				t.Errorf("%s: problems %v", tt.name, problems)
+			}
+		} else if len(problems) != 1 || !strings.Contains(problems[0], tt.want) {
+			t.Errorf("%s: problems %v, want %q", tt.name, problems, tt.want)
+		}
+		// The same records are fine in a run that may write
+		fn = writeAuditLog(t, `{"type":"run","outcome":"started"}`, tt.rec, `{"type":"end","outcome":"completed"}`)
+		if problems, _ := verifyAuditLog(fn); len(problems) != 0 {
+			t.Errorf("%s: problems %v in a run that may write", tt.name, problems)
+		}
+	}
+}
+
+// Logs are appended to, so a log can start with records of older versions
+func TestVerifyAuditLogV0(t *testing.T) {
+	fn := writeAuditLog(t,
+		`{"Type":"run","Method":"partial","Outcome":"started"}`,
+		`{"Type":"file","Filename":"a","Size":10,"OpenMode":"read-only","Ranges":[{"Start":0,"Length":20}],"Outcome":"ok"}`,
+		`{"Type":"end","Outcome":"completed"}`,
+		`{"type":"run","outcome":"started"}`,
+		`{"type":"end","outcome":"completed"}`)
+	problems, err := verifyAuditLog(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 || !strings.Contains(problems[0], "range 0+20 outside file size 10") {
+		t.Errorf("problems %v", problems)
+	}
+}
+
+func TestAuditRecordJSON(t *testing.T) {
+	rec := auditRecord{Type: auditTypeFile, RecordID: 3, Filename: "a", OpenMode: "read-only",
+		Ranges: []fcompare.Range{{Start: 1, Length: 2}}, ErrorCode: "E_X", Outcome: "ok"}
+	j, err := json.Marshal(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"type":"file","record_id":3,"filename":"a","open_mode":"read-only","ranges":[{"start":1,"length":2}],"outcome":"ok","error_code":"E_X"}`
+	if string(j) != want {
+		t.Errorf("got %s\nwant %s", j, want)
+	}
+	var back auditRecord
+	if err := json.Unmarshal(j, &back); err != nil || !reflect.DeepEqual(back, rec) {
+		t.Errorf("round trip %+v, %v", back, err)
+	}
+}
+
+// -correlate finds the file records of audit logs of this and older versions
+func TestCorrelateAuditLog(t *testing.T) {
+	fn := writeAuditLog(t,
+		`{"Type":"run","RunID":"old","Outcome":"started"}`,
+		`{"Type":"file","RecordID":1,"Filename":"a","Outcome":"ok"}`,
+		`{"Type":"end","Outcome":"completed"}`,
+		`{"type":"run","run_id":"new","outcome":"started"}`,
+		`{"type":"file","record_id":1,"filename":"a","outcome":"ok"}`,
+		`{"type":"end","outcome":"completed"}`)
+	runs, err := correlate(filepath.Dir(fn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 {
+		t.Fatalf("%d runs, want 2", len(runs))
+	}
+	for i, id := range []string{"new", "old"} {
+		if runs[i].RunID != id || len(runs[i].Files) != 1 || runs[i].Files[0].RecordID != 1 {
+			t.Errorf("run %d: %+v", i, runs[i])
+		}
+	}
+}
+
+// A byte comparison with -fast, without a checksum cache, is a read-only run,
+// which sets no file times
+func TestFastCompareBytesReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "a", []byte("same content"))
+	writeTestFile(t, dir, "b", []byte("same content"))
+	old := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, name := range []string{"a", "b"} {
+		if err := os.Chtimes(filepath.Join(dir, name), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, stderr, code := runMsfile(t, dir, nil, "-fast", "-no-cache", "-compare", "-comparemethod", "bytes", "-audit-log", "audit.log", "a", "b")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	log := readTestFile(t, filepath.Join(dir, "audit.log"))
+	if !strings.Contains(log, `"read_only":true`) || strings.Contains(log, `"chtimes":true`) {
+		t.Errorf("file times set in a read-only run:\n%s", log)
+	}
+	stdout, stderr, code := runMsfile(t, dir, nil, "-audit-verify", "audit.log")
+	if code != 0 {
+		t.Errorf("-audit-verify: exit code %d: %s%s", code, stdout, stderr)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// byteSize is a flag.Value for a number of bytes, with an optional
+// K, M or G suffix (powers of 1024), e.g. "256K" or "4M"
+type byteSize int64
+
+var byteSizeSuffixes = []struct {
+	suffix string
+	factor int64
+}{
+	{"K", 1 << 10},
+	{"M", 1 << 20},
+	{"G", 1 << 30},
+}
+
+func (b *byteSize) String() string {
+	if b == nil {
+		return "0"
+	}
+	for i := len(byteSizeSuffixes) - 1; i >= 0; i-- {
+		f := byteSizeSuffixes[i].factor
+		if *b != 0 && int64(*b)%f == 0 {
+			return strconv.FormatInt(int64(*b)/f, 10) + byteSizeSuffixes[i].suffix
+		}
+	}
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(value string) error {
+	factor := int64(1)
+	upper := strings.ToUpper(value)
+	for _, s := range byteSizeSuffixes {
+		if strings.HasSuffix(upper, s.suffix) {
+			factor = s.factor
+			upper = strings.TrimSuffix(upper, s.suffix)
+			break
+		}
+	}
+	n, err := strconv.ParseInt(upper, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q", value)
+	}
+	*b = byteSize(n * factor)
+	return nil
+}
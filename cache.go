@@ -0,0 +1,178 @@
+package main
+
+// cache.go - Cache of checksums, in an extended attribute of each file or in a cache file
+// Re-running msfile over an unchanged archive would read every file again. So the
+// checksums that are computed are stored with the size and mtime of the file at
+// that time, and a later run reuses them if the file still has the same size and
+// mtime. By default, the cache of a file is its extended attribute
+// user.msfile.<hash> (e.g. user.msfile.sha256). Setting the attribute doesn't
+// change the mtime or atime of the file. With -cache FILE, all entries are kept in
+// FILE instead (see fcompare.Cache), which also works for read-only archives.
+//
+// An entry for a file whose size or mtime changed is stale. Stale entries are
+// kept, and reported, unless -refresh-cache is set: a file in an archive that
+// changes after its checksum was cached deserves attention. Where extended
+// attributes can't be written (read-only or immutable files, filesystems without
+// them, other platforms than Linux), nothing is cached without -cache.
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/524D/msfile/fcompare"
+	"github.com/524D/msfile/msinfo"
+)
+
+// Prefix of the name of the extended attribute, followed by the hash algorithm
+const cacheXattrPrefix = "user.msfile."
+
+// Entries of the extended attribute don't have the Path and Algo fields
+type cacheEntry = fcompare.CacheEntry
+
+// The -cache file, nil without -cache
+var checksumCache *fcompare.Cache
+
+// Number of files whose checksums were taken from the cache
+var cachedChecksums int
+
+func cacheXattr() string {
+	return cacheXattrPrefix + hashAlgo.String()
+}
+
+// useCache reports whether the cache is read and written in this run
+func useCache() bool {
+	// Checksums from the cache would make the checksum source differ from the first run
+	return !par.noCache && !par.requireFresh && !par.reproducible
+}
+
+// readCache returns the cache entry of a file, and whether it has one
+func readCache(fn string) (cacheEntry, bool) {
+	if checksumCache != nil {
+		return checksumCache.Get(fn, hashAlgo)
+	}
+	value, ok, err := getXattr(fn, cacheXattr())
+	if err != nil || !ok {
+		return cacheEntry{}, false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(value, &entry); err != nil {
+		return cacheEntry{}, false
+	}
+	return entry, true
+}
+
+// writeCache stores the cache entry of a file
+func writeCache(fn string, entry cacheEntry) {
+	if checksumCache != nil {
+		checksumCache.Put(fn, hashAlgo, entry)
+		return
+	}
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	// Errors are ignored, because the cache is only an optimization
+	audit.setXattr(fn)
+	setXattr(fn, cacheXattr(), value)
+}
+
+// applyCache fills in the checksums needed for the compare method from the
+// cache, and returns false if they are not available
+func applyCache(fileinfo *FileInfo) bool {
+	if !useCache() {
+		return false
+	}
+	entry, ok := readCache(fileinfo.Filename)
+	if !ok || !entry.Matches(fileinfo.Size, fileinfo.MtimeNs) {
+		return false
+	}
+	switch fileinfo.CompareMethod {
+	case "partial":
+		smallFile := fileinfo.Size <= partialConfig.FullThreshold
+		if entry.Partial != "" && entry.ChunkSize == partialConfig.ChunkSize && entry.FullThreshold == partialConfig.FullThreshold {
+			fileinfo.PartialChecksum = entry.Partial
+			for i, key := range []string{msinfo.PropPartialChecksumHead, msinfo.PropPartialChecksumMiddle, msinfo.PropPartialChecksumTail} {
+				if i < len(entry.PartialChunks) {
+					fileinfo.Properties[key] = entry.PartialChunks[i]
+				}
+			}
+		} else if smallFile && entry.Full != "" {
+			fileinfo.PartialChecksum = entry.Full
+		} else {
+			return false
+		}
+		if smallFile {
+			fileinfo.FullChecksum = fileinfo.PartialChecksum
+		}
+	case "partial-adaptive":
+		// The label of the adaptive checksum tells the chunk size of its regions
+		if fileinfo.Size <= partialConfig.FullThreshold && entry.Full != "" {
+			fileinfo.PartialChecksum = entry.Full
+			fileinfo.FullChecksum = entry.Full
+		} else if fileinfo.Size > partialConfig.FullThreshold && strings.HasPrefix(entry.Adaptive, fcompare.AdaptivePartialChecksumLabel(hashAlgo, partialConfig)) {
+			fileinfo.PartialChecksum = entry.Adaptive
+		} else {
+			return false
+		}
+	case "full":
+		if entry.Full == "" {
+			return false
+		}
+		fileinfo.FullChecksum = entry.Full
+	default:
+		return false
+	}
+	fileinfo.ChecksumSource = checksumSourceCache
+	if hashAlgo != fcompare.HashSHA256 {
+		fileinfo.HashAlgo = hashAlgo.String()
+	}
+	return true
+}
+
+// updateCache stores the checksums that were computed for a file in its cache entry
+func updateCache(fileinfo FileInfo, mtime time.Time) {
+	if !useCache() || fileinfo.ChecksumSource != checksumSourceFresh {
+		return
+	}
+	// A file that was modified within the resolution of file times could be modified
+	// again without a change of its mtime
+	if time.Since(mtime) <= max(timeTolerance(fileinfo.Filename), time.Second) {
+		return
+	}
+	entry, ok := readCache(fileinfo.Filename)
+	if ok && !entry.Matches(fileinfo.Size, fileinfo.MtimeNs) {
+		if !par.refreshCache {
+			fmt.Fprintln(os.Stderr, "Warning:", fileinfo.Filename, "changed since its checksum was cached; use -refresh-cache to update the cache")
+			return
+		}
+		ok = false
+	}
+	if !ok {
+		entry = cacheEntry{Size: fileinfo.Size, MtimeNs: fileinfo.MtimeNs}
+	}
+	switch fileinfo.CompareMethod {
+	case "partial":
+		entry.Partial = fileinfo.PartialChecksum
+		entry.ChunkSize, entry.FullThreshold = partialConfig.ChunkSize, partialConfig.FullThreshold
+		entry.PartialChunks = nil
+		for _, key := range []string{msinfo.PropPartialChecksumHead, msinfo.PropPartialChecksumMiddle, msinfo.PropPartialChecksumTail} {
+			if c, ok := fileinfo.Properties[key]; ok {
+				entry.PartialChunks = append(entry.PartialChunks, c)
+			}
+		}
+	case "partial-adaptive":
+		if fileinfo.Size > partialConfig.FullThreshold {
+			entry.Adaptive = fileinfo.PartialChecksum
+		}
+	case "full":
+	default:
+		return
+	}
+	if fileinfo.FullChecksum != "" {
+		entry.Full = fileinfo.FullChecksum
+	}
+	writeCache(fileinfo.Filename, entry)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/524D/msfile/msfileio"
+)
+
+// A second run with -cache takes all checksums from the cache, and reads no file
+func TestCacheFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFixtureTree(t, dir)
+	// Files that were just modified are not cached
+	old := time.Now().Add(-time.Hour)
+	err := filepath.Walk(filepath.Join(dir, "data"), func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			err = os.Chtimes(path, old, old)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, method := range []string{"partial", "partial-adaptive", "full"} {
+		cache := filepath.Join(t.TempDir(), "cache.json")
+		var first []msfileio.FileInfo
+		for run := 0; run < 2; run++ {
+			stdout, stderr, code := runMsfile(t, dir, nil, "-r", "-checksums", "-comparemethod", method, "-cache", cache, "-format", "ndjson", "data")
+			if code != 0 {
+				t.Fatalf("%s, run %d: exit code %d: %s", method, run, code, stderr)
+			}
+			infos := readLines[msfileio.FileInfo](t, "ndjson", stdout)
+			wantSource := msfileio.ChecksumSourceCache
+			if run == 0 {
+				first = infos
+				wantSource = msfileio.ChecksumSourceFresh
+			} else if !strings.Contains(stderr, "Checksums: 0 computed from files") {
+				t.Errorf("%s, second run computed checksums: %s", method, stderr)
+			}
+			for i, inf := range infos {
+				if inf.ChecksumSource != wantSource {
+					t.Errorf("%s, run %d: %s has checksum source %q, want %q", method, run, inf.Filename, inf.ChecksumSource, wantSource)
+				}
+				if run == 1 && (inf.PartialChecksum != first[i].PartialChecksum || inf.FullChecksum != first[i].FullChecksum) {
+					t.Errorf("%s: %s has checksums %s %s from the cache, %s %s from the file", method, inf.Filename,
+						inf.PartialChecksum, inf.FullChecksum, first[i].PartialChecksum, first[i].FullChecksum)
+				}
+			}
+		}
+	}
+}
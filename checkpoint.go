@@ -0,0 +1,78 @@
+package main
+
+// checkpoint.go - Stop a run at a deadline, and resume it later
+// With -max-runtime or -stop-at, msfile stops starting new files when the
+// deadline has passed, finishes the file it is working on, and writes a
+// checkpoint with all files that were completed. A later run with -resume
+// skips those files.
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/524D/msfile/msfileio"
+)
+
+// Exit codes when a run stopped at its deadline before all files were processed,
+// and when files of such a run also failed (exit code 1 of a complete run)
+const exitIncomplete = 4
+const exitIncompleteFailed = 5
+
+type checkpoint = msfileio.Checkpoint
+
+// runDeadline returns the time after which no new files should be started,
+// or the zero time if there is no deadline
+func runDeadline(start time.Time, maxRuntime time.Duration, stopAt string) (time.Time, error) {
+	var deadline time.Time
+	if maxRuntime > 0 {
+		deadline = start.Add(maxRuntime)
+	}
+	if stopAt != "" {
+		t, err := time.ParseInLocation("15:04", stopAt, time.Local)
+		if err != nil {
+			return deadline, usageError("-stop-at must be a time of day as HH:MM")
+		}
+		// The next occurrence of that time of day
+		stop := time.Date(start.Year(), start.Month(), start.Day(), t.Hour(), t.Minute(), 0, 0, time.Local)
+		if !stop.After(start) {
+			stop = stop.AddDate(0, 0, 1)
+		}
+		if deadline.IsZero() || stop.Before(deadline) {
+			deadline = stop
+		}
+	}
+	return deadline, nil
+}
+
+// deadlinePassed reports whether the deadline of the run has passed; a zero
+// deadline never passes. The tests replace it to stop a run after some files.
+var deadlinePassed = func(deadline time.Time) bool {
+	return !deadline.IsZero() && time.Now().After(deadline)
+}
+
+// readCheckpoint returns the files that were completed according to a checkpoint
+func readCheckpoint(fn string) ([]string, error) {
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(b, &cp); err != nil {
+		return nil, &FileError{Code: ErrCodeParse, Message: err.Error(), Path: fn}
+	}
+	return cp.Completed, nil
+}
+
+// writeCheckpoint writes the list of completed files.
+// An existing checkpoint (possibly the one we resumed from) is never left half-written.
+func writeCheckpoint(fn string, completed []string) error {
+	if completed == nil {
+		completed = []string{}
+	}
+	b, err := json.MarshalIndent(checkpoint{Completed: completed}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileVerified(fn, b)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/524D/msfile/msfileio"
+)
+
+// A run that stops at its deadline writes a checkpoint with the files it
+// completed, and a run with -resume skips exactly those
+func TestCheckpointResume(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "data"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := []string{"data/a.mgf", "data/b.mgf", "data/c.mgf", "data/d.mgf"}
+	for _, fn := range files {
+		writeTestFile(t, dir, fn, []byte(fn))
+	}
+	for i := range files {
+		files[i] = filepath.FromSlash(files[i])
+	}
+	stdout, stderr, code := runMsfile(t, dir, []string{"MSFILE_TEST_STOP_AFTER=2"},
+		"-r", "-max-runtime", "1h", "-checkpoint", "cp.json", "-emit-skipped", "-format", "ndjson", "data")
+	if code != exitIncomplete {
+		t.Fatalf("exit code %d, want %d: %s", code, exitIncomplete, stderr)
+	}
+	processed, skipped := resumeRecords(t, stdout)
+	if !slices.Equal(processed, files[:2]) || !slices.Equal(skipped[skipDeadline], files[2:]) {
+		t.Errorf("first run processed %v, skipped %v", processed, skipped)
+	}
+	completed, err := readCheckpoint(filepath.Join(dir, "cp.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(completed, files[:2]) {
+		t.Errorf("checkpoint %v, want %v", completed, files[:2])
+	}
+
+	stdout, stderr, code = runMsfile(t, dir, nil, "-r", "-resume", "cp.json", "-emit-skipped", "-format", "ndjson", "data")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	processed, skipped = resumeRecords(t, stdout)
+	if !slices.Equal(processed, files[2:]) || !slices.Equal(skipped[skipCompleted], files[:2]) || len(skipped) != 1 {
+		t.Errorf("resumed run processed %v, skipped %v", processed, skipped)
+	}
+}
+
+// A run that stops at its deadline exits with exitIncomplete, also when files
+// failed, but then with a code of its own
+func TestCheckpointExitCode(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "a.gz", []byte("\x1f\x8bnot gzip"))
+	writeTestFile(t, dir, "b.txt", []byte("b"))
+	for _, tc := range []struct {
+		stopAfter string
+		want      int
+	}{
+		{"0", exitIncomplete},
+		{"1", exitIncompleteFailed},
+		{"2", 1},
+	} {
+		_, stderr, code := runMsfile(t, dir, []string{"MSFILE_TEST_STOP_AFTER=" + tc.stopAfter},
+			"-verify-embedded", "-max-runtime", "1h", "-checkpoint", "cp.json", "a.gz", "b.txt")
+		if code != tc.want {
+			t.Errorf("stopped after %s files: exit code %d, want %d: %s", tc.stopAfter, code, tc.want, stderr)
+		}
+	}
+}
+
+// resumeRecords returns the files of the FileInfo records of a run, and those of
+// the skipped records by reason
+func resumeRecords(t *testing.T, stdout string) (processed []string, skipped map[string][]string) {
+	t.Helper()
+	skipped = make(map[string][]string)
+	r := msfileio.NewRecords(strings.NewReader(stdout))
+	for {
+		rec, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Skipped != nil {
+			skipped[rec.Skipped.SkipReason] = append(skipped[rec.Skipped.SkipReason], rec.Skipped.Filename)
+		} else {
+			processed = append(processed, rec.File.Filename)
+		}
+	}
+	return processed, skipped
+}
@@ -0,0 +1,137 @@
+package main
+
+// comparegroups.go - Compare more than 2 files
+// With -compare and more than 2 files, msfile prints the groups of files that
+// are the same, in the order in which their first file was given. Files that
+// are unlike all others are only printed with -show-unique. With -stream, each
+// file that is the same as an earlier file is also printed as soon as it is found.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/524D/msfile/fcompare"
+)
+
+// compareMethods maps the names of -comparemethod to the methods of fcompare
+var compareMethods = map[string]fcompare.CompareMethod{
+	"size":             fcompare.CmpSize,
+	"partial":          fcompare.CmpPartial,
+	"partial-adaptive": fcompare.CmpPartialAdaptive,
+	"full":             fcompare.CmpFull,
+	"bytes":            fcompare.CmpBytes,
+}
+
+// compareGroups compares all files with fcompare, and returns the groups of files
+// that are the same. A name that refers to the same file as an earlier name is
+// skipped, because a file would always be the same as itself.
+func compareGroups(fns []string) ([][]string, error) {
+	method, _ := par.methodPolicy.methodFor(fns[0])
+	var files []string
+	var infos []os.FileInfo
+next:
+	for _, fn := range fns {
+		if m, _ := par.methodPolicy.methodFor(fn); m != method {
+			return nil, usageError("Can't compare files with different methods: %s for %s and %s for %s", method, fns[0], m, fn)
+		}
+		fi, err := os.Stat(fn)
+		if err != nil {
+			return nil, codedError("", err)
+		}
+		for i, seen := range infos {
+			if os.SameFile(fi, seen) {
+				fmt.Fprintln(os.Stderr, fn, "refers to the same file as", files[i]+", skipped")
+				continue next
+			}
+		}
+		files = append(files, fn)
+		infos = append(infos, fi)
+	}
+	if precomputed != nil {
+		fmt.Fprintln(os.Stderr, "Warning: -precomputed is not used to compare more than 2 files")
+	}
+
+	var groups [][]string
+	var err error
+	if par.stream {
+		groups, err = compareStream(files, compareMethods[method])
+	} else {
+		var idx [][]int
+		idx, err = fcompare.CompareFilesOptCtx(readCtx, files, fcompare.WithMethod(compareMethods[method]),
+			fcompare.WithHash(hashAlgo), fcompare.WithPartialConfig(partialConfig), fcompare.WithKeepATime(!par.fast),
+			fcompare.WithCache(checksumCache), fcompare.WithStopOnError(true))
+		for _, g := range idx {
+			var names []string
+			for _, i := range g {
+				names = append(names, files[i])
+			}
+			groups = append(groups, names)
+		}
+	}
+
+	// The files are read by fcompare, which reported the reads to the audit log
+	failed := make(map[string]error)
+	var cerr *fcompare.CompareError
+	if errors.As(err, &cerr) {
+		for _, f := range cerr.Failed {
+			failed[f.Path] = f.Err
+		}
+	}
+	for i, fn := range files {
+		audit.read(fn, infos[i].Size(), failed[fn])
+	}
+	if err != nil {
+		return nil, codedError("", err)
+	}
+	return groups, nil
+}
+
+// compareStream compares files like compareGroups, and prints each file that is
+// the same as an earlier file as soon as it is found
+func compareStream(fns []string, method fcompare.CompareMethod) ([][]string, error) {
+	ctx, cancel := context.WithCancel(readCtx)
+	defer cancel()
+	// One file at a time, so the matches of a file are the files before it
+	results, err := fcompare.CompareFilesStream(ctx, fns, fcompare.IndexConfig{Method: method, Algo: hashAlgo,
+		Partial: partialConfig, KeepATime: !par.fast, Cache: checksumCache}, 1)
+	if err != nil {
+		return nil, err
+	}
+	var groups [][]string
+	groupOf := make(map[string]int)
+	for r := range results {
+		if r.Err != nil {
+			cancel()
+			return nil, &fcompare.CompareError{Failed: []fcompare.FailedFile{{Index: r.Index, Path: r.Path, Err: r.Err}}}
+		}
+		if len(r.Matches) > 0 {
+			g := groupOf[r.Matches[0]]
+			if !par.quiet {
+				fmt.Println(r.Path, "is the same as", groups[g][0])
+			}
+			groups[g] = append(groups[g], r.Path)
+			groupOf[r.Path] = g
+		} else {
+			groupOf[r.Path] = len(groups)
+			groups = append(groups, []string{r.Path})
+		}
+	}
+	return groups, nil
+}
+
+// writeCompareGroups writes the groups of files that are the same, one per line.
+// Single files are only written if showUnique is set.
+func writeCompareGroups(w io.Writer, groups [][]string, showUnique bool) {
+	n := 0
+	for _, g := range groups {
+		if len(g) < 2 && !showUnique {
+			continue
+		}
+		n++
+		fmt.Fprintf(w, "group %d: %s\n", n, strings.Join(g, " "))
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestCompareGroups(t *testing.T) {
+	dir := t.TempDir()
+	big := bytes.Repeat([]byte("0123456789abcdef"), 1<<16)
+	a := writeTestFile(t, dir, "a", big)
+	b := writeTestFile(t, dir, "b", big)
+	c := writeTestFile(t, dir, "c", []byte("hello\n"))
+	d := writeTestFile(t, dir, "d", []byte("other\n"))
+	e := writeTestFile(t, dir, "e", []byte("hello\n"))
+	fns := []string{c, a, d, b, e}
+	want := [][]string{{c, e}, {a, b}, {d}}
+	for _, method := range validMethods {
+		for _, stream := range []bool{false, true} {
+			withPar(t, func(p *params) { p.method, p.fast, p.stream, p.quiet = method, true, stream, true })
+			groups, err := compareGroups(fns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := want
+			if method == "size" {
+				w = [][]string{{c, d, e}, {a, b}}
+			}
+			if !reflect.DeepEqual(groups, w) {
+				t.Errorf("%s, stream %v: groups %v, want %v", method, stream, groups, w)
+			}
+		}
+	}
+}
+
+// A name for a file that was given before is skipped
+func TestCompareGroupsSameFile(t *testing.T) {
+	dir := t.TempDir()
+	a := writeTestFile(t, dir, "a", []byte("x"))
+	b := writeTestFile(t, dir, "b", []byte("x"))
+	withPar(t, func(p *params) { p.method, p.fast = "bytes", true })
+	groups, err := compareGroups([]string{a, b, a})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]string{{a, b}}; !reflect.DeepEqual(groups, want) {
+		t.Errorf("groups %v, want %v", groups, want)
+	}
+}
+
+func TestCompareGroupsMissing(t *testing.T) {
+	dir := t.TempDir()
+	a := writeTestFile(t, dir, "a", []byte("x"))
+	withPar(t, func(p *params) { p.method, p.fast = "full", true })
+	if _, err := compareGroups([]string{a, a + ".missing", a}); err == nil || errorCode(err) != ErrCodeNotFound {
+		t.Errorf("error %v, want %s", err, ErrCodeNotFound)
+	}
+}
+
+func TestWriteCompareGroups(t *testing.T) {
+	groups := [][]string{{"a", "b"}, {"c"}, {"d", "e"}}
+	var buf bytes.Buffer
+	writeCompareGroups(&buf, groups, false)
+	if want := "group 1: a b\ngroup 2: d e\n"; buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+	buf.Reset()
+	writeCompareGroups(&buf, groups, true)
+	if want := "group 1: a b\ngroup 2: c\ngroup 3: d e\n"; buf.String() != want {
+		t.Errorf("with showUnique got %q, want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+// comparerange.go - Compare only a region of two files
+// With -compare-range OFFSET:LENGTH, only that region of both files is hashed.
+// This answers questions like "did the first 80 GB of a resumable transfer
+// arrive intact?". With OFFSET:transferred, the region extends to the end of
+// the smaller file, so it covers exactly the data that both files have.
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/524D/msfile/fcompare"
+)
+
+// The region to compare, nil if files are compared with a compare method
+var compareRange *fcompare.Range
+
+// resolveCompareRange parses OFFSET:LENGTH or OFFSET:transferred, and checks
+// that files of the given sizes both contain the region
+func resolveCompareRange(s string, size1, size2 int64) (*fcompare.Range, error) {
+	offsetStr, lengthStr, ok := strings.Cut(s, ":")
+	if !ok {
+		return nil, usageError("-compare-range must have the form OFFSET:LENGTH or OFFSET:transferred")
+	}
+	offset, err := strconv.ParseInt(offsetStr, 10, 64)
+	if err != nil || offset < 0 {
+		return nil, usageError("Invalid -compare-range offset %q", offsetStr)
+	}
+	var length int64
+	if lengthStr == "transferred" {
+		length = min(size1, size2) - offset
+		if length < 0 {
+			return nil, &FileError{Code: ErrCodeRangeOutOfBounds, Message: "offset " + offsetStr + " is beyond the end of the smaller file"}
+		}
+	} else {
+		length, err = strconv.ParseInt(lengthStr, 10, 64)
+		if err != nil || length < 0 {
+			return nil, usageError("Invalid -compare-range length %q", lengthStr)
+		}
+	}
+	if offset+length > size1 || offset+length > size2 {
+		return nil, &FileError{Code: ErrCodeRangeOutOfBounds,
+			Message: "the region ends at " + strconv.FormatInt(offset+length, 10) + ", beyond the end of the file of " + strconv.FormatInt(min(size1, size2), 10) + " bytes"}
+	}
+	return &fcompare.Range{Start: offset, Length: length}, nil
+}
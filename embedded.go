@@ -0,0 +1,139 @@
+package main
+
+// embedded.go - Verification of the integrity information embedded in files
+// This is the CRC32 and size in the trailer of each gzip member, and the content
+// checksum of each zstd frame. To find out if a file is compressed, only its
+// first 4 bytes are read.
+// Decompression stops at msinfo.DecompressionBudget, so a gzip bomb can't hang the run.
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/524D/msfile/fcompare"
+	"github.com/524D/msfile/msinfo"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// countingReader counts the bytes that are consumed from the underlying reader.
+// It implements io.ByteReader, so that the gzip (flate) reader doesn't
+// add its own buffering, and the count is the exact position in the compressed stream.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+// verifyEmbedded checks the embedded integrity information of a file,
+// and adds the results to fileinfo. It returns false if the file is corrupt.
+// Files without embedded integrity information are left alone.
+func verifyEmbedded(fileinfo *FileInfo) (bool, error) {
+	f, err := fcompare.OpenObserved(readCtx, fileinfo.Filename)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, 4)
+	m, err := io.ReadFull(f, magic)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	magic = magic[:m]
+	isGzip := bytes.HasPrefix(magic, gzipMagic)
+	if !isGzip && !msinfo.IsZstd(magic) {
+		return true, nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	if !isGzip {
+		return verifyZstd(fileinfo, f, fi.Size())
+	}
+	cr := &countingReader{r: bufio.NewReader(f)}
+
+	// The gzip reader handles multi-member files (concatenated streams) by default,
+	// and checks the CRC32 and size of each member when it reaches its trailer
+	zr, err := gzip.NewReader(cr)
+	var n int64
+	if err == nil {
+		n, err = io.Copy(io.Discard, msinfo.LimitDecompressed(zr, fi.Size()))
+	}
+	if err != nil {
+		// Read errors of the file itself are not a property of the gzip stream
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			return false, err
+		}
+		// A gzip bomb isn't verified to the end, so its CRC is unknown
+		if errors.Is(err, msinfo.ErrBudgetExceeded) {
+			setFileError(fileinfo, ErrCodeParse, err.Error())
+			return false, nil
+		}
+		fileinfo.Properties[msinfo.PropGzipCRCValid] = "false"
+		fileinfo.Properties[msinfo.PropGzipError] = err.Error()
+		fileinfo.Properties[msinfo.PropGzipErrorOffset] = strconv.FormatInt(cr.n, 10)
+		setFileError(fileinfo, ErrCodeGzipCorrupt, err.Error()+" at offset "+strconv.FormatInt(cr.n, 10))
+		return false, nil
+	}
+	fileinfo.Properties[msinfo.PropGzipCRCValid] = "true"
+	fileinfo.DecompressedSize = n
+	return true, nil
+}
+
+// verifyZstd decompresses all frames of a zstd file, which checks the content
+// checksums of the frames that have one, and reads the metadata in skippable
+// frames at the start
+func verifyZstd(fileinfo *FileInfo, f *fcompare.ObservedFile, size int64) (bool, error) {
+	// A truncated skippable frame is reported by the decoder below
+	meta, err := msinfo.ReadZstdMetadata(io.NewSectionReader(f, 0, size))
+	if err == nil && meta != "" {
+		fileinfo.Properties[msinfo.PropZstdMetadata] = meta
+	}
+	zr, err := msinfo.NewZstdReader(bufio.NewReader(f))
+	var n int64
+	if err == nil {
+		defer zr.Close()
+		n, err = io.Copy(io.Discard, msinfo.LimitDecompressed(zr, size))
+	}
+	if err != nil {
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			return false, err
+		}
+		if errors.Is(err, msinfo.ErrBudgetExceeded) {
+			setFileError(fileinfo, ErrCodeParse, err.Error())
+			return false, nil
+		}
+		fileinfo.Properties[msinfo.PropZstdChecksumValid] = "false"
+		fileinfo.Properties[msinfo.PropZstdError] = err.Error()
+		setFileError(fileinfo, ErrCodeZstdCorrupt, err.Error())
+		return false, nil
+	}
+	fileinfo.Properties[msinfo.PropZstdChecksumValid] = "true"
+	fileinfo.DecompressedSize = n
+	return true, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/524D/msfile/msinfo"
+	"github.com/klauspost/compress/zstd"
+)
+
+func gzipMembers(members ...string) []byte {
+	var b bytes.Buffer
+	for _, m := range members {
+		zw := gzip.NewWriter(&b)
+		zw.Write([]byte(m))
+		zw.Close()
+	}
+	return b.Bytes()
+}
+
+// zstdFrames compresses each of frames into a zstd frame with a content checksum,
+// after a skippable frame with meta
+func zstdFrames(t testing.TB, meta string, frames ...string) []byte {
+	t.Helper()
+	b := binary.LittleEndian.AppendUint32(nil, 0x184d2a50)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(meta)))
+	b = append(b, meta...)
+	zw, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range frames {
+		b = zw.EncodeAll([]byte(f), b)
+	}
+	return b
+}
+
+func TestVerifyEmbedded(t *testing.T) {
+	dir := t.TempDir()
+	good := gzipMembers("first member\n", "second member\n")
+	// The CRC32 of the last member is in the 8 bytes before its size
+	bad := bytes.Clone(good)
+	bad[len(bad)-5] ^= 0xff
+	goodZstd := zstdFrames(t, "meta", "first frame\n", "second frame\n")
+	// The content checksum is in the last 4 bytes of a frame
+	badZstd := bytes.Clone(goodZstd)
+	badZstd[len(badZstd)-1] ^= 0xff
+	for _, tc := range []struct {
+		name  string
+		data  []byte
+		valid bool
+		crc   string
+	}{
+		{"plain.txt", []byte("not compressed"), true, ""},
+		{"good.gz", good, true, "true"},
+		{"bad.gz", bad, false, "false"},
+		{"truncated.gz", good[:len(good)-3], false, "false"},
+		{"good.zst", goodZstd, true, ""},
+		{"bad.zst", badZstd, false, ""},
+		{"truncated.zst", goodZstd[:len(goodZstd)-3], false, ""},
+	} {
+		inf := FileInfo{Filename: writeTestFile(t, dir, tc.name, tc.data), Properties: make(map[string]string)}
+		valid, err := verifyEmbedded(&inf)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if valid != tc.valid || inf.Properties[msinfo.PropGzipCRCValid] != tc.crc {
+			t.Errorf("%s: valid %v, %s %q, want %v, %q", tc.name, valid, msinfo.PropGzipCRCValid, inf.Properties[msinfo.PropGzipCRCValid], tc.valid, tc.crc)
+		}
+		if tc.valid == (inf.Error != nil) {
+			t.Errorf("%s: error %v", tc.name, inf.Error)
+		}
+		if strings.HasSuffix(tc.name, ".zst") {
+			if got, want := inf.Properties[msinfo.PropZstdChecksumValid], strconv.FormatBool(tc.valid); got != want {
+				t.Errorf("%s: %s %q, want %q", tc.name, msinfo.PropZstdChecksumValid, got, want)
+			}
+			if inf.Properties[msinfo.PropZstdMetadata] != "meta" {
+				t.Errorf("%s: %s %q, want meta", tc.name, msinfo.PropZstdMetadata, inf.Properties[msinfo.PropZstdMetadata])
+			}
+		}
+	}
+	inf := FileInfo{Filename: writeTestFile(t, dir, "size.zst", goodZstd), Properties: make(map[string]string)}
+	if valid, err := verifyEmbedded(&inf); !valid || err != nil || inf.DecompressedSize != int64(len("first frame\nsecond frame\n")) {
+		t.Errorf("verifyEmbedded = %v, %v, decompressed size %d", valid, err, inf.DecompressedSize)
+	}
+}
+
+// The gzip member walker must report corrupt files as such, never panic or fail the run
+func FuzzVerifyEmbedded(f *testing.F) {
+	f.Add(gzipMembers("a"))
+	f.Add(gzipMembers("first", "second"))
+	f.Add(gzipMembers("")[:10])
+	f.Add([]byte{0x1f, 0x8b})
+	f.Add(zstdFrames(f, "meta", "first", "second"))
+	dir := f.TempDir()
+	f.Fuzz(func(t *testing.T, data []byte) {
+		inf := FileInfo{Filename: writeTestFile(t, dir, "f.gz", data), Properties: make(map[string]string)}
+		valid, err := verifyEmbedded(&inf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !valid && inf.Error == nil {
+			t.Error("invalid without an error of the file")
+		}
+	})
+}
+
+// With -decompressed-checksum, a compressed file matches its original
+func TestDecompressedChecksum(t *testing.T) {
+	dir := t.TempDir()
+	content := "<mzML>\n" + strings.Repeat("<spectrum/>\n", 100) + "</mzML>\n"
+	writeTestFile(t, dir, "a.mzML", []byte(content))
+	writeTestFile(t, dir, "a.mzML.gz", gzipMembers(content[:50], content[50:]))
+	writeTestFile(t, dir, "a.mzML.zst", zstdFrames(t, "meta", content))
+	writeTestFile(t, dir, "b.mzML.gz", gzipMembers(content+"\n"))
+
+	for _, tc := range []struct {
+		a, b string
+		want string
+	}{
+		{"a.mzML", "a.mzML.gz", "Decompressed contents are the same"},
+		{"a.mzML.zst", "a.mzML", "Decompressed contents are the same"},
+		{"a.mzML.gz", "a.mzML.zst", "Decompressed contents are the same"},
+		{"a.mzML", "b.mzML.gz", "Decompressed contents are different"},
+	} {
+		stdout, stderr, code := runMsfile(t, dir, nil, "-compare", "-decompressed-checksum", tc.a, tc.b)
+		if code != 0 || strings.TrimSpace(stdout) != tc.want {
+			t.Errorf("%s %s: exit code %d, %q, want %q: %s", tc.a, tc.b, code, stdout, tc.want, stderr)
+		}
+	}
+
+	stdout, stderr, code := runMsfile(t, dir, nil, "-decompressed-checksum", "-format", "ndjson", "-root", "A=.")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	sums := make(map[string]string)
+	for _, inf := range readLines[FileInfo](t, "ndjson", stdout) {
+		sums[inf.Filename] = inf.Properties[msinfo.PropDecompressedChecksum]
+	}
+	if sums["a.mzML"] == "" || sums["a.mzML.gz"] != sums["a.mzML"] || sums["a.mzML.zst"] != sums["a.mzML"] || sums["b.mzML.gz"] == sums["a.mzML"] {
+		t.Errorf("decompressed checksums %v", sums)
+	}
+	if !strings.Contains(stderr, "Root A duplicates: 2 within the root") {
+		t.Errorf("summary:\n%s", stderr)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+// encoding.go - Detect the character encoding of text-based MS formats
+// Search engines and other downstream tools often fail on MGF or FASTA files
+// with a byte-order mark or in UTF-16, as written by some Windows tools.
+// Only the start of the file is inspected.
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/524D/msfile/fcompare"
+)
+
+// Number of bytes read from the start of a file to detect its encoding
+const encodingSampleSize = 4096
+
+// Extensions of text-based MS formats
+var textFormatExtensions = map[string]bool{
+	".mgf":     true,
+	".fasta":   true,
+	".fa":      true,
+	".mzml":    true,
+	".mzxml":   true,
+	".imzml":   true,
+	".mzid":    true,
+	".ms1":     true,
+	".ms2":     true,
+	".pepxml":  true,
+	".protxml": true,
+}
+
+// Encodings that don't cause problems for downstream tools
+var plainEncodings = map[string]bool{
+	"ascii": true,
+	"utf-8": true,
+}
+
+// Byte-order marks, longest first because the UTF-32LE BOM starts with the UTF-16LE BOM
+var byteOrderMarks = []struct {
+	bom      []byte
+	encoding string
+}{
+	{[]byte{0xff, 0xfe, 0x00, 0x00}, "utf-32le-bom"},
+	{[]byte{0x00, 0x00, 0xfe, 0xff}, "utf-32be-bom"},
+	{[]byte{0xef, 0xbb, 0xbf}, "utf-8-bom"},
+	{[]byte{0xff, 0xfe}, "utf-16le-bom"},
+	{[]byte{0xfe, 0xff}, "utf-16be-bom"},
+}
+
+// isTextFormat reports whether a file is a text-based MS format, judged by its extension
+func isTextFormat(fn string) bool {
+	return textFormatExtensions[strings.ToLower(filepath.Ext(fn))]
+}
+
+// detectEncoding returns the encoding of a text file:
+// one of the BOM encodings above, "utf-16le" or "utf-16be" for UTF-16 without BOM,
+// "ascii", "utf-8", or "unknown-8bit" for anything else.
+func detectEncoding(fn string) (string, error) {
+	f, err := fcompare.OpenObserved(readCtx, fn)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	buf := make([]byte, encodingSampleSize)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	buf = buf[:n]
+
+	for _, b := range byteOrderMarks {
+		if bytes.HasPrefix(buf, b.bom) {
+			return b.encoding, nil
+		}
+	}
+
+	// Text in UTF-16 without BOM has a zero byte in about every other position,
+	// at odd positions for little endian, at even positions for big endian
+	var zerosEven, zerosOdd int
+	for i, c := range buf {
+		if c == 0 {
+			if i%2 == 0 {
+				zerosEven++
+			} else {
+				zerosOdd++
+			}
+		}
+	}
+	if zerosOdd > len(buf)/4 && zerosEven == 0 {
+		return "utf-16le", nil
+	}
+	if zerosEven > len(buf)/4 && zerosOdd == 0 {
+		return "utf-16be", nil
+	}
+
+	ascii := true
+	for _, c := range buf {
+		if c >= utf8.RuneSelf {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return "ascii", nil
+	}
+	// The sample may end in the middle of a multi-byte character
+	if n == encodingSampleSize {
+		for i := 1; i < utf8.UTFMax && i <= len(buf); i++ {
+			if utf8.RuneStart(buf[len(buf)-i]) {
+				if !utf8.FullRune(buf[len(buf)-i:]) {
+					buf = buf[:len(buf)-i]
+				}
+				break
+			}
+		}
+	}
+	if utf8.Valid(buf) {
+		return "utf-8", nil
+	}
+	return "unknown-8bit", nil
+}
@@ -0,0 +1,169 @@
+package main
+
+// errorcodes.go - Stable, machine-readable codes for every failure condition
+// Scripts and orchestrators can branch on the code instead of matching error messages.
+// Codes are append-only: existing codes are never renamed, removed or reused
+// for a different condition, so automation that depends on them keeps working.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/524D/msfile/msfileio"
+)
+
+// Error codes
+const (
+	ErrCodeUsage            = "E_USAGE"
+	ErrCodeAtimePreserve    = "E_ATIME_PRESERVE"
+	ErrCodeNotFound         = "E_NOT_FOUND"
+	ErrCodePermission       = "E_PERMISSION"
+	ErrCodeIOStat           = "E_IO_STAT"
+	ErrCodeIOOpen           = "E_IO_OPEN"
+	ErrCodeIORead           = "E_IO_READ"
+	ErrCodeIOWrite          = "E_IO_WRITE"
+	ErrCodeParse            = "E_PARSE"
+	ErrCodeChecksumMismatch = "E_CHECKSUM_MISMATCH"
+	ErrCodeGzipCorrupt      = "E_GZIP_CORRUPT"
+	ErrCodeSplitIncomplete  = "E_SPLIT_INCOMPLETE"
+	ErrCodeNotImmutable     = "E_NOT_IMMUTABLE"
+	ErrCodeNotFresh         = "E_NOT_FRESH"
+	ErrCodeAuditLog         = "E_AUDIT_LOG"
+	ErrCodeInternal         = "E_INTERNAL"
+	ErrCodeWriteVerify      = "E_WRITE_VERIFY"
+	ErrCodeAlreadyHeld      = "E_ALREADY_HELD"
+	ErrCodeRangeOutOfBounds = "E_RANGE_OUT_OF_BOUNDS"
+	ErrCodeDevice           = "E_DEVICE"
+	ErrCodeZstdCorrupt      = "E_ZSTD_CORRUPT"
+)
+
+// The registry of all error codes, in the order in which they were introduced
+var errorCodeRegistry = []struct {
+	code        string
+	description string
+}{
+	{ErrCodeUsage, "Invalid command line flags or arguments"},
+	{ErrCodeAtimePreserve, "Access times can't be preserved in the directory of a file"},
+	{ErrCodeNotFound, "File doesn't exist"},
+	{ErrCodePermission, "Permission denied"},
+	{ErrCodeIOStat, "Error getting file information"},
+	{ErrCodeIOOpen, "Error opening a file"},
+	{ErrCodeIORead, "Error reading a file"},
+	{ErrCodeIOWrite, "Error writing a file"},
+	{ErrCodeParse, "Invalid content in an input file (precomputed checksums, checkpoint, sidecar)"},
+	{ErrCodeChecksumMismatch, "Checksum doesn't match the expected value"},
+	{ErrCodeGzipCorrupt, "Gzip stream is corrupt or truncated"},
+	{ErrCodeSplitIncomplete, "Parts of a split file are missing or have inconsistent sizes"},
+	{ErrCodeNotImmutable, "File is not immutable, but -require-immutable is set"},
+	{ErrCodeNotFresh, "Checksum was not computed from the file, but -require-fresh is set"},
+	{ErrCodeAuditLog, "Error writing the audit log"},
+	{ErrCodeInternal, "Unexpected error"},
+	{ErrCodeWriteVerify, "Data or metadata written by msfile read back differently than written"},
+	{ErrCodeAlreadyHeld, "Content is already held according to -registry, but -require-unknown is set"},
+	{ErrCodeRangeOutOfBounds, "The -compare-range region doesn't fit in both files"},
+	{ErrCodeDevice, "File is a character or block device, which msfile never reads"},
+	{ErrCodeZstdCorrupt, "Zstd stream is corrupt or truncated"},
+}
+
+// FileError is an error with a code, and the file it applies to (if any)
+type FileError = msfileio.FileError
+
+// Number of per-file errors by code, for the summary at the end of a run
+var errorCounts = make(map[string]int)
+
+// codedError converts an error to a FileError.
+// If code is empty, the code is derived from the error.
+func codedError(code string, err error) *FileError {
+	var fe *FileError
+	if errors.As(err, &fe) {
+		return fe
+	}
+	e := &FileError{Code: code, Message: err.Error()}
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		e.Path = pathErr.Path
+		e.Message = pathErr.Err.Error()
+	}
+	if e.Code == "" {
+		e.Code = errorCode(err)
+	}
+	return e
+}
+
+// Exit code of fatal
+var fatalExitCode = 1
+
+// fatal logs an error, and exits with fatalExitCode
+func fatal(v ...any) {
+	// The records that were written stay readable, e.g. the JSON array is closed
+	if recordsWritten > 0 {
+		closeOutput()
+	}
+	log.Print(v...)
+	os.Exit(fatalExitCode)
+}
+
+// usageError returns a FileError for invalid command line usage
+func usageError(format string, a ...any) *FileError {
+	return &FileError{Code: ErrCodeUsage, Message: fmt.Sprintf(format, a...)}
+}
+
+// errorCode derives the code of an error that doesn't have one
+func errorCode(err error) string {
+	var fe *FileError
+	if errors.As(err, &fe) {
+		return fe.Code
+	}
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return ErrCodeNotFound
+	case errors.Is(err, os.ErrPermission):
+		return ErrCodePermission
+	}
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		switch pathErr.Op {
+		case "stat", "lstat":
+			return ErrCodeIOStat
+		case "open":
+			return ErrCodeIOOpen
+		case "read", "seek":
+			return ErrCodeIORead
+		case "write", "close", "sync":
+			return ErrCodeIOWrite
+		}
+	}
+	return ErrCodeInternal
+}
+
+// setFileError records a failure of a file that doesn't stop the run
+func setFileError(fileinfo *FileInfo, code string, message string) {
+	fileinfo.Error = &FileError{Code: code, Message: message, Path: fileinfo.Filename}
+	errorCounts[code]++
+}
+
+// listErrorCodes writes the error code registry
+func listErrorCodes(w io.Writer) {
+	for _, e := range errorCodeRegistry {
+		fmt.Fprintf(w, "%-20s %s\n", e.code, e.description)
+	}
+}
+
+// errorSummary returns the number of per-file errors by code, e.g. "E_GZIP_CORRUPT: 2, E_NOT_IMMUTABLE: 1"
+func errorSummary() string {
+	var codes []string
+	for code := range errorCounts {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	var s []string
+	for _, code := range codes {
+		s = append(s, fmt.Sprintf("%s: %d", code, errorCounts[code]))
+	}
+	return strings.Join(s, ", ")
+}
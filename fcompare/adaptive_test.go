@@ -0,0 +1,159 @@
+package fcompare
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeSparseFile creates a sparse file of size bytes with data written at the given offsets
+func writeSparseFile(t testing.TB, dir, name string, size int64, data map[int64][]byte) string {
+	t.Helper()
+	fn := filepath.Join(dir, name)
+	f, err := os.Create(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.Truncate(size); err != nil {
+		t.Skipf("can't create a file of %d bytes: %v", size, err)
+	}
+	for off, b := range data {
+		if _, err := f.WriteAt(b, off); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return fn
+}
+
+func TestAdaptiveRegionCount(t *testing.T) {
+	for _, tc := range []struct {
+		size int64
+		want int
+	}{
+		{minPartialChecksumSize + 1, 3},
+		{1<<30 - 1, 3},
+		{1 << 30, 4},
+		{1 << 31, 6},
+		{1 << 35, 12},
+		{1 << 38, 16},
+		{1 << 45, 16},
+	} {
+		if got := adaptiveRegionCount(tc.size, DefaultPartialChecksumConfig.ChunkSize); got != tc.want {
+			t.Errorf("adaptiveRegionCount(%d) = %d, want %d", tc.size, got, tc.want)
+		}
+		ranges := AdaptivePartialChecksumRanges(tc.size)
+		if len(ranges) != tc.want || ranges[0].Start != 0 || ranges[len(ranges)-1].Start+DefaultPartialChecksumConfig.ChunkSize != tc.size {
+			t.Errorf("size %d: ranges %v don't span the file", tc.size, ranges)
+		}
+		for i := 1; i < len(ranges); i++ {
+			if ranges[i].Start < ranges[i-1].Start+DefaultPartialChecksumConfig.ChunkSize {
+				t.Errorf("size %d: ranges %v overlap", tc.size, ranges)
+			}
+		}
+	}
+}
+
+// Files that differ only outside the 3 regions of the fixed scheme collide with it,
+// but are told apart by the adaptive scheme, which samples more regions of large files
+func TestAdaptivePartialChecksumCollision(t *testing.T) {
+	if testing.Short() {
+		t.Skip("uses sparse files of 4 GB")
+	}
+	const size = 4 << 30
+	dir := t.TempDir()
+	offsets := adaptiveRegionOffsets(size, DefaultPartialChecksumConfig.ChunkSize)
+	if len(offsets) <= 3 {
+		t.Fatalf("%d regions for %d bytes", len(offsets), int64(size))
+	}
+	// The same head, middle and tail, and a difference in the second adaptive region
+	common := map[int64][]byte{0: []byte("head"), size / 2: []byte("middle"), size - 4: []byte("tail")}
+	a := writeSparseFile(t, dir, "a", size, common)
+	common[offsets[1]+100] = []byte("different")
+	b := writeSparseFile(t, dir, "b", size, common)
+
+	fixedA, _, err := GetPartialChecksum(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixedB, _, err := GetPartialChecksum(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fixedA != fixedB {
+		t.Fatal("the fixed partial checksums differ, so the test files don't collide")
+	}
+	adaptiveA, fullA, err := GetAdaptivePartialChecksum(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adaptiveB, _, err := GetAdaptivePartialChecksum(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if adaptiveA == adaptiveB {
+		t.Error("the adaptive partial checksums of different files are equal")
+	}
+	if fullA || !strings.HasPrefix(adaptiveA, "sha256-adaptive-v1:") {
+		t.Errorf("adaptive checksum %s (full %v), want the sha256-adaptive-v1 label", adaptiveA, fullA)
+	}
+	// The digest only depends on the content at the sampled offsets
+	again, _, err := GetAdaptivePartialChecksum(a)
+	if err != nil || again != adaptiveA {
+		t.Errorf("second adaptive checksum %s, %v, want %s", again, err, adaptiveA)
+	}
+}
+
+// Small files are hashed completely, like with the fixed scheme
+func TestAdaptivePartialChecksumSmall(t *testing.T) {
+	fn := writeFile(t, t.TempDir(), "f", randomData(5, 1000))
+	adaptive, full, err := GetAdaptivePartialChecksum(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := GetChecksum(fn)
+	if err != nil || !full || adaptive != want {
+		t.Errorf("GetAdaptivePartialChecksum = %s, %v, want the full checksum %s", adaptive, full, want)
+	}
+}
+
+// The chunk size and threshold of the config set the regions, and the chunk size is in the label
+func TestAdaptivePartialChecksumConfig(t *testing.T) {
+	cfg := PartialChecksumConfig{ChunkSize: 4096, FullThreshold: 3 * 4096}
+	dir := t.TempDir()
+	data := randomData(6, 100000)
+	fn := writeFile(t, dir, "f", data)
+	sum, full, err := GetAdaptivePartialChecksumConfig(fn, HashSHA256, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	label := AdaptivePartialChecksumLabel(HashSHA256, cfg)
+	if full || label != "sha256-adaptive-v1-c4096:" || !strings.HasPrefix(sum, label) {
+		t.Errorf("checksum %s (full %v), want the label %s", sum, full, label)
+	}
+	h := sha256.New()
+	for _, r := range AdaptivePartialChecksumRangesConfig(int64(len(data)), cfg) {
+		if r.Length != cfg.ChunkSize {
+			t.Errorf("range %v, want %d bytes", r, cfg.ChunkSize)
+		}
+		h.Write(data[r.Start : r.Start+r.Length])
+	}
+	if want := label + hex.EncodeToString(h.Sum(nil)); sum != want {
+		t.Errorf("checksum %s, want %s of the ranges", sum, want)
+	}
+	if l := AdaptivePartialChecksumLabel(HashCRC64, DefaultPartialChecksumConfig); l != "crc64-adaptive-v1:" {
+		t.Errorf("label of the default config %s, want crc64-adaptive-v1:", l)
+	}
+	// Files up to the threshold are hashed completely
+	small := writeFile(t, dir, "small", data[:3*4096])
+	want, _ := GetChecksum(small)
+	if sum, full, err := GetAdaptivePartialChecksumConfig(small, HashSHA256, cfg); err != nil || !full || sum != want {
+		t.Errorf("small file: %s, %v, %v, want the full checksum %s", sum, full, err, want)
+	}
+	if _, _, err := GetAdaptivePartialChecksumConfig(fn, HashSHA256, PartialChecksumConfig{ChunkSize: 4096}); err == nil {
+		t.Error("invalid config: no error")
+	}
+}
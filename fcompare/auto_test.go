@@ -0,0 +1,136 @@
+package fcompare
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// autoCorpus writes files that are told apart at each stage of CmpAuto:
+// by size, by partial checksum, and only by full checksum
+func autoCorpus(t *testing.T, cfg PartialChecksumConfig) (fns []string, groups [][]int) {
+	t.Helper()
+	dir := t.TempDir()
+	size := int(10 * cfg.FullThreshold)
+	base := randomData(7, size)
+	variant := func(off int) []byte {
+		data := append([]byte(nil), base...)
+		data[off] ^= 1
+		return data
+	}
+	fns = []string{
+		writeFile(t, dir, "unique", randomData(8, size+1)), // 0: unique size
+		writeFile(t, dir, "small-a", []byte("same size")),  // 1, 2: same size, other content
+		writeFile(t, dir, "small-b", []byte("other one")),
+		writeFile(t, dir, "middle", variant(size/2+10)), // 3: other middle chunk than base
+		writeFile(t, dir, "base", base),                 // 4, 5: identical
+		writeFile(t, dir, "base-copy", base),
+		writeFile(t, dir, "outside", variant(size/4)),      // 6: other data outside the chunks
+		writeFile(t, dir, "outside-copy", variant(size/4)), // 7: identical to 6
+		writeFile(t, dir, "small-a-copy", []byte("same size")),
+	}
+	groups = [][]int{{0}, {1, 8}, {2}, {3}, {4, 5}, {6, 7}}
+	return fns, groups
+}
+
+func TestCmpAutoGroups(t *testing.T) {
+	cfg := PartialChecksumConfig{ChunkSize: 4096, FullThreshold: 3 * 4096}
+	fns, want := autoCorpus(t, cfg)
+	got, err := CompareFilesOpt(fns, WithMethod(CmpAuto), WithPartialConfig(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("groups %v, want %v", got, want)
+	}
+	// The groups are those of CmpFull
+	full, err := CompareFilesOpt(fns, WithMethod(CmpFull), WithPartialConfig(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, full) {
+		t.Errorf("groups %v, with CmpFull %v", got, full)
+	}
+	// CmpPartial can't tell the files that differ outside the chunks apart
+	partial, err := CompareFilesOpt(fns, WithMethod(CmpPartial), WithPartialConfig(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(partial, want) {
+		t.Error("the files that differ outside the chunks have different partial checksums")
+	}
+}
+
+// Each file is read only as far as the stages that it reaches
+func TestCmpAutoStages(t *testing.T) {
+	cfg := PartialChecksumConfig{ChunkSize: 4096, FullThreshold: 3 * 4096}
+	fns, _ := autoCorpus(t, cfg)
+	rec, ctx := newRecorder()
+	if _, err := CompareFilesOptCtx(ctx, fns, WithMethod(CmpAuto), WithPartialConfig(cfg), WithKeepATime(true)); err != nil {
+		t.Fatal(err)
+	}
+	size := int64(10 * cfg.FullThreshold)
+	chunks := PartialChecksumRangesConfig(size, cfg)
+	for i, want := range [][]Range{
+		nil,
+		{{0, 9}},
+		{{0, 9}},
+		chunks,
+		{{0, size}},
+		{{0, size}},
+		{{0, size}},
+		{{0, size}},
+		{{0, 9}},
+	} {
+		if got := rec.merged(fns[i]); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: read %v, want %v", fns[i], got, want)
+		}
+	}
+	// A file that is never opened has its times untouched
+	if n := rec.chtimes[fns[0]]; n != 0 {
+		t.Errorf("file times of the file with a unique size set %d times", n)
+	}
+}
+
+// Files with different sizes are never read, so only the few files that share a
+// size with another file are hashed
+func TestCmpAutoSkipsUniqueSizes(t *testing.T) {
+	dir := t.TempDir()
+	var fns []string
+	for i := 0; i < 200; i++ {
+		fns = append(fns, writeFile(t, dir, fmt.Sprint("u", i), randomData(int64(i), 1000+i)))
+	}
+	fns = append(fns,
+		writeFile(t, dir, "a", randomData(1, 5000)),
+		writeFile(t, dir, "b", randomData(1, 5000)),
+		writeFile(t, dir, "c", randomData(2, 5000)))
+	rec, ctx := newRecorder()
+	groups, err := CompareFilesOptCtx(ctx, fns, WithMethod(CmpAuto), WithConcurrency(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 202 || !reflect.DeepEqual(groups[200], []int{200, 201}) {
+		t.Errorf("%d groups, group 200 is %v, want 202 groups and files 200 and 201 together", len(groups), groups[200])
+	}
+	if len(rec.ranges) != 3 {
+		t.Errorf("%d files read, want 3", len(rec.ranges))
+	}
+}
+
+// CmpAuto gives the groups of CmpFull, with any number of workers
+func TestCmpAutoMatchesFull(t *testing.T) {
+	fns := writeCorpus(t, t.TempDir(), 50, 3000)
+	want, err := CompareFiles(fns, CmpFull, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, workers := range []int{1, 4} {
+		got, err := CompareFilesParallel(fns, CmpAuto, workers, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%d workers: groups %v, want %v", workers, got, want)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package fcompare
+
+// cache.go - A file with the checksums of files, so unchanged files are not read again
+// Each entry holds the checksums of a file computed with one hash algorithm,
+// with the size and mtime of the file at that time. An entry is only used while
+// the file has the same size and mtime; otherwise it is replaced.
+//
+// The cache is written with Save to a temporary file that is renamed over the
+// cache file, so a reader never sees a partly written cache. Save merges the
+// entries of the file on disk that were written by other runs in the meantime,
+// so concurrent runs don't lose each other's entries, except that for the same
+// file and algorithm the last Save wins.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Version of the cache file format
+const cacheVersion = 1
+
+// Files modified less than this long ago are not cached: they could be modified
+// again without a change of their mtime (FAT stores the mtime in 2 s units)
+const cacheMinAge = 2 * time.Second
+
+// CacheEntry holds the checksums of a file with one hash algorithm
+type CacheEntry struct {
+	Path    string `json:"path,omitempty"` // absolute path
+	Algo    string `json:"algo,omitempty"`
+	Size    int64  `json:"size"`
+	MtimeNs int64  `json:"mtime_ns"`
+	Full    string `json:"full,omitempty"`
+	// Partial checksum with the given chunk size and threshold, and the checksums
+	// of its chunks, if known
+	Partial       string   `json:"partial,omitempty"`
+	PartialChunks []string `json:"partial_chunks,omitempty"`
+	ChunkSize     int64    `json:"chunk_size,omitempty"`
+	FullThreshold int64    `json:"full_threshold,omitempty"`
+	Adaptive      string   `json:"adaptive,omitempty"` // adaptive partial checksum
+}
+
+// Matches reports whether the entry was stored for a file with the given size and mtime
+func (e CacheEntry) Matches(size int64, mtimeNs int64) bool {
+	return e.Size == size && e.MtimeNs == mtimeNs
+}
+
+type cacheFile struct {
+	Version int          `json:"version"`
+	Entries []CacheEntry `json:"entries"`
+}
+
+type cacheKey struct {
+	path string
+	algo string
+}
+
+// Cache is a checksum cache file, see OpenCache. It is safe for concurrent use.
+type Cache struct {
+	path    string
+	mu      sync.Mutex
+	entries map[cacheKey]CacheEntry
+	changed map[cacheKey]bool // entries that were stored since the cache was read
+}
+
+// OpenCache reads the cache file at path. A file that doesn't exist is an empty cache.
+// Use the cache with CompareFilesCache, and write it with Save.
+func OpenCache(path string) (*Cache, error) {
+	c := &Cache{path: path, changed: make(map[cacheKey]bool)}
+	entries, err := readCacheFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c.entries = entries
+	return c, nil
+}
+
+func readCacheFile(path string) (map[cacheKey]CacheEntry, error) {
+	entries := make(map[cacheKey]CacheEntry)
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f cacheFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("checksum cache %s: %w", path, err)
+	}
+	if f.Version != cacheVersion {
+		return nil, fmt.Errorf("checksum cache %s has version %d, expected %d", path, f.Version, cacheVersion)
+	}
+	for _, e := range f.Entries {
+		entries[cacheKey{e.Path, e.Algo}] = e
+	}
+	return entries, nil
+}
+
+// Get returns the entry of a file for a hash algorithm, whether or not it matches
+// the current size and mtime of the file
+func (c *Cache) Get(path string, algo HashAlgo) (CacheEntry, bool) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return CacheEntry{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[cacheKey{abs, algo.String()}]
+	return e, ok
+}
+
+// Put stores the entry of a file for a hash algorithm; Path and Algo of e are set by Put
+func (c *Cache) Put(path string, algo HashAlgo, e CacheEntry) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return
+	}
+	e.Path, e.Algo = abs, algo.String()
+	key := cacheKey{abs, e.Algo}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = e
+	c.changed[key] = true
+}
+
+// Save writes the cache file, if entries were stored
+func (c *Cache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.changed) == 0 {
+		return nil
+	}
+	// Keep the entries that other runs stored since the cache was read
+	onDisk, err := readCacheFile(c.path)
+	if err != nil {
+		return err
+	}
+	for key, e := range onDisk {
+		if !c.changed[key] {
+			c.entries[key] = e
+		}
+	}
+	f := cacheFile{Version: cacheVersion}
+	for _, e := range c.entries {
+		f.Entries = append(f.Entries, e)
+	}
+	sort.Slice(f.Entries, func(i, j int) bool {
+		if f.Entries[i].Path != f.Entries[j].Path {
+			return f.Entries[i].Path < f.Entries[j].Path
+		}
+		return f.Entries[i].Algo < f.Entries[j].Algo
+	})
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	c.changed = make(map[cacheKey]bool)
+	return nil
+}
+
+// lookup returns the cached checksum of a file for a method, if the file didn't change
+func (c *Cache) lookup(path string, fi os.FileInfo, method CompareMethod, algo HashAlgo, partial PartialChecksumConfig) (string, bool) {
+	e, ok := c.Get(path, algo)
+	if !ok || !e.Matches(fi.Size(), fi.ModTime().UnixNano()) {
+		return "", false
+	}
+	switch method {
+	case CmpPartial:
+		if e.ChunkSize == partial.ChunkSize && e.FullThreshold == partial.FullThreshold && e.Partial != "" {
+			return e.Partial, true
+		}
+		// For small files, the partial checksum is the full checksum
+		if fi.Size() <= partial.FullThreshold {
+			return e.Full, e.Full != ""
+		}
+	case CmpPartialAdaptive:
+		// The label tells the chunk size of the regions
+		if fi.Size() <= partial.FullThreshold {
+			return e.Full, e.Full != ""
+		}
+		if strings.HasPrefix(e.Adaptive, AdaptivePartialChecksumLabel(algo, partial)) {
+			return e.Adaptive, true
+		}
+	case CmpFull:
+		return e.Full, e.Full != ""
+	}
+	return "", false
+}
+
+// record stores the checksum of a file computed with a method in its entry
+func (c *Cache) record(path string, fi os.FileInfo, method CompareMethod, algo HashAlgo, partial PartialChecksumConfig, sum string) {
+	if time.Since(fi.ModTime()) < cacheMinAge {
+		return
+	}
+	e, ok := c.Get(path, algo)
+	if !ok || !e.Matches(fi.Size(), fi.ModTime().UnixNano()) {
+		e = CacheEntry{Size: fi.Size(), MtimeNs: fi.ModTime().UnixNano()}
+	}
+	// Files up to the threshold are hashed completely
+	switch method {
+	case CmpPartial:
+		e.Partial, e.PartialChunks = sum, nil
+		e.ChunkSize, e.FullThreshold = partial.ChunkSize, partial.FullThreshold
+		if fi.Size() <= partial.FullThreshold {
+			e.Full = sum
+		}
+	case CmpPartialAdaptive:
+		if fi.Size() <= partial.FullThreshold {
+			e.Full = sum
+		} else {
+			e.Adaptive = sum
+		}
+	case CmpFull:
+		e.Full = sum
+	default:
+		return
+	}
+	c.Put(path, algo, e)
+}
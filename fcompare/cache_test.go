@@ -0,0 +1,176 @@
+package fcompare
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeOldFiles writes files with an mtime an hour ago, so that they are cached
+func writeOldFiles(t *testing.T, dir string, n int) []string {
+	t.Helper()
+	old := time.Now().Add(-time.Hour)
+	var fns []string
+	for i := 0; i < n; i++ {
+		fn := writeFile(t, dir, fmt.Sprint("f", i), randomData(int64(i%3), 50000))
+		if err := os.Chtimes(fn, old, old); err != nil {
+			t.Fatal(err)
+		}
+		fns = append(fns, fn)
+	}
+	return fns
+}
+
+// A second run with a saved cache reads nothing when no file changed
+func TestCacheSecondRunReadsNothing(t *testing.T) {
+	dir := t.TempDir()
+	fns := writeOldFiles(t, dir, 6)
+	var cacheFn string
+	for _, method := range []CompareMethod{CmpPartial, CmpPartialAdaptive, CmpFull, CmpAuto} {
+		cacheFn = filepath.Join(t.TempDir(), "cache.json")
+		var first [][]int
+		for run := 0; run < 2; run++ {
+			cache, err := OpenCache(cacheFn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec, ctx := newRecorder()
+			groups, err := CompareFilesOptCtx(ctx, fns, WithMethod(method), WithCache(cache))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := cache.Save(); err != nil {
+				t.Fatal(err)
+			}
+			if run == 0 {
+				first = groups
+				if len(rec.ranges) == 0 {
+					t.Errorf("method %d: the first run read nothing", method)
+				}
+				continue
+			}
+			if !reflect.DeepEqual(groups, first) {
+				t.Errorf("method %d: groups %v, first run %v", method, groups, first)
+			}
+			if len(rec.ranges) != 0 {
+				t.Errorf("method %d: the second run read %v", method, rec.ranges)
+			}
+		}
+	}
+
+	// A file with another mtime is read again, and only that file
+	newer := time.Now().Add(-time.Minute)
+	if err := os.Chtimes(fns[2], newer, newer); err != nil {
+		t.Fatal(err)
+	}
+	cache, err := OpenCache(cacheFn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, ctx := newRecorder()
+	if _, err := CompareFilesOptCtx(ctx, fns, WithMethod(CmpFull), WithCache(cache)); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.ranges) != 1 || len(rec.ranges[fns[2]]) == 0 {
+		t.Errorf("read %v after changing the mtime of %s", rec.ranges, fns[2])
+	}
+}
+
+// Files that were just modified are not cached, since their mtime may not change
+// with the next modification
+func TestCacheRecentFiles(t *testing.T) {
+	fn := writeFile(t, t.TempDir(), "new", []byte("data"))
+	cache, err := OpenCache(filepath.Join(t.TempDir(), "cache.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CompareFilesCache([]string{fn}, CmpFull, HashSHA256, cache, false, false); err != nil {
+		t.Fatal(err)
+	}
+	if e, ok := cache.Get(fn, HashSHA256); ok {
+		t.Errorf("new file cached: %+v", e)
+	}
+}
+
+// Saves of caches that were opened at the same time keep the entries of both
+func TestCacheConcurrentSaves(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "cache.json")
+	a, err := OpenCache(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := OpenCache(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Put("/a", HashSHA256, CacheEntry{Size: 1, Full: "aa"})
+	b.Put("/b", HashMD5, CacheEntry{Size: 2, Full: "bb"})
+	if err := a.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Save(); err != nil {
+		t.Fatal(err)
+	}
+	c, err := OpenCache(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, ok := c.Get("/a", HashSHA256); !ok || e.Full != "aa" {
+		t.Errorf("entry of the first save: %+v, %v", e, ok)
+	}
+	if e, ok := c.Get("/b", HashMD5); !ok || e.Full != "bb" {
+		t.Errorf("entry of the second save: %+v, %v", e, ok)
+	}
+
+	// However saves interleave, the cache file is always complete
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				cache, err := OpenCache(fn)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				cache.Put(fmt.Sprintf("/f%d-%d", i, j), HashSHA256, CacheEntry{Size: int64(j), Full: "x"})
+				if err := cache.Save(); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if _, err := OpenCache(fn); err != nil {
+		t.Errorf("cache after concurrent saves: %v", err)
+	}
+	if tmp, _ := filepath.Glob(fn + ".tmp*"); len(tmp) != 0 {
+		t.Errorf("temporary files left: %v", tmp)
+	}
+}
+
+// An adaptive checksum with other regions than those of the config is not used
+func TestCacheAdaptiveChunkSize(t *testing.T) {
+	fns := writeOldFiles(t, t.TempDir(), 2)
+	cache, err := OpenCache(filepath.Join(t.TempDir(), "cache.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	small := PartialChecksumConfig{ChunkSize: 4096, FullThreshold: 3 * 4096}
+	for _, cfg := range []PartialChecksumConfig{small, {ChunkSize: 8192, FullThreshold: 3 * 8192}, small} {
+		rec, ctx := newRecorder()
+		if _, err := CompareFilesOptCtx(ctx, fns, WithMethod(CmpPartialAdaptive), WithPartialConfig(cfg), WithCache(cache)); err != nil {
+			t.Fatal(err)
+		}
+		// The second config replaces the checksums of the first
+		if len(rec.ranges) != len(fns) {
+			t.Errorf("chunk size %d: read %d files, want %d", cfg.ChunkSize, len(rec.ranges), len(fns))
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package fcompare
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Files that differ only in the last megabyte differ only in the tail chunk
+func TestPartialChecksumResultTail(t *testing.T) {
+	dir := t.TempDir()
+	const size = 20 << 20
+	head := map[int64][]byte{0: []byte("<?xml"), size / 2: []byte("spectrum")}
+	a := writeSparseFile(t, dir, "a", size, head)
+	head[size-1000] = []byte("index")
+	b := writeSparseFile(t, dir, "b", size, head)
+
+	resA, err := GetPartialChecksumResult(a, HashSHA256, DefaultPartialChecksumConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resB, err := GetPartialChecksumResult(b, HashSHA256, DefaultPartialChecksumConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resA.IsFull || len(resA.Chunks) != 3 || len(resB.Chunks) != 3 {
+		t.Fatalf("results %+v and %+v, want 3 chunks", resA, resB)
+	}
+	for i, name := range []string{"head", "middle", "tail"} {
+		if differ := resA.Chunks[i].Checksum != resB.Chunks[i].Checksum; differ != (name == "tail") {
+			t.Errorf("%s chunks differ: %v", name, differ)
+		}
+		if resA.Chunks[i].Range != resB.Chunks[i].Range {
+			t.Errorf("%s chunks at %v and %v", name, resA.Chunks[i].Range, resB.Chunks[i].Range)
+		}
+	}
+	if resA.Checksum == resB.Checksum {
+		t.Error("the combined checksums are equal")
+	}
+	var ranges []Range
+	for _, c := range resA.Chunks {
+		ranges = append(ranges, c.Range)
+	}
+	if want := PartialChecksumRanges(size); !reflect.DeepEqual(ranges, want) {
+		t.Errorf("chunks at %v, want %v", ranges, want)
+	}
+	// The combined checksum is the one of GetPartialChecksum
+	if sum, _, _ := GetPartialChecksum(a); sum != resA.Checksum {
+		t.Errorf("GetPartialChecksum = %s, want %s", sum, resA.Checksum)
+	}
+}
+
+// Files up to the threshold are hashed completely, without chunks
+func TestPartialChecksumResultSmall(t *testing.T) {
+	fn := writeFile(t, t.TempDir(), "f", randomData(6, 1000))
+	res, err := GetPartialChecksumResult(fn, HashSHA256, DefaultPartialChecksumConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := GetChecksum(fn)
+	if !res.IsFull || res.Checksum != want || len(res.Chunks) != 0 {
+		t.Errorf("result %+v, want the full checksum %s without chunks", res, want)
+	}
+}
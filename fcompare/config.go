@@ -0,0 +1,30 @@
+package fcompare
+
+import "fmt"
+
+// PartialChecksumConfig sets the regions that partial checksums read.
+// Larger chunks are more efficient on disks with slow seeks, smaller chunks
+// on slow networks.
+type PartialChecksumConfig struct {
+	// Size of each of the first, middle and last chunk of the file
+	ChunkSize int64
+	// Files up to this size are hashed completely; must be at least 3 chunks
+	FullThreshold int64
+}
+
+// DefaultPartialChecksumConfig are the chunk size and threshold of GetPartialChecksum
+var DefaultPartialChecksumConfig = PartialChecksumConfig{
+	ChunkSize:     1024 * 1024,
+	FullThreshold: minPartialChecksumSize,
+}
+
+// Validate returns an error if the chunks of the config could overlap
+func (c PartialChecksumConfig) Validate() error {
+	if c.ChunkSize <= 0 || c.FullThreshold <= 0 {
+		return fmt.Errorf("chunk size (%d) and full-read threshold (%d) must be positive", c.ChunkSize, c.FullThreshold)
+	}
+	if c.FullThreshold < 3*c.ChunkSize {
+		return fmt.Errorf("full-read threshold (%d) must be at least 3 times the chunk size (%d)", c.FullThreshold, c.ChunkSize)
+	}
+	return nil
+}
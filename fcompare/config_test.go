@@ -0,0 +1,107 @@
+package fcompare
+
+import (
+	"testing"
+)
+
+func TestPartialChecksumConfigValidate(t *testing.T) {
+	for _, cfg := range []PartialChecksumConfig{
+		{0, 16 << 20},
+		{1 << 20, 0},
+		{-1, 16 << 20},
+		{1 << 20, -1},
+		{1 << 20, 3<<20 - 1},
+	} {
+		if cfg.Validate() == nil {
+			t.Errorf("%+v: no error", cfg)
+		}
+	}
+	for _, cfg := range []PartialChecksumConfig{DefaultPartialChecksumConfig, {256 << 10, 8 << 20}, {1, 3}} {
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("%+v: %v", cfg, err)
+		}
+	}
+}
+
+func TestInvalidConfigError(t *testing.T) {
+	fn := writeFile(t, t.TempDir(), "f", randomData(1, 1000))
+	bad := PartialChecksumConfig{ChunkSize: 1000, FullThreshold: 2000}
+	if _, _, err := GetPartialChecksumConfig(fn, HashSHA256, bad); err == nil {
+		t.Error("GetPartialChecksumConfig: no error")
+	}
+	if _, err := CompareFilesConfig([]string{fn}, CmpPartial, HashSHA256, bad, false, false); err == nil {
+		t.Error("CompareFilesConfig: no error")
+	}
+}
+
+// Chunk sizes give different, but stable digests, and files up to the threshold are read completely
+func TestPartialChecksumConfigs(t *testing.T) {
+	const size = 4 << 20
+	data := randomData(3, size)
+	fn := writeFile(t, t.TempDir(), "f", data)
+	full, err := GetChecksum(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]PartialChecksumConfig)
+	for _, tc := range []struct {
+		cfg    PartialChecksumConfig
+		isFull bool
+	}{
+		{PartialChecksumConfig{256 << 10, 1 << 20}, false},
+		{PartialChecksumConfig{512 << 10, 2 << 20}, false},
+		{PartialChecksumConfig{1 << 20, 3 << 20}, false},
+		{PartialChecksumConfig{1 << 20, size - 1}, false},
+		{PartialChecksumConfig{1 << 20, size}, true},
+		{DefaultPartialChecksumConfig, true},
+	} {
+		sum, isFull, err := GetPartialChecksumConfig(fn, HashSHA256, tc.cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isFull != tc.isFull {
+			t.Errorf("%+v: isFull %v, want %v", tc.cfg, isFull, tc.isFull)
+		}
+		if isFull != (sum == full) {
+			t.Errorf("%+v: checksum %s, full checksum %s", tc.cfg, sum, full)
+		}
+		again, _, _ := GetPartialChecksumConfig(fn, HashSHA256, tc.cfg)
+		if again != sum {
+			t.Errorf("%+v: checksums %s and %s of the same file", tc.cfg, sum, again)
+		}
+		// The threshold only decides whether the file is read completely
+		if prev, ok := seen[sum]; ok && !isFull && prev.ChunkSize != tc.cfg.ChunkSize {
+			t.Errorf("%+v and %+v give the same checksum", tc.cfg, prev)
+		}
+		seen[sum] = tc.cfg
+	}
+	// The default config is what GetPartialChecksum uses
+	sum, _, _ := GetPartialChecksum(fn)
+	if want, _, _ := GetPartialChecksumConfig(fn, HashSHA256, DefaultPartialChecksumConfig); sum != want {
+		t.Errorf("GetPartialChecksum = %s, want %s", sum, want)
+	}
+}
+
+// Files that differ outside the chunks of a small chunk size are told apart by a larger one
+func TestCompareFilesConfig(t *testing.T) {
+	dir := t.TempDir()
+	data := randomData(4, 4<<20)
+	a := writeFile(t, dir, "a", data)
+	data[1<<20] ^= 1 // after the first chunk of 256K, before the one in the middle
+	b := writeFile(t, dir, "b", data)
+	for _, tc := range []struct {
+		cfg    PartialChecksumConfig
+		groups int
+	}{
+		{PartialChecksumConfig{256 << 10, 1 << 20}, 1},
+		{PartialChecksumConfig{2 << 20, 6 << 20}, 2},
+	} {
+		groups, err := CompareFilesConfig([]string{a, b}, CmpPartial, HashSHA256, tc.cfg, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(groups) != tc.groups {
+			t.Errorf("%+v: groups %v, want %d groups", tc.cfg, groups, tc.groups)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package fcompare
+
+import (
+	"context"
+	"io"
+)
+
+// ctxReader returns the error of its context once it is cancelled.
+// io.Copy reads in chunks of 32K, so hashing stops promptly.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// CompareFilesCtx is CompareFiles, but stops when ctx is cancelled or its deadline
+// passes, and then returns ctx.Err() with the groups of the files that were
+// processed before. File times are restored in any case.
+func CompareFilesCtx(ctx context.Context, fns []string, method CompareMethod, keepATime bool, checkKeepAtime bool) ([][]int, error) {
+	return CompareFilesOptCtx(ctx, fns, WithMethod(method), WithKeepATime(keepATime), WithCheckKeepAtime(checkKeepAtime))
+}
+
+// GetChecksumCtx is GetChecksum, but stops when ctx is cancelled or its deadline passes
+func GetChecksumCtx(ctx context.Context, filename string) (string, error) {
+	return getChecksum(ctx, filename, HashSHA256)
+}
+
+// GetPartialChecksumCtx is GetPartialChecksum, but stops when ctx is cancelled or its deadline passes
+func GetPartialChecksumCtx(ctx context.Context, filename string) (string, bool, error) {
+	return getPartialChecksum(ctx, filename, HashSHA256, DefaultPartialChecksumConfig)
+}
+
+// GetChecksumHashCtx is GetChecksumHash, but stops when ctx is cancelled or its deadline passes
+func GetChecksumHashCtx(ctx context.Context, filename string, algo HashAlgo) (string, error) {
+	return getChecksum(ctx, filename, algo)
+}
+
+// GetPartialChecksumResultCtx is GetPartialChecksumResult, but stops when ctx is
+// cancelled or its deadline passes
+func GetPartialChecksumResultCtx(ctx context.Context, filename string, algo HashAlgo, cfg PartialChecksumConfig) (PartialChecksumResult, error) {
+	return getPartialChecksumResult(ctx, filename, algo, cfg, true)
+}
+
+// GetAdaptivePartialChecksumCtx is GetAdaptivePartialChecksumConfig, but stops when
+// ctx is cancelled or its deadline passes
+func GetAdaptivePartialChecksumCtx(ctx context.Context, filename string, algo HashAlgo, cfg PartialChecksumConfig) (string, bool, error) {
+	return getAdaptivePartialChecksum(ctx, filename, algo, cfg)
+}
+
+// GetRangeChecksumCtx is GetRangeChecksumHash, but stops when ctx is cancelled or its deadline passes
+func GetRangeChecksumCtx(ctx context.Context, filename string, algo HashAlgo, offset int64, length int64) (string, error) {
+	return getRangeChecksum(ctx, filename, algo, offset, length)
+}
+
+// EqualContentsCtx is EqualContents, but stops when ctx is cancelled or its deadline
+// passes, and only restores the file times if keepATime is set
+func EqualContentsCtx(ctx context.Context, a, b string, keepATime bool) (bool, int64, error) {
+	return equalContents(ctx, a, b, keepATime)
+}
@@ -0,0 +1,114 @@
+package fcompare
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/djherbis/atime"
+)
+
+// bigFile returns a sparse file that takes at least a few hundred ms to hash
+func bigFile(t *testing.T) string {
+	t.Helper()
+	return writeSparseFile(t, t.TempDir(), "big", 512<<20, map[int64][]byte{0: []byte("head"), 300 << 20: []byte("middle")})
+}
+
+// checkCancelled runs f, cancels its context after a short time, and checks that f
+// returns context.Canceled within a bounded time after that
+func checkCancelled(t *testing.T, what string, f func(ctx context.Context) error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var cancelled time.Time
+	timer := time.AfterFunc(20*time.Millisecond, func() {
+		cancelled = time.Now()
+		cancel()
+	})
+	defer timer.Stop()
+	err := f(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("%s: error %v, want context.Canceled", what, err)
+	}
+	if d := time.Since(cancelled); d > time.Second {
+		t.Errorf("%s: returned %v after the cancellation", what, d)
+	}
+}
+
+func TestCancelMidHash(t *testing.T) {
+	fn := bigFile(t)
+	checkCancelled(t, "GetChecksumCtx", func(ctx context.Context) error {
+		_, err := GetChecksumCtx(ctx, fn)
+		return err
+	})
+	checkCancelled(t, "GetChecksumHashCtx", func(ctx context.Context) error {
+		_, err := GetChecksumHashCtx(ctx, fn, HashBLAKE3)
+		return err
+	})
+	// A partial checksum of the whole file is a full read
+	cfg := PartialChecksumConfig{ChunkSize: 256 << 20, FullThreshold: 1 << 30}
+	checkCancelled(t, "GetPartialChecksumResultCtx", func(ctx context.Context) error {
+		_, err := GetPartialChecksumResultCtx(ctx, fn, HashSHA256, cfg)
+		return err
+	})
+	checkCancelled(t, "CompareFilesCtx", func(ctx context.Context) error {
+		_, err := CompareFilesCtx(ctx, []string{fn, fn}, CmpFull, false, false)
+		return err
+	})
+}
+
+// The access time of a file is restored when hashing is cancelled
+func TestCancelRestoresTimes(t *testing.T) {
+	fn := bigFile(t)
+	aTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	mTime := aTime.Add(-time.Hour)
+	if err := os.Chtimes(fn, aTime, mTime); err != nil {
+		t.Fatal(err)
+	}
+	checkCancelled(t, "CompareFilesCtx", func(ctx context.Context) error {
+		_, err := CompareFilesCtx(ctx, []string{fn}, CmpFull, true, false)
+		return err
+	})
+	got, err := atime.Stat(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(aTime) || !fi.ModTime().Equal(mTime) {
+		t.Errorf("times after cancellation: atime %v, mtime %v, want %v, %v", got, fi.ModTime(), aTime, mTime)
+	}
+}
+
+func TestDeadlineMidHash(t *testing.T) {
+	fn := bigFile(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := GetChecksumCtx(ctx, fn); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error %v, want context.DeadlineExceeded", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("returned after %v", d)
+	}
+}
+
+// A context that is never cancelled gives the same checksum as the plain function
+func TestChecksumCtx(t *testing.T) {
+	fn := writeFile(t, t.TempDir(), "f", randomData(2, 100000))
+	want, err := GetChecksum(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := GetChecksumCtx(context.Background(), fn); err != nil || got != want {
+		t.Errorf("GetChecksumCtx = %s, %v, want %s", got, err, want)
+	}
+	wantPartial, _, _ := GetPartialChecksum(fn)
+	if got, _, err := GetPartialChecksumCtx(context.Background(), fn); err != nil || got != wantPartial {
+		t.Errorf("GetPartialChecksumCtx = %s, %v, want %s", got, err, wantPartial)
+	}
+}
@@ -0,0 +1,59 @@
+package fcompare
+
+// detailed.go - Groups of files with the checksum that they share
+// CompareFiles only returns the indexes of the files in each group, but the
+// checksums have been computed anyway. CompareFilesDetailed returns them, so a
+// dedup tool can show "these 5 files share sha256 abc123" without hashing again.
+
+import (
+	"context"
+	"strconv"
+	"strings"
+)
+
+// DetailedGroup is a group of files that are the same, with what they share
+type DetailedGroup struct {
+	// The checksum that the files share, in hex, or their size with CmpSize.
+	// Empty with CmpBytes, where files are compared byte by byte. With CmpPartial,
+	// the checksum of files up to the threshold is their full checksum.
+	Key string
+	// The method by which the files were found to be the same. With CmpAuto, the
+	// last stage that was needed: CmpSize for a file with a unique size, CmpFull
+	// for files that were hashed completely, and CmpPartial otherwise.
+	Method  CompareMethod
+	Indices []int // indexes into the list of files, in increasing order
+}
+
+// CompareFilesDetailed is CompareFilesOpt, but returns the groups with the checksum
+// (or size) that their files share. Groups are ordered by their first file.
+// As with CompareFiles, files that can't be read are reported in a *CompareError.
+func CompareFilesDetailed(fns []string, opts ...Option) ([]DetailedGroup, error) {
+	return CompareFilesDetailedCtx(context.Background(), fns, opts...)
+}
+
+// CompareFilesDetailedCtx is CompareFilesDetailed, but stops when ctx is cancelled, like CompareFilesCtx
+func CompareFilesDetailedCtx(ctx context.Context, fns []string, opts ...Option) ([]DetailedGroup, error) {
+	return compareGroups(ctx, fns, configOf(opts))
+}
+
+// describeKey returns the method and the shared checksum (or size) of a key of the
+// pool. Keys are the results of the stages that a file went through, each preceded
+// by a slash; with CmpBytes, the size and the index of the first file of the group.
+func (p *pool) describeKey(k string) (CompareMethod, string) {
+	parts := strings.Split(strings.TrimPrefix(k, "/"), "/")
+	switch p.cfg.method {
+	case CmpBytes:
+		return CmpBytes, ""
+	case CmpAuto:
+		stages := []CompareMethod{CmpSize, CmpPartial, CmpFull}
+		method := stages[min(len(parts), len(stages))-1]
+		// Files up to the threshold are completely hashed by the partial stage
+		if method == CmpPartial {
+			if size, err := strconv.ParseInt(parts[0], 10, 64); err == nil && size <= p.cfg.partial.FullThreshold {
+				method = CmpFull
+			}
+		}
+		return method, parts[len(parts)-1]
+	}
+	return p.cfg.method, parts[0]
+}
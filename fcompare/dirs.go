@@ -0,0 +1,127 @@
+package fcompare
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"sort"
+)
+
+// DirOptions holds the settings of CompareDirs
+type DirOptions struct {
+	Method    CompareMethod
+	Algo      HashAlgo
+	Workers   int // number of files hashed at the same time (default 1)
+	KeepATime bool
+	Cache     *Cache // if not nil, see CompareFilesCache
+}
+
+// DirReport is the result of CompareDirs. All paths are relative to the
+// directories, with forward slashes, and sorted.
+type DirReport struct {
+	Identical []string
+	Different []string
+	OnlyInA   []string
+	OnlyInB   []string
+	// Pairs that could not be compared; Path is the relative path, and Index
+	// is the index of the pair in Failed
+	Failed []FailedFile
+}
+
+// CompareDirs compares the regular files under dirA with those at the same
+// relative path under dirB. Subdirectories, like Bruker .d directories, are
+// compared file by file. Symlinks are not followed.
+// Files that can't be read are reported in DirReport.Failed; only an error
+// walking the directories is returned as error.
+func CompareDirs(dirA, dirB string, opts DirOptions) (DirReport, error) {
+	var report DirReport
+	filesA, err := dirFiles(dirA)
+	if err != nil {
+		return report, err
+	}
+	filesB, err := dirFiles(dirB)
+	if err != nil {
+		return report, err
+	}
+
+	// Both files of each pair are compared in a single run, so that files of
+	// different sizes are never opened with CmpAuto
+	var rels []string
+	var fns []string
+	for rel := range filesA {
+		if !filesB[rel] {
+			report.OnlyInA = append(report.OnlyInA, rel)
+			continue
+		}
+		rels = append(rels, rel)
+	}
+	for rel := range filesB {
+		if !filesA[rel] {
+			report.OnlyInB = append(report.OnlyInB, rel)
+		}
+	}
+	sort.Strings(rels)
+	sort.Strings(report.OnlyInA)
+	sort.Strings(report.OnlyInB)
+	for _, rel := range rels {
+		fns = append(fns, filepath.Join(dirA, filepath.FromSlash(rel)), filepath.Join(dirB, filepath.FromSlash(rel)))
+	}
+
+	groups, err := compareFiles(context.Background(), fns, compareConfig{method: opts.Method, algo: opts.Algo,
+		workers: max(opts.Workers, 1), keepATime: opts.KeepATime, cache: opts.Cache})
+	var cmpErr *CompareError
+	if err != nil && !errors.As(err, &cmpErr) {
+		return report, err
+	}
+	groupOf := make([]int, len(fns))
+	for i := range groupOf {
+		groupOf[i] = -1
+	}
+	for g, group := range groups {
+		for _, i := range group {
+			groupOf[i] = g
+		}
+	}
+	failed := make(map[int]error)
+	if cmpErr != nil {
+		for _, f := range cmpErr.Failed {
+			// Only the first error of a pair is reported
+			if _, ok := failed[f.Index/2]; !ok {
+				failed[f.Index/2] = f.Err
+			}
+		}
+	}
+	for i, rel := range rels {
+		if err, ok := failed[i]; ok {
+			report.Failed = append(report.Failed, FailedFile{Index: len(report.Failed), Path: rel, Err: err})
+			continue
+		}
+		if groupOf[2*i] == groupOf[2*i+1] {
+			report.Identical = append(report.Identical, rel)
+		} else {
+			report.Different = append(report.Different, rel)
+		}
+	}
+	return report, nil
+}
+
+// dirFiles returns the relative paths, with forward slashes, of all regular files under dir
+func dirFiles(dir string) (map[string]bool, error) {
+	files := make(map[string]bool)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = true
+		return nil
+	})
+	return files, err
+}
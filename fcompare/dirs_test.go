@@ -0,0 +1,92 @@
+package fcompare
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/djherbis/atime"
+)
+
+// writeTree writes files with the given content, by relative path with slashes
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for rel, data := range files {
+		fn := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(fn), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// A Bruker .d directory, and a file at the top
+var brukerTree = map[string]string{
+	"sample.d/analysis.tdf":     "tdf",
+	"sample.d/analysis.tdf_bin": "binary data",
+	"sample.d/method.m/a.xml":   "<method/>",
+	"run.mzML":                  "<mzML/>",
+}
+
+func TestCompareDirs(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		change func(files map[string]string)
+		want   DirReport
+	}{
+		{"identical", func(map[string]string) {}, DirReport{
+			Identical: []string{"run.mzML", "sample.d/analysis.tdf", "sample.d/analysis.tdf_bin", "sample.d/method.m/a.xml"}}},
+		{"extra file", func(f map[string]string) { f["sample.d/extra.txt"] = "x" }, DirReport{
+			Identical: []string{"run.mzML", "sample.d/analysis.tdf", "sample.d/analysis.tdf_bin", "sample.d/method.m/a.xml"},
+			OnlyInB:   []string{"sample.d/extra.txt"}}},
+		{"missing file", func(f map[string]string) { delete(f, "sample.d/method.m/a.xml") }, DirReport{
+			Identical: []string{"run.mzML", "sample.d/analysis.tdf", "sample.d/analysis.tdf_bin"},
+			OnlyInA:   []string{"sample.d/method.m/a.xml"}}},
+		{"modified file", func(f map[string]string) { f["sample.d/analysis.tdf_bin"] = "binary dat4" }, DirReport{
+			Identical: []string{"run.mzML", "sample.d/analysis.tdf", "sample.d/method.m/a.xml"},
+			Different: []string{"sample.d/analysis.tdf_bin"}}},
+	} {
+		dirA, dirB := t.TempDir(), t.TempDir()
+		writeTree(t, dirA, brukerTree)
+		filesB := make(map[string]string)
+		for k, v := range brukerTree {
+			filesB[k] = v
+		}
+		tc.change(filesB)
+		writeTree(t, dirB, filesB)
+		for _, method := range []CompareMethod{CmpFull, CmpAuto, CmpBytes} {
+			got, err := CompareDirs(dirA, dirB, DirOptions{Method: method, Workers: 2})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("%s, method %d: report %+v, want %+v", tc.name, method, got, tc.want)
+			}
+		}
+	}
+	if _, err := CompareDirs(t.TempDir(), filepath.Join(t.TempDir(), "missing"), DirOptions{Method: CmpFull}); err == nil {
+		t.Error("missing directory: no error")
+	}
+}
+
+// With KeepATime, the access times of the compared files are restored
+func TestCompareDirsKeepATime(t *testing.T) {
+	dirA, dirB := t.TempDir(), t.TempDir()
+	writeTree(t, dirA, brukerTree)
+	writeTree(t, dirB, brukerTree)
+	old := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	fn := filepath.Join(dirB, "run.mzML")
+	if err := os.Chtimes(fn, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CompareDirs(dirA, dirB, DirOptions{Method: CmpFull, KeepATime: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := atime.Stat(fn); err != nil || !got.Equal(old) {
+		t.Errorf("access time %v, %v, want %v", got, err, old)
+	}
+}
@@ -0,0 +1,39 @@
+package fcompare
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// Five unique files and one pair of duplicates give exactly one group
+func TestCompareFilesDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	unique := func(i int) string {
+		return writeFile(t, dir, fmt.Sprint("unique", i), randomData(int64(i), 1000))
+	}
+	fns := []string{unique(0), unique(1), writeFile(t, dir, "dup-a", randomData(9, 1000)),
+		unique(2), unique(3), unique(4), writeFile(t, dir, "dup-b", randomData(9, 1000))}
+	want := [][]int{{2, 6}}
+	for _, method := range []CompareMethod{CmpPartial, CmpFull, CmpPartialAdaptive, CmpAuto, CmpBytes} {
+		got, err := CompareFilesDuplicates(fns, method, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("method %d: groups %v, want %v", method, got, want)
+		}
+		got, err = CompareFilesOpt(fns, WithMethod(method), WithConcurrency(4), WithOnlyDuplicates(true))
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("method %d with 4 workers: groups %v, %v, want %v", method, got, err, want)
+		}
+		// By default, every file is in a group
+		all, err := CompareFiles(fns, method, false, false)
+		if err != nil || len(all) != 6 {
+			t.Errorf("method %d without the option: groups %v, %v, want 6 groups", method, all, err)
+		}
+	}
+	if got, err := CompareFilesDuplicates(fns[:2], CmpFull, false, false); err != nil || len(got) != 0 {
+		t.Errorf("unique files only: groups %v, %v, want none", got, err)
+	}
+}
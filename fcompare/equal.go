@@ -0,0 +1,97 @@
+package fcompare
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+
+	"github.com/djherbis/atime"
+)
+
+// Size of the blocks that EqualContents compares
+const equalBlockSize = 1024 * 1024
+
+// EqualContents compares two files byte by byte, and stops at the first block
+// that differs. It returns the offset of the first byte that differs, or -1 if
+// the files are equal or have different sizes; files of different sizes are not read.
+// The file times of both files are restored.
+func EqualContents(a, b string) (bool, int64, error) {
+	return equalContents(context.Background(), a, b, true)
+}
+
+// equalContents is EqualContents, which restores the file times only if keepATime is set
+func equalContents(ctx context.Context, a, b string, keepATime bool) (equal bool, diffAt int64, err error) {
+	fiA, err := os.Stat(a)
+	if err != nil {
+		return false, -1, err
+	}
+	fiB, err := os.Stat(b)
+	if err != nil {
+		return false, -1, err
+	}
+	if fiA.Size() != fiB.Size() {
+		return false, -1, nil
+	}
+	if keepATime {
+		defer func() {
+			if rerr := restoreTimesAfterRead(ctx, a, atime.Get(fiA), fiA.ModTime()); rerr != nil && err == nil {
+				equal, diffAt, err = false, -1, rerr
+			}
+			if rerr := restoreTimesAfterRead(ctx, b, atime.Get(fiB), fiB.ModTime()); rerr != nil && err == nil {
+				equal, diffAt, err = false, -1, rerr
+			}
+		}()
+	}
+
+	fa, err := OpenObserved(ctx, a)
+	if err != nil {
+		return false, -1, err
+	}
+	defer fa.Close()
+	fb, err := OpenObserved(ctx, b)
+	if err != nil {
+		return false, -1, err
+	}
+	defer fb.Close()
+
+	bufA := make([]byte, equalBlockSize)
+	bufB := make([]byte, equalBlockSize)
+	ra := &ctxReader{ctx, fa}
+	rb := &ctxReader{ctx, fb}
+	var offset int64
+	for {
+		// Read the blocks of both files at the same time
+		var nb int
+		var errB error
+		done := make(chan struct{})
+		go func() {
+			nb, errB = io.ReadFull(rb, bufB)
+			close(done)
+		}()
+		na, errA := io.ReadFull(ra, bufA)
+		<-done
+		if errA != nil && errA != io.EOF && errA != io.ErrUnexpectedEOF {
+			return false, -1, errA
+		}
+		if errB != nil && errB != io.EOF && errB != io.ErrUnexpectedEOF {
+			return false, -1, errB
+		}
+		n := min(na, nb)
+		if !bytes.Equal(bufA[:n], bufB[:n]) {
+			for i := 0; i < n; i++ {
+				if bufA[i] != bufB[i] {
+					return false, offset + int64(i), nil
+				}
+			}
+		}
+		// A file that changed size while it was read
+		if na != nb {
+			return false, offset + int64(n), nil
+		}
+		if na < equalBlockSize {
+			return true, -1, nil
+		}
+		offset += int64(na)
+	}
+}
@@ -0,0 +1,60 @@
+package fcompare
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Files that can't be read give an error instead of ending the process
+func TestUnreadableFile(t *testing.T) {
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing")
+	if _, _, err := GetPartialChecksum(missing); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("GetPartialChecksum: error %v, want ErrNotExist", err)
+	}
+	if _, err := GetChecksum(missing); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("GetChecksum: error %v, want ErrNotExist", err)
+	}
+	// A directory can be opened, but not read
+	if _, err := GetChecksum(dir); err == nil {
+		t.Error("GetChecksum of a directory: no error")
+	}
+	if os.Geteuid() != 0 {
+		fn := writeFile(t, dir, "unreadable", []byte("data"))
+		if err := os.Chmod(fn, 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := GetChecksum(fn); !errors.Is(err, fs.ErrPermission) {
+			t.Errorf("GetChecksum of an unreadable file: error %v, want ErrPermission", err)
+		}
+	}
+	fn := writeFile(t, dir, "a", []byte("data"))
+	for _, method := range []CompareMethod{CmpSize, CmpPartial, CmpFull, CmpPartialAdaptive, CmpAuto, CmpBytes} {
+		if _, err := CompareFiles([]string{fn, missing}, method, false, false); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("CompareFiles method %d with a missing file: error %v, want ErrNotExist", method, err)
+		}
+	}
+}
+
+func TestInvalidMethod(t *testing.T) {
+	fn := writeFile(t, t.TempDir(), "a", []byte("data"))
+	for _, method := range []CompareMethod{-1, CmpBytes + 1, 1000} {
+		if _, err := CompareFiles([]string{fn, fn}, method, false, false); !errors.Is(err, ErrInvalidMethod) {
+			t.Errorf("CompareFiles method %d: error %v, want ErrInvalidMethod", method, err)
+		}
+		// The method is checked before any file, so a missing file doesn't hide the error
+		if _, err := CompareFiles([]string{"missing"}, method, false, true); !errors.Is(err, ErrInvalidMethod) {
+			t.Errorf("CompareFiles method %d of a missing file: error %v, want ErrInvalidMethod", method, err)
+		}
+		if _, err := processFile(context.Background(), fn, method, HashSHA256, DefaultPartialChecksumConfig, false, nil); !errors.Is(err, ErrInvalidMethod) {
+			t.Errorf("processFile method %d: error %v, want ErrInvalidMethod", method, err)
+		}
+	}
+	if _, err := CompareFiles(nil, CmpFull, false, true); err != nil {
+		t.Errorf("CompareFiles of no files: %v", err)
+	}
+}
@@ -1,14 +1,19 @@
 package fcompare
 
 import (
-	"crypto/sha256"
+	"context"
 	"encoding/hex"
 	"errors"
+	"fmt"
+	"hash"
 	"io"
-	"log"
+	"math/bits"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/djherbis/atime"
@@ -25,15 +30,47 @@ const (
 	CmpSize CompareMethod = iota
 	CmpPartial
 	CmpFull
+	CmpPartialAdaptive
+	// CmpAuto compares sizes first, then partial checksums of files with the same size,
+	// then full checksums of files with the same partial checksum. The groups are
+	// the same as with CmpFull, but files with a unique size are never opened.
+	CmpAuto
+	// CmpBytes compares the contents of files of the same size byte by byte, and
+	// stops at the first difference. Nothing is hashed, so the files of a group
+	// are compared one by one with the first file of each existing group.
+	CmpBytes
+)
+
+// ErrInvalidMethod is returned for a CompareMethod that is not one of the constants above
+var ErrInvalidMethod = errors.New("invalid compare method")
+
+func (m CompareMethod) valid() bool {
+	return m >= CmpSize && m <= CmpBytes
+}
+
+// The adaptive partial checksum samples more regions as files grow, so that
+// huge files are not fingerprinted by only 3 MB of data.
+// The scheme version is part of the returned label; if the region layout is
+// ever changed, bump the version so that stored values are not silently compared
+// against digests computed with a different layout.
+const (
+	adaptiveSchemeVersion = 1
+	adaptiveMinRegions    = 3
+	adaptiveMaxRegions    = 16
 )
 
 // Check if we can keep the atime (access time) of files
 // For this, we assume that we can set the atime if we can
 // create a new file in the same directory as the given file,
-// and if we can set it's atime
+// and if we can set it's atime.
+// On filesystems that are mounted read-only or with noatime (Linux), reading
+// doesn't change the atime, so there is nothing to restore, and it returns true.
 func TestKeepAtime(fn string) (bool, error) {
 	// Get directory of file
 	dir := filepath.Dir(fn)
+	if !atimeUpdated(dir) {
+		return true, nil
+	}
 	// Create a new file in the same directory
 	f, err := os.CreateTemp(dir, "fcompare")
 	if err != nil {
@@ -63,8 +100,175 @@ func TestKeepAtime(fn string) (bool, error) {
 	return true, nil
 }
 
+// Group is a set of files that are the same according to the compare method
+type Group struct {
+	Files []string
+	// Files that are the same file as an earlier file of the group (the same
+	// path, or a hardlink), and were matched by identity without reading them
+	ByIdentity []string
+}
+
+// CompareFilesNamed compares files, and returns the groups of files that are the same.
+// Groups are in the order in which their first file appears in fns, and files keep
+// their order within a group. A path that appears more than once in fns appears
+// that many times in its group.
+// As with CompareFiles, files that can't be read are reported in a *CompareError.
+func CompareFilesNamed(fns []string, method CompareMethod, keepATime bool, checkKeepAtime bool) ([]Group, error) {
+	return CompareFilesNamedHash(fns, method, HashSHA256, keepATime, checkKeepAtime)
+}
+
+// CompareFilesNamedHash is CompareFilesNamed with checksums computed with algo
+func CompareFilesNamedHash(fns []string, method CompareMethod, algo HashAlgo, keepATime bool, checkKeepAtime bool) ([]Group, error) {
+	indexGroups, err := CompareFilesHash(fns, method, algo, keepATime, checkKeepAtime)
+	// With a CompareError, the groups of the other files are still returned
+	var cmpErr *CompareError
+	if err != nil && !errors.As(err, &cmpErr) {
+		return nil, err
+	}
+	sort.Slice(indexGroups, func(i, j int) bool { return indexGroups[i][0] < indexGroups[j][0] })
+	ids := identities(fns, nil)
+	groups := make([]Group, len(indexGroups))
+	for i, g := range indexGroups {
+		for _, j := range g {
+			groups[i].Files = append(groups[i].Files, fns[j])
+			if ids[j] != j {
+				groups[i].ByIdentity = append(groups[i].ByIdentity, fns[j])
+			}
+		}
+	}
+	return groups, err
+}
+
+// CompareFiles is like CompareFilesNamed, but returns groups of indexes into fns.
+// It is kept for backwards compatibility.
+// Files that can't be read don't stop the comparison; they are reported in a
+// *CompareError, which is returned together with the groups of the other files.
 func CompareFiles(fns []string, method CompareMethod, keepATime bool, checkKeepAtime bool) ([][]int, error) {
-	if checkKeepAtime {
+	return CompareFilesHash(fns, method, HashSHA256, keepATime, checkKeepAtime)
+}
+
+// CompareFilesHash is CompareFiles with checksums computed with algo
+func CompareFilesHash(fns []string, method CompareMethod, algo HashAlgo, keepATime bool, checkKeepAtime bool) ([][]int, error) {
+	return CompareFilesOpt(fns, WithMethod(method), WithHash(algo), WithKeepATime(keepATime), WithCheckKeepAtime(checkKeepAtime))
+}
+
+// CompareFilesDuplicates is CompareFiles, but only returns groups of 2 or more files
+func CompareFilesDuplicates(fns []string, method CompareMethod, keepATime bool, checkKeepAtime bool) ([][]int, error) {
+	return CompareFilesOpt(fns, WithMethod(method), WithKeepATime(keepATime), WithCheckKeepAtime(checkKeepAtime), WithOnlyDuplicates(true))
+}
+
+// CompareFilesConfig is CompareFilesHash, with the chunk size and threshold of the
+// CmpPartial method from cfg instead of the defaults
+func CompareFilesConfig(fns []string, method CompareMethod, algo HashAlgo, cfg PartialChecksumConfig, keepATime bool, checkKeepAtime bool) ([][]int, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return CompareFilesOpt(fns, WithMethod(method), WithHash(algo), WithPartialConfig(cfg), WithKeepATime(keepATime), WithCheckKeepAtime(checkKeepAtime))
+}
+
+// CompareFilesParallel is CompareFiles, but hashes up to workers files at the same time.
+// The result doesn't depend on the number of workers: groups are ordered by their
+// first file, and the indexes in each group are in increasing order.
+// A path that appears more than once in fns, or a hardlink to an earlier file, is read only once.
+func CompareFilesParallel(fns []string, method CompareMethod, workers int, keepATime bool, checkKeepAtime bool) ([][]int, error) {
+	return CompareFilesOpt(fns, WithMethod(method), WithConcurrency(workers), WithKeepATime(keepATime), WithCheckKeepAtime(checkKeepAtime))
+}
+
+// CompareFilesFailFast is CompareFilesParallel, but the first file that can't be read
+// stops the comparison: files that are being hashed are abandoned, and no new files
+// are started. The *CompareError then contains only that file, and the groups
+// contain the files that were completed before.
+// As with CompareFilesCtx, cancelling ctx stops the comparison too.
+func CompareFilesFailFast(ctx context.Context, fns []string, method CompareMethod, workers int, keepATime bool, checkKeepAtime bool) ([][]int, error) {
+	return CompareFilesOptCtx(ctx, fns, WithMethod(method), WithConcurrency(workers), WithStopOnError(true), WithKeepATime(keepATime), WithCheckKeepAtime(checkKeepAtime))
+}
+
+// CompareFilesSymlinks is CompareFiles, with the given handling of names that are symlinks
+func CompareFilesSymlinks(fns []string, method CompareMethod, policy SymlinkPolicy, keepATime bool, checkKeepAtime bool) ([][]int, error) {
+	return CompareFilesOpt(fns, WithMethod(method), WithSymlinks(policy), WithKeepATime(keepATime), WithCheckKeepAtime(checkKeepAtime))
+}
+
+// CompareFilesCache is CompareFilesHash, but takes the checksums of files that didn't
+// change from cache, and stores the checksums that it computes in cache. Call
+// cache.Save to write them to the cache file.
+func CompareFilesCache(fns []string, method CompareMethod, algo HashAlgo, cache *Cache, keepATime bool, checkKeepAtime bool) ([][]int, error) {
+	return CompareFilesOpt(fns, WithMethod(method), WithHash(algo), WithCache(cache), WithKeepATime(keepATime), WithCheckKeepAtime(checkKeepAtime))
+}
+
+// compareConfig holds the settings of compareFiles; the zero value of algo is SHA256
+type compareConfig struct {
+	method         CompareMethod
+	algo           HashAlgo
+	workers        int  // number of files hashed at the same time, at least 1
+	stopOnError    bool // stop at the first file that can't be read
+	keepATime      bool
+	checkKeepAtime bool
+	partial        PartialChecksumConfig // DefaultPartialChecksumConfig if zero
+	onlyDuplicates bool                  // leave out groups of a single file
+	symlinks       SymlinkPolicy
+	cache          *Cache // nil if checksums are not cached
+}
+
+// identities returns for each file the index of the first name in fns of the same
+// file: the same path, or another link to the same inode (on Windows, the same
+// file index). Files that can't be stat'ed are only the same as the same path.
+// Files with an error in excluded (which may be nil) are only the same as themselves.
+func identities(fns []string, excluded []error) []int {
+	ids := make([]int, len(fns))
+	byPath := make(map[string]int)
+	type statted struct {
+		index int
+		fi    os.FileInfo
+	}
+	// Only files of the same size can be the same file
+	bySize := make(map[int64][]statted)
+next:
+	for i, fn := range fns {
+		ids[i] = i
+		if excluded != nil && excluded[i] != nil {
+			continue
+		}
+		if j, ok := byPath[fn]; ok {
+			ids[i] = j
+			continue
+		}
+		byPath[fn] = i
+		fi, err := os.Stat(fn)
+		if err != nil {
+			continue
+		}
+		for _, s := range bySize[fi.Size()] {
+			if os.SameFile(fi, s.fi) {
+				ids[i] = s.index
+				continue next
+			}
+		}
+		bySize[fi.Size()] = append(bySize[fi.Size()], statted{i, fi})
+	}
+	return ids
+}
+
+// errStopped cancels the workers of compareFiles when a file fails with stopOnError
+var errStopped = errors.New("stopped after an error")
+
+func compareFiles(parent context.Context, fns []string, cfg compareConfig) ([][]int, error) {
+	detailed, err := compareGroups(parent, fns, cfg)
+	var groups [][]int
+	for _, g := range detailed {
+		groups = append(groups, g.Indices)
+	}
+	return groups, err
+}
+
+// compareGroups is compareFiles, but returns the groups with the key that they share
+func compareGroups(parent context.Context, fns []string, cfg compareConfig) ([]DetailedGroup, error) {
+	if !cfg.method.valid() {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidMethod, cfg.method)
+	}
+	if cfg.symlinks < SymlinkFollow || cfg.symlinks > SymlinkCompareTarget {
+		return nil, fmt.Errorf("invalid symlink policy %d", cfg.symlinks)
+	}
+	if cfg.checkKeepAtime && len(fns) > 0 {
 		canKeep, err := TestKeepAtime(fns[0])
 		if err != nil {
 			return nil, err
@@ -73,130 +277,653 @@ func CompareFiles(fns []string, method CompareMethod, keepATime bool, checkKeepA
 			return nil, errors.New("can't keep atime")
 		}
 	}
+	if cfg.partial == (PartialChecksumConfig{}) {
+		cfg.partial = DefaultPartialChecksumConfig
+	}
+	if err := cfg.partial.Validate(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancelCause(parent)
+	defer cancel(nil)
+
+	// Each distinct file is processed once, by the first free worker. Reading a
+	// file twice at the same time could make one of the readers restore the atime
+	// that was changed by the other. Names that refer to the same file, like the
+	// same path twice or hardlinks, get the result of the first name.
+	paths, linkErrs := applySymlinkPolicy(fns, cfg.symlinks)
+	ids := identities(paths, linkErrs)
+	p := &pool{ctx: ctx, cancel: cancel, fns: paths, cfg: cfg, keys: make([]string, len(fns)),
+		errs: make([]error, len(fns)), done: make([]bool, len(fns)), stoppedBy: -1}
+	var todo []int
+	for i := range fns {
+		switch {
+		case linkErrs[i] != nil:
+			// Skipped and broken symlinks are not read
+			p.errs[i], p.done[i] = linkErrs[i], true
+		case ids[i] == i:
+			todo = append(todo, i)
+		}
+	}
+	switch cfg.method {
+	case CmpAuto:
+		p.runStaged(todo)
+	case CmpBytes:
+		p.runBytes(todo)
+	default:
+		p.run(todo, cfg.method)
+	}
 
 	// Compare files, and return a list of files that are the same
 	// The list of files is returned as a list of lists of integers
 	// Each list of integers contains the indexes of files that are the same
 	// For example, if files 1, 2, and 3 are the same, and files 4 and 5 are the same, then the return value is:
 	// [[1, 2, 3], [4, 5]]
-	var equalFiles [][]int
+	// Files that can't be read are left out of the groups, and reported in a CompareError
 	var fis = make(map[string][]int)
-	var err error
+	var failed []FailedFile
+	cancelled := false
 	for i, fn := range fns {
-		fi, err := processFile(fn, method, keepATime)
-		if err != nil {
-			return equalFiles, err
+		f := ids[i]
+		switch {
+		case !p.done[f]:
+			cancelled = true
+		case p.errs[f] != nil && ctx.Err() != nil && f != p.stoppedBy:
+			// A cancelled context is not a problem of the file
+			cancelled = true
+		case p.errs[f] != nil:
+			failed = append(failed, FailedFile{Index: i, Path: fn, Err: p.errs[f]})
+		default:
+			// Check if we already have the same file in fis
+			fis[p.keys[f]] = append(fis[p.keys[f]], i)
+		}
+	}
+	if cfg.onlyDuplicates {
+		for k, g := range fis {
+			if len(g) < 2 {
+				delete(fis, k)
+			}
+		}
+	}
+	if cancelled && parent.Err() != nil {
+		return p.groupsOf(fis), parent.Err()
+	}
+	equalFiles := p.groupsOf(fis)
+	if failed != nil {
+		return equalFiles, &CompareError{Failed: failed}
+	}
+	return equalFiles, nil
+}
+
+// pool processes files with a number of workers.
+// Every worker writes only the entries of the files it processed.
+type pool struct {
+	ctx       context.Context
+	cancel    context.CancelCauseFunc
+	fns       []string
+	cfg       compareConfig
+	keys      []string // what is the same for files that are the same
+	errs      []error
+	done      []bool
+	stoppedBy int // with stopOnError, the file that stopped the comparison
+	mu        sync.Mutex
+}
+
+// run processes the files with the given indexes, and adds the result of the
+// method to their keys
+func (p *pool) run(todo []int, method CompareMethod) {
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(max(p.cfg.workers, 1), len(todo)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				sum, err := processFile(p.ctx, p.fns[i], method, p.cfg.algo, p.cfg.partial, p.cfg.keepATime, p.cfg.cache)
+				p.keys[i] += "/" + sum
+				p.errs[i] = err
+				p.done[i] = true
+				if err != nil && p.cfg.stopOnError && p.ctx.Err() == nil {
+					p.mu.Lock()
+					if p.stoppedBy < 0 {
+						p.stoppedBy = i
+					}
+					p.mu.Unlock()
+					p.cancel(errStopped)
+				}
+			}
+		}()
+	}
+feed:
+	for _, i := range todo {
+		select {
+		case next <- i:
+		case <-p.ctx.Done():
+			break feed
 		}
-		// Check if we already have the same file in fis
-		fis[fi] = append(fis[fi], i)
 	}
-	for _, v := range fis {
-		equalFiles = append(equalFiles, v)
+	close(next)
+	wg.Wait()
+}
+
+// runBytes compares files of the same size byte by byte with the first file
+// of each group of equal files found so far
+func (p *pool) runBytes(todo []int) {
+	p.run(todo, CmpSize)
+	var sized []int
+	for _, i := range todo {
+		if p.done[i] && p.errs[i] == nil {
+			sized = append(sized, i)
+			// Files that are not compared, e.g. after cancellation, are not done
+			p.done[i] = false
+		}
+	}
+	firsts := make(map[string][]int) // first files of the groups, by size
+	for _, i := range sized {
+		if p.ctx.Err() != nil {
+			return
+		}
+		size := p.keys[i]
+		group := -1
+		for _, f := range firsts[size] {
+			equal, _, err := equalContents(p.ctx, p.fns[f], p.fns[i], p.cfg.keepATime)
+			if err != nil {
+				p.errs[i] = err
+				break
+			}
+			if equal {
+				group = f
+				break
+			}
+		}
+		p.done[i] = true
+		if p.errs[i] != nil {
+			if p.cfg.stopOnError && p.ctx.Err() == nil {
+				p.stoppedBy = i
+				p.cancel(errStopped)
+			}
+			continue
+		}
+		if group < 0 {
+			firsts[size] = append(firsts[size], i)
+			group = i
+		}
+		p.keys[i] = size + "/" + strconv.Itoa(group)
 	}
-	return equalFiles, err
+}
+
+// runStaged compares files by size first, then by partial checksum for files
+// of the same size, and then by full checksum for files with the same partial
+// checksum. Files with a unique size are never opened.
+func (p *pool) runStaged(todo []int) {
+	p.run(todo, CmpSize)
+	for _, stage := range []CompareMethod{CmpPartial, CmpFull} {
+		// Only files that are still the same as another file go to the next stage
+		count := make(map[string]int)
+		for _, i := range todo {
+			if p.done[i] && p.errs[i] == nil {
+				count[p.keys[i]]++
+			}
+		}
+		var next []int
+		for _, i := range todo {
+			if count[p.keys[i]] < 2 {
+				continue
+			}
+			// Files up to the threshold are completely hashed by the partial stage
+			if stage == CmpFull {
+				size, _ := strconv.ParseInt(strings.Split(p.keys[i], "/")[1], 10, 64)
+				if size <= p.cfg.partial.FullThreshold {
+					continue
+				}
+			}
+			next = append(next, i)
+		}
+		if len(next) == 0 {
+			return
+		}
+		// Files that the stage doesn't get to, e.g. after cancellation, are not done
+		for _, i := range next {
+			p.done[i] = false
+		}
+		p.run(next, stage)
+	}
+}
+
+// groupsOf returns the groups of file indexes by key, ordered by their first index
+func (p *pool) groupsOf(fis map[string][]int) []DetailedGroup {
+	var groups []DetailedGroup
+	for k, v := range fis {
+		method, key := p.describeKey(k)
+		groups = append(groups, DetailedGroup{Key: key, Method: method, Indices: v})
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Indices[0] < groups[j].Indices[0] })
+	return groups
+}
+
+// FailedFile is a file that CompareFiles couldn't process
+type FailedFile struct {
+	Index int // index in the list of files passed to CompareFiles
+	Path  string
+	Err   error
+}
+
+// CompareError is returned by CompareFiles when some files couldn't be processed.
+// The groups that are returned with it contain all other files.
+type CompareError struct {
+	Failed []FailedFile
+}
+
+func (e *CompareError) Error() string {
+	if len(e.Failed) == 1 {
+		return e.Failed[0].Err.Error()
+	}
+	return fmt.Sprintf("%d files could not be compared, first error: %v", len(e.Failed), e.Failed[0].Err)
+}
+
+// Unwrap returns the errors of all failed files, for errors.Is and errors.As
+func (e *CompareError) Unwrap() []error {
+	errs := make([]error, len(e.Failed))
+	for i, f := range e.Failed {
+		errs[i] = f.Err
+	}
+	return errs
 }
 
 func GetPartialChecksum(filename string) (string, bool, error) {
-	// The partial checksum is the SHA256 sum of the first 1M of the file, plus the middle 1M of the file, plus the last 1M of the file
-	// If the file is less than 16M, then the partial checksum is the SHA256 sum of the entire file
-	// The limit of 16M is used because reding 16M is probably faster than reading 1M three times
-	// The middle of the file is defined as the middle 1M of the file, rounded down to the nearest 1M
+	return GetPartialChecksumHash(filename, HashSHA256)
+}
+
+// GetPartialChecksumHash is GetPartialChecksum with algo instead of SHA256
+func GetPartialChecksumHash(filename string, algo HashAlgo) (string, bool, error) {
+	return GetPartialChecksumConfig(filename, algo, DefaultPartialChecksumConfig)
+}
+
+// GetPartialChecksumConfig is GetPartialChecksum with algo instead of SHA256,
+// and the chunk size and threshold from cfg instead of the defaults
+func GetPartialChecksumConfig(filename string, algo HashAlgo, cfg PartialChecksumConfig) (string, bool, error) {
+	return getPartialChecksum(context.Background(), filename, algo, cfg)
+}
+
+func getPartialChecksum(ctx context.Context, filename string, algo HashAlgo, cfg PartialChecksumConfig) (string, bool, error) {
+	res, err := getPartialChecksumResult(ctx, filename, algo, cfg, false)
+	return res.Checksum, res.IsFull, err
+}
+
+// PartialChecksumResult is the partial checksum of a file, with the checksums of
+// the chunks that it is made of
+type PartialChecksumResult struct {
+	Checksum string // the partial checksum, as returned by GetPartialChecksum
+	IsFull   bool   // the file was hashed completely, and Checksum is the full checksum
+	// The first, middle and last chunk, if the file was larger than the threshold.
+	// When two files differ, these tell which part of the files differs.
+	Chunks []ChunkChecksum
+}
 
-	isFull := false // Indicates if the partial checksum is the same as the full checksum
+// ChunkChecksum is the checksum of a single chunk that the partial checksum reads
+type ChunkChecksum struct {
+	Range
+	Checksum string
+}
+
+// GetPartialChecksumResult is GetPartialChecksumConfig, but also returns the
+// checksums of the individual chunks
+func GetPartialChecksumResult(filename string, algo HashAlgo, cfg PartialChecksumConfig) (PartialChecksumResult, error) {
+	return getPartialChecksumResult(context.Background(), filename, algo, cfg, true)
+}
+
+func getPartialChecksumResult(ctx context.Context, filename string, algo HashAlgo, cfg PartialChecksumConfig, chunks bool) (PartialChecksumResult, error) {
+	if err := cfg.Validate(); err != nil {
+		return PartialChecksumResult{}, err
+	}
 	// Get file size
 	fi, err := os.Stat(filename)
 	if err != nil {
-		return "", false, err
+		return PartialChecksumResult{}, err
 	}
-	filesize := fi.Size()
 
-	f, err := os.Open(filename)
+	f, err := OpenObserved(ctx, filename)
 	if err != nil {
-		log.Fatal(err)
+		return PartialChecksumResult{}, err
 	}
 	defer f.Close()
+	return partialChecksumResult(ctx, f, fi.Size(), algo, cfg, chunks)
+}
+
+// partialChecksum computes the partial checksum of size bytes of data from r.
+// cfg must be valid.
+func partialChecksum(ctx context.Context, rs io.ReadSeeker, size int64, algo HashAlgo, cfg PartialChecksumConfig) (string, bool, error) {
+	res, err := partialChecksumResult(ctx, rs, size, algo, cfg, false)
+	return res.Checksum, res.IsFull, err
+}
+
+// partialChecksumResult computes the partial checksum of size bytes of data from r,
+// and the checksums of the chunks if chunks is set. cfg must be valid.
+func partialChecksumResult(ctx context.Context, rs io.ReadSeeker, size int64, algo HashAlgo, cfg PartialChecksumConfig, chunks bool) (PartialChecksumResult, error) {
+	// The partial checksum is the SHA256 sum of the first 1M of the file, plus the middle 1M of the file, plus the last 1M of the file
+	// If the file is less than 16M, then the partial checksum is the SHA256 sum of the entire file
+	// The limit of 16M is used because reding 16M is probably faster than reading 1M three times
+	// The middle of the file is defined as the middle 1M of the file, rounded down to the nearest 1M
+	// (1M and 16M are the defaults of cfg.ChunkSize and cfg.FullThreshold)
+	var res PartialChecksumResult
+	r := &ctxReader{ctx, rs}
 
-	h := sha256.New()
+	h := algo.New()
 
 	// If the file is less than 16M, then the partial checksum is the SHA256 sum of the entire file
-	if filesize <= minPartialChecksumSize {
+	if size <= cfg.FullThreshold {
 		// Compute SHA256 sum of entire file
-		if _, err := io.Copy(h, f); err != nil {
-			return "", false, err
+		if _, err := io.Copy(h, r); err != nil {
+			return PartialChecksumResult{}, err
 		}
-		isFull = true
+		res.IsFull = true
 
 	} else {
-		// Compute SHA256 sum of first 1M of file
-		if _, err := io.CopyN(h, f, 1024*1024); err != nil {
-			return "", false, err
+		// Hash the first, middle and last chunk of the file
+		for _, rg := range partialRanges(size, cfg) {
+			if _, err := rs.Seek(rg.Start, io.SeekStart); err != nil {
+				return PartialChecksumResult{}, err
+			}
+			var w io.Writer = h
+			var ch hash.Hash
+			if chunks {
+				ch = algo.New()
+				w = io.MultiWriter(h, ch)
+			}
+			if _, err := io.CopyN(w, r, rg.Length); err != nil {
+				return PartialChecksumResult{}, err
+			}
+			if chunks {
+				res.Chunks = append(res.Chunks, ChunkChecksum{rg, hex.EncodeToString(ch.Sum(nil))})
+			}
 		}
+	}
 
-		// Compute SHA256 sum of middle 1M of file
+	res.Checksum = hex.EncodeToString(h.Sum(nil))
+	return res, nil
+}
 
-		// Compute the middle of the file, rounded down to the nearest 1M
-		filemid := filesize / 2
-		filemid = filemid - (filemid % (1024 * 1024))
+// GetRangeChecksum returns the SHA256 sum of a region of a file.
+// The file must contain the whole region.
+func GetRangeChecksum(filename string, offset int64, length int64) (string, error) {
+	return GetRangeChecksumHash(filename, HashSHA256, offset, length)
+}
 
-		// Seek to middle of file
-		if _, err := f.Seek(filemid, io.SeekStart); err != nil {
-			return "", false, err
-		}
-		if _, err := io.CopyN(h, f, 1024*1024); err != nil {
-			return "", false, err
-		}
+// GetRangeChecksumHash is GetRangeChecksum with algo instead of SHA256
+func GetRangeChecksumHash(filename string, algo HashAlgo, offset int64, length int64) (string, error) {
+	return getRangeChecksum(context.Background(), filename, algo, offset, length)
+}
+
+func getRangeChecksum(ctx context.Context, filename string, algo HashAlgo, offset int64, length int64) (string, error) {
+	f, err := OpenObserved(ctx, filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := algo.New()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return "", err
+	}
+	if _, err := io.CopyN(h, &ctxReader{ctx, f}, length); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Range is a region of a file, as read by one of the checksum functions
+type Range struct {
+	Start  int64 `json:"start"`
+	Length int64 `json:"length"`
+}
+
+// PartialChecksumRanges returns the byte ranges that GetPartialChecksum reads
+// for a file of the given size
+func PartialChecksumRanges(filesize int64) []Range {
+	return PartialChecksumRangesConfig(filesize, DefaultPartialChecksumConfig)
+}
+
+// PartialChecksumRangesConfig returns the byte ranges that GetPartialChecksumConfig
+// reads for a file of the given size
+func PartialChecksumRangesConfig(filesize int64, cfg PartialChecksumConfig) []Range {
+	if filesize <= cfg.FullThreshold {
+		return []Range{{0, filesize}}
+	}
+	return partialRanges(filesize, cfg)
+}
+
+// partialRanges returns the first, middle and last chunk of a file that is larger than
+// the threshold. The middle chunk starts at the middle of the file, rounded down to a
+// multiple of the chunk size. Because the threshold is at least 3 chunks, the chunks
+// never overlap, even if the chunk size doesn't divide the file size.
+func partialRanges(filesize int64, cfg PartialChecksumConfig) []Range {
+	chunk := cfg.ChunkSize
+	filemid := filesize / 2
+	filemid = filemid - (filemid % chunk)
+	return []Range{{0, chunk}, {filemid, chunk}, {filesize - chunk, chunk}}
+}
+
+// AdaptivePartialChecksumRanges returns the byte ranges that GetAdaptivePartialChecksum
+// reads for a file of the given size
+func AdaptivePartialChecksumRanges(filesize int64) []Range {
+	return AdaptivePartialChecksumRangesConfig(filesize, DefaultPartialChecksumConfig)
+}
+
+// AdaptivePartialChecksumRangesConfig returns the byte ranges that
+// GetAdaptivePartialChecksumConfig reads for a file of the given size
+func AdaptivePartialChecksumRangesConfig(filesize int64, cfg PartialChecksumConfig) []Range {
+	if filesize <= cfg.FullThreshold {
+		return []Range{{0, filesize}}
+	}
+	var ranges []Range
+	for _, offset := range adaptiveRegionOffsets(filesize, cfg.ChunkSize) {
+		ranges = append(ranges, Range{offset, cfg.ChunkSize})
+	}
+	return ranges
+}
+
+// adaptiveRegionCount returns the number of regions sampled by the adaptive
+// partial checksum for a file of the given size.
+// Files under 1 GB get 3 regions (like the fixed scheme), larger files get
+// roughly 1.5 extra regions per doubling of the size, up to 16 regions
+// for files of 256 GB and more. With large chunks, there are never more
+// regions than fit in the file.
+func adaptiveRegionCount(filesize int64, chunk int64) int {
+	log2 := bits.Len64(uint64(filesize)) - 1
+	if log2 < 30 {
+		return adaptiveMinRegions
+	}
+	n := adaptiveMinRegions + 3*(log2-29)/2
+	if n > adaptiveMaxRegions {
+		n = adaptiveMaxRegions
+	}
+	return int(min(int64(n), filesize/chunk))
+}
 
-		// Compute SHA256 sum of last 1M of file
-		if _, err := f.Seek(-1024*1024, io.SeekEnd); err != nil {
+// adaptiveRegionOffsets returns the start offsets of the regions sampled by the
+// adaptive partial checksum. The offsets depend only on the file size, so the
+// digest is reproducible. The first region starts at the beginning of the file,
+// the last region ends at the end of the file, and the regions in between are
+// evenly spaced and rounded down to the nearest chunk boundary.
+func adaptiveRegionOffsets(filesize int64, chunk int64) []int64 {
+	n := adaptiveRegionCount(filesize, chunk)
+	offsets := make([]int64, n)
+	span := filesize - chunk
+	for i := 1; i < n-1; i++ {
+		pos := span / int64(n-1) * int64(i)
+		offsets[i] = pos - (pos % chunk)
+	}
+	offsets[n-1] = span
+	return offsets
+}
+
+// GetAdaptivePartialChecksum computes a partial checksum that samples a number of
+// 1M regions that grows with the file size (see adaptiveRegionCount).
+// As with GetPartialChecksum, files up to 16M are hashed completely, and the
+// returned bool indicates that the result equals the full checksum.
+// Otherwise, the digest is prefixed with a label that encodes the scheme version,
+// e.g. "sha256-adaptive-v1:<hex>", so it can never be mistaken for a
+// fixed-scheme partial checksum or a full checksum.
+func GetAdaptivePartialChecksum(filename string) (string, bool, error) {
+	return GetAdaptivePartialChecksumHash(filename, HashSHA256)
+}
+
+// GetAdaptivePartialChecksumHash is GetAdaptivePartialChecksum with algo instead of SHA256.
+// The label starts with the name of the algorithm, e.g. "crc64-adaptive-v1:".
+func GetAdaptivePartialChecksumHash(filename string, algo HashAlgo) (string, bool, error) {
+	return getAdaptivePartialChecksum(context.Background(), filename, algo, DefaultPartialChecksumConfig)
+}
+
+// GetAdaptivePartialChecksumConfig is GetAdaptivePartialChecksumHash with regions of
+// cfg.ChunkSize, and files up to cfg.FullThreshold hashed completely. See
+// AdaptivePartialChecksumLabel for the label of other chunk sizes than the default.
+func GetAdaptivePartialChecksumConfig(filename string, algo HashAlgo, cfg PartialChecksumConfig) (string, bool, error) {
+	return getAdaptivePartialChecksum(context.Background(), filename, algo, cfg)
+}
+
+// AdaptivePartialChecksumLabel returns the label before the hex digest of an adaptive
+// partial checksum of a file larger than cfg.FullThreshold. The default chunk size
+// gives e.g. "sha256-adaptive-v1:", other chunk sizes add the size in bytes, e.g.
+// "sha256-adaptive-v1-c4194304:", so digests of different regions never look the same.
+func AdaptivePartialChecksumLabel(algo HashAlgo, cfg PartialChecksumConfig) string {
+	label := algo.String() + "-adaptive-v" + strconv.Itoa(adaptiveSchemeVersion)
+	if cfg.ChunkSize != DefaultPartialChecksumConfig.ChunkSize {
+		label += "-c" + strconv.FormatInt(cfg.ChunkSize, 10)
+	}
+	return label + ":"
+}
+
+func getAdaptivePartialChecksum(ctx context.Context, filename string, algo HashAlgo, cfg PartialChecksumConfig) (string, bool, error) {
+	if err := cfg.Validate(); err != nil {
+		return "", false, err
+	}
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return "", false, err
+	}
+	filesize := fi.Size()
+
+	if filesize <= cfg.FullThreshold {
+		sum, err := getChecksum(ctx, filename, algo)
+		return sum, true, err
+	}
+
+	f, err := OpenObserved(ctx, filename)
+	if err != nil {
+		return "", false, err
+	}
+	defer f.Close()
+	r := &ctxReader{ctx, f}
+
+	h := algo.New()
+	for _, offset := range adaptiveRegionOffsets(filesize, cfg.ChunkSize) {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
 			return "", false, err
 		}
-		if _, err := io.Copy(h, f); err != nil {
+		if _, err := io.CopyN(h, r, cfg.ChunkSize); err != nil {
 			return "", false, err
 		}
 	}
 
-	return hex.EncodeToString(h.Sum(nil)), isFull, nil
+	return AdaptivePartialChecksumLabel(algo, cfg) + hex.EncodeToString(h.Sum(nil)), false, nil
 }
 
 func GetChecksum(filename string) (string, error) {
-	f, err := os.Open(filename)
+	return GetChecksumHash(filename, HashSHA256)
+}
+
+// GetChecksumHash is GetChecksum with algo instead of SHA256
+func GetChecksumHash(filename string, algo HashAlgo) (string, error) {
+	return getChecksum(context.Background(), filename, algo)
+}
+
+func getChecksum(ctx context.Context, filename string, algo HashAlgo) (string, error) {
+	f, err := OpenObserved(ctx, filename)
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
 	defer f.Close()
+	// The holes of sparse files, e.g. preallocated by acquisition software, are not read
+	return checksum(ctx, sparseReader(f), algo)
+}
+
+// ChecksumReader returns the checksum of everything read from r, as GetChecksumHash
+// does for a file
+func ChecksumReader(r io.Reader, algo HashAlgo) (string, error) {
+	return checksum(context.Background(), r, algo)
+}
 
-	h := sha256.New()
+// PartialChecksumReader returns the partial checksum of a stream of size bytes, as
+// GetPartialChecksum does for a file. The stream must be positioned at its start.
+// Files larger than the threshold are read at 3 places, so r must be able to seek.
+func PartialChecksumReader(r io.ReadSeeker, size int64) (string, bool, error) {
+	return partialChecksum(context.Background(), r, size, HashSHA256, DefaultPartialChecksumConfig)
+}
+
+// PartialChecksumReaderAt returns the partial checksum of the first size bytes of r,
+// as GetPartialChecksumConfig does for a file, e.g. for a file inside a zip archive.
+func PartialChecksumReaderAt(r io.ReaderAt, size int64, cfg PartialChecksumConfig) (string, bool, error) {
+	return PartialChecksumReaderAtHash(r, size, HashSHA256, cfg)
+}
+
+// PartialChecksumReaderAtHash is PartialChecksumReaderAt with algo instead of SHA256
+func PartialChecksumReaderAtHash(r io.ReaderAt, size int64, algo HashAlgo, cfg PartialChecksumConfig) (string, bool, error) {
+	if err := cfg.Validate(); err != nil {
+		return "", false, err
+	}
+	return partialChecksum(context.Background(), io.NewSectionReader(r, 0, size), size, algo, cfg)
+}
 
-	if _, err := io.Copy(h, f); err != nil {
+func checksum(ctx context.Context, r io.Reader, algo HashAlgo) (string, error) {
+	h := algo.New()
+
+	if _, err := io.Copy(h, &ctxReader{ctx, r}); err != nil {
 		return "", err
 	}
 
 	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
-func processFile(filename string, method CompareMethod, keepATime bool) (string, error) {
-	var fileinfo string
+func processFile(ctx context.Context, filename string, method CompareMethod, algo HashAlgo, partial PartialChecksumConfig, keepATime bool, cache *Cache) (fileinfo string, err error) {
 
-	// Get file times
-	atime, err := atime.Stat(filename)
-	if err != nil {
-		log.Fatal(err.Error())
+	if !method.valid() {
+		return fileinfo, fmt.Errorf("%w: %d", ErrInvalidMethod, method)
 	}
+
+	// Get file times
 	fi, err := os.Stat(filename)
 	if err != nil {
 		return fileinfo, err
 	}
+	atime := atime.Get(fi)
 	mtime := fi.ModTime()
 
-	if keepATime {
-		// Restore file times before we return
-		defer os.Chtimes(filename, atime, mtime)
+	if cache != nil {
+		if sum, ok := cache.lookup(filename, fi, method, algo, partial); ok {
+			return sum, nil
+		}
+	}
+
+	// Stat doesn't change the atime, so with CmpSize there is nothing to restore
+	if keepATime && method != CmpSize {
+		// Restore file times before we return, also when ctx is cancelled.
+		// A file that was modified while it was read fails.
+		defer func() {
+			if rerr := restoreTimesAfterRead(ctx, filename, atime, mtime); rerr != nil && err == nil {
+				err = rerr
+			}
+		}()
 	}
 
 	switch method {
 	case CmpPartial:
 		// Get partial checksum
-		fileinfo, _, err = GetPartialChecksum(filename)
+		fileinfo, _, err = getPartialChecksum(ctx, filename, algo, partial)
+		if err != nil {
+			return fileinfo, err
+		}
+	case CmpPartialAdaptive:
+		// Get adaptive partial checksum
+		fileinfo, _, err = getAdaptivePartialChecksum(ctx, filename, algo, partial)
 		if err != nil {
 			return fileinfo, err
 		}
@@ -206,13 +933,14 @@ func processFile(filename string, method CompareMethod, keepATime bool) (string,
 		fileinfo = strconv.FormatInt(fSize, 10)
 	case CmpFull:
 		// Get full checksum
-		fileinfo, err = GetChecksum(filename)
+		fileinfo, err = getChecksum(ctx, filename, algo)
 		if err != nil {
 			return fileinfo, err
 		}
-	default:
-		log.Fatal("Invalid compare method")
 	}
 
+	if cache != nil {
+		cache.record(filename, fi, method, algo, partial, fileinfo)
+	}
 	return fileinfo, nil
 }
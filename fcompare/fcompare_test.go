@@ -0,0 +1,50 @@
+package fcompare
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFile writes a file with data in dir, and returns its path
+func writeFile(t testing.TB, dir, name string, data []byte) string {
+	t.Helper()
+	fn := filepath.Join(dir, name)
+	if err := os.WriteFile(fn, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return fn
+}
+
+// randomData returns n pseudo-random bytes, the same for the same seed
+func randomData(seed int64, n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func TestGetRangeChecksumHash(t *testing.T) {
+	data := randomData(1, 10000)
+	fn := writeFile(t, t.TempDir(), "f", data)
+	for _, algo := range []HashAlgo{HashSHA256, HashMD5, HashCRC64, HashBLAKE3} {
+		for _, rg := range []Range{{0, 0}, {0, 10000}, {17, 4096}, {9999, 1}} {
+			got, err := GetRangeChecksumHash(fn, algo, rg.Start, rg.Length)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, _ := ChecksumReader(bytes.NewReader(data[rg.Start:rg.Start+rg.Length]), algo)
+			if got != want {
+				t.Errorf("%v %+v: got %s, want %s", algo, rg, got, want)
+			}
+		}
+	}
+	sha, _ := GetRangeChecksum(fn, 17, 4096)
+	if want, _ := GetRangeChecksumHash(fn, HashSHA256, 17, 4096); sha != want {
+		t.Errorf("GetRangeChecksum = %s, want the SHA256 %s", sha, want)
+	}
+	if _, err := GetRangeChecksumHash(fn, HashSHA256, 9000, 2000); err == nil {
+		t.Error("no error for a range past the end of the file")
+	}
+}
@@ -0,0 +1,61 @@
+package fcompare
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Hardlinks to the same file are grouped by identity, and the content is read once
+func TestHardlinksReadOnce(t *testing.T) {
+	dir := t.TempDir()
+	data := randomData(11, 100000)
+	a := writeFile(t, dir, "a", data)
+	links := []string{filepath.Join(dir, "link1"), filepath.Join(dir, "link2")}
+	for _, l := range links {
+		if err := os.Link(a, l); err != nil {
+			t.Skip("can't create hardlinks:", err)
+		}
+	}
+	copyA := writeFile(t, dir, "copy", data)
+	other := writeFile(t, dir, "other", randomData(12, 100000))
+	fns := []string{links[0], a, copyA, links[1], other}
+
+	for _, method := range []CompareMethod{CmpPartial, CmpFull, CmpAuto, CmpBytes} {
+		rec, ctx := newRecorder()
+		groups, err := CompareFilesOptCtx(ctx, fns, WithMethod(method))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := [][]int{{0, 1, 2, 3}, {4}}; !reflect.DeepEqual(groups, want) {
+			t.Errorf("method %d: groups %v, want %v", method, groups, want)
+		}
+		// Only the first name of the file is read, and only once
+		if got := rec.merged(links[0]); len(got) == 0 {
+			t.Errorf("method %d: %s not read", method, links[0])
+		}
+		for _, fn := range []string{a, links[1]} {
+			if got := rec.ranges[fn]; len(got) != 0 {
+				t.Errorf("method %d: hardlink %s read at %v", method, fn, got)
+			}
+		}
+		if method == CmpFull {
+			var n int64
+			for _, r := range rec.ranges[links[0]] {
+				n += r.Length
+			}
+			if n != int64(len(data)) {
+				t.Errorf("read %d bytes of %s, want %d", n, links[0], len(data))
+			}
+		}
+	}
+
+	groups, err := CompareFilesNamed(fns, CmpFull, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 || !reflect.DeepEqual(groups[0].ByIdentity, []string{a, links[1]}) || len(groups[1].ByIdentity) != 0 {
+		t.Errorf("groups %+v, want %s and %s matched by identity", groups, a, links[1])
+	}
+}
@@ -0,0 +1,95 @@
+package fcompare
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"strings"
+
+	"github.com/zeebo/blake3"
+)
+
+// HashAlgo is the hash algorithm used for checksums.
+// The zero value is SHA256, which all functions without a HashAlgo parameter use.
+// CRC32 and CRC64 are much faster, but not cryptographic: only use them to find
+// duplicates in data that nobody tampers with. BLAKE3 is cryptographic too, and
+// several times faster than SHA256 on CPUs with AVX2 or SSE4.1.
+type HashAlgo int
+
+const (
+	HashSHA256 HashAlgo = iota // 64 hex digits
+	HashSHA1                   // 40 hex digits
+	HashMD5                    // 32 hex digits
+	HashCRC32                  // 8 hex digits (IEEE polynomial)
+	HashCRC64                  // 16 hex digits (ECMA polynomial)
+	HashBLAKE3                 // 64 hex digits (256 bit output)
+)
+
+var hashNames = []string{"sha256", "sha1", "md5", "crc32", "crc64", "blake3"}
+
+// Number of algorithms above, and the constructors of those added with
+// RegisterHashAlgo, which follow them
+const builtinHashes = 6
+
+var registeredHashes []func() hash.Hash
+
+// RegisterHashAlgo adds a hash algorithm, e.g. xxh3 from another module,
+// and returns its HashAlgo. Afterwards, ParseHashAlgo accepts name.
+// It must be called before checksums are computed, typically from an init function.
+func RegisterHashAlgo(name string, newHash func() hash.Hash) (HashAlgo, error) {
+	if _, err := ParseHashAlgo(name); err == nil {
+		return HashSHA256, fmt.Errorf("hash algorithm %q already exists", name)
+	}
+	hashNames = append(hashNames, strings.ToLower(name))
+	registeredHashes = append(registeredHashes, newHash)
+	return HashAlgo(len(hashNames) - 1), nil
+}
+
+var crc64Table = crc64.MakeTable(crc64.ECMA)
+
+// New returns a new hash.Hash for the algorithm
+func (a HashAlgo) New() hash.Hash {
+	switch a {
+	case HashSHA1:
+		return sha1.New()
+	case HashMD5:
+		return md5.New()
+	case HashCRC32:
+		return crc32.NewIEEE()
+	case HashCRC64:
+		return crc64.New(crc64Table)
+	case HashBLAKE3:
+		return blake3.New()
+	}
+	if a >= builtinHashes && int(a) < len(hashNames) {
+		return registeredHashes[a-builtinHashes]()
+	}
+	return sha256.New()
+}
+
+// HexLen returns the length of the hex string of a checksum
+func (a HashAlgo) HexLen() int {
+	return a.New().Size() * 2
+}
+
+func (a HashAlgo) String() string {
+	if a < 0 || int(a) >= len(hashNames) {
+		return fmt.Sprintf("HashAlgo(%d)", int(a))
+	}
+	return hashNames[a]
+}
+
+// ParseHashAlgo returns the algorithm with the given name (sha256, sha1, md5, crc32,
+// crc64, blake3, or one added with RegisterHashAlgo)
+func ParseHashAlgo(name string) (HashAlgo, error) {
+	for i, n := range hashNames {
+		if strings.EqualFold(name, n) {
+			return HashAlgo(i), nil
+		}
+	}
+	return HashSHA256, fmt.Errorf("unknown hash algorithm %q, must be one of %s", name, strings.Join(hashNames, ", "))
+}
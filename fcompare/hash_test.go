@@ -0,0 +1,115 @@
+package fcompare
+
+import (
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Published digests of "abc", and of the empty file
+var knownDigests = map[HashAlgo][2]string{
+	HashSHA256: {"ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+	HashSHA1:   {"a9993e364706816aba3e25717850c26c9cd0d89d", "da39a3ee5e6b4b0d3255bfef95601890afd80709"},
+	HashMD5:    {"900150983cd24fb0d6963f7d28e17f72", "d41d8cd98f00b204e9800998ecf8427e"},
+	HashCRC32:  {"352441c2", "00000000"},
+	HashCRC64:  {"2cd8094a1a277627", "0000000000000000"},
+	HashBLAKE3: {"6437b3ac38465133ffb63b75273a8db548c558465d79db03fd359c6cd5bd9d85", "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262"},
+}
+
+func TestKnownDigests(t *testing.T) {
+	dir := t.TempDir()
+	abc := writeFile(t, dir, "abc", []byte("abc"))
+	empty := writeFile(t, dir, "empty", nil)
+	for algo, want := range knownDigests {
+		for i, fn := range []string{abc, empty} {
+			got, err := GetChecksumHash(fn, algo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want[i] {
+				t.Errorf("%v of %s: %s, want %s", algo, fn, got, want[i])
+			}
+			// Small files are hashed completely for the partial checksum
+			partial, full, err := GetPartialChecksumHash(fn, algo)
+			if err != nil || !full || partial != want[i] {
+				t.Errorf("partial %v of %s: %s, %v, %v, want %s", algo, fn, partial, full, err, want[i])
+			}
+		}
+		if algo.HexLen() != len(want[0]) {
+			t.Errorf("%v: HexLen %d, want %d", algo, algo.HexLen(), len(want[0]))
+		}
+		if parsed, err := ParseHashAlgo(algo.String()); err != nil || parsed != algo {
+			t.Errorf("ParseHashAlgo(%q) = %v, %v", algo.String(), parsed, err)
+		}
+	}
+	// The default of the functions without a HashAlgo is SHA-256
+	if got, _ := GetChecksum(abc); got != knownDigests[HashSHA256][0] {
+		t.Errorf("GetChecksum: %s, want SHA-256", got)
+	}
+}
+
+func TestParseHashAlgo(t *testing.T) {
+	if a, err := ParseHashAlgo("BLAKE3"); err != nil || a != HashBLAKE3 {
+		t.Errorf("ParseHashAlgo(BLAKE3) = %v, %v", a, err)
+	}
+	if _, err := ParseHashAlgo("sha3"); err == nil {
+		t.Error("ParseHashAlgo(sha3): no error")
+	}
+	if s := HashAlgo(-1).String(); s != "HashAlgo(-1)" {
+		t.Errorf("String of an invalid HashAlgo: %s", s)
+	}
+}
+
+func TestRegisterHashAlgo(t *testing.T) {
+	algo, err := RegisterHashAlgo("FNV128-test", fnv.New128a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RegisterHashAlgo("fnv128-TEST", fnv.New128a); err == nil {
+		t.Error("registering a name twice: no error")
+	}
+	if _, err := RegisterHashAlgo("md5", fnv.New128a); err == nil {
+		t.Error("registering a built-in name: no error")
+	}
+	if parsed, err := ParseHashAlgo("fnv128-test"); err != nil || parsed != algo || algo.String() != "fnv128-test" {
+		t.Errorf("ParseHashAlgo = %v, %v, want %v", parsed, err, algo)
+	}
+	fn := writeFile(t, t.TempDir(), "abc", []byte("abc"))
+	got, err := GetChecksumHash(fn, algo)
+	// FNV-1a 128 of "abc"
+	if want := "a68d622cec8b5822836dbc7977af7f3b"; err != nil || got != want {
+		t.Errorf("checksum with a registered algorithm: %s, %v, want %s", got, err, want)
+	}
+}
+
+// BenchmarkHashAlgo compares the throughput of the full checksum of a 1 GB file,
+// which is in the page cache after the first run, with SHA256 and BLAKE3
+func BenchmarkHashAlgo(b *testing.B) {
+	const size = 1 << 30
+	fn := filepath.Join(b.TempDir(), "1g")
+	f, err := os.Create(fn)
+	if err != nil {
+		b.Fatal(err)
+	}
+	block := randomData(1, 1<<20)
+	for i := 0; i < size/len(block); i++ {
+		if _, err := f.Write(block); err != nil {
+			f.Close()
+			b.Skip("can't write a 1 GB file:", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		b.Fatal(err)
+	}
+	for _, algo := range []HashAlgo{HashSHA256, HashBLAKE3} {
+		b.Run(algo.String(), func(b *testing.B) {
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				if _, err := GetChecksumHash(fn, algo); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package fcompare
+
+// index.go - Compare files that arrive one at a time with the files seen before
+// An Index keeps the groups of files that are the same, like CompareFiles, but
+// files are added one by one, and each Add reports the earlier files that the
+// new file is the same as. With CmpAuto, a file is only read when an earlier
+// file has the same size, and an earlier file is only read when a new file
+// has its size; the checksums of each file are computed at most once.
+
+import (
+	"context"
+	"os"
+	"sync"
+)
+
+// IndexConfig holds the settings of an Index
+type IndexConfig struct {
+	Method    CompareMethod
+	Algo      HashAlgo
+	Partial   PartialChecksumConfig // DefaultPartialChecksumConfig if zero
+	KeepATime bool
+	Cache     *Cache // if not nil, see CompareFilesCache
+}
+
+// Index holds the groups of the files that were added to it; see NewIndex
+type Index struct {
+	cfg compareConfig
+	mu  sync.Mutex
+	// Files in the order in which they were added
+	files []*indexedFile
+	// Groups of indexes into files; the first file of a group represents it
+	groups [][]int
+	// Group by checksum key, with methods other than CmpAuto and CmpBytes
+	byKey map[string]int
+	// Groups whose first file has a size, with CmpAuto and CmpBytes
+	bySize map[int64][]int
+	// Files by size, to find the same file under another name
+	filesBySize map[int64][]int
+}
+
+type indexedFile struct {
+	path       string
+	fi         os.FileInfo
+	group      int
+	byIdentity bool   // the same file as an earlier file, matched without reading it
+	partial    string // with CmpAuto, computed when another file has the same size
+	full       string
+}
+
+// NewIndex returns an empty Index.
+// Add can be called from several goroutines. With CmpAuto and CmpBytes, new
+// files are compared with earlier files, so these Adds run one at a time; with
+// the other methods, the checksums of files are computed at the same time.
+func NewIndex(cfg IndexConfig) (*Index, error) {
+	c := compareConfig{method: cfg.Method, algo: cfg.Algo, workers: 1, keepATime: cfg.KeepATime, partial: cfg.Partial, cache: cfg.Cache}
+	if !c.method.valid() {
+		return nil, ErrInvalidMethod
+	}
+	if c.partial == (PartialChecksumConfig{}) {
+		c.partial = DefaultPartialChecksumConfig
+	}
+	if err := c.partial.Validate(); err != nil {
+		return nil, err
+	}
+	return &Index{cfg: c, byKey: make(map[string]int), bySize: make(map[int64][]int), filesBySize: make(map[int64][]int)}, nil
+}
+
+// Add compares a file with the files that were added before, and adds it.
+// It returns the earlier files that are the same, in the order in which they were
+// added, or nil if there are none. A file that can't be read is not added.
+func (ix *Index) Add(path string) ([]string, error) {
+	matches, _, err := ix.add(context.Background(), path)
+	return matches, err
+}
+
+// add is Add, which also returns the checksum (or size) by which the file was
+// grouped; that is empty with CmpAuto and CmpBytes, and for files matched by identity
+func (ix *Index) add(ctx context.Context, path string) ([]string, string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, "", err
+	}
+	f := &indexedFile{path: path, fi: fi, group: -1}
+
+	// The same file is never read twice
+	ix.mu.Lock()
+	for _, i := range ix.filesBySize[fi.Size()] {
+		if os.SameFile(ix.files[i].fi, fi) {
+			f.group, f.byIdentity = ix.files[i].group, true
+			return ix.insert(f), "", nil
+		}
+	}
+
+	switch ix.cfg.method {
+	case CmpAuto, CmpBytes:
+		defer ix.mu.Unlock()
+		for _, g := range ix.bySize[fi.Size()] {
+			same, err := ix.same(ctx, ix.files[ix.groups[g][0]], f)
+			if err != nil {
+				return nil, "", err
+			}
+			if same {
+				f.group = g
+				break
+			}
+		}
+		return ix.insertLocked(f), "", nil
+	}
+	ix.mu.Unlock()
+
+	key, err := processFile(ctx, path, ix.cfg.method, ix.cfg.algo, ix.cfg.partial, ix.cfg.keepATime, ix.cfg.cache)
+	if err != nil {
+		return nil, "", err
+	}
+	ix.mu.Lock()
+	if g, ok := ix.byKey[key]; ok {
+		f.group = g
+	} else {
+		f.group = len(ix.groups)
+		ix.byKey[key] = f.group
+	}
+	return ix.insert(f), key, nil
+}
+
+// insert adds f, unlocks ix, and returns the earlier files of the group of f
+func (ix *Index) insert(f *indexedFile) []string {
+	defer ix.mu.Unlock()
+	return ix.insertLocked(f)
+}
+
+// insertLocked adds f to its group, or to a new group if it has none
+func (ix *Index) insertLocked(f *indexedFile) []string {
+	if f.group < 0 || f.group == len(ix.groups) {
+		f.group = len(ix.groups)
+		ix.groups = append(ix.groups, nil)
+		ix.bySize[f.fi.Size()] = append(ix.bySize[f.fi.Size()], f.group)
+	}
+	var matches []string
+	for _, i := range ix.groups[f.group] {
+		matches = append(matches, ix.files[i].path)
+	}
+	ix.groups[f.group] = append(ix.groups[f.group], len(ix.files))
+	ix.filesBySize[f.fi.Size()] = append(ix.filesBySize[f.fi.Size()], len(ix.files))
+	ix.files = append(ix.files, f)
+	return matches
+}
+
+// same reports whether a file with the same size as g is the same as g, with CmpAuto or CmpBytes
+func (ix *Index) same(ctx context.Context, g, f *indexedFile) (bool, error) {
+	if ix.cfg.method == CmpBytes {
+		equal, _, err := equalContents(ctx, g.path, f.path, ix.cfg.keepATime)
+		return equal, err
+	}
+	for _, stage := range []CompareMethod{CmpPartial, CmpFull} {
+		// Files up to the threshold are completely hashed by the partial stage
+		if stage == CmpFull && f.fi.Size() <= ix.cfg.partial.FullThreshold {
+			break
+		}
+		// An earlier file that can't be read anymore is not an error of f
+		sumG, err := ix.checksum(ctx, g, stage)
+		if err != nil {
+			return false, nil
+		}
+		sumF, err := ix.checksum(ctx, f, stage)
+		if err != nil {
+			return false, err
+		}
+		if sumG != sumF {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// checksum returns the partial or full checksum of a file, and computes it the first time
+func (ix *Index) checksum(ctx context.Context, f *indexedFile, method CompareMethod) (string, error) {
+	sum := &f.partial
+	if method == CmpFull {
+		sum = &f.full
+	}
+	if *sum != "" {
+		return *sum, nil
+	}
+	s, err := processFile(ctx, f.path, method, ix.cfg.algo, ix.cfg.partial, ix.cfg.keepATime, ix.cfg.cache)
+	if err != nil {
+		return "", err
+	}
+	*sum = s
+	return s, nil
+}
+
+// Groups returns the groups of files that are the same, in the order in which
+// their first file was added. Files keep their order within a group.
+func (ix *Index) Groups() []Group {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	groups := make([]Group, len(ix.groups))
+	for i, g := range ix.groups {
+		for _, j := range g {
+			groups[i].Files = append(groups[i].Files, ix.files[j].path)
+			if ix.files[j].byIdentity {
+				groups[i].ByIdentity = append(groups[i].ByIdentity, ix.files[j].path)
+			}
+		}
+	}
+	return groups
+}
+
+// Len returns the number of files in the index
+func (ix *Index) Len() int {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	return len(ix.files)
+}
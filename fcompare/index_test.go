@@ -0,0 +1,183 @@
+package fcompare
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// Matches are reported by the Add of the second identical file, and Groups
+// returns the groups so far between Adds
+func TestIndexAdd(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", randomData(1, 100000))
+	b := writeFile(t, dir, "b", randomData(2, 100000))
+	c := writeFile(t, dir, "c", randomData(1, 100000))
+	d := writeFile(t, dir, "d", randomData(3, 500))
+	e := writeFile(t, dir, "e", randomData(2, 100000))
+	steps := []struct {
+		path    string
+		matches []string
+		groups  [][]string
+	}{
+		{a, nil, [][]string{{a}}},
+		{b, nil, [][]string{{a}, {b}}},
+		{c, []string{a}, [][]string{{a, c}, {b}}},
+		{d, nil, [][]string{{a, c}, {b}, {d}}},
+		{e, []string{b}, [][]string{{a, c}, {b, e}, {d}}},
+		// The same path again is matched by identity
+		{a, []string{a, c}, [][]string{{a, c, a}, {b, e}, {d}}},
+	}
+	for _, method := range []CompareMethod{CmpPartial, CmpFull, CmpPartialAdaptive, CmpAuto, CmpBytes} {
+		ix, err := NewIndex(IndexConfig{Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, s := range steps {
+			matches, err := ix.Add(s.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(matches, s.matches) {
+				t.Errorf("method %d, add %d: matches %v, want %v", method, i, matches, s.matches)
+			}
+			var groups [][]string
+			for _, g := range ix.Groups() {
+				groups = append(groups, g.Files)
+			}
+			if !reflect.DeepEqual(groups, s.groups) {
+				t.Errorf("method %d, add %d: groups %v, want %v", method, i, groups, s.groups)
+			}
+		}
+		if g := ix.Groups()[0]; !reflect.DeepEqual(g.ByIdentity, []string{a}) {
+			t.Errorf("method %d: matched by identity %v, want %v", method, g.ByIdentity, []string{a})
+		}
+		if ix.Len() != len(steps) {
+			t.Errorf("method %d: Len %d, want %d", method, ix.Len(), len(steps))
+		}
+	}
+
+	// CmpSize groups files of the same size
+	ix, err := NewIndex(IndexConfig{Method: CmpSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range []string{a, b, d} {
+		if _, err := ix.Add(fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(ix.Groups()) != 2 {
+		t.Errorf("CmpSize: groups %v, want 2", ix.Groups())
+	}
+}
+
+// With CmpAuto, a file is only read when an earlier file has the same size,
+// and then the earlier file is read once
+func TestIndexAutoReads(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a", randomData(1, 100000))
+	b := writeFile(t, dir, "b", randomData(2, 50000))
+	c := writeFile(t, dir, "c", randomData(3, 100000))
+	d := writeFile(t, dir, "d", randomData(1, 100000))
+	ix, err := NewIndex(IndexConfig{Method: CmpAuto})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, ctx := newRecorder()
+	for _, fn := range []string{a, b} {
+		if _, _, err := ix.add(ctx, fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(rec.ranges) != 0 {
+		t.Errorf("files of unique sizes read: %v", rec.ranges)
+	}
+	for _, fn := range []string{c, d} {
+		if _, _, err := ix.add(ctx, fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := rec.ranges[b]; ok {
+		t.Errorf("file of a unique size read: %v", rec.ranges[b])
+	}
+	// a is hashed when c arrives, and its checksum is reused for d
+	for _, fn := range []string{a, c, d} {
+		var n int64
+		for _, r := range rec.ranges[fn] {
+			n += r.Length
+		}
+		if n != 100000 {
+			t.Errorf("read %d bytes of %s, want 100000", n, fn)
+		}
+	}
+}
+
+// A file that can't be read is not added; a later file with its content starts a new group
+func TestIndexAddError(t *testing.T) {
+	dir := t.TempDir()
+	ix, err := NewIndex(IndexConfig{Method: CmpFull})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ix.Add(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Errorf("missing file: error %v", err)
+	}
+	if ix.Len() != 0 {
+		t.Errorf("Len %d after an error, want 0", ix.Len())
+	}
+	if _, err := NewIndex(IndexConfig{Method: -1}); err != ErrInvalidMethod {
+		t.Errorf("invalid method: error %v, want ErrInvalidMethod", err)
+	}
+	if _, err := NewIndex(IndexConfig{Partial: PartialChecksumConfig{ChunkSize: 10}}); err == nil {
+		t.Error("invalid partial config: no error")
+	}
+}
+
+// Concurrent Adds give the groups of CompareFiles, and every file of a group but
+// the first that was added has matches
+func TestIndexConcurrentAdd(t *testing.T) {
+	dir := t.TempDir()
+	var fns []string
+	for i := 0; i < 40; i++ {
+		fns = append(fns, writeFile(t, dir, fmt.Sprint("f", i), randomData(int64(i%7), 20000)))
+	}
+	for _, method := range []CompareMethod{CmpPartial, CmpAuto} {
+		ix, err := NewIndex(IndexConfig{Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		matched := 0
+		for _, fn := range fns {
+			wg.Add(1)
+			go func(fn string) {
+				defer wg.Done()
+				matches, err := ix.Add(fn)
+				if err != nil {
+					t.Error(err)
+				}
+				ix.Groups()
+				mu.Lock()
+				if len(matches) > 0 {
+					matched++
+				}
+				mu.Unlock()
+			}(fn)
+		}
+		wg.Wait()
+		groups := ix.Groups()
+		if len(groups) != 7 || matched != len(fns)-7 {
+			t.Errorf("method %d: %d groups and %d files with matches, want 7 and %d", method, len(groups), matched, len(fns)-7)
+		}
+		for _, g := range groups {
+			if len(g.Files) != len(fns)/7 && len(g.Files) != len(fns)/7+1 {
+				t.Errorf("method %d: group %v", method, g.Files)
+			}
+		}
+	}
+}
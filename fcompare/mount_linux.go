@@ -0,0 +1,19 @@
+package fcompare
+
+import "syscall"
+
+// Mount flags in statfs f_flags, from linux/statfs.h
+const (
+	stRdonly  = 0x0001
+	stNoatime = 0x0400
+)
+
+// atimeUpdated reports whether reading a file in dir can change its access time.
+// It is false on filesystems that are mounted read-only or with noatime.
+func atimeUpdated(dir string) bool {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return true
+	}
+	return uint64(st.Flags)&(stRdonly|stNoatime) == 0
+}
@@ -0,0 +1,100 @@
+package fcompare
+
+// observe.go - Report what is done to files
+// An audit log must show what a program actually read from each file, not what
+// it would read according to the chunk layout. The functions that take a context
+// report every region that they read, and every time they set the file times,
+// to the FileObserver of the context.
+
+import (
+	"context"
+	"io"
+	"os"
+)
+
+// FileObserver is told what is done to files. Its functions can be nil, and can
+// be called from several goroutines at the same time.
+type FileObserver struct {
+	// A region of a file was read; consecutive reads are reported separately
+	Read func(path string, r Range)
+	// The file times of a file were set, to restore them after reading
+	Chtimes func(path string)
+}
+
+type observerKey struct{}
+
+// ContextWithObserver returns a context with which the functions of fcompare and
+// msinfo that take it report what they do to files to obs
+func ContextWithObserver(ctx context.Context, obs *FileObserver) context.Context {
+	return context.WithValue(ctx, observerKey{}, obs)
+}
+
+// observerOf returns the FileObserver of ctx, or one that does nothing
+func observerOf(ctx context.Context) *FileObserver {
+	if obs, ok := ctx.Value(observerKey{}).(*FileObserver); ok {
+		return obs
+	}
+	return &FileObserver{}
+}
+
+// ObservedFile is a file that is open for reading, and reports the regions that
+// are read to a FileObserver
+type ObservedFile struct {
+	f    *os.File
+	path string
+	obs  *FileObserver
+	pos  int64
+}
+
+// OpenObserved opens a file for reading, like os.Open. The regions that are read
+// are reported to the FileObserver of ctx, if it has one.
+func OpenObserved(ctx context.Context, path string) (*ObservedFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &ObservedFile{f: f, path: path, obs: observerOf(ctx)}, nil
+}
+
+func (o *ObservedFile) report(off int64, n int) {
+	if n > 0 && o.obs.Read != nil {
+		o.obs.Read(o.path, Range{Start: off, Length: int64(n)})
+	}
+}
+
+func (o *ObservedFile) Read(p []byte) (int, error) {
+	n, err := o.f.Read(p)
+	o.report(o.pos, n)
+	o.pos += int64(n)
+	return n, err
+}
+
+func (o *ObservedFile) ReadAt(p []byte, off int64) (int, error) {
+	n, err := o.f.ReadAt(p, off)
+	o.report(off, n)
+	return n, err
+}
+
+// Seek passes whence on to the file, so SEEK_DATA and SEEK_HOLE work too
+func (o *ObservedFile) Seek(offset int64, whence int) (int64, error) {
+	pos, err := o.f.Seek(offset, whence)
+	if err == nil {
+		o.pos = pos
+	}
+	return pos, err
+}
+
+func (o *ObservedFile) Stat() (os.FileInfo, error) {
+	return o.f.Stat()
+}
+
+func (o *ObservedFile) Name() string {
+	return o.path
+}
+
+func (o *ObservedFile) Close() error {
+	return o.f.Close()
+}
+
+// ObservedFile has no WriteTo, so io.Copy can't read the file without Read
+var _ io.ReadSeekCloser = (*ObservedFile)(nil)
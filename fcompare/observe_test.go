@@ -0,0 +1,173 @@
+package fcompare
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder is a FileObserver that remembers what it was told
+type recorder struct {
+	mu      sync.Mutex
+	ranges  map[string][]Range
+	chtimes map[string]int
+}
+
+func newRecorder() (*recorder, context.Context) {
+	rec := &recorder{ranges: make(map[string][]Range), chtimes: make(map[string]int)}
+	obs := &FileObserver{
+		Read: func(path string, r Range) {
+			rec.mu.Lock()
+			defer rec.mu.Unlock()
+			rec.ranges[path] = append(rec.ranges[path], r)
+		},
+		Chtimes: func(path string) {
+			rec.mu.Lock()
+			defer rec.mu.Unlock()
+			rec.chtimes[path]++
+		},
+	}
+	return rec, ContextWithObserver(context.Background(), obs)
+}
+
+// merged returns the ranges that were read from path, sorted and merged where they touch
+func (rec *recorder) merged(path string) []Range {
+	rs := append([]Range(nil), rec.ranges[path]...)
+	sort.Slice(rs, func(i, j int) bool { return rs[i].Start < rs[j].Start })
+	var out []Range
+	for _, r := range rs {
+		if n := len(out); n > 0 && r.Start <= out[n-1].Start+out[n-1].Length {
+			out[n-1].Length = max(out[n-1].Length, r.Start+r.Length-out[n-1].Start)
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// The partial checksum must read exactly its chunks, and nothing else
+func TestObserverPartialChecksum(t *testing.T) {
+	cfg := PartialChecksumConfig{ChunkSize: 4096, FullThreshold: 3 * 4096}
+	dir := t.TempDir()
+	for _, size := range []int{100, 3 * 4096, 100000} {
+		fn := writeFile(t, dir, "f", randomData(int64(size), size))
+		rec, ctx := newRecorder()
+		if _, err := GetPartialChecksumResultCtx(ctx, fn, HashSHA256, cfg); err != nil {
+			t.Fatal(err)
+		}
+		want := PartialChecksumRangesConfig(int64(size), cfg)
+		if got := rec.merged(fn); !reflect.DeepEqual(got, want) {
+			t.Errorf("size %d: read %v, want %v", size, got, want)
+		}
+	}
+}
+
+func TestObserverAdaptiveChecksum(t *testing.T) {
+	size := int64(3 * minPartialChecksumSize)
+	fn := writeFile(t, t.TempDir(), "f", randomData(2, int(size)))
+	rec, ctx := newRecorder()
+	if _, _, err := GetAdaptivePartialChecksumCtx(ctx, fn, HashSHA256, DefaultPartialChecksumConfig); err != nil {
+		t.Fatal(err)
+	}
+	want := AdaptivePartialChecksumRanges(size)
+	if got := rec.merged(fn); !reflect.DeepEqual(got, want) {
+		t.Errorf("read %v, want %v", got, want)
+	}
+}
+
+// EqualContents stops reading at the first difference
+func TestObserverEqualContents(t *testing.T) {
+	dir := t.TempDir()
+	data := randomData(3, 4*equalBlockSize)
+	a := writeFile(t, dir, "a", data)
+	data[100] ^= 1
+	b := writeFile(t, dir, "b", data)
+	rec, ctx := newRecorder()
+	equal, offset, err := EqualContentsCtx(ctx, a, b, true)
+	if err != nil || equal || offset != 100 {
+		t.Fatalf("EqualContentsCtx = %v, %d, %v, want false, 100, nil", equal, offset, err)
+	}
+	for _, fn := range []string{a, b} {
+		got := rec.merged(fn)
+		if len(got) != 1 || got[0].Start != 0 || got[0].Length <= 100 || got[0].Length >= int64(len(data)) {
+			t.Errorf("%s: read %v, want a prefix past the difference", fn, got)
+		}
+	}
+}
+
+func TestObserverRestoreTimes(t *testing.T) {
+	fn := writeFile(t, t.TempDir(), "f", []byte("x"))
+	fi, err := os.Stat(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, ctx := newRecorder()
+	atim := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := RestoreTimesCtx(ctx, fn, atim, fi.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if rec.chtimes[fn] != 1 {
+		t.Errorf("Chtimes reported %d times, want 1", rec.chtimes[fn])
+	}
+	// Times that are already right are not set again
+	if err := RestoreTimesCtx(ctx, fn, atim, fi.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if rec.chtimes[fn] != 1 {
+		t.Errorf("Chtimes reported %d times after a second restore, want 1", rec.chtimes[fn])
+	}
+}
+
+// Without an observer, reading works the same
+func TestOpenObservedWithoutObserver(t *testing.T) {
+	fn := writeFile(t, t.TempDir(), "f", []byte("hello"))
+	f, err := OpenObserved(context.Background(), fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	buf := make([]byte, 3)
+	if n, err := f.ReadAt(buf, 2); n != 3 || err != nil || string(buf) != "llo" {
+		t.Errorf("ReadAt = %d, %v, %q", n, err, buf)
+	}
+	if f.Name() != fn {
+		t.Errorf("Name() = %s, want %s", f.Name(), fn)
+	}
+}
+
+// Without keepATime, byte comparisons never set file times
+func TestEqualContentsKeepATime(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	var fns []string
+	for _, name := range []string{"a", "b", "c"} {
+		fn := writeFile(t, dir, name, randomData(1, 5000))
+		if err := os.Chtimes(fn, old, old); err != nil {
+			t.Fatal(err)
+		}
+		fns = append(fns, fn)
+	}
+	rec, ctx := newRecorder()
+	if equal, _, err := EqualContentsCtx(ctx, fns[0], fns[1], false); err != nil || !equal {
+		t.Fatalf("EqualContentsCtx = %v, %v", equal, err)
+	}
+	if _, err := CompareFilesOptCtx(ctx, fns, WithMethod(CmpBytes), WithKeepATime(false)); err != nil {
+		t.Fatal(err)
+	}
+	ix, err := NewIndex(IndexConfig{Method: CmpBytes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range fns {
+		if _, _, err := ix.add(ctx, fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(rec.ranges) != len(fns) || len(rec.chtimes) != 0 {
+		t.Errorf("read %d files, and set the times of %v, want %d files read and no times set", len(rec.ranges), rec.chtimes, len(fns))
+	}
+}
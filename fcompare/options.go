@@ -0,0 +1,94 @@
+package fcompare
+
+// options.go - Options of CompareFilesOpt
+// The CompareFilesXxx functions each add a parameter for one setting. With
+// CompareFilesOpt, settings are given as options, in any combination:
+//
+//	groups, err := fcompare.CompareFilesOpt(fns, fcompare.WithMethod(fcompare.CmpAuto),
+//		fcompare.WithKeepATime(true), fcompare.WithConcurrency(4))
+
+import (
+	"context"
+)
+
+// Option is a setting of CompareFilesOpt
+type Option func(*compareConfig)
+
+// defaultConfig has the settings of CompareFilesOpt without options: CmpPartial with
+// DefaultPartialChecksumConfig, SHA256, one file at a time, symlinks followed, and
+// file times not restored
+func defaultConfig() compareConfig {
+	return compareConfig{method: CmpPartial, algo: HashSHA256, workers: 1, partial: DefaultPartialChecksumConfig}
+}
+
+// WithMethod sets the compare method
+func WithMethod(method CompareMethod) Option {
+	return func(c *compareConfig) { c.method = method }
+}
+
+// WithHash sets the hash algorithm of the checksums
+func WithHash(algo HashAlgo) Option {
+	return func(c *compareConfig) { c.algo = algo }
+}
+
+// WithPartialConfig sets the chunk size and threshold of CmpPartial
+func WithPartialConfig(cfg PartialChecksumConfig) Option {
+	return func(c *compareConfig) { c.partial = cfg }
+}
+
+// WithKeepATime restores the file times of the files that are read
+func WithKeepATime(keep bool) Option {
+	return func(c *compareConfig) { c.keepATime = keep }
+}
+
+// WithCheckKeepAtime fails the comparison if file times can't be restored in the
+// directory of the first file, see TestKeepAtime
+func WithCheckKeepAtime(check bool) Option {
+	return func(c *compareConfig) { c.checkKeepAtime = check }
+}
+
+// WithConcurrency sets the number of files that are hashed at the same time, as
+// with CompareFilesParallel. Values below 1 mean 1.
+func WithConcurrency(workers int) Option {
+	return func(c *compareConfig) { c.workers = max(workers, 1) }
+}
+
+// WithStopOnError stops at the first file that can't be read, as with CompareFilesFailFast
+func WithStopOnError(stop bool) Option {
+	return func(c *compareConfig) { c.stopOnError = stop }
+}
+
+// WithOnlyDuplicates leaves out the groups of a single file, as with CompareFilesDuplicates
+func WithOnlyDuplicates(only bool) Option {
+	return func(c *compareConfig) { c.onlyDuplicates = only }
+}
+
+// WithSymlinks sets the handling of names that are symlinks, as with CompareFilesSymlinks
+func WithSymlinks(policy SymlinkPolicy) Option {
+	return func(c *compareConfig) { c.symlinks = policy }
+}
+
+// WithCache takes checksums from cache and stores new ones in it, as with CompareFilesCache
+func WithCache(cache *Cache) Option {
+	return func(c *compareConfig) { c.cache = cache }
+}
+
+// CompareFilesOpt compares files with the given options, and returns groups of
+// indexes into fns, like CompareFiles. See defaultConfig for the settings without options.
+func CompareFilesOpt(fns []string, opts ...Option) ([][]int, error) {
+	return CompareFilesOptCtx(context.Background(), fns, opts...)
+}
+
+// CompareFilesOptCtx is CompareFilesOpt, but stops when ctx is cancelled, like CompareFilesCtx
+func CompareFilesOptCtx(ctx context.Context, fns []string, opts ...Option) ([][]int, error) {
+	return compareFiles(ctx, fns, configOf(opts))
+}
+
+// configOf returns the default settings, changed by opts
+func configOf(opts []Option) compareConfig {
+	cfg := defaultConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
@@ -0,0 +1,83 @@
+package fcompare
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// Each option changes its own setting of the defaults, and nothing else
+func TestOptions(t *testing.T) {
+	cache := &Cache{}
+	partial := PartialChecksumConfig{ChunkSize: 4096, FullThreshold: 3 * 4096}
+	for _, tc := range []struct {
+		name string
+		opts []Option
+		want func(c *compareConfig)
+	}{
+		{"none", nil, func(c *compareConfig) {}},
+		{"method", []Option{WithMethod(CmpAuto)}, func(c *compareConfig) { c.method = CmpAuto }},
+		{"hash", []Option{WithHash(HashMD5)}, func(c *compareConfig) { c.algo = HashMD5 }},
+		{"partial config", []Option{WithPartialConfig(partial)}, func(c *compareConfig) { c.partial = partial }},
+		{"keep atime", []Option{WithKeepATime(true)}, func(c *compareConfig) { c.keepATime = true }},
+		{"check keep atime", []Option{WithCheckKeepAtime(true)}, func(c *compareConfig) { c.checkKeepAtime = true }},
+		{"concurrency", []Option{WithConcurrency(8)}, func(c *compareConfig) { c.workers = 8 }},
+		{"concurrency below 1", []Option{WithConcurrency(0)}, func(c *compareConfig) { c.workers = 1 }},
+		{"stop on error", []Option{WithStopOnError(true)}, func(c *compareConfig) { c.stopOnError = true }},
+		{"only duplicates", []Option{WithOnlyDuplicates(true)}, func(c *compareConfig) { c.onlyDuplicates = true }},
+		{"symlinks", []Option{WithSymlinks(SymlinkSkip)}, func(c *compareConfig) { c.symlinks = SymlinkSkip }},
+		{"cache", []Option{WithCache(cache)}, func(c *compareConfig) { c.cache = cache }},
+		{"combined", []Option{WithMethod(CmpFull), WithHash(HashBLAKE3), WithConcurrency(4), WithKeepATime(true)}, func(c *compareConfig) {
+			c.method, c.algo, c.workers, c.keepATime = CmpFull, HashBLAKE3, 4, true
+		}},
+		{"last wins", []Option{WithMethod(CmpFull), WithMethod(CmpBytes)}, func(c *compareConfig) { c.method = CmpBytes }},
+	} {
+		want := defaultConfig()
+		tc.want(&want)
+		if got := configOf(tc.opts); got != want {
+			t.Errorf("%s: config %+v, want %+v", tc.name, got, want)
+		}
+	}
+}
+
+// CompareFilesOpt gives the groups of the CompareFilesXxx function with the same settings
+func TestCompareFilesOpt(t *testing.T) {
+	fns := writeCorpus(t, t.TempDir(), 24, 30000)
+	partial := PartialChecksumConfig{ChunkSize: 4096, FullThreshold: 3 * 4096}
+	for _, tc := range []struct {
+		name   string
+		opts   []Option
+		legacy func() ([][]int, error)
+	}{
+		{"defaults", nil, func() ([][]int, error) { return CompareFiles(fns, CmpPartial, false, false) }},
+		{"method and keep atime", []Option{WithMethod(CmpFull), WithKeepATime(true)},
+			func() ([][]int, error) { return CompareFiles(fns, CmpFull, true, false) }},
+		{"hash", []Option{WithMethod(CmpFull), WithHash(HashMD5)},
+			func() ([][]int, error) { return CompareFilesHash(fns, CmpFull, HashMD5, false, false) }},
+		{"partial config", []Option{WithHash(HashCRC32), WithPartialConfig(partial)},
+			func() ([][]int, error) { return CompareFilesConfig(fns, CmpPartial, HashCRC32, partial, false, false) }},
+		{"concurrency", []Option{WithMethod(CmpAuto), WithConcurrency(4)},
+			func() ([][]int, error) { return CompareFilesParallel(fns, CmpAuto, 4, false, false) }},
+		{"only duplicates", []Option{WithMethod(CmpBytes), WithOnlyDuplicates(true)},
+			func() ([][]int, error) { return CompareFilesDuplicates(fns, CmpBytes, false, false) }},
+		{"symlinks", []Option{WithSymlinks(SymlinkCompareTarget)},
+			func() ([][]int, error) {
+				return CompareFilesSymlinks(fns, CmpPartial, SymlinkCompareTarget, false, false)
+			}},
+	} {
+		want, err := tc.legacy()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := CompareFilesOpt(fns, tc.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: groups %v, want %v", tc.name, got, want)
+		}
+	}
+	if _, err := CompareFilesOpt(fns, WithMethod(-1)); !errors.Is(err, ErrInvalidMethod) {
+		t.Errorf("invalid method: error %v, want ErrInvalidMethod", err)
+	}
+}
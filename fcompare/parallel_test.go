@@ -0,0 +1,159 @@
+package fcompare
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+// writeCorpus writes n files of size bytes in dir, in groups of up to 4 with the same
+// content, and some of the same size with other content. The names are in random order.
+func writeCorpus(t testing.TB, dir string, n, size int) []string {
+	t.Helper()
+	fns := make([]string, n)
+	for i, p := range randomPerm(n) {
+		data := randomData(int64(p/4), size)
+		if p%7 == 0 {
+			data = randomData(int64(n+p), size)
+		}
+		fns[i] = writeFile(t, dir, fmt.Sprintf("f%04d.mzML", p), data)
+	}
+	return fns
+}
+
+func randomPerm(n int) []int {
+	perm := make([]int, n)
+	data := randomData(int64(n), n)
+	for i := range perm {
+		j := int(data[i]) % (i + 1)
+		perm[i] = perm[j]
+		perm[j] = i
+	}
+	return perm
+}
+
+// The groups are the same for every number of workers and every method
+func TestCompareFilesParallelDeterministic(t *testing.T) {
+	dir := t.TempDir()
+	fns := writeCorpus(t, dir, 60, 5000)
+	// Duplicate names are grouped with their first occurrence
+	fns = append(fns, fns[3], fns[10])
+	for _, method := range []CompareMethod{CmpSize, CmpPartial, CmpFull, CmpPartialAdaptive, CmpAuto, CmpBytes} {
+		want, err := CompareFiles(fns, method, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 1; i < len(want); i++ {
+			if want[i-1][0] >= want[i][0] {
+				t.Fatalf("method %d: groups not ordered by their first file: %v", method, want)
+			}
+		}
+		for _, workers := range []int{1, 2, 8, 64} {
+			for run := 0; run < 3; run++ {
+				got, err := CompareFilesParallel(fns, method, workers, false, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Fatalf("method %d, %d workers: groups %v, want %v", method, workers, got, want)
+				}
+			}
+		}
+	}
+}
+
+// Unreadable files are reported by index, whatever worker processed them
+func TestCompareFilesParallelErrors(t *testing.T) {
+	dir := t.TempDir()
+	fns := writeCorpus(t, dir, 20, 1000)
+	for _, i := range []int{4, 11} {
+		if err := os.Remove(fns[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, workers := range []int{1, 8} {
+		_, err := CompareFilesParallel(fns, CmpFull, workers, false, false)
+		var cmpErr *CompareError
+		if !errors.As(err, &cmpErr) || len(cmpErr.Failed) != 2 || cmpErr.Failed[0].Index != 4 || cmpErr.Failed[1].Index != 11 {
+			t.Errorf("%d workers: error %#v, want files 4 and 11", workers, err)
+		}
+	}
+}
+
+func BenchmarkCompareFilesParallel(b *testing.B) {
+	fns := writeCorpus(b, b.TempDir(), 200, 1<<20)
+	for workers := 1; workers <= 2*runtime.GOMAXPROCS(0); workers *= 2 {
+		b.Run(fmt.Sprint("workers=", workers), func(b *testing.B) {
+			b.SetBytes(int64(len(fns)) << 20)
+			for i := 0; i < b.N; i++ {
+				if _, err := CompareFilesParallel(fns, CmpFull, workers, false, false); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// The first unreadable file stops all workers, and is the only file in the error
+func TestCompareFilesFailFast(t *testing.T) {
+	dir := t.TempDir()
+	fns := writeCorpus(t, dir, 40, 1000)
+	for _, i := range []int{5, 6, 30} {
+		if err := os.Remove(fns[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, workers := range []int{1, 8} {
+		groups, err := CompareFilesFailFast(context.Background(), fns, CmpFull, workers, false, false)
+		var cmpErr *CompareError
+		if !errors.As(err, &cmpErr) || len(cmpErr.Failed) != 1 {
+			t.Fatalf("%d workers: error %#v, want one failed file", workers, err)
+		}
+		if i := cmpErr.Failed[0].Index; i != 5 && i != 6 && i != 30 {
+			t.Errorf("%d workers: failed file %d isn't one of the removed files", workers, i)
+		}
+		n := 0
+		for _, g := range groups {
+			n += len(g)
+		}
+		if n >= len(fns)-2 {
+			t.Errorf("%d workers: %d files grouped after the first error", workers, n)
+		}
+		if workers == 1 && (cmpErr.Failed[0].Index != 5 || n != 5) {
+			t.Errorf("1 worker: failed file %d after %d files, want file 5 after 5 files", cmpErr.Failed[0].Index, n)
+		}
+	}
+	// Without errors, the result is that of CompareFiles
+	ok := writeCorpus(t, t.TempDir(), 30, 1000)
+	want, _ := CompareFiles(ok, CmpFull, false, false)
+	if got, err := CompareFilesFailFast(context.Background(), ok, CmpFull, 8, false, false); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("CompareFilesFailFast = %v, %v, want %v", got, err, want)
+	}
+}
+
+func TestCompareFilesFailFastCancel(t *testing.T) {
+	fns := writeCorpus(t, t.TempDir(), 10, 1000)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := CompareFilesFailFast(ctx, fns, CmpFull, 4, false, false); !errors.Is(err, context.Canceled) {
+		t.Errorf("error %v, want context.Canceled", err)
+	}
+}
+
+func BenchmarkCompareFilesFailFast(b *testing.B) {
+	fns := writeCorpus(b, b.TempDir(), 200, 1<<20)
+	for _, workers := range []int{1, 8} {
+		b.Run(fmt.Sprint("N=", workers), func(b *testing.B) {
+			b.SetBytes(int64(len(fns)) << 20)
+			for i := 0; i < b.N; i++ {
+				if _, err := CompareFilesFailFast(context.Background(), fns, CmpFull, workers, false, false); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
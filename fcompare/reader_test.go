@@ -0,0 +1,61 @@
+package fcompare
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// The reader-based functions give the digests of the path-based functions for the same content
+func TestChecksumReaders(t *testing.T) {
+	dir := t.TempDir()
+	cfg := PartialChecksumConfig{ChunkSize: 4096, FullThreshold: 3 * 4096}
+	for _, size := range []int{0, 100, 3 * 4096, 3*4096 + 1, 100000} {
+		data := randomData(int64(size), size)
+		fn := writeFile(t, dir, fmt.Sprint("f", size), data)
+		for _, algo := range []HashAlgo{HashSHA256, HashMD5, HashCRC32, HashBLAKE3} {
+			want, err := GetChecksumHash(fn, algo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, err := ChecksumReader(bytes.NewReader(data), algo); err != nil || got != want {
+				t.Errorf("size %d, %v: ChecksumReader = %s, %v, want %s", size, algo, got, err, want)
+			}
+			wantPartial, wantFull, err := GetPartialChecksumConfig(fn, algo, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, full, err := PartialChecksumReaderAtHash(bytes.NewReader(data), int64(size), algo, cfg)
+			if err != nil || got != wantPartial || full != wantFull {
+				t.Errorf("size %d, %v: PartialChecksumReaderAtHash = %s, %v, %v, want %s, %v", size, algo, got, full, err, wantPartial, wantFull)
+			}
+		}
+		want, wantFull, _ := GetPartialChecksum(fn)
+		if got, full, err := PartialChecksumReader(bytes.NewReader(data), int64(size)); err != nil || got != want || full != wantFull {
+			t.Errorf("size %d: PartialChecksumReader = %s, %v, %v, want %s, %v", size, got, full, err, want, wantFull)
+		}
+		if got, full, err := PartialChecksumReaderAt(bytes.NewReader(data), int64(size), DefaultPartialChecksumConfig); err != nil || got != want || full != wantFull {
+			t.Errorf("size %d: PartialChecksumReaderAt = %s, %v, %v, want %s, %v", size, got, full, err, want, wantFull)
+		}
+	}
+}
+
+// Only the first size bytes of the ReaderAt are hashed
+func TestPartialChecksumReaderAtSize(t *testing.T) {
+	data := randomData(5, 50000)
+	cfg := PartialChecksumConfig{ChunkSize: 4096, FullThreshold: 3 * 4096}
+	want, _, err := PartialChecksumReaderAt(bytes.NewReader(data[:40000]), 40000, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _, err := PartialChecksumReaderAt(bytes.NewReader(data), 40000, cfg); err != nil || got != want {
+		t.Errorf("PartialChecksumReaderAt of a prefix = %s, %v, want %s", got, err, want)
+	}
+	if _, _, err := PartialChecksumReaderAt(bytes.NewReader(data), 40000, PartialChecksumConfig{ChunkSize: 4096}); err == nil {
+		t.Error("invalid config: no error")
+	}
+	// A ReaderAt that is shorter than size is an error, not a checksum of less data
+	if _, _, err := PartialChecksumReaderAt(bytes.NewReader(data[:30000]), 40000, cfg); err == nil {
+		t.Error("short ReaderAt: no error")
+	}
+}
@@ -0,0 +1,94 @@
+package fcompare
+
+import (
+	"errors"
+	"io"
+	"syscall"
+)
+
+// whence values of lseek that find the data and holes of sparse files
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+// sparseFile reads a file, and returns zeros for its holes without reading them.
+// The result is the same as reading the file, so checksums are the same as on
+// other platforms. If the filesystem can't find holes, the file is read normally.
+type sparseFile struct {
+	f         *ObservedFile
+	off       int64
+	regionEnd int64 // end of the data or hole at off
+	inHole    bool
+	plain     bool // SEEK_DATA is not supported
+}
+
+func sparseReader(f *ObservedFile) io.Reader {
+	return &sparseFile{f: f}
+}
+
+func (s *sparseFile) Read(p []byte) (int, error) {
+	if s.plain {
+		return s.f.Read(p)
+	}
+	if s.off >= s.regionEnd {
+		if err := s.findRegion(); err != nil {
+			return 0, err
+		}
+		if s.plain {
+			// Nothing was read yet from the current position
+			if _, err := s.f.Seek(s.off, io.SeekStart); err != nil {
+				return 0, err
+			}
+			return s.f.Read(p)
+		}
+	}
+	p = p[:min(int64(len(p)), s.regionEnd-s.off)]
+	var n int
+	var err error
+	if s.inHole {
+		clear(p)
+		n = len(p)
+	} else {
+		n, err = s.f.ReadAt(p, s.off)
+		// The file may have changed since the region was found
+		if err == io.EOF && n > 0 {
+			err = nil
+		}
+	}
+	s.off += int64(n)
+	return n, err
+}
+
+// findRegion finds the data or hole that starts at s.off
+func (s *sparseFile) findRegion() error {
+	data, err := s.f.Seek(s.off, seekData)
+	if errors.Is(err, syscall.ENXIO) {
+		// There is no data after s.off: the rest of the file is a hole
+		fi, err := s.f.Stat()
+		if err != nil {
+			return err
+		}
+		if s.off >= fi.Size() {
+			return io.EOF
+		}
+		s.inHole, s.regionEnd = true, fi.Size()
+		return nil
+	}
+	if err != nil {
+		s.plain = true
+		return nil
+	}
+	if data > s.off {
+		s.inHole, s.regionEnd = true, data
+		return nil
+	}
+	hole, err := s.f.Seek(s.off, seekHole)
+	if err != nil {
+		s.plain = true
+		return nil
+	}
+	// The end of the file counts as a hole; a file that grows is read up to there first
+	s.inHole, s.regionEnd = false, max(hole, s.off+1)
+	return nil
+}
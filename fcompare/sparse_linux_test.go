@@ -0,0 +1,62 @@
+package fcompare
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+)
+
+// The holes of a sparse file are not read, if the filesystem can find them
+func TestSparseHolesNotRead(t *testing.T) {
+	const size = 64 << 20
+	fn := writeSparseFile(t, t.TempDir(), "sparse", size, map[int64][]byte{0: []byte("head"), 32 << 20: []byte("middle")})
+	f, err := os.Open(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := f.Seek(1<<20, seekData)
+	f.Close()
+	if err != nil || data == 1<<20 {
+		t.Skipf("the filesystem doesn't find holes: SEEK_DATA gives %d, %v", data, err)
+	}
+	rec, ctx := newRecorder()
+	if _, err := GetChecksumHashCtx(ctx, fn, HashSHA256); err != nil {
+		t.Fatal(err)
+	}
+	var n int64
+	for _, r := range rec.ranges[fn] {
+		n += r.Length
+	}
+	if n == 0 || n > 1<<20 {
+		t.Errorf("read %d bytes of a file with 2 blocks of data", n)
+	}
+}
+
+// A file that is read through sparseReader gives the same data as reading it
+func TestSparseReader(t *testing.T) {
+	const size = 10 << 20
+	fn := writeSparseFile(t, t.TempDir(), "sparse", size, map[int64][]byte{3 << 20: []byte("data"), size - 1: []byte("z")})
+	want, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := OpenObserved(context.Background(), fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r := sparseReader(f)
+	var got []byte
+	buf := make([]byte, 12345) // reads that don't line up with the regions
+	for {
+		n, err := r.Read(buf)
+		got = append(got, buf[:n]...)
+		if err != nil {
+			break
+		}
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("read %d bytes, want %d; content differs", len(got), len(want))
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package fcompare
+
+import (
+	"io"
+)
+
+// sparseReader returns the file itself; holes are only skipped on Linux
+func sparseReader(f *ObservedFile) io.Reader {
+	return f
+}
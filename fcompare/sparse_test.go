@@ -0,0 +1,68 @@
+package fcompare
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+// A sparse file and a dense copy of the same content have the same checksums
+func TestSparseDenseChecksum(t *testing.T) {
+	dir := t.TempDir()
+	const size = 24 << 20
+	for name, data := range map[string]map[int64][]byte{
+		"hole at the end":   {0: []byte("head")},
+		"hole at the start": {size - 4: []byte("tail")},
+		"holes around data": {8 << 20: bytes.Repeat([]byte("x"), 100000)},
+		"only a hole":       {},
+		"data in between":   {0: []byte("a"), 5<<20 + 3: []byte("b"), 17 << 20: []byte("c"), size - 1: []byte("d")},
+	} {
+		sparse := writeSparseFile(t, dir, "sparse", size, data)
+		content, err := os.ReadFile(sparse)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dense := writeFile(t, dir, "dense", content)
+		for _, algo := range []HashAlgo{HashSHA256, HashCRC64} {
+			got, err := GetChecksumHash(sparse, algo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := GetChecksumHash(dense, algo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if plain, _ := ChecksumReader(bytes.NewReader(content), algo); got != want || plain != want {
+				t.Errorf("%s, %v: sparse %s, dense %s, from memory %s", name, algo, got, want, plain)
+			}
+		}
+	}
+}
+
+// BenchmarkSparseChecksum compares the full checksum of a 10 GB sparse file with
+// that of reading all of it
+func BenchmarkSparseChecksum(b *testing.B) {
+	fn := writeSparseFile(b, b.TempDir(), "sparse", 10<<30, map[int64][]byte{0: []byte("head"), 5 << 30: []byte("middle")})
+	b.Run("sparse-aware", func(b *testing.B) {
+		b.SetBytes(10 << 30)
+		for i := 0; i < b.N; i++ {
+			if _, err := GetChecksum(fn); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("read", func(b *testing.B) {
+		b.SetBytes(10 << 30)
+		for i := 0; i < b.N; i++ {
+			f, err := os.Open(fn)
+			if err != nil {
+				b.Fatal(err)
+			}
+			_, err = ChecksumReader(f, HashSHA256)
+			f.Close()
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,71 @@
+package fcompare
+
+// stream.go - Report the result of each file as soon as it is known
+// CompareFiles returns the groups when all files are done, which can take hours
+// for an archive. CompareFilesStream sends the result of each file while the
+// others are still being hashed, so duplicates can be shown as they are found.
+
+import (
+	"context"
+	"sync"
+)
+
+// FileResult is the result of a file, sent by CompareFilesStream
+type FileResult struct {
+	Index int // index of the file in fns
+	Path  string
+	// Checksum (or size, with CmpSize) by which the file was grouped; empty with
+	// CmpAuto and CmpBytes, and for files matched by identity
+	Digest string
+	// Files that were done before, and are the same as this file
+	Matches []string
+	Err     error
+}
+
+// CompareFilesStream compares files like an Index, with up to workers files hashed
+// at the same time, and sends the result of each file on the returned channel as
+// soon as it is done. Results are in the order in which files are done, and the
+// matches of a file are the files that were done before it.
+// The channel is closed when all files are done, or when ctx is cancelled; then
+// files that were not done yet get no result. Hashing waits while the receiver
+// is slow, so the receiver must read until the channel is closed, or cancel ctx.
+func CompareFilesStream(ctx context.Context, fns []string, cfg IndexConfig, workers int) (<-chan FileResult, error) {
+	ix, err := NewIndex(cfg)
+	if err != nil {
+		return nil, err
+	}
+	results := make(chan FileResult)
+	go func() {
+		defer close(results)
+		next := make(chan int)
+		var wg sync.WaitGroup
+		for w := 0; w < min(max(workers, 1), len(fns)); w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range next {
+					matches, digest, err := ix.add(ctx, fns[i])
+					if ctx.Err() != nil {
+						return
+					}
+					select {
+					case results <- FileResult{Index: i, Path: fns[i], Digest: digest, Matches: matches, Err: err}:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}()
+		}
+	feed:
+		for i := range fns {
+			select {
+			case next <- i:
+			case <-ctx.Done():
+				break feed
+			}
+		}
+		close(next)
+		wg.Wait()
+	}()
+	return results, nil
+}
@@ -0,0 +1,123 @@
+package fcompare
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// A slow receiver gets every result, with the matches of each duplicate
+func TestCompareFilesStreamSlowConsumer(t *testing.T) {
+	fns := writeCorpus(t, t.TempDir(), 30, 20000)
+	missing := filepath.Join(t.TempDir(), "missing")
+	fns = append(fns, missing)
+	want, err := CompareFilesNamed(fns[:30], CmpPartial, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := CompareFilesStream(context.Background(), fns, IndexConfig{Method: CmpPartial}, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	n, matched := 0, 0
+	for r := range results {
+		time.Sleep(5 * time.Millisecond)
+		n++
+		if fns[r.Index] != r.Path {
+			t.Errorf("result %d has path %s, want %s", r.Index, r.Path, fns[r.Index])
+		}
+		if r.Path == missing {
+			if !os.IsNotExist(r.Err) {
+				t.Errorf("%s: error %v", missing, r.Err)
+			}
+			continue
+		}
+		if r.Err != nil || r.Digest == "" {
+			t.Errorf("%s: digest %q, error %v", r.Path, r.Digest, r.Err)
+		}
+		// The matches are the files of its group that were sent before
+		for _, m := range r.Matches {
+			if !seen[m] {
+				t.Errorf("%s matches %s, which was not sent yet", r.Path, m)
+			}
+		}
+		if len(r.Matches) > 0 {
+			matched++
+		}
+		seen[r.Path] = true
+	}
+	if n != len(fns) {
+		t.Errorf("%d results, want %d", n, len(fns))
+	}
+	// Each file after the first of its group has matches
+	if len(seen) != 30 || matched != 30-len(want) {
+		t.Errorf("%d files sent without error, %d with matches, want 30 and %d", len(seen), matched, 30-len(want))
+	}
+}
+
+// The first file of a group has no matches, and each later file matches all earlier ones
+func TestCompareFilesStreamMatches(t *testing.T) {
+	dir := t.TempDir()
+	var fns []string
+	for i := 0; i < 12; i++ {
+		fns = append(fns, writeFile(t, dir, fmt.Sprint("f", i), randomData(int64(i%3), 5000)))
+	}
+	for _, method := range []CompareMethod{CmpPartial, CmpFull, CmpAuto, CmpBytes} {
+		results, err := CompareFilesStream(context.Background(), fns, IndexConfig{Method: method}, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sent := make(map[int]int) // files sent per group of randomData seeds
+		for r := range results {
+			if r.Err != nil {
+				t.Fatal(r.Err)
+			}
+			g := r.Index % 3
+			if len(r.Matches) != sent[g] {
+				t.Errorf("method %d: %s has %d matches, want %d", method, r.Path, len(r.Matches), sent[g])
+			}
+			sent[g]++
+		}
+	}
+	if _, err := CompareFilesStream(context.Background(), fns, IndexConfig{Method: -1}, 1); err != ErrInvalidMethod {
+		t.Errorf("invalid method: error %v, want ErrInvalidMethod", err)
+	}
+}
+
+// Cancelling without reading the channel stops the workers, and closes the channel
+func TestCompareFilesStreamCancel(t *testing.T) {
+	fns := writeCorpus(t, t.TempDir(), 50, 20000)
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	results, err := CompareFilesStream(ctx, fns, IndexConfig{Method: CmpFull}, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-results
+	cancel()
+	deadline := time.After(10 * time.Second)
+	for n := 0; ; n++ {
+		select {
+		case _, ok := <-results:
+			if !ok {
+				if n >= len(fns)-1 {
+					t.Errorf("all %d results sent after cancel", n)
+				}
+				for i := 0; runtime.NumGoroutine() > before && i < 100; i++ {
+					time.Sleep(10 * time.Millisecond)
+				}
+				if g := runtime.NumGoroutine(); g > before {
+					t.Errorf("%d goroutines after the channel was closed, %d before", g, before)
+				}
+				return
+			}
+		case <-deadline:
+			t.Fatal("channel not closed after cancel")
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package fcompare
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// SymlinkPolicy sets how CompareFilesSymlinks handles names that are symlinks.
+// A broken symlink, or a symlink loop, is always an error of that file only,
+// which is reported in the *CompareError.
+type SymlinkPolicy int
+
+const (
+	// SymlinkFollow reads the target through the symlink, like all other functions do.
+	// A symlink to a file that is also compared is matched by identity.
+	SymlinkFollow SymlinkPolicy = iota
+	// SymlinkSkip leaves symlinks out of the groups, and reports them in the
+	// *CompareError with ErrSymlinkSkipped
+	SymlinkSkip
+	// SymlinkCompareTarget resolves symlinks with filepath.EvalSymlinks, and reads
+	// and restores the times of the target. Symlinks to the same target are
+	// matched by identity, so each target is read once.
+	SymlinkCompareTarget
+)
+
+// ErrSymlinkSkipped is the error of the symlinks that SymlinkSkip leaves out
+var ErrSymlinkSkipped = errors.New("symlink skipped")
+
+// applySymlinkPolicy returns the paths that are read for fns, and the error
+// of each name that is not read because of the policy
+func applySymlinkPolicy(fns []string, policy SymlinkPolicy) ([]string, []error) {
+	paths := append([]string(nil), fns...)
+	errs := make([]error, len(fns))
+	if policy == SymlinkFollow {
+		return paths, errs
+	}
+	for i, fn := range fns {
+		// Other errors are reported when the file is read
+		fi, err := os.Lstat(fn)
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		switch policy {
+		case SymlinkSkip:
+			errs[i] = &os.PathError{Op: "compare", Path: fn, Err: ErrSymlinkSkipped}
+		case SymlinkCompareTarget:
+			paths[i], errs[i] = filepath.EvalSymlinks(fn)
+		}
+	}
+	return paths, errs
+}
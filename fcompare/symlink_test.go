@@ -0,0 +1,85 @@
+package fcompare
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// symlinkCorpus returns a file, a symlink to it, a copy, another file, a broken
+// symlink and a symlink loop
+func symlinkCorpus(t *testing.T) []string {
+	t.Helper()
+	dir := t.TempDir()
+	data := randomData(13, 5000)
+	a := writeFile(t, dir, "a", data)
+	b := writeFile(t, dir, "b", data)
+	c := writeFile(t, dir, "c", randomData(14, 5000))
+	link := filepath.Join(dir, "link")
+	broken := filepath.Join(dir, "broken")
+	loop := filepath.Join(dir, "loop1")
+	for _, l := range [][2]string{{"a", link}, {"missing", broken}, {"loop2", loop}, {"loop1", filepath.Join(dir, "loop2")}} {
+		if err := os.Symlink(l[0], l[1]); err != nil {
+			t.Skip("can't create symlinks:", err)
+		}
+	}
+	return []string{a, link, b, c, broken, loop}
+}
+
+func TestSymlinkPolicies(t *testing.T) {
+	fns := symlinkCorpus(t)
+	for _, tc := range []struct {
+		policy  SymlinkPolicy
+		groups  [][]int
+		failed  []int
+		skipped []int // failed with ErrSymlinkSkipped
+	}{
+		{SymlinkFollow, [][]int{{0, 1, 2}, {3}}, []int{4, 5}, nil},
+		{SymlinkSkip, [][]int{{0, 2}, {3}}, []int{1, 4, 5}, []int{1, 4, 5}},
+		{SymlinkCompareTarget, [][]int{{0, 1, 2}, {3}}, []int{4, 5}, nil},
+	} {
+		for _, method := range []CompareMethod{CmpFull, CmpAuto} {
+			groups, err := CompareFilesSymlinks(fns, method, tc.policy, false, false)
+			var cmpErr *CompareError
+			if !errors.As(err, &cmpErr) {
+				t.Fatalf("policy %d: error %v, want a CompareError", tc.policy, err)
+			}
+			if !reflect.DeepEqual(groups, tc.groups) {
+				t.Errorf("policy %d, method %d: groups %v, want %v", tc.policy, method, groups, tc.groups)
+			}
+			var failed, skipped []int
+			for _, f := range cmpErr.Failed {
+				failed = append(failed, f.Index)
+				if errors.Is(f.Err, ErrSymlinkSkipped) {
+					skipped = append(skipped, f.Index)
+				}
+			}
+			sort.Ints(failed)
+			if !reflect.DeepEqual(failed, tc.failed) || !reflect.DeepEqual(skipped, tc.skipped) {
+				t.Errorf("policy %d, method %d: failed %v, skipped %v, want %v, %v", tc.policy, method, failed, skipped, tc.failed, tc.skipped)
+			}
+		}
+	}
+}
+
+// With SymlinkCompareTarget, the target is read once for all names of it
+func TestSymlinkCompareTargetReadsOnce(t *testing.T) {
+	fns := symlinkCorpus(t)
+	rec, ctx := newRecorder()
+	if _, err := CompareFilesOptCtx(ctx, fns[:4], WithMethod(CmpFull), WithSymlinks(SymlinkCompareTarget)); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.ranges[fns[1]]) != 0 {
+		t.Errorf("the symlink itself was read: %v", rec.ranges[fns[1]])
+	}
+	var n int64
+	for _, r := range rec.ranges[fns[0]] {
+		n += r.Length
+	}
+	if n != 5000 {
+		t.Errorf("target read at %v, want once completely", rec.ranges[fns[0]])
+	}
+}
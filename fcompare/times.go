@@ -0,0 +1,52 @@
+package fcompare
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/djherbis/atime"
+)
+
+// ErrModifiedWhileRead is returned for a file whose mtime changed while it was read,
+// e.g. because acquisition software still had it open. Its checksum may not
+// match any version of the file, and its file times are not restored, because
+// that would hide the modification.
+var ErrModifiedWhileRead = errors.New("file was modified while it was read")
+
+// RestoreTimes sets the access and modification time of a file that were captured
+// before it was read. If the mtime of the file is no longer mtime, nothing is
+// changed, and an error that wraps ErrModifiedWhileRead is returned. Times that
+// didn't change, e.g. on filesystems mounted with noatime, are not set again.
+func RestoreTimes(fn string, atim, mtime time.Time) error {
+	return RestoreTimesCtx(context.Background(), fn, atim, mtime)
+}
+
+// RestoreTimesCtx is RestoreTimes, which reports setting the times to the FileObserver of ctx
+func RestoreTimesCtx(ctx context.Context, fn string, atim, mtime time.Time) error {
+	fi, err := os.Stat(fn)
+	if err != nil {
+		return err
+	}
+	if !fi.ModTime().Equal(mtime) {
+		return &os.PathError{Op: "restore times", Path: fn, Err: ErrModifiedWhileRead}
+	}
+	if atime.Get(fi).Equal(atim) {
+		return nil
+	}
+	if obs := observerOf(ctx); obs.Chtimes != nil {
+		obs.Chtimes(fn)
+	}
+	return os.Chtimes(fn, atim, mtime)
+}
+
+// restoreTimesAfterRead is RestoreTimes for the functions of this package, which
+// never failed a file for times that couldn't be set. It returns only an error
+// that wraps ErrModifiedWhileRead.
+func restoreTimesAfterRead(ctx context.Context, fn string, atim, mtime time.Time) error {
+	if err := RestoreTimesCtx(ctx, fn, atim, mtime); errors.Is(err, ErrModifiedWhileRead) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+// filelist.go - Read the files to process from stdin or a list file
+// Tens of thousands of paths don't fit on a command line, so with the argument
+// '-' the paths are read from stdin (e.g. find ... | msfile -json -), and with
+// -from FILE from a file. There is one path per line; leading and trailing
+// whitespace is trimmed, and blank lines and lines starting with # are skipped.
+// A list with NUL bytes, e.g. from find -print0, has one path per NUL-terminated
+// record instead, which is taken as it is, since such paths can contain newlines
+// and any other character.
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"strings"
+)
+
+// Longest line of a file list
+const maxFileListLine = 1024 * 1024
+
+// fileArgs returns the positional arguments, with '-' replaced by the paths read
+// from stdin, followed by the paths in the -from file
+func fileArgs() ([]string, error) {
+	var args []string
+	stdinRead := false
+	for _, arg := range flag.Args() {
+		if arg != "-" {
+			args = append(args, arg)
+			continue
+		}
+		// Stdin can be read only once
+		if stdinRead {
+			continue
+		}
+		stdinRead = true
+		paths, err := readFileList(os.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, paths...)
+	}
+	if par.from == "" {
+		return args, nil
+	}
+	if par.from == "-" {
+		if stdinRead {
+			return args, nil
+		}
+		paths, err := readFileList(os.Stdin)
+		return append(args, paths...), err
+	}
+	f, err := os.Open(par.from)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	paths, err := readFileList(f)
+	return append(args, paths...), err
+}
+
+// readFileList reads one path per line, or per NUL-terminated record if the
+// start of the list has a NUL byte
+func readFileList(r io.Reader) ([]string, error) {
+	var paths []string
+	br := bufio.NewReaderSize(r, 64*1024)
+	head, err := br.Peek(64 * 1024)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, err
+	}
+	s := bufio.NewScanner(br)
+	s.Buffer(make([]byte, 64*1024), maxFileListLine)
+	if bytes.IndexByte(head, 0) >= 0 {
+		s.Split(scanNulTerminated)
+		for s.Scan() {
+			if s.Text() != "" {
+				paths = append(paths, s.Text())
+			}
+		}
+		return paths, s.Err()
+	}
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		paths = append(paths, line)
+	}
+	return paths, s.Err()
+}
+
+// scanNulTerminated is a bufio.SplitFunc for NUL-terminated records
+func scanNulTerminated(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReadFileList(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		list string
+		want []string
+	}{
+		{"lines", "a.mzML\nb.mzML\n", []string{"a.mzML", "b.mzML"}},
+		{"no final newline", "a.mzML\nb.mzML", []string{"a.mzML", "b.mzML"}},
+		{"blank lines and comments", "\n  \n# files\na.mzML\n\n\t b.mzML \r\n", []string{"a.mzML", "b.mzML"}},
+		{"CRLF", "a.mzML\r\nb.mzML\r\n", []string{"a.mzML", "b.mzML"}},
+		{"NUL-terminated", "a.mzML\x00 b .mzML\x00#c\nd.mzML\x00", []string{"a.mzML", " b .mzML", "#c\nd.mzML"}},
+		{"NUL-separated", "a.mzML\x00\x00b.mzML", []string{"a.mzML", "b.mzML"}},
+		{"empty", "", nil},
+	} {
+		got, err := readFileList(strings.NewReader(tc.list))
+		if err != nil || !slices.Equal(got, tc.want) {
+			t.Errorf("%s: %q, %v, want %q", tc.name, got, err, tc.want)
+		}
+	}
+
+	// A NUL anywhere in the first 64 KB selects NUL-terminated records
+	long := strings.Repeat("x", 30000)
+	got, err := readFileList(strings.NewReader(long + "\n" + long + "\x00"))
+	if err != nil || len(got) != 1 || got[0] != long+"\n"+long {
+		t.Errorf("long NUL-terminated record: %d paths, %v", len(got), err)
+	}
+	if _, err := readFileList(strings.NewReader(strings.Repeat("x", maxFileListLine+1))); err == nil {
+		t.Error("no error for a line longer than maxFileListLine")
+	}
+}
+
+// The paths come from the -from file, or from stdin with -from -
+func TestFileArgsFrom(t *testing.T) {
+	dir := t.TempDir()
+	fn := writeTestFile(t, dir, "list.txt", []byte("# run 1\na.mzML\n\nb.mzML\n"))
+	withPar(t, func(p *params) { p.from = fn })
+	got, err := fileArgs()
+	if err != nil || !slices.Equal(got, []string{"a.mzML", "b.mzML"}) {
+		t.Errorf("-from FILE: %q, %v", got, err)
+	}
+
+	fn = writeTestFile(t, dir, "list0", []byte("c.mzML\x00d\n.mzML\x00"))
+	f, err := os.Open(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	stdin := os.Stdin
+	os.Stdin = f
+	defer func() { os.Stdin = stdin }()
+	par.from = "-"
+	got, err = fileArgs()
+	if err != nil || !slices.Equal(got, []string{"c.mzML", "d\n.mzML"}) {
+		t.Errorf("-from -: %q, %v", got, err)
+	}
+
+	par.from = filepath.Join(dir, "missing")
+	if _, err := fileArgs(); err == nil {
+		t.Error("no error for a missing -from file")
+	}
+}
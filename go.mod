@@ -1,5 +1,11 @@
 module github.com/524D/msfile
 
-go 1.21.4
+go 1.22
 
-require github.com/djherbis/atime v1.1.0
+require (
+	github.com/djherbis/atime v1.1.0
+	github.com/klauspost/compress v1.18.0
+	github.com/zeebo/blake3 v0.2.4
+)
+
+require github.com/klauspost/cpuid/v2 v2.0.12 // indirect
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// The -hash flag selects the algorithm, which is recorded in the output unless it's sha256
+func TestHashFlag(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "abc.txt", []byte("abc"))
+	for _, tc := range []struct{ hash, algo, sum string }{
+		{"sha256", "", "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{"md5", "md5", "900150983cd24fb0d6963f7d28e17f72"},
+		{"BLAKE3", "blake3", "6437b3ac38465133ffb63b75273a8db548c558465d79db03fd359c6cd5bd9d85"},
+		{"crc32", "crc32", "352441c2"},
+	} {
+		stdout, stderr, code := runMsfile(t, dir, nil, "-checksums", "-comparemethod", "full", "-hash", tc.hash, "-format", "ndjson", "abc.txt")
+		if code != 0 {
+			t.Fatalf("-hash %s: exit code %d: %s", tc.hash, code, stderr)
+		}
+		var inf FileInfo
+		if err := json.Unmarshal([]byte(stdout), &inf); err != nil {
+			t.Fatal(err)
+		}
+		if inf.FullChecksum != tc.sum || inf.HashAlgo != tc.algo {
+			t.Errorf("-hash %s: checksum %s, algorithm %q, want %s, %q", tc.hash, inf.FullChecksum, inf.HashAlgo, tc.sum, tc.algo)
+		}
+	}
+	if _, _, code := runMsfile(t, dir, nil, "-checksums", "-hash", "sha3", "abc.txt"); code == 0 {
+		t.Error("-hash sha3: exit code 0")
+	}
+}
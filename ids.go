@@ -0,0 +1,146 @@
+package main
+
+// ids.go - Identifiers to correlate the records of a run across artifacts
+// Every run gets a random run ID, and every file it considers gets a record ID
+// that counts up from 1 in processing order. The output records, the audit log
+// and the -tee-manifest carry both, so records can be joined without matching
+// paths, which break when files are renamed during a run.
+// With -reproducible, the run ID is left out, like the timestamps.
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// The ID of the current run, "" with -reproducible
+var runID string
+
+// The last record ID that was handed out
+var lastRecordID int64
+
+// newRunID returns a random (version 4) UUID
+func newRunID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// newRecordID returns the ID of the next file of the run
+func newRecordID() int64 {
+	lastRecordID++
+	return lastRecordID
+}
+
+// correlatedRecord is a record of a file in one of the artifacts of a run
+type correlatedRecord struct {
+	Artifact string          `json:"artifact"`
+	Line     int             `json:"line"`
+	Record   json.RawMessage `json:"record"`
+}
+
+type correlatedFile struct {
+	RecordID int64              `json:"record_id"`
+	Records  []correlatedRecord `json:"records"`
+}
+
+type correlatedRun struct {
+	RunID string            `json:"run_id"`
+	Files []*correlatedFile `json:"files"`
+}
+
+// correlate reads the JSON lines files in dir (output, audit log, manifests),
+// and joins their records by run ID and record ID
+func correlate(dir string) ([]*correlatedRun, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	runs := make(map[string]map[int64]*correlatedFile)
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		if err := correlateFile(filepath.Join(dir, e.Name()), e.Name(), runs); err != nil {
+			return nil, err
+		}
+	}
+	var result []*correlatedRun
+	for id, files := range runs {
+		run := &correlatedRun{RunID: id}
+		for _, f := range files {
+			run.Files = append(run.Files, f)
+		}
+		sort.Slice(run.Files, func(i, j int) bool { return run.Files[i].RecordID < run.Files[j].RecordID })
+		result = append(result, run)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].RunID < result[j].RunID })
+	return result, nil
+}
+
+// correlateFile adds the records of one artifact. Lines that are not JSON objects
+// with a record ID are ignored, so other files in the directory do no harm.
+func correlateFile(fn string, name string, runs map[string]map[int64]*correlatedFile) error {
+	f, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	// The audit log has the run ID only in the header of each run
+	currentRun := ""
+	for line := 1; ; line++ {
+		b, err := r.ReadBytes('\n')
+		if len(b) > 0 {
+			// Audit logs of older versions have the Go field names
+			var ids struct {
+				Type      string `json:"type"`
+				RunID     string `json:"run_id"`
+				RecordID  int64  `json:"record_id"`
+				AuditRun  string `json:"RunID"`
+				AuditFile int64  `json:"RecordID"`
+			}
+			if json.Unmarshal(b, &ids) == nil {
+				if ids.AuditRun != "" {
+					currentRun = ids.AuditRun
+				}
+				if ids.Type == auditTypeRun && ids.RunID != "" {
+					currentRun = ids.RunID
+				}
+				run, id := ids.RunID, ids.RecordID
+				if id == 0 {
+					id = ids.AuditFile
+				}
+				if run == "" {
+					run = currentRun
+				}
+				if run != "" && id != 0 {
+					if runs[run] == nil {
+						runs[run] = make(map[int64]*correlatedFile)
+					}
+					cf := runs[run][id]
+					if cf == nil {
+						cf = &correlatedFile{RecordID: id}
+						runs[run][id] = cf
+					}
+					cf.Records = append(cf.Records, correlatedRecord{Artifact: name, Line: line, Record: json.RawMessage(b)})
+				}
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
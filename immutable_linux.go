@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Inode flags, from linux/fs.h
+const (
+	fsImmutableFl = 0x00000010
+	fsAppendFl    = 0x00000020
+)
+
+// FS_IOC_GETFLAGS is defined as _IOR('f', 1, long), so it depends on the size of long
+const fsIocGetflags = 0x80000000 | (unsafe.Sizeof(uintptr(0)) << 16) | ('f' << 8) | 1
+
+// getImmutable reports whether a file has the immutable or append-only inode flag set
+// (chattr +i / +a). This works on ext2/3/4, XFS, btrfs and other filesystems
+// that implement FS_IOC_GETFLAGS; supported is false for other filesystems.
+func getImmutable(fn string) (immutable, appendOnly, supported bool, err error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return false, false, false, err
+	}
+	defer f.Close()
+
+	// The kernel writes an int, even though the ioctl is declared with a long
+	var flags uint32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), fsIocGetflags, uintptr(unsafe.Pointer(&flags)))
+	if errno != 0 {
+		if errno == syscall.ENOTTY || errno == syscall.EOPNOTSUPP || errno == syscall.EINVAL {
+			return false, false, false, nil
+		}
+		return false, false, false, errno
+	}
+	return flags&fsImmutableFl != 0, flags&fsAppendFl != 0, true, nil
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !windows
+
+package main
+
+// getImmutable is not implemented on this platform
+func getImmutable(fn string) (immutable, appendOnly, supported bool, err error) {
+	return false, false, false, nil
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// getImmutable reports whether a file has the ReadOnly attribute set.
+// Windows has no append-only attribute.
+func getImmutable(fn string) (immutable, appendOnly, supported bool, err error) {
+	fi, err := os.Stat(fn)
+	if err != nil {
+		return false, false, false, err
+	}
+	attr, ok := fi.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return false, false, false, nil
+	}
+	return attr.FileAttributes&syscall.FILE_ATTRIBUTE_READONLY != 0, false, true, nil
+}
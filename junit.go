@@ -0,0 +1,194 @@
+package main
+
+// junit.go - Report the results of a run as JUnit XML, for CI systems
+// With -report-junit FILE, each file is a test case, so that failures show up in
+// the test report of e.g. GitLab CI. A file fails if its record has an error (e.g.
+// from -verify-embedded, split files or -checksum-from-name), with -verify if it
+// doesn't match its stored checksum, and with -verify-copy if its copy doesn't
+// match. Files that can't be checked are errors, and skipped files are skipped.
+// The class name of a test case is the directory of the file, since GitLab needs
+// one. XML 1.0 can't contain most control characters, not even escaped, so those
+// (and invalid UTF-8) are written as \xNN.
+
+import (
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+type junitReport struct {
+	fn    string
+	start time.Time
+	cases []junitCase
+}
+
+type junitSuites struct {
+	XMLName  xml.Name   `xml:"testsuites"`
+	Name     string     `xml:"name,attr"`
+	Tests    int        `xml:"tests,attr"`
+	Failures int        `xml:"failures,attr"`
+	Errors   int        `xml:"errors,attr"`
+	Time     string     `xml:"time,attr"`
+	Suite    junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Errors    int         `xml:"errors,attr"`
+	Skipped   int         `xml:"skipped,attr"`
+	Time      string      `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr,omitempty"`
+	Cases     []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+}
+
+// junitProblem is a failure or an error; the text has the details, e.g. the
+// expected and actual checksum
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+// The JUnit report of the current run, nil if none is requested
+var junit *junitReport
+
+func newJUnitReport(fn string) *junitReport {
+	return &junitReport{fn: fn, start: time.Now()}
+}
+
+// add adds a test case for file fn, which took d to check
+func (j *junitReport) add(fn string, d time.Duration, c junitCase) {
+	if j == nil {
+		return
+	}
+	c.Name, c.Classname, c.Time = junitText(fn), junitText(filepath.Dir(fn)), junitSeconds(d)
+	j.cases = append(j.cases, c)
+}
+
+// file adds a processed file, which fails if its record has an error
+func (j *junitReport) file(inf FileInfo, d time.Duration) {
+	var c junitCase
+	if inf.Error != nil {
+		c.Failure = &junitProblem{Message: junitText(inf.Error.Message), Type: inf.Error.Code}
+	}
+	j.add(inf.Filename, d, c)
+}
+
+// skip adds a skipped file
+func (j *junitReport) skip(fn string, reason string) {
+	j.add(fn, 0, junitCase{Skipped: &junitSkipped{Message: reason}})
+}
+
+// verified adds a file checked by -verify
+func (j *junitReport) verified(e verifyEntry) {
+	var c junitCase
+	switch e.status {
+	case verifyOK:
+	case verifyFailed:
+		text := fmt.Sprintf("expected: %s:%s (%d bytes)\nactual:   ", e.algo, e.expected, e.size)
+		if e.actual != "" {
+			text += fmt.Sprintf("%s:%s (%d bytes)", e.algo, e.actual, e.gotSize)
+		} else {
+			text += fmt.Sprintf("%d bytes", e.gotSize)
+		}
+		c.Failure = &junitProblem{Message: e.problem, Type: ErrCodeChecksumMismatch, Text: junitText(text)}
+	case verifyMissing:
+		c.Failure = &junitProblem{Message: "file is missing", Type: verifyMissing}
+	case verifyNoChecksum:
+		c.Skipped = &junitSkipped{Message: "no full checksum in the record"}
+	case verifyNew:
+		c.Skipped = &junitSkipped{Message: "new file, without a record"}
+	default:
+		c.Error = &junitProblem{Message: junitText(e.problem), Type: verifyError}
+	}
+	j.add(e.fn, e.took, c)
+}
+
+// copied adds a file checked by -verify-copy
+func (j *junitReport) copied(e copyEntry) {
+	var c junitCase
+	switch e.status {
+	case copyOK:
+	case copyError:
+		c.Error = &junitProblem{Message: junitText(e.problem), Type: copyError}
+	default:
+		c.Failure = &junitProblem{Message: junitText(strings.TrimSpace(e.status + " " + e.problem)), Type: e.status}
+	}
+	j.add(e.rel, e.took, c)
+}
+
+// write writes the report, with the name of the test suite, e.g. "msfile -verify"
+func (j *junitReport) write(suite string) error {
+	if j == nil {
+		return nil
+	}
+	s := junitSuite{Name: suite, Tests: len(j.cases), Time: junitSeconds(time.Since(j.start)), Cases: j.cases}
+	if !par.reproducible {
+		s.Timestamp = j.start.Format("2006-01-02T15:04:05")
+	}
+	for _, c := range j.cases {
+		switch {
+		case c.Failure != nil:
+			s.Failures++
+		case c.Error != nil:
+			s.Errors++
+		case c.Skipped != nil:
+			s.Skipped++
+		}
+	}
+	b, err := xml.MarshalIndent(junitSuites{Name: "msfile", Tests: s.Tests, Failures: s.Failures, Errors: s.Errors, Time: s.Time, Suite: s}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileVerified(j.fn, append([]byte(xml.Header), append(b, '\n')...))
+}
+
+// junitSeconds returns a duration in seconds, or 0 with -reproducible
+func junitSeconds(d time.Duration) string {
+	if par.reproducible {
+		d = 0
+	}
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// junitText replaces the characters that XML 1.0 can't contain, and invalid
+// UTF-8, by \xNN for each of their bytes
+func junitText(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 || !isXMLChar(r) {
+			for _, c := range []byte(s[i : i+size]) {
+				fmt.Fprintf(&b, `\x%02x`, c)
+			}
+		} else {
+			b.WriteString(s[i : i+size])
+		}
+		i += size
+	}
+	return b.String()
+}
+
+// isXMLChar reports whether r is allowed in XML 1.0
+func isXMLChar(r rune) bool {
+	return r == '\t' || r == '\n' || r == '\r' ||
+		r >= 0x20 && r <= 0xd7ff || r >= 0xe000 && r <= 0xfffd || r >= 0x10000 && r <= 0x10ffff
+}
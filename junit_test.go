@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestJUnitText(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"a.mzML", "a.mzML"},
+		{"tab\tnewline\n", "tab\tnewline\n"},
+		{"bell\x07.raw", `bell\x07.raw`},
+		{"latin1 \xe9.raw", `latin1 \xe9.raw`},
+		{"µ-spectra ✓.mzML", "µ-spectra ✓.mzML"},
+		{"￾", `\xef\xbf\xbe`},
+	} {
+		if got := junitText(tc.in); got != tc.want {
+			t.Errorf("junitText(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+// readJUnitReport parses a report, which must be valid XML
+func readJUnitReport(t *testing.T, fn string) junitSuites {
+	t.Helper()
+	var r junitSuites
+	if err := xml.Unmarshal([]byte(readTestFile(t, fn)), &r); err != nil {
+		t.Fatalf("report is not valid XML: %v\n%s", err, readTestFile(t, fn))
+	}
+	for _, c := range r.Suite.Cases {
+		if c.Classname == "" {
+			t.Errorf("test case %s without class name", c.Name)
+		}
+	}
+	return r
+}
+
+// junitOutcomes returns the outcome of each test case by name: ok, skipped, or
+// the type of the failure or error
+func junitOutcomes(r junitSuites) map[string]string {
+	outcomes := make(map[string]string)
+	for _, c := range r.Suite.Cases {
+		switch {
+		case c.Failure != nil:
+			outcomes[c.Name] = "failure " + c.Failure.Type
+		case c.Error != nil:
+			outcomes[c.Name] = "error " + c.Error.Type
+		case c.Skipped != nil:
+			outcomes[c.Name] = "skipped " + c.Skipped.Message
+		default:
+			outcomes[c.Name] = "ok"
+		}
+	}
+	return outcomes
+}
+
+func TestReportJUnit(t *testing.T) {
+	dir := t.TempDir()
+	good := gzipMembers("first member\n")
+	bad := append([]byte(nil), good...)
+	bad[len(bad)-5] ^= 0xff
+	writeTestFile(t, dir, "good.gz", good)
+	writeTestFile(t, dir, "bad.gz", bad)
+	writeTestFile(t, dir, "plain.txt", []byte("plain"))
+	files := []string{"good.gz", "bad.gz", "plain.txt"}
+	want := map[string]string{"good.gz": "ok", "bad.gz": "failure " + ErrCodeGzipCorrupt, "plain.txt": "ok"}
+	// XML can't contain control characters, so they are written as \xNN; Windows
+	// doesn't allow them in names
+	if runtime.GOOS != "windows" {
+		writeTestFile(t, dir, "bell\x07.gz", bad)
+		files = append(files, "bell\x07.gz")
+		want[`bell\x07.gz`] = "failure " + ErrCodeGzipCorrupt
+	}
+	if os.Symlink("good.gz", filepath.Join(dir, "link.gz")) == nil {
+		files = append(files, "link.gz")
+		want["link.gz"] = "skipped " + skipSymlink
+	}
+	_, stderr, code := runMsfile(t, dir, nil, append([]string{"-verify-embedded", "-follow-symlinks", "no", "-report-junit", "report.xml"}, files...)...)
+	if code != 1 {
+		t.Errorf("exit code %d, want 1: %s", code, stderr)
+	}
+	r := readJUnitReport(t, filepath.Join(dir, "report.xml"))
+	got := junitOutcomes(r)
+	for name, outcome := range want {
+		if got[name] != outcome {
+			t.Errorf("%s: %q, want %q", name, got[name], outcome)
+		}
+	}
+	failures := 0
+	for _, outcome := range want {
+		if strings.HasPrefix(outcome, "failure ") {
+			failures++
+		}
+	}
+	if len(got) != len(want) || r.Tests != len(want) || r.Failures != failures || r.Suite.Name != "msfile" {
+		t.Errorf("report %s with %d tests, %d failures: %v", r.Suite.Name, r.Tests, r.Failures, got)
+	}
+}
+
+func TestReportJUnitVerify(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "a.mzML", []byte("<mzML>a</mzML>\n"))
+	writeTestFile(t, dir, "b.mzML", []byte("<mzML>b</mzML>\n"))
+	stdout, stderr, code := runMsfile(t, dir, nil, "-checksums", "-comparemethod", "full", "-format", "ndjson", "a.mzML", "b.mzML")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	writeTestFile(t, dir, "run.json", []byte(stdout))
+	writeTestFile(t, dir, "b.mzML", []byte("<mzML>B</mzML>\n"))
+	_, stderr, code = runMsfile(t, dir, nil, "-verify", "-from", "run.json", "-report-junit", "report.xml", "-reproducible")
+	if code != 1 {
+		t.Errorf("exit code %d, want 1: %s", code, stderr)
+	}
+	r := readJUnitReport(t, filepath.Join(dir, "report.xml"))
+	got := junitOutcomes(r)
+	if got["a.mzML"] != "ok" || got["b.mzML"] != "failure "+ErrCodeChecksumMismatch || r.Suite.Name != "msfile -verify" {
+		t.Errorf("report %s: %v", r.Suite.Name, got)
+	}
+	for _, c := range r.Suite.Cases {
+		if c.Failure != nil && (!strings.Contains(c.Failure.Text, "expected: sha256:") || !strings.Contains(c.Failure.Text, "actual:   sha256:")) {
+			t.Errorf("failure without the expected and actual checksum: %q", c.Failure.Text)
+		}
+	}
+	// With -reproducible, the report doesn't depend on when it was written
+	if report := readTestFile(t, filepath.Join(dir, "report.xml")); strings.Contains(report, "timestamp") || !strings.Contains(report, `time="0.000"`) {
+		t.Errorf("report with times:\n%s", report)
+	}
+}
+
+func TestReportJUnitVerifyCopy(t *testing.T) {
+	dir := t.TempDir()
+	writeCopyTree(t, dir)
+	if err := os.Remove(filepath.Join(dir, "dst", "a.mzML")); err != nil {
+		t.Fatal(err)
+	}
+	_, stderr, code := runMsfile(t, dir, nil, "-verify-copy", "-report-junit", "report.xml", "src", "dst")
+	if code != 1 {
+		t.Errorf("exit code %d, want 1: %s", code, stderr)
+	}
+	r := readJUnitReport(t, filepath.Join(dir, "report.xml"))
+	got := junitOutcomes(r)
+	if got["a.mzML"] != "failure "+copyMissing || got[filepath.Join("sub", "b.mgf")] != "ok" || r.Suite.Name != "msfile -verify-copy" {
+		t.Errorf("report %s: %v", r.Suite.Name, got)
+	}
+
+	_, stderr, code = runMsfile(t, dir, nil, "-compare", "-report-junit", "report.xml", "src/a.mzML", "src/a.mzML")
+	if code == 0 || !strings.Contains(stderr, "-report-junit doesn't work with -compare") {
+		t.Errorf("-compare: exit code %d: %s", code, stderr)
+	}
+}
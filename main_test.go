@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The tests of the command line run msfile in a child process, see runMsfile
+func TestMain(m *testing.M) {
+	if os.Getenv("MSFILE_TEST_MAIN") == "1" {
+		// msfile has no flags with secrets yet, so the tests bring their own
+		flag.String("test-secret", "", "a secret, for tests")
+		registerSensitiveFlag("test-secret")
+		flag.Func("test-secret-checked", "a secret that is checked, for tests", func(s string) error {
+			return fmt.Errorf("%s is not a valid secret", s)
+		})
+		registerSensitiveFlag("test-secret-checked")
+		getenvOption("MSFILE_TEST_ENV_SECRET")
+		if n, err := strconv.Atoi(os.Getenv("MSFILE_TEST_STOP_AFTER")); err == nil {
+			// Stop the run after n files, as if its deadline passed
+			deadlinePassed = func(time.Time) bool {
+				n--
+				return n < 0
+			}
+		}
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runMsfile runs msfile with args in dir, and returns its stdout, stderr and exit code
+func runMsfile(t *testing.T, dir string, env []string, args ...string) (string, string, int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = dir
+	cmd.Env = append(append(os.Environ(), "MSFILE_TEST_MAIN=1"), env...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		t.Fatal(err)
+	}
+	return stdout.String(), stderr.String(), cmd.ProcessState.ExitCode()
+}
+
+// writeTestFile writes a file with data in dir, and returns its path
+func writeTestFile(t testing.TB, dir, name string, data []byte) string {
+	t.Helper()
+	fn := filepath.Join(dir, name)
+	if err := os.WriteFile(fn, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return fn
+}
+
+// withPar runs a test with the flags changed by set, and restores them afterwards
+func withPar(t *testing.T, set func(p *params)) {
+	t.Helper()
+	saved := par
+	t.Cleanup(func() { par = saved })
+	set(&par)
+}
+
+// readTestFile returns the content of a file that a test run wrote
+func readTestFile(t *testing.T, fn string) string {
+	t.Helper()
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// checkNoSecret fails the test if secret appears in any of outputs
+func checkNoSecret(t *testing.T, what string, secret string, outputs ...string) {
+	t.Helper()
+	for _, out := range outputs {
+		if strings.Contains(out, secret) {
+			t.Errorf("%s: secret in output %q", what, out)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+// memo.go - Reuse the result of a file that is processed again in the same run
+// A file can be reached more than once in one run: under overlapping -r or -root
+// directories, as an explicit argument and through -r, or through another hard
+// link. Its contents are read the first time, and later references reuse that
+// result, with ChecksumSource "in-run". A reference is the same file if it has the
+// same device and inode (os.SameFile), size, mtime and compare method. The result
+// of -checksum-from-name depends on the name, so it is checked for each reference.
+
+import (
+	"maps"
+	"os"
+)
+
+// memoKey selects the entries that may be the same file; os.SameFile decides
+type memoKey struct {
+	size, mtimeNs int64
+	method        string
+}
+
+type memoEntry struct {
+	fi             os.FileInfo
+	info           FileInfo
+	mutable        bool // counted in mutableFiles
+	embeddedFailed bool // counted in embeddedFailures
+}
+
+// The files that were processed in this run
+var fileMemo = make(map[memoKey][]memoEntry)
+
+// Number of files whose checksums were reused from another reference in this run
+var inRunChecksums int
+
+func newMemoKey(fi os.FileInfo, fileinfo FileInfo) memoKey {
+	return memoKey{size: fi.Size(), mtimeNs: fileinfo.MtimeNs, method: fileinfo.CompareMethod}
+}
+
+// lookupMemo returns the result of an earlier reference to the same file, for fileinfo
+func lookupMemo(fi os.FileInfo, fileinfo FileInfo) (FileInfo, bool) {
+	for _, e := range fileMemo[newMemoKey(fi, fileinfo)] {
+		if !os.SameFile(e.fi, fi) {
+			continue
+		}
+		inf := e.info
+		inf.Filename, inf.RunID, inf.RecordID = fileinfo.Filename, fileinfo.RunID, fileinfo.RecordID
+		inf.Atime, inf.AtimeNs = fileinfo.Atime, fileinfo.AtimeNs
+		inf.Properties = maps.Clone(e.info.Properties)
+		if inf.ChecksumSource != "" {
+			inf.ChecksumSource = checksumSourceInRun
+			inRunChecksums++
+		}
+		if e.mutable {
+			mutableFiles++
+		}
+		if e.embeddedFailed {
+			embeddedFailures++
+		}
+		return inf, true
+	}
+	return FileInfo{}, false
+}
+
+// rememberFile stores the result of a file, before the name is checked
+func rememberFile(fi os.FileInfo, fileinfo FileInfo, mutable, embeddedFailed bool) {
+	fileinfo.Properties = maps.Clone(fileinfo.Properties)
+	k := newMemoKey(fi, fileinfo)
+	fileMemo[k] = append(fileMemo[k], memoEntry{fi: fi, info: fileinfo, mutable: mutable, embeddedFailed: embeddedFailed})
+}
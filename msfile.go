@@ -5,103 +5,562 @@ package main
 // Output of msfile is a JSON string, which can be used by other programs
 
 import (
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/524D/msfile/fcompare"
+	"github.com/524D/msfile/msfileio"
+	"github.com/524D/msfile/msinfo"
 	"github.com/djherbis/atime"
 )
 
-// For files less than minPartialChecksumSize, we use the full checksum as the partial checksum
-// because the speed benefit of reading 1M three times is probably less than reading the entire file once
-const minPartialChecksumSize = 16 * 1024 * 1024
+// Exit code when both arguments of -compare refer to the same file
+// This is neither "same" nor "different", and must not be mistaken for a duplicate
+const exitSameFile = 3
+
+// With -exit-code, -compare exits with 1 when the files differ, and errors exit
+// with 2 instead of 1, like cmp and diff
+const exitDifferent = 1
+const exitTrouble = 2
 
 type params struct {
-	compare bool
-	json    bool
-	method  string
-}
+	compare     bool
+	json        bool
+	method      string
+	auditLog    string
+	auditVerify string
+	reportJUnit string
+
+	precomputed            string
+	precomputedTrustAlways bool
 
-type FileInfo struct {
-	Filename        string
-	Size            int64
-	Atime           int64
-	Mtime           int64
-	PartialChecksum string
-	FullChecksum    string
-	Properties      map[string]string
+	noRecordCmdline      bool
+	fast                 bool
+	reproducible         bool
+	verifyEmbedded       bool
+	listProperties       bool
+	order                string
+	checkImmutable       bool
+	decompressedChecksum bool
+	requireImmut         bool
+	withCompanions       bool
+	splitNumbered        bool
+	requireFresh         bool
+	methodPolicy         methodPolicy
+	explainPolicy        string
+	maxRuntime           time.Duration
+	stopAt               string
+	checkpoint           string
+	resume               string
+	listErrorCodes       bool
+	resourceUsage        bool
+	profile              string
+	checksumFromName     string
+	checksumFromNameAlgo string
+	teeVerify            string
+	teeManifest          string
+	stdinName            string
+	verifyCopy           bool
+	typeOnly             bool
+	scanCount            bool
+	registry             string
+	registryRate         float64
+	requireUnknown       bool
+	timeTolerance        time.Duration
+	compareRange         string
+	hash                 string
+	chunkSize            byteSize
+	fullThreshold        byteSize
+	allowPseudoFS        bool
+	followSymlinks       string
+	exitCode             bool
+	quiet                bool
+	yesReally            bool
+	maxDepth             int
+	maxPathLength        int
+	maxDirEntries        int
+	emitSkipped          bool
+	hashString           string
+	hashHex              string
+	roots                rootList
+	showUnique           bool
+	recursive            bool
+	paranoid             bool
+	format               string
+	correlate            string
+	from                 string
+	noCache              bool
+	refreshCache         bool
+	cacheFile            string
+	verify               bool
+	checksums            bool
+	stream               bool
 }
 
+// FileInfo is the record of a file in the output, see package msfileio
+type FileInfo = msfileio.FileInfo
+
+// Values of FileInfo.ChecksumSource
+const (
+	checksumSourceFresh    = msfileio.ChecksumSourceFresh    // computed by reading the file in this run
+	checksumSourceExternal = msfileio.ChecksumSourceExternal // read from a -precomputed file
+	checksumSourceCache    = msfileio.ChecksumSourceCache    // read from the checksum cache of the file
+	checksumSourceInRun    = msfileio.ChecksumSourceInRun    // reused from another reference to the file in this run
+)
+
 // flags:
 //  -compare: compare two files
 //  -json: produce output in JSON format
-//  -comparemethod: partial, size, full (default: partial)
+//  -correlate: join the records of the artifacts in a directory by run and record ID
+//  -format: output format: human, ndjson, json, csv or tsv
+//  -comparemethod: partial, partial-adaptive, size, full, bytes (default: partial)
+//  -audit-log: append a record of every file opened and byte range read to a file
+//  -audit-verify: check the internal consistency of an audit log
+//  -report-junit: write the result of each file as a JUnit XML test case, for CI systems
+//  -precomputed: reuse checksums from a sha256sum or msfile JSON file
+//  -precomputed-trust-always: reuse precomputed checksums even if size and mtime can't be checked
+//  -no-record-cmdline: don't record the command line in the audit log
+//  -fast: don't preserve access times (for scratch data only)
+//  -reproducible: make output byte-identical between runs over identical data
+//  -verify-embedded: verify integrity information embedded in files (gzip CRCs)
+//  -list-properties: print the registry of property keys
+//  -order: order in which files are processed: walk, small-first, large-first (default: walk)
+//  -check-immutable: report if files are immutable
+//  -require-immutable: fail if files are not immutable
+//  -decompressed-checksum: record the checksum of the decompressed content of gzip and zstd files
+//  -with-companions: also process the companion files of the given files (e.g. .wiff.scan for .wiff)
+//  -split-numbered: treat name.001, name.002, ... as parts of a split file, also without a sidecar
+//  -require-fresh: compute all checksums by reading the files, never use precomputed or cached values
+//  -no-cache: don't read or write the checksum cache in the extended attributes of files
+//  -refresh-cache: replace cache entries of files that changed since they were cached
+//  -cache: keep the checksum cache in a file, instead of in the extended attributes of files
+//  -method-for: compare method for files matching a pattern, 'pattern=method' (repeatable)
+//  -explain-policy: show which -method-for rule applies to a file
+//  -max-runtime: stop starting new files after this time, and write a checkpoint
+//  -stop-at: stop starting new files at this time of day (HH:MM), and write a checkpoint
+//  -checkpoint: file to write the checkpoint to
+//  -resume: skip the files that were completed according to a checkpoint
+//  -list-error-codes: print the registry of error codes
+//  -resource-usage: report CPU time, peak memory and I/O of the run
+//  -profile: write CPU and heap profiles to a directory
+//  -checksum-from-name: verify files against a digest in their name, extracted with a regular expression
+//  -checksum-from-name-algo: hash algorithm of that digest: auto, md5, sha1, sha256 (default: auto, by its length)
+//  -tee-verify: copy stdin to stdout, and verify it against a digest (sha256:<digest>)
+//  -tee-manifest: with -tee-verify, append the digest and size of the stream to a file
+//  -stdin-name: name of the stdin stream in the -tee-manifest file
+//  -checksums: also compute checksums with -comparemethod when not comparing, e.g. for a later -verify
+//  -verify: check files against the full checksums in msfile JSON output, read from -from or stdin
+//  -verify-copy: verify that a copy of a file or directory matches its source, using full checksums
+//  -type-only: only print the detected format of each file
+//  -scan-count: count the spectra of mzML, mzXML and MGF files
+//  -registry: look up full checksums in a registry (URL template with {hash}, or a local lookup file)
+//  -registry-rate: maximum number of registry lookups per second (default: 10)
+//  -require-unknown: fail files whose content is already known to the registry
+//  -hash: hash algorithm for checksums in compare mode: sha256, sha1, md5, crc32, crc64, blake3 (default: sha256)
+//  -chunksize: size of each of the 3 chunks of the partial checksum, and of the regions of the adaptive one (default: 1M)
+//  -full-threshold: files up to this size are hashed completely by the partial methods (default: 16M)
+//  -allow-pseudo-fs: also walk pseudo-filesystems like /proc and /sys
+//  -follow-symlinks: yes, no or target: how to handle arguments that are symlinks
+//  -exit-code: with -compare, exit with 0 if the files are the same, 1 if they differ, 2 on errors
+//  -quiet: with -compare, don't print the result
+//  -yes-really: allow walking / or a home directory with many files
+//  -max-depth: skip directories more than this many levels below a -r or -root directory (default: 128)
+//  -max-path-length: skip files and directories with a longer path
+//  -max-dir-entries: skip directories with more entries
+//  -emit-skipped: write a record with the reason for each file that is skipped
+//  -hash-string: print the checksums of a string, and exit
+//  -hash-hex: print the checksums of hex encoded data, and exit
+//  -paranoid: turn crashes while reading file content into per-file errors
+//  -r, -recursive: process all files under directory arguments
+//  -from: read the files to process from a file, one per line or NUL-terminated ('-' as argument reads them from stdin)
+//  -show-unique: with -compare and more than 2 files, also print files that are unlike all others
+//  -stream: with -compare and more than 2 files, print each duplicate as soon as it is found
+//  -root: process all files under a directory, labelled in the output (repeatable)
+//  -compare-range: with -compare, compare only a region of the files, OFFSET:LENGTH or OFFSET:transferred
+//  -time-tolerance: largest difference between file times that is not a real difference (default: detected per filesystem)
 
 var par params
 
+// Hash algorithm for checksums in compare mode, from -hash
+var hashAlgo fcompare.HashAlgo
+
+// Chunk size and threshold of partial checksums, from -chunksize and -full-threshold
+var partialConfig = fcompare.DefaultPartialChecksumConfig
+
+// Number of files that failed -verify-embedded
+var embeddedFailures int
+
+// Set when a run stops at its -max-runtime or -stop-at deadline
+var stoppedAtDeadline bool
+
+// Number of files for which checksums were computed, or taken from a precomputed source
+var freshHashes, externalChecksums int
+
+// Number of split files with missing or inconsistent parts
+var splitFailures int
+
+// Set when -compare finds that the files are not all the same
+var filesDiffer bool
+
+// Number of files found writable by -check-immutable, including files for which it is unknown
+var mutableFiles int
+
 // parse flags
 func handleCommandLine() {
 	flag.BoolVar(&par.compare, "compare", false, "compare files, instead of printing results")
-	flag.BoolVar(&par.json, "json", false, "produce output in JSON format")
-	flag.StringVar(&par.method, "comparemethod", "partial", "method to use when comparing files (partial, size, full))")
+	flag.BoolVar(&par.json, "json", false, "produce output in JSON format, one object per line (same as -format ndjson)")
+	flag.StringVar(&par.correlate, "correlate", "", "join the records of the output, audit log and manifest files in `directory` by run ID and record ID, print them as JSON, and exit")
+	flag.StringVar(&par.format, "format", "human", "output `format`: human, ndjson (one JSON object per line), json (a single JSON array), csv or tsv")
+	flag.StringVar(&par.method, "comparemethod", "partial", "method to use when comparing files (partial, partial-adaptive, size, full, bytes)")
+	flag.StringVar(&par.auditLog, "audit-log", "", "append a JSON lines record of every file opened and byte range read to `file`")
+	flag.StringVar(&par.auditVerify, "audit-verify", "", "check the internal consistency of audit log `file` and exit")
+	flag.StringVar(&par.reportJUnit, "report-junit", "", "write the result of each file to `file` as a JUnit XML test case (also with -verify and -verify-copy), for CI systems")
+	flag.StringVar(&par.precomputed, "precomputed", "", "reuse checksums from `file` (sha256sum format or msfile JSON) for files with unchanged size and mtime")
+	flag.BoolVar(&par.precomputedTrustAlways, "precomputed-trust-always", false, "trust precomputed checksums without checking size and mtime")
+	flag.BoolVar(&par.noRecordCmdline, "no-record-cmdline", false, "don't record the command line in the audit log")
+	flag.BoolVar(&par.fast, "fast", false, "skip all access time handling; CHANGES the atime of files read. Only for scratch data")
+	flag.BoolVar(&par.reproducible, "reproducible", false, "process files in sorted order and omit run timestamps, so identical data gives identical output")
+	flag.BoolVar(&par.verifyEmbedded, "verify-embedded", false, "verify integrity information embedded in files (CRC32 and size of gzip members, checksums of zstd frames)")
+	flag.BoolVar(&par.listProperties, "list-properties", false, "print the property keys that msfile can report, and the formats that populate them")
+	flag.StringVar(&par.order, "order", "walk", "order in which files are processed (walk: as given, small-first, large-first)")
+	flag.BoolVar(&par.checkImmutable, "check-immutable", false, "report if files are immutable (chattr +i on Linux, ReadOnly attribute on Windows)")
+	flag.BoolVar(&par.decompressedChecksum, "decompressed-checksum", false, "record the checksum of the decompressed content of gzip and zstd files; -compare and the -root duplicates then match a compressed file with its original")
+	flag.BoolVar(&par.requireImmut, "require-immutable", false, "like -check-immutable, but fail if any file is not immutable")
+	flag.BoolVar(&par.withCompanions, "with-companions", false, "also process companion files of the given files (e.g. .wiff.scan for .wiff, .ibd for .imzML)")
+	flag.BoolVar(&par.splitNumbered, "split-numbered", false, "treat files named like name.001, name.002, ... as parts of a split file, also without a name.sha256 sidecar")
+	flag.BoolVar(&par.requireFresh, "require-fresh", false, "compute all checksums by reading the files; ignores -precomputed and the checksum cache")
+	flag.BoolVar(&par.noCache, "no-cache", false, "don't reuse checksums cached in the user.msfile.<hash> extended attribute of files (Linux), and don't cache new ones")
+	flag.BoolVar(&par.refreshCache, "refresh-cache", false, "replace the cached checksums of files whose size or mtime changed since they were cached, instead of warning")
+	flag.StringVar(&par.cacheFile, "cache", "", "keep the checksum cache in `file`, instead of in extended attributes")
+	flag.Var(&par.methodPolicy, "method-for", "use compare method for files matching a pattern, as 'pattern=method' or 'default=method' (repeatable)")
+	flag.StringVar(&par.explainPolicy, "explain-policy", "", "show which -method-for rule selects the compare method for `path`, and exit")
+	flag.DurationVar(&par.maxRuntime, "max-runtime", 0, "don't start new files after this `duration`; write a checkpoint and exit with status 4, or 5 if files failed")
+	flag.StringVar(&par.stopAt, "stop-at", "", "don't start new files after this `time` of day (HH:MM); write a checkpoint and exit with status 4, or 5 if files failed")
+	flag.StringVar(&par.checkpoint, "checkpoint", "", "write the checkpoint to `file` (default: the -resume file, or msfile-checkpoint.json)")
+	flag.StringVar(&par.resume, "resume", "", "skip files that were completed according to checkpoint `file`")
+	flag.BoolVar(&par.listErrorCodes, "list-error-codes", false, "print the machine-readable error codes that msfile can report, and exit")
+	flag.BoolVar(&par.resourceUsage, "resource-usage", false, "report CPU time, peak RSS, GC and read statistics of the run on stderr (as JSON with -json or -format)")
+	flag.StringVar(&par.profile, "profile", "", "write pprof CPU and heap profiles to `directory`")
+	flag.StringVar(&par.checksumFromName, "checksum-from-name", "", "verify files against the digest in their base name, extracted with `regexp` (group 'digest' or the only group)")
+	flag.StringVar(&par.checksumFromNameAlgo, "checksum-from-name-algo", "auto", "hash algorithm of the digest in file names (auto, md5, sha1, sha256)")
+	flag.StringVar(&par.teeVerify, "tee-verify", "", "copy stdin to stdout unchanged, and fail after the stream ends if it doesn't match `sha256:digest`")
+	flag.StringVar(&par.teeManifest, "tee-manifest", "", "with -tee-verify, append the digest and size of the stream to `file` as a JSON line")
+	flag.StringVar(&par.stdinName, "stdin-name", "-", "`name` of the stdin stream in the -tee-manifest file")
+	flag.StringVar(&par.registry, "registry", "", "look up full checksums in a registry: URL template with {hash}, or a local `file` with '<sha256> <location>' lines")
+	flag.Float64Var(&par.registryRate, "registry-rate", 10, "maximum number of HTTP registry lookups per second (0: unlimited)")
+	flag.BoolVar(&par.requireUnknown, "require-unknown", false, "fail files whose content is already known to -registry")
+	flag.StringVar(&par.hash, "hash", "sha256", "hash `algorithm` for checksums in compare mode (sha256, sha1, md5, crc32, crc64, blake3); blake3 is faster than sha256, crc32/crc64 are fast but not cryptographic")
+	par.chunkSize = byteSize(fcompare.DefaultPartialChecksumConfig.ChunkSize)
+	par.fullThreshold = byteSize(fcompare.DefaultPartialChecksumConfig.FullThreshold)
+	flag.Var(&par.chunkSize, "chunksize", "`size` of each of the 3 chunks read by the partial method, and of the regions read by partial-adaptive, e.g. 4M")
+	flag.Var(&par.fullThreshold, "full-threshold", "files up to this `size` are hashed completely by the partial methods; at least 3 times -chunksize")
+	flag.BoolVar(&par.exitCode, "exit-code", false, "with -compare, exit with 0 if the files are the same, 1 if they differ, and 2 on errors")
+	flag.BoolVar(&par.quiet, "quiet", false, "with -compare, don't print the result")
+	flag.StringVar(&par.followSymlinks, "follow-symlinks", symlinksFollow, "how to handle arguments that are symlinks: yes (read the target), no (skip them) or target (replace them by their target, and skip duplicates)")
+	flag.BoolVar(&par.allowPseudoFS, "allow-pseudo-fs", false, "also walk into pseudo-filesystems (proc, sysfs, devfs, cgroup, ...)")
+	flag.BoolVar(&par.yesReally, "yes-really", false, "allow walking / or a home directory that contains many files")
+	flag.IntVar(&par.maxDepth, "max-depth", msinfo.DefaultMaxDepth, "skip directories more than `n` levels below a -r or -root directory; 0 is no limit")
+	flag.IntVar(&par.maxPathLength, "max-path-length", 0, "skip files and directories whose path is longer than `n` bytes; 0 is no limit")
+	flag.IntVar(&par.maxDirEntries, "max-dir-entries", 0, "skip directories with more than `n` entries; 0 is no limit")
+	flag.BoolVar(&par.emitSkipped, "emit-skipped", false, "write a record with a SkipReason for every file that is considered but not processed")
+	flag.StringVar(&par.hashString, "hash-string", "", "print the full and partial checksum of `string` (using -hash, -chunksize and -full-threshold), and exit")
+	flag.StringVar(&par.hashHex, "hash-hex", "", "print the full and partial checksum of the bytes in `hex`, and exit")
+	flag.BoolVar(&par.paranoid, "paranoid", false, "turn a crash while reading the content of a file (e.g. a crafted upload) into an E_PARSE error of that file")
+	flag.BoolVar(&par.recursive, "r", false, "process all regular files under directory arguments; symbolic links are not followed")
+	flag.BoolVar(&par.recursive, "recursive", false, "same as -r")
+	flag.StringVar(&par.from, "from", "", "also process the files listed in `file`, one per line; blank lines and lines starting with # are skipped. A list with NUL bytes (find -print0) has NUL-terminated paths. The argument - reads the list from stdin")
+	flag.BoolVar(&par.stream, "stream", false, "with -compare and more than 2 files, print each file that is the same as an earlier file as soon as it is found, before the groups")
+	flag.BoolVar(&par.showUnique, "show-unique", false, "with -compare and more than 2 files, also print files that are unlike all others")
+	flag.Var(&par.roots, "root", "process all files under a directory, recorded with a label, as 'LABEL=PATH' (repeatable)")
+	flag.StringVar(&par.compareRange, "compare-range", "", "with -compare, compare only the `region` OFFSET:LENGTH of both files; OFFSET:transferred compares up to the end of the smaller file")
+	flag.DurationVar(&par.timeTolerance, "time-tolerance", 0, "treat file times that differ by at most `duration` as equal (default: the resolution detected for each filesystem)")
+	flag.BoolVar(&par.scanCount, "scan-count", false, "count the spectra, and MS1 and MS2 spectra, of mzML, mzXML and MGF files; reads the whole file, except for indexed mzML")
+	flag.BoolVar(&par.typeOnly, "type-only", false, "only print the detected format of each file, like the file command")
+	flag.BoolVar(&par.checksums, "checksums", false, "also compute the checksums of each file with -comparemethod in the output records, without -compare")
+	flag.BoolVar(&par.verify, "verify", false, "check the files in the msfile JSON output read from -from or stdin against their full checksums, and exit; files under the arguments without a record are reported as new")
+	flag.BoolVar(&par.verifyCopy, "verify-copy", false, "verify that the copy (second argument) of a file or directory (first argument) is identical, using full checksums")
+
+	// Secrets are kept out of the recorded command line and out of error messages
+	var secrets []string
+	recordedArgs, secrets = redactArgs(os.Args)
+	stderr := newRedactWriter(os.Stderr, secrets)
+	flag.CommandLine.SetOutput(stderr)
+	log.SetOutput(stderr)
 
 	flag.Parse()
 
-}
+	if par.json && par.format == "human" {
+		par.format = "ndjson"
+	}
+	if par.requireImmut {
+		par.checkImmutable = true
+	}
+	// Compare mode always needs the checksums
+	if par.compare {
+		par.checksums = true
+	}
+	if par.compare && par.exitCode {
+		fatalExitCode = exitTrouble
+	}
 
-func processFile(filename string) (FileInfo, error) {
-	var fileinfo FileInfo
+}
 
+func processFile(filename string) (fileinfo FileInfo, err error) {
 	fileinfo.Properties = make(map[string]string)
 	fileinfo.Filename = filename
-	// Get file times
-	atime, err := atime.Stat(filename)
-	if err != nil {
-		log.Fatal(err.Error())
-	}
+	fileinfo.RunID = runID
+	fileinfo.RecordID = newRecordID()
 	fi, err := os.Stat(filename)
 	if err != nil {
 		return fileinfo, err
 	}
+	// Reading a device can hang, or never end
+	if isDevice(fi) {
+		return fileinfo, &FileError{Code: ErrCodeDevice, Message: "is a device", Path: filename}
+	}
+	// Get file times
+	// The atime is taken from the same stat call, so each file is stat'ed only once
+	atime := atime.Get(fi)
 	mtime := fi.ModTime()
 
 	// Convert times to Unix time
 	fileinfo.Atime = atime.Unix()
 	fileinfo.Mtime = mtime.Unix()
+	fileinfo.AtimeNs = atime.UnixNano()
+	fileinfo.MtimeNs = mtime.UnixNano()
 
-	// Restore file times before we return
-	defer os.Chtimes(filename, atime, mtime)
+	if !par.fast {
+		// Restore file times before we return, and report if that didn't work
+		defer func() {
+			if rerr := restoreTimes(filename, atime, mtime); rerr != nil && err == nil {
+				err = rerr
+			}
+		}()
+	}
 
 	fileinfo.Size = fi.Size()
+	fileinfo.AllocatedSize = fileinfo.Size
+	if n, ok := allocatedSize(filename, fi); ok {
+		fileinfo.AllocatedSize = n
+	}
 
-	if par.compare {
+	if par.checksums {
+		fileinfo.CompareMethod, _ = par.methodPolicy.methodFor(filename)
+		if compareRange != nil {
+			fileinfo.CompareMethod = "range"
+		}
+	}
+	// A file that was already processed in this run isn't read again
+	if inf, ok := lookupMemo(fi, fileinfo); ok {
+		if nameChecksumPattern != nil {
+			if _, err := verifyNameChecksum(&inf, par.checksumFromNameAlgo); err != nil {
+				return inf, err
+			}
+		}
+		return inf, nil
+	}
+	if par.checksums && !applyPrecomputed(&fileinfo) && !applyCache(&fileinfo) {
 		// Compare files
 
 		// Use appropriate method to compare files
-		switch par.method {
+		switch fileinfo.CompareMethod {
 		case "partial":
 			// Get partial checksum
+			res, err := fcompare.GetPartialChecksumResultCtx(readCtx, filename, hashAlgo, partialConfig)
+			if err != nil {
+				return fileinfo, err
+			}
+			fileinfo.PartialChecksum = res.Checksum
+			if res.IsFull {
+				fileinfo.FullChecksum = fileinfo.PartialChecksum
+			}
+			// The checksums of the chunks tell which part of two files differs
+			for i, key := range []string{msinfo.PropPartialChecksumHead, msinfo.PropPartialChecksumMiddle, msinfo.PropPartialChecksumTail} {
+				if i < len(res.Chunks) {
+					fileinfo.Properties[key] = res.Chunks[i].Checksum
+				}
+			}
+		case "partial-adaptive":
+			// Get adaptive partial checksum, which samples more regions for larger files
 			isFull := false
-			fileinfo.PartialChecksum, isFull, err = fcompare.GetPartialChecksum(filename)
+			fileinfo.PartialChecksum, isFull, err = fcompare.GetAdaptivePartialChecksumCtx(readCtx, filename, hashAlgo, partialConfig)
 			if err != nil {
 				return fileinfo, err
 			}
 			if isFull {
 				fileinfo.FullChecksum = fileinfo.PartialChecksum
 			}
-		case "size":
-			// Compare file sizes
+		case "size", "bytes":
+			// Compare file sizes. With bytes, the contents are compared later
 		case "full":
 			// Get full checksum
-			fileinfo.FullChecksum, err = fcompare.GetChecksum(filename)
+			fileinfo.FullChecksum, err = fcompare.GetChecksumHashCtx(readCtx, filename, hashAlgo)
+			if err != nil {
+				return fileinfo, err
+			}
+		case "range":
+			// Get checksum of the -compare-range region
+			fileinfo.PartialChecksum, err = fcompare.GetRangeChecksumCtx(readCtx, filename, hashAlgo, compareRange.Start, compareRange.Length)
 			if err != nil {
 				return fileinfo, err
 			}
 		default:
-			log.Fatal("Invalid compare method")
+			fatal(usageError("Invalid compare method"))
+		}
+		if fileinfo.PartialChecksum != "" || fileinfo.FullChecksum != "" {
+			fileinfo.ChecksumSource = checksumSourceFresh
+			if hashAlgo != fcompare.HashSHA256 {
+				fileinfo.HashAlgo = hashAlgo.String()
+			}
+		}
+		updateCache(fileinfo, mtime)
+	}
+	// A partial checksum can only be compared with one of the same chunks
+	if (fileinfo.CompareMethod == "partial" || fileinfo.CompareMethod == "partial-adaptive") && fileinfo.PartialChecksum != "" {
+		fileinfo.ChunkSize, fileinfo.FullThreshold = partialConfig.ChunkSize, partialConfig.FullThreshold
+	}
+	switch fileinfo.ChecksumSource {
+	case checksumSourceFresh:
+		freshHashes++
+	case checksumSourceExternal:
+		externalChecksums++
+	case checksumSourceCache:
+		cachedChecksums++
+	}
+	if par.requireFresh && fileinfo.ChecksumSource != "" && fileinfo.ChecksumSource != checksumSourceFresh && fileinfo.ChecksumSource != checksumSourceInRun {
+		fatal(&FileError{Code: ErrCodeNotFresh, Message: "checksum was not computed from the file, but -require-fresh is set", Path: filename})
+	}
+
+	// The checksum of the content, whether it is compressed or not
+	if par.decompressedChecksum {
+		err := guarded(&fileinfo, "decompressed checksum", func() error {
+			sum, err := msinfo.DecompressedChecksumCtx(readCtx, filename, hashAlgo)
+			if err != nil {
+				return err
+			}
+			fileinfo.Properties[msinfo.PropDecompressedChecksum] = sum
+			return nil
+		})
+		if err != nil {
+			return fileinfo, err
+		}
+	}
+
+	mutable := false
+	if par.checkImmutable {
+		immutable, appendOnly, supported, err := getImmutable(filename)
+		if err != nil {
+			return fileinfo, err
+		}
+		if supported {
+			fileinfo.Properties[msinfo.PropImmutable] = strconv.FormatBool(immutable)
+			fileinfo.Properties[msinfo.PropAppendOnly] = strconv.FormatBool(appendOnly)
+		} else {
+			fileinfo.Properties[msinfo.PropImmutable] = "unknown"
+		}
+		if !immutable {
+			mutable = true
+			mutableFiles++
+			if par.requireImmut {
+				setFileError(&fileinfo, ErrCodeNotImmutable, "file is not immutable")
+			}
+		}
+	}
+
+	// The format is only reported, so it isn't needed to compare files
+	if !par.compare {
+		err := guarded(&fileinfo, "format detection", func() error {
+			format, err := msinfo.DetectFormatCtx(readCtx, filename)
+			if err != nil {
+				return err
+			}
+			fileinfo.Properties[msinfo.PropFormat] = format
+			if format != msinfo.FormatGzip && format != msinfo.FormatZstd {
+				return nil
+			}
+			// A corrupt stream is reported by -verify-embedded, so the format of the content is then unknown
+			format, err = msinfo.DetectContentFormatCtx(readCtx, filename)
+			var pathErr *os.PathError
+			if errors.As(err, &pathErr) {
+				return err
+			}
+			if err == nil {
+				fileinfo.Properties[msinfo.PropContentFormat] = format
+			}
+			return nil
+		})
+		if err != nil {
+			return fileinfo, err
+		}
+		if hasMzMLHeader(fileinfo) {
+			err := guarded(&fileinfo, "mzML header parsing", func() error {
+				return setMzMLProperties(&fileinfo)
+			})
+			if err != nil {
+				return fileinfo, err
+			}
+		}
+		if hasScanCount(fileinfo) {
+			err := guarded(&fileinfo, "spectrum counting", func() error {
+				return setScanCounts(&fileinfo)
+			})
+			if err != nil {
+				return fileinfo, err
+			}
+		}
+	}
+
+	// Text-based MS formats that are not plain ASCII/UTF-8 cause trouble in many tools
+	if isTextFormat(filename) {
+		err := guarded(&fileinfo, "encoding detection", func() error {
+			encoding, err := detectEncoding(filename)
+			if err != nil {
+				return err
+			}
+			fileinfo.Properties[msinfo.PropEncoding] = encoding
+			if !plainEncodings[encoding] {
+				fmt.Fprintln(os.Stderr, "Warning:", filename, "has encoding", encoding+", not plain ASCII or UTF-8")
+			}
+			return nil
+		})
+		if err != nil {
+			return fileinfo, err
+		}
+	}
+
+	embeddedFailed := false
+	if par.verifyEmbedded {
+		valid := false // stays false after a panic with -paranoid
+		err := guarded(&fileinfo, "verification of embedded integrity information", func() error {
+			var err error
+			valid, err = verifyEmbedded(&fileinfo)
+			return err
+		})
+		if err != nil {
+			return fileinfo, err
+		}
+		if !valid {
+			embeddedFailed = true
+			embeddedFailures++
+		}
+	}
+
+	rememberFile(fi, fileinfo, mutable, embeddedFailed)
+
+	if nameChecksumPattern != nil {
+		if _, err := verifyNameChecksum(&fileinfo, par.checksumFromNameAlgo); err != nil {
+			return fileinfo, err
 		}
 	}
 
@@ -109,66 +568,703 @@ func processFile(filename string) (FileInfo, error) {
 
 }
 
+// addCompanions adds the companion files of the given files to the list,
+// and reports the companions that were added and those that are missing
+func addCompanions(fns []string) []string {
+	seen := make(map[string]bool)
+	for _, fn := range fns {
+		seen[filepath.Clean(fn)] = true
+	}
+	result := append([]string(nil), fns...)
+	for _, fn := range fns {
+		companions, err := msinfo.Companions(fn)
+		if err != nil {
+			fatal(codedError("", err))
+		}
+		for _, c := range companions {
+			if !c.Exists {
+				if c.Required {
+					fmt.Fprintln(os.Stderr, "Missing companion", c.Path, "of", fn)
+				}
+				continue
+			}
+			if seen[filepath.Clean(c.Path)] {
+				continue
+			}
+			seen[filepath.Clean(c.Path)] = true
+			fmt.Fprintln(os.Stderr, "Added companion", c.Path, "of", fn)
+			result = append(result, c.Path)
+		}
+	}
+	return result
+}
+
+// probeKeepAtime stops the run if the file times of files in the directories of
+// fns can't be restored
+func probeKeepAtime(fns []string) {
+	// In fast mode, we don't care about access times, so there is no need to test if we can keep them
+	if par.fast {
+		return
+	}
+	// Files in the same directory share the result
+	probed := make(map[string]bool)
+	for _, fn := range fns {
+		if probed[filepath.Dir(fn)] {
+			continue
+		}
+		probed[filepath.Dir(fn)] = true
+		canKeep, err := fcompare.TestKeepAtime(fn)
+		audit.probe(filepath.Dir(fn), err)
+		probeTimeGranularity(filepath.Dir(fn))
+		if !canKeep {
+			fatal(&FileError{Code: ErrCodeAtimePreserve, Message: "unable to preserve file times", Path: fn})
+		}
+	}
+}
+
+// orderFiles sorts files according to the -order flag.
+// "walk" keeps the given order, "small-first" gives quick results for the
+// many small files in a mixed set, "large-first" starts the longest work first.
+// The sort is stable, so files of equal size keep their relative order.
+func orderFiles(fns []string, order string) []string {
+	var smallFirst bool
+	switch order {
+	case "walk":
+		return fns
+	case "small-first":
+		smallFirst = true
+	case "large-first":
+		smallFirst = false
+	default:
+		fatal(usageError("Invalid order: %s", order))
+	}
+
+	// Files that can't be stat'ed are sorted as empty files;
+	// the error is reported when the file is processed
+	sizes := make(map[string]int64, len(fns))
+	for _, fn := range fns {
+		if fi, err := os.Stat(fn); err == nil {
+			sizes[fn] = fi.Size()
+		}
+	}
+	sorted := append([]string(nil), fns...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if smallFirst {
+			return sizes[sorted[i]] < sizes[sorted[j]]
+		}
+		return sizes[sorted[i]] > sizes[sorted[j]]
+	})
+	return sorted
+}
+
+// differingChunks returns which of the chunks read by the partial method differ
+// between two files, e.g. "tail", or "" if the chunks are not known
+func differingChunks(inf1, inf2 FileInfo) string {
+	var differ []string
+	for _, c := range []struct{ key, name string }{
+		{msinfo.PropPartialChecksumHead, "head"}, {msinfo.PropPartialChecksumMiddle, "middle"}, {msinfo.PropPartialChecksumTail, "tail"},
+	} {
+		v1, v2 := inf1.Properties[c.key], inf2.Properties[c.key]
+		if v1 == "" || v2 == "" {
+			return ""
+		}
+		if v1 != v2 {
+			differ = append(differ, c.name)
+		}
+	}
+	return strings.Join(differ, ", ")
+}
+
+// isSameFile checks if two paths refer to the same file on disk,
+// using the device and inode (or file ID on Windows)
+func isSameFile(fn1, fn2 string) (bool, error) {
+	fi1, err := os.Stat(fn1)
+	if err != nil {
+		return false, err
+	}
+	fi2, err := os.Stat(fn2)
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(fi1, fi2), nil
+}
+
 func main() {
+	start := time.Now()
 	handleCommandLine()
+	if !par.reproducible {
+		var err error
+		runID, err = newRunID()
+		if err != nil {
+			fatal(codedError("", err))
+		}
+	}
+
+	if !isValidMethod(par.method) {
+		fatal(usageError("Invalid compare method"))
+	}
+
+	if par.explainPolicy != "" {
+		method, rule := par.methodPolicy.methodFor(par.explainPolicy)
+		fmt.Println(par.explainPolicy+":", method, "(selected by "+rule+")")
+		os.Exit(0)
+	}
+
+	if par.listErrorCodes {
+		listErrorCodes(os.Stdout)
+		os.Exit(0)
+	}
+
+	if par.listProperties {
+		msinfo.WriteProperties(os.Stdout)
+		os.Exit(0)
+	}
+
+	if par.correlate != "" {
+		runs, err := correlate(par.correlate)
+		if err != nil {
+			fatal(codedError("", err))
+		}
+		j, err := json.MarshalIndent(runs, "", "  ")
+		if err != nil {
+			fatal(codedError("", err))
+		}
+		fmt.Println(string(j))
+		os.Exit(0)
+	}
+
+	if par.auditVerify != "" {
+		problems, err := verifyAuditLog(par.auditVerify)
+		if err != nil {
+			fatal(codedError("", err))
+		}
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		if len(problems) > 0 {
+			os.Exit(1)
+		}
+		fmt.Println("Audit log is consistent")
+		os.Exit(0)
+	}
+
+	if par.teeVerify != "" {
+		expected, err := parseTeeExpected(par.teeVerify)
+		if err != nil {
+			fatal(err)
+		}
+		sum, n, err := teeVerify(os.Stdin, os.Stdout)
+		if err != nil {
+			fatal(codedError("", err))
+		}
+		if par.teeManifest != "" {
+			if err := appendTeeManifest(par.teeManifest, par.stdinName, sum, n); err != nil {
+				fatal(codedError("", err))
+			}
+		}
+		if sum != expected {
+			fatal(&FileError{Code: ErrCodeChecksumMismatch, Message: "checksum " + sum + " of " + strconv.FormatInt(n, 10) + " bytes doesn't match " + expected, Path: par.stdinName})
+		}
+		os.Exit(0)
+	}
+
+	var err error
+	hashAlgo, err = fcompare.ParseHashAlgo(par.hash)
+	if err != nil {
+		fatal(usageError("%v", err))
+	}
+
+	partialConfig = fcompare.PartialChecksumConfig{ChunkSize: int64(par.chunkSize), FullThreshold: int64(par.fullThreshold)}
+	if err := partialConfig.Validate(); err != nil {
+		fatal(usageError("%v", err))
+	}
+
+	if par.hashString != "" || par.hashHex != "" {
+		data := []byte(par.hashString)
+		if par.hashHex != "" {
+			data, err = hex.DecodeString(par.hashHex)
+			if err != nil {
+				fatal(usageError("Invalid -hash-hex: %v", err))
+			}
+		}
+		fmt.Println("FullChecksum:", msinfo.ChecksumBytes(data, hashAlgo)[0])
+		partial, _, err := msinfo.PartialChecksumBytes(data, hashAlgo, partialConfig)
+		if err != nil {
+			fatal(usageError("%v", err))
+		}
+		fmt.Println("PartialChecksum:", partial)
+		os.Exit(0)
+	}
 
 	// Print usage if no arguments are provided
-	if flag.NArg() == 0 {
+	if flag.NArg() == 0 && len(par.roots.roots) == 0 && par.from == "" && !par.verify {
 		fmt.Println("Usage: msfile [options] file1 [file2]")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
-	for _, fn := range flag.Args() {
-		canKeep, _ := fcompare.TestKeepAtime(fn)
-		if !canKeep {
-			log.Fatalln("Warning: unable to preserve file times for", fn)
+	if !slices.Contains(outputFormats, par.format) {
+		fatal(usageError("Invalid -format %q, must be one of %s", par.format, strings.Join(outputFormats, ", ")))
+	}
+	if par.json && par.format != "ndjson" {
+		fatal(usageError("-json can't be combined with -format %s", par.format))
+	}
+
+	if par.compareRange != "" && !par.compare {
+		fatal(usageError("-compare-range only works with -compare"))
+	}
+	if !slices.Contains(symlinkPolicies, par.followSymlinks) {
+		fatal(usageError("Invalid -follow-symlinks %q, must be one of %s", par.followSymlinks, strings.Join(symlinkPolicies, ", ")))
+	}
+	if par.compare && par.typeOnly {
+		fatal(usageError("-type-only doesn't work with -compare"))
+	}
+	if par.compare && len(par.roots.roots) > 0 {
+		fatal(usageError("-root doesn't work with -compare"))
+	}
+
+	if par.checksumFromName != "" {
+		if err := compileNameChecksumPattern(par.checksumFromName, par.checksumFromNameAlgo); err != nil {
+			fatal(err)
 		}
 	}
 
+	if par.registry != "" {
+		var err error
+		registry, err = openRegistry(par.registry, par.registryRate)
+		if err != nil {
+			fatal(codedError("", err))
+		}
+	}
+
+	var stopProfile func() error
+	if par.profile != "" {
+		var err error
+		stopProfile, err = startProfile(par.profile)
+		if err != nil {
+			fatal(codedError("", err))
+		}
+	}
+
+	if par.requireFresh && par.precomputed != "" {
+		fmt.Fprintln(os.Stderr, "Ignoring -precomputed because -require-fresh is set")
+		par.precomputed = ""
+	}
+	if par.precomputed != "" {
+		var err error
+		precomputed, err = readPrecomputed(par.precomputed)
+		if err != nil {
+			fatal(codedError("", err))
+		}
+	}
+
+	if par.cacheFile != "" && useCache() {
+		var err error
+		checksumCache, err = fcompare.OpenCache(par.cacheFile)
+		if err != nil {
+			fatal(codedError("", err))
+		}
+	}
+
+	if par.auditLog != "" {
+		var err error
+		audit, err = openAuditLog(par.auditLog)
+		if err != nil {
+			fatal(codedError("", err))
+		}
+	}
+
+	if par.reportJUnit != "" {
+		if par.compare {
+			fatal(usageError("-report-junit doesn't work with -compare"))
+		}
+		junit = newJUnitReport(par.reportJUnit)
+	}
+
+	if par.verifyCopy {
+		if flag.NArg() != 2 {
+			fatal(usageError("-verify-copy needs a source and a destination"))
+		}
+		src, dst := flag.Arg(0), flag.Arg(1)
+		if !par.fast {
+			for _, root := range []string{src, dst} {
+				// Probe inside directories, next to files
+				probe := root
+				if fi, err := os.Stat(root); err == nil && fi.IsDir() {
+					probe = filepath.Join(root, "probe")
+				}
+				canKeep, err := fcompare.TestKeepAtime(probe)
+				audit.probe(filepath.Dir(probe), err)
+				probeTimeGranularity(filepath.Dir(probe))
+				if !canKeep {
+					fatal(&FileError{Code: ErrCodeAtimePreserve, Message: "unable to preserve file times", Path: root})
+				}
+			}
+		}
+		entries, err := verifyCopy(src, dst)
+		if err != nil {
+			fatal(codedError("", err))
+		}
+		ok := writeCopyReport(os.Stdout, src, dst, entries)
+		for _, e := range entries {
+			junit.copied(e)
+		}
+		if err := junit.write("msfile -verify-copy"); err != nil {
+			fatal(codedError("", err))
+		}
+		audit.close()
+		if !ok {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if par.verify {
+		if par.compare {
+			fatal(usageError("-verify doesn't work with -compare"))
+		}
+		// The arguments are the scope of the earlier run, to find new files
+		var scope []string
+		if flag.NArg() != 1 || flag.Arg(0) != "-" {
+			scope = flag.Args()
+		}
+		records, err := readVerifyRecords()
+		if err != nil {
+			fatal(codedError("", err))
+		}
+		added, err := newFiles(records, scope)
+		if err != nil {
+			fatal(codedError("", err))
+		}
+		// Missing files are reported by verifyFiles
+		var fns []string
+		for _, inf := range records {
+			if _, err := os.Stat(inf.Filename); err == nil && inf.FullChecksum != "" {
+				fns = append(fns, inf.Filename)
+			}
+		}
+		probeKeepAtime(fns)
+		entries := append(verifyFiles(records), added...)
+		ok := writeVerifyReport(os.Stdout, entries)
+		for _, e := range entries {
+			junit.verified(e)
+		}
+		if err := junit.write("msfile -verify"); err != nil {
+			fatal(codedError("", err))
+		}
+		audit.close()
+		if !ok {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	args, err := fileArgs()
+	if err != nil {
+		fatal(codedError("", err))
+	}
+	if par.recursive {
+		args, err = expandDirs(args)
+		if err != nil {
+			fatal(codedError("", err))
+		}
+	}
+	if len(par.roots.roots) > 0 {
+		args, err = expandRoots(args)
+		if err != nil {
+			fatal(codedError("", err))
+		}
+	}
+
+	args = applySymlinkPolicy(args, par.followSymlinks)
+
+	probeKeepAtime(args)
+
+	if par.typeOnly {
+		for _, fn := range args {
+			inf, err := processFile(fn)
+			audit.file(inf, err)
+			if err != nil {
+				fatal(codedError("", err))
+			}
+			fmt.Println(fn+":", inf.Properties[msinfo.PropFormat])
+		}
+		audit.close()
+		os.Exit(0)
+	}
+
 	// Check if we are comparing files
 	if par.compare {
-		// This only works with 2 files
-		if flag.NArg() != 2 {
-			log.Fatal("Compare option only works with 2 files")
+		if len(args) < 2 {
+			fatal(usageError("Compare option needs at least 2 files"))
+		} else if len(args) > 2 {
+			// With more than 2 files, print the groups of files that are the same
+			if par.compareRange != "" {
+				fatal(usageError("-compare-range only works with 2 files"))
+			}
+			if par.decompressedChecksum {
+				fatal(usageError("-decompressed-checksum only works with -compare of 2 files"))
+			}
+			groups, err := compareGroups(args)
+			if err != nil {
+				fatal(err)
+			}
+			if !par.quiet {
+				writeCompareGroups(os.Stdout, groups, par.showUnique)
+			}
+			filesDiffer = len(groups) > 1
 		} else {
-			inf1, err := processFile(flag.Args()[0])
+			// Different names can refer to the same file, e.g. on case-insensitive
+			// filesystems, or through hardlinks. Comparing a file to itself
+			// would wrongly suggest that one of them is a duplicate.
+			same, err := isSameFile(args[0], args[1])
 			if err != nil {
-				log.Fatal(err)
+				fatal(codedError("", err))
+			}
+			if same {
+				if !par.quiet {
+					fmt.Println("Both arguments refer to the same file")
+				}
+				audit.close()
+				os.Exit(exitSameFile)
 			}
-			inf2, err := processFile(flag.Args()[1])
+			if par.compareRange != "" {
+				fi1, err := os.Stat(args[0])
+				if err != nil {
+					fatal(codedError("", err))
+				}
+				fi2, err := os.Stat(args[1])
+				if err != nil {
+					fatal(codedError("", err))
+				}
+				compareRange, err = resolveCompareRange(par.compareRange, fi1.Size(), fi2.Size())
+				if err != nil {
+					fatal(err)
+				}
+			}
+			// With -method-for, both files must be compared with the same method
+			method1, _ := par.methodPolicy.methodFor(args[0])
+			method2, _ := par.methodPolicy.methodFor(args[1])
+			if method1 != method2 && compareRange == nil {
+				fatal(usageError("Can't compare files with different methods: %s for %s and %s for %s", method1, args[0], method2, args[1]))
+			}
+			inf1, err := processFile(args[0])
+			audit.file(inf1, err)
 			if err != nil {
-				log.Fatal(err)
+				fatal(codedError("", err))
 			}
-			if (par.method == "partial" && inf1.PartialChecksum == inf2.PartialChecksum) ||
-				(par.method == "size" && inf1.Size == inf2.Size) ||
-				(par.method == "full" && inf1.FullChecksum == inf2.FullChecksum) {
-				fmt.Println("Files are the same")
+			inf2, err := processFile(args[1])
+			audit.file(inf2, err)
+			if err != nil {
+				fatal(codedError("", err))
+			}
+			checkRegistry(&inf1)
+			checkRegistry(&inf2)
+			method := inf1.CompareMethod
+			var equal bool
+			var msg string
+			if par.decompressedChecksum {
+				equal = inf1.Properties[msinfo.PropDecompressedChecksum] == inf2.Properties[msinfo.PropDecompressedChecksum]
+				if equal {
+					msg = "Decompressed contents are the same"
+				} else {
+					msg = "Decompressed contents are different"
+				}
+			} else if method == "bytes" {
+				var offset int64
+				equal, offset, err = fcompare.EqualContentsCtx(readCtx, args[0], args[1], !par.fast)
+				// The files are only read now, up to the first difference
+				audit.read(args[0], inf1.Size, err)
+				audit.read(args[1], inf2.Size, err)
+				if err != nil {
+					fatal(codedError("", err))
+				}
+				switch {
+				case equal:
+					msg = "Files are the same"
+				case offset < 0:
+					msg = "Files are different in size"
+				default:
+					msg = fmt.Sprintf("Files are different at offset %d", offset)
+				}
+			} else if method == "range" {
+				equal = inf1.PartialChecksum == inf2.PartialChecksum
+				if equal {
+					msg = fmt.Sprintf("Files are the same in bytes %d to %d", compareRange.Start, compareRange.Start+compareRange.Length)
+				} else {
+					msg = fmt.Sprintf("Files are different in bytes %d to %d", compareRange.Start, compareRange.Start+compareRange.Length)
+				}
 			} else {
-				fmt.Println("Files are different")
+				equal = ((method == "partial" || method == "partial-adaptive") && inf1.PartialChecksum == inf2.PartialChecksum) ||
+					(method == "size" && inf1.Size == inf2.Size) ||
+					(method == "full" && inf1.FullChecksum == inf2.FullChecksum)
+				if equal {
+					msg = "Files are the same"
+				} else if chunks := differingChunks(inf1, inf2); chunks != "" {
+					msg = "Files are different (differing chunks: " + chunks + ")"
+				} else {
+					msg = "Files are different"
+				}
+			}
+			if !par.quiet {
+				fmt.Println(msg)
 			}
+			filesDiffer = !equal
 		}
 	} else {
 
-		// for all remaining arguments
-		for _, arg := range flag.Args() {
-			// process each file
-			inf, err := processFile(arg)
+		if par.withCompanions {
+			args = addCompanions(args)
+		}
+		// Parts of split files are processed as a single file
+		args, splitSets, err := findSplitSets(args)
+		if err != nil {
+			fatal(codedError("", err))
+		}
+		if par.reproducible {
+			args = append([]string(nil), args...)
+			sort.Strings(args)
+		}
+		args = orderFiles(args, par.order)
+
+		deadline, err := runDeadline(start, par.maxRuntime, par.stopAt)
+		if err != nil {
+			fatal(codedError("", err))
+		}
+		var completed []string
+		if par.resume != "" {
+			completed, err = readCheckpoint(par.resume)
 			if err != nil {
-				log.Fatal(err)
+				fatal(codedError("", err))
 			}
-			// Output in JSON format if requested
-			if par.json {
-				// Convert inf to a JSON string
-				j, err := json.Marshal(inf)
-				if err != nil {
-					log.Fatal(err)
+		}
+		done := make(map[string]bool, len(completed))
+		for _, fn := range completed {
+			done[filepath.Clean(fn)] = true
+		}
+
+		// for all remaining arguments
+		for _, arg := range args {
+			if done[filepath.Clean(arg)] {
+				skipFile(arg, skipCompleted)
+				continue
+			}
+			if stoppedAtDeadline || deadlinePassed(deadline) {
+				stoppedAtDeadline = true
+				skipFile(arg, skipDeadline)
+				continue
+			}
+			// Devices are never read
+			if fi, err := os.Stat(arg); err == nil && isDevice(fi) {
+				fmt.Fprintln(os.Stderr, "Skipping device", arg)
+				skipFile(arg, skipSpecialFile)
+				continue
+			}
+			// process each file
+			fileStart := time.Now()
+			var inf FileInfo
+			if set, ok := splitSets[arg]; ok {
+				var valid bool
+				inf, valid, err = processSplit(set)
+				if !valid {
+					splitFailures++
 				}
-				fmt.Println(string(j))
 			} else {
-				fmt.Printf("%+v\n", inf)
+				inf, err = processFile(arg)
+				audit.file(inf, err)
 			}
+			if err != nil {
+				fatal(codedError("", err))
+			}
+			checkRegistry(&inf)
+			if len(par.roots.roots) > 0 {
+				setRoot(arg, &inf)
+			}
+			writeRecord(inf)
+			junit.file(inf, time.Since(fileStart))
+			completed = append(completed, arg)
+		}
+		closeOutput()
 
+		if stoppedAtDeadline {
+			cpFile := par.checkpoint
+			if cpFile == "" {
+				cpFile = par.resume
+			}
+			if cpFile == "" {
+				cpFile = "msfile-checkpoint.json"
+			}
+			if err := writeCheckpoint(cpFile, completed); err != nil {
+				fatal(codedError("", err))
+			}
+			fmt.Fprintln(os.Stderr, "Deadline reached, stopped before processing all files; resume with -resume", cpFile)
+		}
+	}
+	if checksumCache != nil {
+		if err := checksumCache.Save(); err != nil {
+			fatal(codedError("", err))
 		}
 	}
+	audit.close()
+	if par.precomputed != "" || par.requireFresh || cachedChecksums > 0 || inRunChecksums > 0 {
+		fmt.Fprintf(os.Stderr, "Checksums: %d computed from files, %d from precomputed input, %d from the cache, %d reused within the run\n", freshHashes, externalChecksums, cachedChecksums, inRunChecksums)
+	}
+	exitCode := 0
+	if par.exitCode && filesDiffer {
+		exitCode = exitDifferent
+	}
+	if embeddedFailures > 0 {
+		fmt.Fprintln(os.Stderr, embeddedFailures, "file(s) failed verification of embedded integrity information")
+		exitCode = 1
+	}
+	if splitFailures > 0 {
+		fmt.Fprintln(os.Stderr, splitFailures, "split file(s) failed verification")
+		exitCode = 1
+	}
+	if par.checkImmutable && mutableFiles > 0 {
+		fmt.Fprintln(os.Stderr, mutableFiles, "file(s) are not immutable")
+		if par.requireImmut {
+			exitCode = 1
+		}
+	}
+	if nameChecksumPattern != nil {
+		fmt.Fprintf(os.Stderr, "Checksums from file names: %d match, %d mismatch, %d without digest in name\n",
+			nameChecksumCounts[nameChecksumMatch], nameChecksumCounts[nameChecksumMismatch], nameChecksumCounts[nameChecksumNoPattern])
+		if nameChecksumCounts[nameChecksumMismatch] > 0 {
+			exitCode = 1
+		}
+	}
+	if errorCounts[ErrCodeAlreadyHeld] > 0 {
+		fmt.Fprintln(os.Stderr, errorCounts[ErrCodeAlreadyHeld], "file(s) are already held according to the registry")
+		exitCode = 1
+	}
+	// A run that stopped at its deadline must be resumed, whether files failed or not
+	if stoppedAtDeadline {
+		if exitCode == 0 {
+			exitCode = exitIncomplete
+		} else {
+			exitCode = exitIncompleteFailed
+		}
+	}
+	if len(par.roots.roots) > 0 {
+		writeRootSummary(os.Stderr)
+	}
+	if (par.emitSkipped || walkLimitSkips > 0) && len(skipCounts) > 0 {
+		fmt.Fprintln(os.Stderr, "Skipped files:", skipSummary())
+	}
+	if len(errorCounts) > 0 {
+		fmt.Fprintln(os.Stderr, "Errors by code:", errorSummary())
+	}
+	if stopProfile != nil {
+		if err := stopProfile(); err != nil {
+			fatal(codedError("", err))
+		}
+	}
+	if par.resourceUsage {
+		writeResourceUsage(os.Stderr, getResourceUsage(start), par.format == "ndjson" || par.format == "json")
+	}
+	if err := junit.write("msfile"); err != nil {
+		fatal(codedError("", err))
+	}
+	os.Exit(exitCode)
 }
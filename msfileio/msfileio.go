@@ -0,0 +1,207 @@
+// Package msfileio contains the types of the JSON artifacts that msfile writes,
+// and readers for them. msfile itself writes these types, so programs that use
+// this package always read the same fields that msfile writes.
+package msfileio
+
+import (
+	"encoding/json"
+
+	"github.com/524D/msfile/fcompare"
+)
+
+// FileInfo is the record of a file, as written by msfile -json (one per line),
+// and by -tee-manifest
+type FileInfo struct {
+	Filename        string `json:"filename"`
+	RunID           string `json:"run_id,omitempty"`    // random ID of the run, omitted with -reproducible
+	RecordID        int64  `json:"record_id,omitempty"` // ID of the file within the run, counting from 1
+	Size            int64  `json:"size"`
+	AllocatedSize   int64  `json:"allocated_size"` // disk space used, less than Size for sparse or compressed files; Size where unknown
+	Atime           int64  `json:"atime"`
+	Mtime           int64  `json:"mtime"`
+	AtimeNs         int64  `json:"atime_ns,omitempty"` // Atime and Mtime with nanoseconds, as far as the filesystem stores them
+	MtimeNs         int64  `json:"mtime_ns,omitempty"`
+	PartialChecksum string `json:"partial_checksum"`
+	FullChecksum    string `json:"full_checksum"`
+	ChecksumSource  string `json:"checksum_source,omitempty"` // where the checksums come from, see ChecksumSourceFresh etc.
+	CompareMethod   string `json:"compare_method,omitempty"`  // method applied to this file in compare mode
+	HashAlgo        string `json:"hash_algo,omitempty"`       // hash algorithm of the checksums, if not sha256
+	// Chunk size and threshold of the partial checksum, with compare method partial
+	ChunkSize     int64  `json:"chunk_size,omitempty"`
+	FullThreshold int64  `json:"full_threshold,omitempty"`
+	Root          string `json:"root,omitempty"`      // label of the -root that contains the file
+	RootPath      string `json:"root_path,omitempty"` // path relative to the root, with forward slashes
+	// Size of the decompressed data, for compressed files checked with -verify-embedded
+	DecompressedSize int64             `json:"decompressed_size,omitempty"`
+	Properties       map[string]string `json:"properties"`
+	// Set when the file failed a verification that doesn't stop the run
+	Error *FileError `json:"error,omitempty"`
+}
+
+// fileInfoV0 has the field names of msfile versions before FileInfo had json tags
+type fileInfoV0 struct {
+	Filename         string
+	RunID            string
+	RecordID         int64
+	Size             int64
+	AllocatedSize    int64
+	Atime            int64
+	Mtime            int64
+	AtimeNs          int64
+	MtimeNs          int64
+	PartialChecksum  string
+	FullChecksum     string
+	ChecksumSource   string
+	CompareMethod    string
+	HashAlgo         string
+	ChunkSize        int64
+	FullThreshold    int64
+	Root             string
+	RootPath         string
+	DecompressedSize int64
+	Properties       map[string]string
+	Error            *FileError
+}
+
+// UnmarshalJSON also reads the records of older msfile versions, which used the
+// Go field names, e.g. "PartialChecksum" instead of "partial_checksum"
+func (f *FileInfo) UnmarshalJSON(b []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	// Older versions always wrote PartialChecksum
+	if _, ok := fields["PartialChecksum"]; ok {
+		var v0 fileInfoV0
+		if err := json.Unmarshal(b, &v0); err != nil {
+			return err
+		}
+		*f = FileInfo(v0)
+		return nil
+	}
+	type fileInfo FileInfo // without the UnmarshalJSON method
+	return json.Unmarshal(b, (*fileInfo)(f))
+}
+
+// Values of FileInfo.ChecksumSource
+const (
+	ChecksumSourceFresh    = "fresh"    // computed by reading the file in this run
+	ChecksumSourceExternal = "external" // read from a -precomputed file
+	ChecksumSourceCache    = "cache"    // read from the checksum cache of the file, see msfile -no-cache
+	ChecksumSourceInRun    = "in-run"   // reused from another reference to the same file in this run
+)
+
+// FileError is an error with a code, and the file it applies to (if any).
+// msfile -list-error-codes lists the codes.
+type FileError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Path    string `json:"path,omitempty"`
+}
+
+func (e *FileError) Error() string {
+	if e.Path != "" {
+		return e.Code + ": " + e.Path + ": " + e.Message
+	}
+	return e.Code + ": " + e.Message
+}
+
+// SkippedRecord is written by -emit-skipped for a file that was considered,
+// but not processed. Its Type is always RecordTypeSkipped.
+type SkippedRecord struct {
+	Type       string `json:"type"`
+	Filename   string `json:"filename"`
+	RunID      string `json:"run_id,omitempty"`
+	RecordID   int64  `json:"record_id,omitempty"`
+	SkipReason string `json:"skip_reason"`
+}
+
+// RecordTypeSkipped is the type of SkippedRecord; FileInfo records have no type
+const RecordTypeSkipped = "skipped"
+
+// AuditRecord is a line of the -audit-log
+type AuditRecord struct {
+	Type     string   `json:"type"`                // "run", "probe", "file" or "end"
+	Time     int64    `json:"time,omitempty"`      // omitted with -reproducible
+	RunID    string   `json:"run_id,omitempty"`    // in the "run" header, omitted with -reproducible
+	RecordID int64    `json:"record_id,omitempty"` // the record ID of a "file" record in the output
+	Args     []string `json:"args,omitempty"`      // with the values of sensitive flags replaced by "***"
+	Env      []string `json:"env,omitempty"`       // environment variables that set options, by name only
+	Method   string   `json:"method,omitempty"`
+	// In the "run" header: the run doesn't write to the files it processes, or
+	// their directories, so no record of the run may have a metadata write
+	ReadOnly bool             `json:"read_only,omitempty"`
+	Filename string           `json:"filename,omitempty"`
+	Dir      string           `json:"dir,omitempty"`
+	Size     int64            `json:"size,omitempty"`
+	OpenMode string           `json:"open_mode,omitempty"`
+	Ranges   []fcompare.Range `json:"ranges,omitempty"` // the regions that were read, merged where they touch
+	// Metadata writes to the file or its directory
+	Chtimes   bool   `json:"chtimes,omitempty"`
+	Xattr     bool   `json:"xattr,omitempty"` // the checksum cache attribute was written
+	TempFile  bool   `json:"temp_file,omitempty"`
+	Outcome   string `json:"outcome"`
+	Error     string `json:"error,omitempty"`
+	ErrorCode string `json:"error_code,omitempty"`
+}
+
+// auditRecordV0 has the field names of msfile versions before AuditRecord had json tags
+type auditRecordV0 struct {
+	Type      string
+	Time      int64
+	RunID     string
+	RecordID  int64
+	Args      []string
+	Env       []string
+	Method    string
+	ReadOnly  bool
+	Filename  string
+	Dir       string
+	Size      int64
+	OpenMode  string
+	Ranges    []fcompare.Range
+	Chtimes   bool
+	Xattr     bool
+	TempFile  bool
+	Outcome   string
+	Error     string
+	ErrorCode string
+}
+
+// UnmarshalJSON also reads the records of older msfile versions, which used the Go
+// field names. Runs append to the same log, so a log can have records of both.
+func (a *AuditRecord) UnmarshalJSON(b []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	// Older versions always wrote Type
+	if _, ok := fields["Type"]; ok {
+		var v0 auditRecordV0
+		if err := json.Unmarshal(b, &v0); err != nil {
+			return err
+		}
+		*a = AuditRecord(v0)
+		return nil
+	}
+	type auditRecord AuditRecord // without the UnmarshalJSON method
+	return json.Unmarshal(b, (*auditRecord)(a))
+}
+
+// Checkpoint is the file written by -max-runtime and -stop-at, and read by -resume
+type Checkpoint struct {
+	Completed []string
+}
+
+// ResourceUsage is the resource usage of a run, written by -resource-usage.
+// Fields that can't be determined on the platform are 0.
+type ResourceUsage struct {
+	WallSeconds      float64
+	UserCPUSeconds   float64
+	SystemCPUSeconds float64
+	PeakRSSBytes     int64
+	NumGC            uint32
+	GCPauseSeconds   float64
+	ReadSyscalls     int64 `json:",omitempty"` // Linux and Windows only
+	ReadBytes        int64 `json:",omitempty"` // Linux and Windows only
+}
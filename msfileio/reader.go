@@ -0,0 +1,106 @@
+package msfileio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Maximum length of a line in a JSON lines stream
+const maxLineSize = 16 * 1024 * 1024
+
+// Lines decodes a stream of JSON lines, one value of type T per line.
+// Empty lines are skipped.
+type Lines[T any] struct {
+	s    *bufio.Scanner
+	line int
+}
+
+// NewLines returns a decoder for the JSON lines in r
+func NewLines[T any](r io.Reader) *Lines[T] {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), maxLineSize)
+	return &Lines[T]{s: s}
+}
+
+// Next returns the next value, or io.EOF at the end of the stream
+func (l *Lines[T]) Next() (T, error) {
+	var v T
+	for l.s.Scan() {
+		l.line++
+		b := bytes.TrimSpace(l.s.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(b, &v); err != nil {
+			return v, fmt.Errorf("line %d: %w", l.line, err)
+		}
+		return v, nil
+	}
+	if err := l.s.Err(); err != nil {
+		return v, err
+	}
+	return v, io.EOF
+}
+
+// Record is a line of the output of msfile -json: either a file or a skipped file
+type Record struct {
+	File    *FileInfo
+	Skipped *SkippedRecord
+}
+
+// Records decodes the output of msfile -json
+type Records struct {
+	l *Lines[json.RawMessage]
+}
+
+// NewRecords returns a decoder for the output of msfile -json in r
+func NewRecords(r io.Reader) *Records {
+	return &Records{l: NewLines[json.RawMessage](r)}
+}
+
+// Next returns the next record, or io.EOF at the end of the stream
+func (r *Records) Next() (Record, error) {
+	raw, err := r.l.Next()
+	if err != nil {
+		return Record{}, err
+	}
+	var t struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(raw, &t); err != nil {
+		return Record{}, fmt.Errorf("line %d: %w", r.l.line, err)
+	}
+	switch t.Type {
+	case "":
+		var inf FileInfo
+		if err := json.Unmarshal(raw, &inf); err != nil {
+			return Record{}, fmt.Errorf("line %d: %w", r.l.line, err)
+		}
+		return Record{File: &inf}, nil
+	case RecordTypeSkipped:
+		var rec SkippedRecord
+		if err := json.Unmarshal(raw, &rec); err != nil {
+			return Record{}, fmt.Errorf("line %d: %w", r.l.line, err)
+		}
+		return Record{Skipped: &rec}, nil
+	default:
+		return Record{}, fmt.Errorf("line %d: unknown record type %q", r.l.line, t.Type)
+	}
+}
+
+// ReadCheckpoint reads a checkpoint
+func ReadCheckpoint(r io.Reader) (Checkpoint, error) {
+	var cp Checkpoint
+	err := json.NewDecoder(r).Decode(&cp)
+	return cp, err
+}
+
+// ReadResourceUsage reads the JSON object written by -resource-usage with -json
+func ReadResourceUsage(r io.Reader) (ResourceUsage, error) {
+	var v struct{ ResourceUsage ResourceUsage }
+	err := json.NewDecoder(r).Decode(&v)
+	return v.ResourceUsage, err
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/524D/msfile/msfileio"
+)
+
+// checkRoundTrip checks that v, decoded from the JSON in data, encodes to the same JSON,
+// so that the type of msfileio has every field that msfile wrote
+func checkRoundTrip(t *testing.T, what string, data []byte, v any) {
+	t.Helper()
+	out, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want, got any
+	if err := json.Unmarshal(data, &want); err != nil {
+		t.Fatalf("%s: %v", what, err)
+	}
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%s: msfile wrote\n%s\nread back as\n%s", what, data, out)
+	}
+}
+
+// readLines decodes all JSON lines in data with msfileio.Lines, and checks each round trip
+func readLines[T any](t *testing.T, what string, data string) []T {
+	t.Helper()
+	var vs []T
+	l := msfileio.NewLines[T](strings.NewReader(data))
+	lines := strings.Split(strings.TrimSpace(data), "\n")
+	for i := 0; ; i++ {
+		v, err := l.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", what, err)
+		}
+		checkRoundTrip(t, what, []byte(lines[i]), v)
+		vs = append(vs, v)
+	}
+	if len(vs) != len(lines) {
+		t.Errorf("%s: read %d of %d lines", what, len(vs), len(lines))
+	}
+	return vs
+}
+
+// The JSON artifacts of every output mode are read by msfileio without losing fields
+func TestMsfileioRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	writeFixtureTree(t, dir)
+	writeTestFile(t, dir, "data/bad.mgf.gz", []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\x03garbage"))
+	// -follow-symlinks no skips arguments that are symlinks
+	wantSkipped := 0
+	if os.Symlink(filepath.Join("data", "z.fasta"), filepath.Join(dir, "link.fasta")) == nil {
+		wantSkipped = 1
+	}
+	args := []string{"-r", "-checksums", "-verify-embedded", "-scan-count", "-emit-skipped", "-follow-symlinks", "no", "-resource-usage", "-audit-log", "audit.log"}
+
+	// -format ndjson, with file records, skipped records and errors
+	stdout, stderr, code := runMsfile(t, dir, nil, append(args, "-format", "ndjson", "data", "link.fasta")...)
+	if code != 1 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	r := msfileio.NewRecords(strings.NewReader(stdout))
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	var files, skipped, errs int
+	for i := 0; ; i++ {
+		rec, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case rec.File != nil:
+			checkRoundTrip(t, "ndjson record", []byte(lines[i]), rec.File)
+			files++
+			if rec.File.Error != nil {
+				errs++
+			}
+		case rec.Skipped != nil:
+			checkRoundTrip(t, "skipped record", []byte(lines[i]), rec.Skipped)
+			skipped++
+		}
+	}
+	if files != 6 || errs != 1 || skipped != wantSkipped {
+		t.Errorf("%d file records with %d errors, and %d skipped, want 6 with 1 error, and %d skipped", files, errs, skipped, wantSkipped)
+	}
+
+	// The resource usage is a JSON object on stderr
+	i := strings.LastIndex(stderr, `{"ResourceUsage"`)
+	if i < 0 {
+		t.Fatalf("no resource usage in %s", stderr)
+	}
+	ru, err := msfileio.ReadResourceUsage(strings.NewReader(stderr[i:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRoundTrip(t, "resource usage", []byte(stderr[i:]), struct{ ResourceUsage msfileio.ResourceUsage }{ru})
+
+	audit := readLines[msfileio.AuditRecord](t, "audit log", readTestFile(t, filepath.Join(dir, "audit.log")))
+	if audit[0].Type != "run" || audit[len(audit)-1].Type != "end" {
+		t.Errorf("audit log from %q to %q, want from run to end", audit[0].Type, audit[len(audit)-1].Type)
+	}
+
+	// -format json is an array of the same records
+	stdout, stderr, code = runMsfile(t, dir, nil, "-r", "-checksums", "-format", "json", "data")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	var raw []json.RawMessage
+	if err := json.Unmarshal([]byte(stdout), &raw); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range raw {
+		var inf msfileio.FileInfo
+		if err := json.Unmarshal(b, &inf); err != nil {
+			t.Fatal(err)
+		}
+		checkRoundTrip(t, "json record", b, inf)
+	}
+
+	// A checkpoint, written when the run stops at its deadline
+	cp := filepath.Join(dir, "cp.json")
+	_, stderr, code = runMsfile(t, dir, nil, "-r", "-max-runtime", "1ns", "-checkpoint", cp, "data")
+	if code != exitIncomplete {
+		t.Fatalf("exit code %d, want %d: %s", code, exitIncomplete, stderr)
+	}
+	data := []byte(readTestFile(t, cp))
+	c, err := msfileio.ReadCheckpoint(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRoundTrip(t, "checkpoint", data, c)
+
+	// The -tee-manifest line of an empty stream
+	manifest := filepath.Join(dir, "manifest.ndjson")
+	_, stderr, code = runMsfile(t, dir, nil, "-tee-verify", "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		"-tee-manifest", manifest, "-stdin-name", "stream")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if m := readLines[msfileio.FileInfo](t, "tee manifest", readTestFile(t, manifest)); m[0].Filename != "stream" {
+		t.Errorf("tee manifest has %q, want stream", m[0].Filename)
+	}
+}
+
+// Records of older msfile versions, with the Go field names, are read too
+func TestMsfileioOldRecords(t *testing.T) {
+	old := `{"Filename":"a.mzML","Size":10,"PartialChecksum":"ab","FullChecksum":"cd","CompareMethod":"full"}`
+	r := msfileio.NewRecords(strings.NewReader(old + "\n\n"))
+	rec, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.File == nil || rec.File.Filename != "a.mzML" || rec.File.Size != 10 || rec.File.FullChecksum != "cd" {
+		t.Errorf("old record read as %+v", rec.File)
+	}
+	if _, err := r.Next(); !errors.Is(err, io.EOF) {
+		t.Errorf("after the last record: %v, want io.EOF", err)
+	}
+	if _, err := msfileio.NewRecords(strings.NewReader(`{"type":"future"}`)).Next(); err == nil {
+		t.Error("unknown record type: no error")
+	}
+}
@@ -0,0 +1,77 @@
+package msinfo
+
+// budget.go - Limits on what a crafted file can make the parsers read and allocate
+// msfile runs on files uploaded by external collaborators, so the parsers of file
+// content must end, with bounded memory, whatever the content. Decompression is
+// limited relative to the compressed size, the XML parsers limit the size of a
+// single token and the nesting of elements, and the mzML header has a size limit.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// MaxDecompressionRatio is the maximum decompressed size of a compressed file,
+// as a multiple of its compressed size. MS data compresses far less than this.
+const MaxDecompressionRatio = 100
+
+const (
+	// Minimum decompression budget, so small files are never limited in practice
+	minDecompressionBudget = 1 << 30
+	// Maximum decompressed size of the header of an mzML file, up to the spectrum list
+	maxMzMLHeaderSize = 64 << 20
+	// Maximum size of a single XML token; the largest are base64 binary arrays of spectra
+	maxXMLTokenSize = 256 << 20
+	// Maximum nesting of XML elements; mzML nests less than 10 deep
+	maxXMLDepth = 256
+	// Maximum window of zstd frames, which the decoder allocates. zstd uses at
+	// most 128 MB, also with --ultra and the default of --long.
+	maxZstdWindow = 128 << 20
+)
+
+// ErrBudgetExceeded is returned by the parsers for content that is larger than
+// any valid file would need, e.g. a gzip bomb
+var ErrBudgetExceeded = errors.New("extraction budget exceeded")
+
+var errXMLTooDeep = fmt.Errorf("%w: XML elements nested more than %d deep", ErrBudgetExceeded, maxXMLDepth)
+
+// DecompressionBudget returns the maximum number of bytes that are decompressed
+// from a file of compressedSize bytes
+func DecompressionBudget(compressedSize int64) int64 {
+	return max(compressedSize*MaxDecompressionRatio, minDecompressionBudget)
+}
+
+// LimitDecompressed returns a reader of the decompressed data r of a file of
+// compressedSize bytes, which fails with ErrBudgetExceeded after DecompressionBudget bytes
+func LimitDecompressed(r io.Reader, compressedSize int64) io.Reader {
+	return &budgetReader{r: r, left: DecompressionBudget(compressedSize),
+		what: fmt.Sprintf("decompressed size more than %d times the compressed size", MaxDecompressionRatio)}
+}
+
+// budgetReader reads up to left bytes from r, and then fails with ErrBudgetExceeded,
+// unless r ends right there
+type budgetReader struct {
+	r    io.Reader
+	left int64
+	what string // what the budget is exceeded with, for the error
+}
+
+func (b *budgetReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if b.left <= 0 {
+		var one [1]byte
+		if n, err := b.r.Read(one[:]); n == 0 && err != nil {
+			return 0, err
+		}
+		return 0, fmt.Errorf("%w: %s", ErrBudgetExceeded, b.what)
+	}
+	if int64(len(p)) > b.left {
+		p = p[:b.left]
+	}
+	n, err := b.r.Read(p)
+	b.left -= int64(n)
+	return n, err
+}
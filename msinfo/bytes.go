@@ -0,0 +1,34 @@
+package msinfo
+
+// bytes.go - Checksums of data in memory
+// Integrators that generate data in memory (synthetic test files, database blobs)
+// can compute the same checksums that msfile computes for a file with that
+// content, e.g. to fill in a manifest before the data is written.
+
+import (
+	"bytes"
+
+	"github.com/524D/msfile/fcompare"
+)
+
+// ChecksumBytes returns the checksums of data, as fcompare.GetChecksumHash returns
+// them for a file with the same content: one for each algorithm, or only SHA256
+// if none is given.
+func ChecksumBytes(data []byte, algos ...fcompare.HashAlgo) []string {
+	if len(algos) == 0 {
+		algos = []fcompare.HashAlgo{fcompare.HashSHA256}
+	}
+	sums := make([]string, len(algos))
+	for i, algo := range algos {
+		// Reading from memory can't fail
+		sums[i], _ = fcompare.ChecksumReader(bytes.NewReader(data), algo)
+	}
+	return sums
+}
+
+// PartialChecksumBytes returns the partial checksum of data with algo, as
+// fcompare.GetPartialChecksumConfig returns it for a file with the same content.
+// isFull indicates that the partial checksum is the full checksum.
+func PartialChecksumBytes(data []byte, algo fcompare.HashAlgo, cfg fcompare.PartialChecksumConfig) (sum string, isFull bool, err error) {
+	return fcompare.PartialChecksumReaderAtHash(bytes.NewReader(data), int64(len(data)), algo, cfg)
+}
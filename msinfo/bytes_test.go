@@ -0,0 +1,99 @@
+package msinfo
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/524D/msfile/fcompare"
+)
+
+var testAlgos = []fcompare.HashAlgo{fcompare.HashSHA256, fcompare.HashMD5, fcompare.HashCRC32, fcompare.HashBLAKE3}
+
+func TestChecksumBytesGolden(t *testing.T) {
+	tests := []struct {
+		data string
+		algo fcompare.HashAlgo
+		want string
+	}{
+		{"", fcompare.HashSHA256, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{"abc", fcompare.HashSHA256, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{"abc", fcompare.HashSHA1, "a9993e364706816aba3e25717850c26c9cd0d89d"},
+		{"abc", fcompare.HashMD5, "900150983cd24fb0d6963f7d28e17f72"},
+		{"abc", fcompare.HashCRC32, "352441c2"},
+		{"abc", fcompare.HashBLAKE3, "6437b3ac38465133ffb63b75273a8db548c558465d79db03fd359c6cd5bd9d85"},
+	}
+	for _, tt := range tests {
+		got := ChecksumBytes([]byte(tt.data), tt.algo)[0]
+		if got != tt.want {
+			t.Errorf("ChecksumBytes(%q, %v) = %s, want %s", tt.data, tt.algo, got, tt.want)
+		}
+		sum, isFull, err := PartialChecksumBytes([]byte(tt.data), tt.algo, fcompare.DefaultPartialChecksumConfig)
+		if err != nil || sum != tt.want || !isFull {
+			t.Errorf("PartialChecksumBytes(%q, %v) = %s, %v, %v, want %s, true", tt.data, tt.algo, sum, isFull, err, tt.want)
+		}
+	}
+	// Without algorithms, only SHA256
+	if sums := ChecksumBytes([]byte("abc")); len(sums) != 1 || sums[0] != tests[1].want {
+		t.Errorf("ChecksumBytes without algorithms = %v", sums)
+	}
+}
+
+// The checksums of data in memory must be those of a file with the same content,
+// for sizes around the chunk size and the threshold
+func TestChecksumBytesMatchFiles(t *testing.T) {
+	small := fcompare.PartialChecksumConfig{ChunkSize: 4096, FullThreshold: 3 * 4096}
+	def := fcompare.DefaultPartialChecksumConfig
+	tests := []struct {
+		cfg   fcompare.PartialChecksumConfig
+		sizes []int64
+	}{
+		{small, []int64{0, 1, 4095, 4096, 4097, 3*4096 - 1, 3 * 4096, 3*4096 + 1, 8 * 4096, 8*4096 + 4095, 8*4096 + 4097, 100003}},
+		{def, []int64{def.FullThreshold - 1, def.FullThreshold, def.FullThreshold + 1, def.FullThreshold + def.ChunkSize/2}},
+	}
+	dir := t.TempDir()
+	rng := rand.New(rand.NewSource(1))
+	for _, tt := range tests {
+		for _, size := range tt.sizes {
+			data := make([]byte, size)
+			rng.Read(data)
+			fn := filepath.Join(dir, strconv.FormatInt(size, 10))
+			if err := os.WriteFile(fn, data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			sums := ChecksumBytes(data, testAlgos...)
+			for i, algo := range testAlgos {
+				want, err := fcompare.GetChecksumHash(fn, algo)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if sums[i] != want {
+					t.Errorf("size %d, %v: ChecksumBytes = %s, file = %s", size, algo, sums[i], want)
+				}
+				wantPartial, wantFull, err := fcompare.GetPartialChecksumConfig(fn, algo, tt.cfg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				partial, isFull, err := PartialChecksumBytes(data, algo, tt.cfg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if partial != wantPartial || isFull != wantFull {
+					t.Errorf("size %d, %v, %+v: PartialChecksumBytes = %s, %v, file = %s, %v", size, algo, tt.cfg, partial, isFull, wantPartial, wantFull)
+				}
+				if isFull != (size <= tt.cfg.FullThreshold) {
+					t.Errorf("size %d, %+v: isFull = %v", size, tt.cfg, isFull)
+				}
+			}
+		}
+	}
+}
+
+func TestPartialChecksumBytesInvalidConfig(t *testing.T) {
+	_, _, err := PartialChecksumBytes([]byte("abc"), fcompare.HashSHA256, fcompare.PartialChecksumConfig{ChunkSize: 10, FullThreshold: 20})
+	if err == nil {
+		t.Error("no error for a threshold below 3 chunks")
+	}
+}
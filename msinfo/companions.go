@@ -0,0 +1,92 @@
+package msinfo
+
+// companions.go - Find the companion files that belong with an MS data file
+// Several MS formats store one acquisition in more than one file, e.g. SCIEX
+// .wiff + .wiff.scan, or imzML metadata + .ibd binary data. Those files only
+// make sense together, so tools that copy, compare or deduplicate them need
+// to know which files belong together.
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Companion is a file that belongs with another file
+type Companion struct {
+	Path     string
+	Exists   bool
+	Required bool // the data is incomplete without this companion
+}
+
+// companionRule describes how to find a companion from a file name, by replacing
+// suffix (matched case-insensitively) with companionSuffix
+type companionRule struct {
+	suffix          string
+	companionSuffix string
+	required        bool
+}
+
+// The rules are checked in order, and only the first matching rule is used,
+// so longer suffixes must come before shorter ones that they end with
+var companionRules = []companionRule{
+	// SCIEX: the .wiff file holds the metadata, the .wiff.scan file the spectra
+	{".wiff.scan", ".wiff", true},
+	{".wiff", ".wiff.scan", true},
+	// imzML: XML metadata and binary spectra
+	{".imzml", ".ibd", true},
+	{".ibd", ".imzML", true},
+	// Skyline: the .skyd file is a cache of chromatogram data, which Skyline
+	// can rebuild from the raw data, so it is not required
+	{".skyd", ".sky", true},
+	{".sky", ".skyd", false},
+}
+
+// Companions returns the companion files of an MS data file,
+// or nil if the format of the file doesn't have companions.
+// If a companion doesn't exist with the expected name, a file in the same
+// directory whose name only differs in case is used instead.
+func Companions(path string) ([]Companion, error) {
+	lower := strings.ToLower(path)
+	for _, rule := range companionRules {
+		if !strings.HasSuffix(lower, rule.suffix) {
+			continue
+		}
+		name := path[:len(path)-len(rule.suffix)] + rule.companionSuffix
+		c := Companion{Path: name, Required: rule.required}
+		found, err := findFile(name)
+		if err != nil {
+			return nil, err
+		}
+		if found != "" {
+			c.Path = found
+			c.Exists = true
+		}
+		return []Companion{c}, nil
+	}
+	return nil, nil
+}
+
+// findFile returns the name of the file, or of a file in the same directory
+// whose name only differs in case, or "" if there is no such file
+func findFile(name string) (string, error) {
+	if _, err := os.Stat(name); err == nil {
+		return name, nil
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+	dir, base := filepath.Split(name)
+	entries, err := os.ReadDir(filepath.Clean(dir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	for _, e := range entries {
+		if strings.EqualFold(e.Name(), base) {
+			return filepath.Join(dir, e.Name()), nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,98 @@
+package msinfo_test
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/524D/msfile/fcompare"
+	"github.com/524D/msfile/msinfo"
+)
+
+// exampleDir writes a few MS files in a temporary directory
+func exampleDir() string {
+	dir, err := os.MkdirTemp("", "msinfo-example")
+	if err != nil {
+		log.Fatal(err)
+	}
+	for name, data := range map[string]string{
+		"a.mgf":   "BEGIN IONS\nTITLE=1\nEND IONS\n",
+		"b.fasta": ">p\nMKV\n",
+		"c.mgf":   "BEGIN IONS\nTITLE=2\nEND IONS\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			log.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// Scan a directory tree, and print the format and checksum of each file
+func ExampleScanner() {
+	dir := exampleDir()
+	defer os.RemoveAll(dir)
+
+	s, err := msinfo.NewScanner(msinfo.ScannerConfig{Method: fcompare.CmpFull})
+	if err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		if err := s.Add(dir, msinfo.PriorityBackground); err != nil {
+			log.Println(err)
+		}
+		s.Close()
+	}()
+	var lines []string
+	for rec := range s.Results() {
+		if rec.Err != nil {
+			log.Println(rec.Path, rec.Err)
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s %s %.16s", filepath.Base(rec.Path), rec.File.Properties[msinfo.PropFormat], rec.File.FullChecksum))
+	}
+	// The workers finish files in any order
+	sort.Strings(lines)
+	for _, l := range lines {
+		fmt.Println(l)
+	}
+	fmt.Println(s.Stats().Done, "files")
+	// Output:
+	// a.mgf mgf 9dafc0bc37ffaa43
+	// b.fasta fasta 308d3ae54a805154
+	// c.mgf mgf 18e473fd5cffb950
+	// 3 files
+}
+
+// A file added with a higher priority is scanned before the files that were queued earlier
+func ExampleScanner_Add() {
+	dir := exampleDir()
+	defer os.RemoveAll(dir)
+
+	s, err := msinfo.NewScanner(msinfo.ScannerConfig{Workers: 1})
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Queue the background files while paused, so that none is started yet
+	s.Pause()
+	for _, name := range []string{"a.mgf", "b.fasta"} {
+		if err := s.Add(filepath.Join(dir, name), msinfo.PriorityBackground); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := s.Add(filepath.Join(dir, "c.mgf"), msinfo.PriorityInteractive); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(s.Stats().Queued, "queued")
+	s.Resume()
+	s.Close()
+	for rec := range s.Results() {
+		fmt.Println(filepath.Base(rec.Path), rec.Priority == msinfo.PriorityInteractive)
+	}
+	// Output:
+	// 3 queued
+	// c.mgf true
+	// a.mgf false
+	// b.fasta false
+}
@@ -0,0 +1,288 @@
+package msinfo
+
+// format.go - Detect the format of an MS data file
+// The format is sniffed from the start of the file: the root element of XML
+// formats, the first lines of text formats, and the signature of binary formats.
+// Only if that doesn't tell the format, the extension is used.
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/524D/msfile/fcompare"
+)
+
+// Formats returned by DetectFormat
+const (
+	FormatMzML      = "mzML"
+	FormatImzML     = "imzML"
+	FormatMzXML     = "mzXML"
+	FormatMzIdentML = "mzIdentML"
+	FormatPepXML    = "pepXML"
+	FormatProtXML   = "protXML"
+	FormatMGF       = "mgf"
+	FormatFASTA     = "fasta"
+	FormatMS1       = "ms1"
+	FormatMS2       = "ms2"
+	FormatThermoRaw = "thermo-raw"
+	FormatSciexWiff = "sciex-wiff"
+	FormatGzip      = "gzip"
+	FormatZstd      = "zstd"
+	FormatUnknown   = "unknown"
+)
+
+// FormatSampleSize is the number of bytes that DetectFormat reads from the start of a file
+const FormatSampleSize = 4096
+
+// Root elements of XML formats
+var xmlRootFormats = map[string]string{
+	"mzML":                   FormatMzML,
+	"indexedmzML":            FormatMzML,
+	"mzXML":                  FormatMzXML,
+	"MzIdentML":              FormatMzIdentML,
+	"msms_pipeline_analysis": FormatPepXML,
+	"protein_summary":        FormatProtXML,
+}
+
+// Formats by extension, for files whose content doesn't tell the format.
+// Longer extensions must come before shorter ones that they end with.
+// .raw is not included, because Thermo, Waters and others all use it.
+var extensionFormats = []struct {
+	ext    string
+	format string
+}{
+	{".mzml", FormatMzML},
+	{".imzml", FormatImzML},
+	{".mzxml", FormatMzXML},
+	{".mzid", FormatMzIdentML},
+	{".pep.xml", FormatPepXML},
+	{".pepxml", FormatPepXML},
+	{".prot.xml", FormatProtXML},
+	{".protxml", FormatProtXML},
+	{".mgf", FormatMGF},
+	{".fasta", FormatFASTA},
+	{".fa", FormatFASTA},
+	{".ms1", FormatMS1},
+	{".ms2", FormatMS2},
+	{".wiff", FormatSciexWiff},
+	{".gz", FormatGzip},
+	{".zst", FormatZstd},
+}
+
+// Extensions of compressed files, which are removed from the name for the format of the content
+var compressedExtensions = map[string]string{
+	FormatGzip: ".gz",
+	FormatZstd: ".zst",
+}
+
+// Signature of Thermo .raw files: 01 A1, followed by "Finnigan" in UTF-16LE
+var thermoRawMagic = []byte{0x01, 0xa1}
+var thermoRawSignature = []byte("F\x00i\x00n\x00n\x00i\x00g\x00a\x00n\x00")
+
+// DetectFormat returns the format of a file, e.g. "mzML", "mgf" or "thermo-raw",
+// or "unknown". Only the first FormatSampleSize bytes are read.
+func DetectFormat(path string) (string, error) {
+	return DetectFormatCtx(context.Background(), path)
+}
+
+// DetectFormatCtx is DetectFormat, which reports what it reads to the FileObserver of ctx
+func DetectFormatCtx(ctx context.Context, path string) (string, error) {
+	f, err := fcompare.OpenObserved(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	head := make([]byte, FormatSampleSize)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	return SniffFormat(head[:n], path), nil
+}
+
+// DetectContentFormatCtx returns the format of the decompressed content of a gzip- or
+// zstd-compressed file, from the first FormatSampleSize bytes of the content, or from
+// the name without the compression extension. For other files, it is DetectFormatCtx.
+func DetectContentFormatCtx(ctx context.Context, path string) (string, error) {
+	r, err := openDecompressed(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	head := make([]byte, FormatSampleSize)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	return SniffFormat(head[:n], ContentName(path)), nil
+}
+
+// DecompressedChecksumCtx returns the checksum of the decompressed content of a gzip-
+// or zstd-compressed file, or of the file itself otherwise, so a compressed file has
+// the same checksum as its original. The content is limited like all decompression,
+// see DecompressionBudget.
+func DecompressedChecksumCtx(ctx context.Context, path string, algo fcompare.HashAlgo) (string, error) {
+	r, err := openDecompressed(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	return fcompare.ChecksumReader(r, algo)
+}
+
+// ContentName returns the name of the decompressed content of a compressed file,
+// i.e. without .gz or .zst, or name itself for other files
+func ContentName(name string) string {
+	ext := compressedExtensions[FormatFromName(name)]
+	if ext == "" {
+		return name
+	}
+	return name[:len(name)-len(ext)]
+}
+
+// SniffFormat returns the format of a file from the start of its content,
+// or from its name if the content is ambiguous
+func SniffFormat(head []byte, name string) string {
+	if len(head) == 0 {
+		return FormatUnknown
+	}
+	format := sniffContent(head)
+	byExt := FormatFromName(name)
+	switch {
+	case format == FormatMzML && byExt == FormatImzML:
+		// imzML files are mzML files with extra metadata, and the binary data in an .ibd file
+		return FormatImzML
+	case format == FormatMS2 && byExt == FormatMS1:
+		// MS1 and MS2 files have the same header lines
+		return FormatMS1
+	case format != "":
+		return format
+	case byExt != "":
+		return byExt
+	}
+	return FormatUnknown
+}
+
+// FormatFromName returns the format that belongs with the extension of name, or "".
+// For a compressed file, the name without .gz gives the format of the content.
+func FormatFromName(name string) string {
+	lower := strings.ToLower(filepath.Base(name))
+	for _, e := range extensionFormats {
+		if strings.HasSuffix(lower, e.ext) {
+			return e.format
+		}
+	}
+	return ""
+}
+
+// sniffContent returns the format that the content starts with, "" if the content
+// could be of any format, or FormatUnknown for XML of another format
+func sniffContent(head []byte) string {
+	if bytes.HasPrefix(head, thermoRawMagic) && bytes.Contains(head[:min(len(head), 64)], thermoRawSignature) {
+		return FormatThermoRaw
+	}
+	if bytes.HasPrefix(head, []byte{0x1f, 0x8b}) {
+		return FormatGzip
+	}
+	if IsZstd(head) {
+		return FormatZstd
+	}
+	text := narrowUTF16(head)
+	text = bytes.TrimPrefix(text, []byte{0xef, 0xbb, 0xbf})
+	if root := xmlRoot(text); root != "" {
+		if format, ok := xmlRootFormats[root]; ok {
+			return format
+		}
+		return FormatUnknown
+	}
+	return sniffTextLines(text)
+}
+
+// narrowUTF16 converts the start of a UTF-16 file with mostly ASCII text to 8 bits,
+// by dropping the high bytes. Other content is returned as is.
+func narrowUTF16(head []byte) []byte {
+	start := 0 // the position of the first low byte
+	switch {
+	case bytes.HasPrefix(head, []byte{0xff, 0xfe}):
+		head = head[2:]
+	case bytes.HasPrefix(head, []byte{0xfe, 0xff}):
+		head, start = head[2:], 1
+	case len(head) >= 2 && head[0] == '<' && head[1] == 0:
+	case len(head) >= 2 && head[0] == 0 && head[1] == '<':
+		start = 1
+	default:
+		return head
+	}
+	text := make([]byte, 0, len(head)/2)
+	for i := start; i < len(head); i += 2 {
+		text = append(text, head[i])
+	}
+	return text
+}
+
+// xmlRoot returns the name of the root element of an XML document, without
+// namespace prefix, or "" if the content is not XML or the root is not in head
+func xmlRoot(head []byte) string {
+	s := bytes.TrimLeft(head, " \t\r\n")
+	if !bytes.HasPrefix(s, []byte("<")) {
+		return ""
+	}
+	for {
+		i := bytes.IndexByte(s, '<')
+		if i < 0 || i+1 >= len(s) {
+			return ""
+		}
+		s = s[i+1:]
+		// Skip the XML declaration, processing instructions, comments and the DOCTYPE
+		switch {
+		case bytes.HasPrefix(s, []byte("!--")):
+			end := bytes.Index(s, []byte("-->"))
+			if end < 0 {
+				return ""
+			}
+			s = s[end+3:]
+			continue
+		case s[0] == '?' || s[0] == '!':
+			continue
+		}
+		end := bytes.IndexAny(s, " \t\r\n/>")
+		if end < 0 {
+			return ""
+		}
+		name := string(s[:end])
+		if colon := strings.LastIndexByte(name, ':'); colon >= 0 {
+			name = name[colon+1:]
+		}
+		return name
+	}
+}
+
+// sniffTextLines returns the format of a text format from its first lines, or ""
+func sniffTextLines(head []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(head))
+	first := true
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		switch {
+		case line == "BEGIN IONS":
+			return FormatMGF
+		case first && strings.HasPrefix(line, ">"):
+			return FormatFASTA
+		case first && strings.HasPrefix(line, "H\t"):
+			return FormatMS2
+		case strings.HasPrefix(line, "#") || strings.Contains(line, "="):
+			// MGF files can start with comments and global parameters
+		default:
+			return ""
+		}
+		first = false
+	}
+	return ""
+}
@@ -0,0 +1,233 @@
+package msinfo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testMzMLHeader = `<?xml version="1.0" encoding="utf-8"?>
+<mzML xmlns="http://psi.hupo.org/ms/mzml" version="1.1.0">
+  <referenceableParamGroupList count="1">
+    <referenceableParamGroup id="CommonInstrumentParams">
+      <cvParam cvRef="MS" accession="MS:1001742" name="LTQ Orbitrap Velos" value=""/>
+      <cvParam cvRef="MS" accession="MS:1000529" name="instrument serial number" value="SN1"/>
+    </referenceableParamGroup>
+  </referenceableParamGroupList>
+  <softwareList count="1">
+    <software id="pwiz" version="3.0.1">
+      <cvParam cvRef="MS" accession="MS:1000615" name="ProteoWizard software" value=""/>
+    </software>
+  </softwareList>
+  <instrumentConfigurationList count="1">
+    <instrumentConfiguration id="IC1">
+      <referenceableParamGroupRef ref="CommonInstrumentParams"/>
+    </instrumentConfiguration>
+  </instrumentConfigurationList>
+  <run id="run1" startTimeStamp="2020-01-02T03:04:05Z">
+`
+
+// testMzML returns an mzML file with spectra of the given MS levels. If indexed,
+// it is an indexed mzML file with the offsets of the spectra.
+func testMzML(indexed bool, levels ...int) []byte {
+	var b bytes.Buffer
+	if indexed {
+		b.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n" + `<indexedmzML xmlns="http://psi.hupo.org/ms/mzml">` + "\n")
+	}
+	b.WriteString(strings.TrimPrefix(testMzMLHeader, `<?xml version="1.0" encoding="utf-8"?>`+"\n"))
+	fmt.Fprintf(&b, "    <spectrumList count=\"%d\">\n", len(levels))
+	var offsets []int
+	for i, level := range levels {
+		offsets = append(offsets, b.Len())
+		fmt.Fprintf(&b, "<spectrum index=\"%d\" id=\"scan=%d\">\n", i, i+1)
+		fmt.Fprintf(&b, "  <cvParam cvRef=\"MS\" accession=\"MS:1000511\" name=\"ms level\" value=\"%d\"/>\n", level)
+		b.WriteString("  <binaryDataArrayList count=\"0\"/>\n</spectrum>\n")
+	}
+	b.WriteString("    </spectrumList>\n  </run>\n</mzML>\n")
+	if indexed {
+		indexOffset := b.Len()
+		b.WriteString("<indexList count=\"1\">\n  <index name=\"spectrum\">\n")
+		for i, off := range offsets {
+			fmt.Fprintf(&b, "    <offset idRef=\"scan=%d\">%d</offset>\n", i+1, off)
+		}
+		b.WriteString("  </index>\n</indexList>\n")
+		fmt.Fprintf(&b, "<indexListOffset>%d</indexListOffset>\n</indexedmzML>\n", indexOffset)
+	}
+	return b.Bytes()
+}
+
+const testMzXML = `<?xml version="1.0"?>
+<mzXML><msRun scanCount="2">
+<scan num="1" msLevel="1"><peaks/></scan>
+<scan num="2" msLevel="2"><peaks/></scan>
+</msRun></mzXML>
+`
+
+const testMGF = "# comment\nCHARGE=2+\nBEGIN IONS\nTITLE=1\n100 1\nEND IONS\nBEGIN IONS\nEND IONS\n"
+
+func gzipData(data []byte) []byte {
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	zw.Write(data)
+	zw.Close()
+	return b.Bytes()
+}
+
+func writeTestFile(t testing.TB, dir, name string, data []byte) string {
+	t.Helper()
+	fn := filepath.Join(dir, name)
+	if err := os.WriteFile(fn, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fn
+}
+
+// Seeds of the fuzz targets for content: one valid file of each format
+func addContentSeeds(f *testing.F) {
+	f.Add(testMzML(false, 1, 2))
+	f.Add(testMzML(true, 1, 2, 2))
+	f.Add([]byte(testMzXML))
+	f.Add([]byte(testMGF))
+	f.Add(gzipData(testMzML(false, 1)))
+	f.Add(zstdData(f, "metadata", testMzML(false, 1)))
+	f.Add(append(append([]byte{0x01, 0xa1}, thermoRawSignature...), make([]byte, 32)...))
+	f.Add([]byte{0xff, 0xfe, '<', 0, 'm', 0, 'z', 0, 'M', 0, 'L', 0, '>', 0})
+	f.Add([]byte(">sp|P1|X\nMKV\n"))
+	f.Add([]byte("H\tCreationDate\tx\nS\t1\t1\t100\n"))
+}
+
+func FuzzSniffFormat(f *testing.F) {
+	addContentSeeds(f)
+	f.Fuzz(func(t *testing.T, head []byte) {
+		if format := SniffFormat(head, "x.mzML"); format == "" {
+			t.Error("SniffFormat returned an empty format")
+		}
+	})
+}
+
+func FuzzParseMzMLHeader(f *testing.F) {
+	addContentSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ParseMzMLHeader(bytes.NewReader(data))
+	})
+}
+
+// CountScans with each format, from a file so that the index of indexed mzML
+// and decompression are fuzzed as well
+func FuzzCountScans(f *testing.F) {
+	addContentSeeds(f)
+	dir := f.TempDir()
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fn := writeTestFile(t, dir, "f", data)
+		for _, format := range []string{FormatMzML, FormatMzXML, FormatMGF} {
+			c, err := CountScans(fn, format)
+			if err == nil && c.Spectra < 0 {
+				t.Errorf("%s: %d spectra", format, c.Spectra)
+			}
+		}
+	})
+}
+
+func TestParseMzMLHeader(t *testing.T) {
+	h, err := ParseMzMLHeader(bytes.NewReader(testMzML(false, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := MzMLHeader{
+		InstrumentModels: []string{"LTQ Orbitrap Velos"},
+		Software:         []string{"ProteoWizard software 3.0.1"},
+		RunID:            "run1",
+		RunStartTime:     "2020-01-02T03:04:05Z",
+	}
+	if fmt.Sprint(h) != fmt.Sprint(want) {
+		t.Errorf("ParseMzMLHeader = %+v, want %+v", h, want)
+	}
+}
+
+func TestCountScansIndexed(t *testing.T) {
+	dir := t.TempDir()
+	data := testMzML(true, 1, 2, 2)
+	fn := writeTestFile(t, dir, "a.mzML", data)
+	c, err := CountScans(fn, FormatMzML)
+	if err != nil || !c.FromIndex || c.Spectra != 3 || c.ByLevel[1] != 1 || c.ByLevel[2] != 2 {
+		t.Errorf("CountScans = %+v, %v, want 3 spectra from the index", c, err)
+	}
+	// An index that points at the same spectrum over and over isn't used
+	first := bytes.Index(data, []byte("<spectrum "))
+	second := bytes.Index(data[first+1:], []byte("<spectrum ")) + first + 1
+	bad := bytes.Replace(data, []byte(fmt.Sprintf(">%d</offset>", second)), []byte(fmt.Sprintf(">%d</offset>", first)), 1)
+	fn = writeTestFile(t, dir, "b.mzML", bad)
+	c, err = CountScans(fn, FormatMzML)
+	if err != nil || c.FromIndex || c.Spectra != 3 {
+		t.Errorf("CountScans with a repeating index = %+v, %v, want 3 spectra without the index", c, err)
+	}
+}
+
+func TestCountScansFormats(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		format string
+		data   []byte
+		want   int
+	}{
+		{FormatMzML, gzipData(testMzML(true, 1, 2)), 2},
+		{FormatMzXML, []byte(testMzXML), 2},
+		{FormatMGF, []byte(testMGF), 2},
+	} {
+		fn := writeTestFile(t, dir, tc.format, tc.data)
+		c, err := CountScans(fn, tc.format)
+		if err != nil || c.Spectra != tc.want {
+			t.Errorf("%s: CountScans = %+v, %v, want %d spectra", tc.format, c, err, tc.want)
+		}
+	}
+}
+
+func TestXMLDepthLimit(t *testing.T) {
+	deep := strings.Repeat("<a>", maxXMLDepth+1)
+	if _, err := ParseMzMLHeader(strings.NewReader("<mzML>" + deep)); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("ParseMzMLHeader: %v, want ErrBudgetExceeded", err)
+	}
+	if _, err := countMzML(strings.NewReader(deep)); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("countMzML: %v, want ErrBudgetExceeded", err)
+	}
+	if _, err := countMzXML(strings.NewReader(deep)); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("countMzXML: %v, want ErrBudgetExceeded", err)
+	}
+}
+
+func TestMzMLHeaderSizeLimit(t *testing.T) {
+	r := io.MultiReader(strings.NewReader("<mzML>"), &endlessComments{})
+	if _, err := ParseMzMLHeader(r); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("ParseMzMLHeader of an endless header: %v, want ErrBudgetExceeded", err)
+	}
+}
+
+// endlessComments is an endless stream of small XML comments
+type endlessComments struct{ n int }
+
+func (c *endlessComments) Read(p []byte) (int, error) {
+	const comment = "<!---->"
+	for i := range p {
+		p[i] = comment[c.n%len(comment)]
+		c.n++
+	}
+	return len(p), nil
+}
+
+func TestBudgetReader(t *testing.T) {
+	// Data of exactly the budget is read to the end
+	b := &budgetReader{r: strings.NewReader("abcd"), left: 4, what: "test"}
+	if data, err := io.ReadAll(b); err != nil || string(data) != "abcd" {
+		t.Errorf("ReadAll = %q, %v, want abcd", data, err)
+	}
+	b = &budgetReader{r: strings.NewReader("abcde"), left: 4, what: "test"}
+	if data, err := io.ReadAll(b); !errors.Is(err, ErrBudgetExceeded) || string(data) != "abcd" {
+		t.Errorf("ReadAll = %q, %v, want abcd and ErrBudgetExceeded", data, err)
+	}
+}
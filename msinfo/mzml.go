@@ -0,0 +1,233 @@
+package msinfo
+
+// mzml.go - Read the acquisition metadata from the start of an mzML file
+// The instrument, software and run are described before the spectra, so the
+// file is only parsed up to the start of the spectrum list. Parsing is streamed,
+// so multi-GB files are never loaded into memory.
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/524D/msfile/fcompare"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Accession of the instrument serial number, which is a cvParam of the
+// instrument configuration like the instrument model
+const accessionSerialNumber = "MS:1000529"
+
+// MzMLHeader is the metadata from the start of an mzML file
+type MzMLHeader struct {
+	InstrumentModels []string // names of the instrument models, without duplicates
+	Software         []string // name and version of each software
+	RunID            string
+	RunStartTime     string // as in the file, normally in RFC 3339 format
+}
+
+type mzmlParam struct {
+	accession string
+	name      string
+	value     string
+}
+
+// ReadMzMLHeader reads the metadata from an mzML file, or from a gzip- or zstd-compressed mzML file
+func ReadMzMLHeader(path string) (MzMLHeader, error) {
+	return ReadMzMLHeaderCtx(context.Background(), path)
+}
+
+// ReadMzMLHeaderCtx is ReadMzMLHeader, which reports what it reads to the FileObserver of ctx
+func ReadMzMLHeaderCtx(ctx context.Context, path string) (MzMLHeader, error) {
+	r, err := openDecompressed(ctx, path)
+	if err != nil {
+		return MzMLHeader{}, err
+	}
+	defer r.Close()
+	return ParseMzMLHeader(r)
+}
+
+// openDecompressed opens a file, and decompresses it if it is gzip- or zstd-compressed
+func openDecompressed(ctx context.Context, path string) (io.ReadCloser, error) {
+	f, err := fcompare.OpenObserved(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(f)
+	magic, _ := br.Peek(4)
+	var zr io.Reader
+	closeZr := func() {}
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		zr, err = gzip.NewReader(br)
+	case IsZstd(magic):
+		var d *zstd.Decoder
+		d, err = NewZstdReader(br)
+		if err == nil {
+			zr, closeZr = d, d.Close
+		}
+	default:
+		return &decompressed{br, f, closeZr}, nil
+	}
+	var fi os.FileInfo
+	if err == nil {
+		fi, err = f.Stat()
+	}
+	if err != nil {
+		closeZr()
+		f.Close()
+		return nil, err
+	}
+	return &decompressed{LimitDecompressed(zr, fi.Size()), f, closeZr}, nil
+}
+
+// xmlDecoder is an xml.Decoder that fails on tokens larger than maxXMLTokenSize,
+// instead of buffering them whatever their size
+type xmlDecoder struct {
+	*xml.Decoder
+	token *budgetReader // the budget of the current token
+}
+
+// newXMLDecoder returns a decoder that reads any declared encoding as UTF-8.
+// The markup and metadata of MS formats is ASCII in all encodings that they
+// declare in practice.
+func newXMLDecoder(r io.Reader) *xmlDecoder {
+	token := &budgetReader{r: r, what: fmt.Sprintf("XML token larger than %d MB", maxXMLTokenSize>>20)}
+	d := xml.NewDecoder(token)
+	d.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	return &xmlDecoder{d, token}
+}
+
+// Token is xml.Decoder.Token, with the token size limit
+func (d *xmlDecoder) Token() (xml.Token, error) {
+	d.token.left = maxXMLTokenSize
+	return d.Decoder.Token()
+}
+
+// RawToken is xml.Decoder.RawToken, with the token size limit
+func (d *xmlDecoder) RawToken() (xml.Token, error) {
+	d.token.left = maxXMLTokenSize
+	return d.Decoder.RawToken()
+}
+
+type decompressed struct {
+	io.Reader
+	f       *fcompare.ObservedFile
+	closeZr func() // frees the zstd decoder
+}
+
+func (d *decompressed) Close() error {
+	d.closeZr()
+	return d.f.Close()
+}
+
+// ParseMzMLHeader reads the metadata from mzML, indexed or not, up to the start of
+// the spectrum or chromatogram list. At most 64 MB of header is read.
+// The instrument model is the first cvParam of an instrumentConfiguration or of a
+// referenceableParamGroup that it refers to, without a value, and which is not the serial
+// number. That is how all common converters write it, but it is not checked against the
+// PSI-MS ontology.
+func ParseMzMLHeader(r io.Reader) (MzMLHeader, error) {
+	var h MzMLHeader
+	d := newXMLDecoder(&budgetReader{r: r, left: maxMzMLHeaderSize,
+		what: fmt.Sprintf("mzML header larger than %d MB", maxMzMLHeaderSize>>20)})
+	var path []string                      // local names of the open elements
+	groups := make(map[string][]mzmlParam) // referenceableParamGroups by id
+	var groupID string
+	var config []mzmlParam // cvParams of the current instrumentConfiguration
+	var software, softwareVersion, softwareName string
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return h, nil
+		}
+		if err != nil {
+			return h, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			parent := ""
+			if len(path) > 0 {
+				parent = path[len(path)-1]
+			}
+			if len(path) == maxXMLDepth {
+				return h, errXMLTooDeep
+			}
+			path = append(path, t.Name.Local)
+			switch t.Name.Local {
+			case "spectrumList", "chromatogramList":
+				return h, nil
+			case "referenceableParamGroup":
+				groupID = attr(t, "id")
+			case "instrumentConfiguration":
+				config = nil
+			case "referenceableParamGroupRef":
+				if parent == "instrumentConfiguration" {
+					config = append(config, groups[attr(t, "ref")]...)
+				}
+			case "software":
+				software, softwareVersion, softwareName = attr(t, "id"), attr(t, "version"), ""
+			case "run":
+				h.RunID = attr(t, "id")
+				h.RunStartTime = attr(t, "startTimeStamp")
+			case "cvParam":
+				p := mzmlParam{attr(t, "accession"), attr(t, "name"), attr(t, "value")}
+				switch parent {
+				case "referenceableParamGroup":
+					groups[groupID] = append(groups[groupID], p)
+				case "instrumentConfiguration":
+					config = append(config, p)
+				case "software":
+					if softwareName == "" {
+						softwareName = p.name
+					}
+				}
+			}
+		case xml.EndElement:
+			if len(path) > 0 {
+				path = path[:len(path)-1]
+			}
+			switch t.Name.Local {
+			case "instrumentConfiguration":
+				if model := instrumentModel(config); model != "" && !slices.Contains(h.InstrumentModels, model) {
+					h.InstrumentModels = append(h.InstrumentModels, model)
+				}
+			case "software":
+				name := softwareName
+				if name == "" {
+					name = software
+				}
+				h.Software = append(h.Software, strings.TrimSpace(name+" "+softwareVersion))
+			case "run", "mzML":
+				return h, nil
+			}
+		}
+	}
+}
+
+func instrumentModel(params []mzmlParam) string {
+	for _, p := range params {
+		if p.value == "" && p.accession != accessionSerialNumber {
+			return p.name
+		}
+	}
+	return ""
+}
+
+func attr(e xml.StartElement, name string) string {
+	for _, a := range e.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,152 @@
+package msinfo
+
+// properties.go - Registry of the keys of FileInfo.Properties
+// All code that sets a property must use one of the keys below, so that the same
+// information always has the same key, whichever format it comes from. Keys are
+// snake_case, like the other fields of the JSON output.
+// Vendor-specific keys that don't fit a canonical key are registered with
+// RegisterProperty, and must be prefixed with the format name and a dot
+// (e.g. "thermo.method_name"), so they can't collide.
+//
+// The descriptions are only in the registry. PROPERTIES.md is generated from it;
+// run go generate after changing it.
+
+//go:generate sh -c "cd .. && go run . -list-properties > PROPERTIES.md"
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Canonical property keys, described in the registry below
+const (
+	PropGzipCRCValid             = "gzip_crc_valid"
+	PropGzipError                = "gzip_error"
+	PropGzipErrorOffset          = "gzip_error_offset"
+	PropImmutable                = "immutable"
+	PropAppendOnly               = "append_only"
+	PropSplitParts               = "split_parts"
+	PropReassembledChecksumValid = "reassembled_checksum_valid"
+	PropSplitError               = "split_error"
+	PropNameChecksum             = "name_checksum"
+	PropNameChecksumAlgorithm    = "name_checksum_algorithm"
+	PropRegistryMatch            = "registry_match"
+	PropRegistryInfo             = "registry_info"
+	PropPartialChecksumHead      = "partial_checksum_head"
+	PropPartialChecksumMiddle    = "partial_checksum_middle"
+	PropPartialChecksumTail      = "partial_checksum_tail"
+	PropFormat                   = "format"
+	PropInstrumentModel          = "instrument_model"
+	PropSoftware                 = "software"
+	PropRunStartTime             = "run_start_time"
+	PropSpectrumCount            = "spectrum_count"
+	PropMS1Count                 = "ms1_count"
+	PropMS2Count                 = "ms2_count"
+	PropEncoding                 = "encoding"
+	PropContentFormat            = "content_format"
+	PropZstdChecksumValid        = "zstd_checksum_valid"
+	PropZstdError                = "zstd_error"
+	PropZstdMetadata             = "zstd_metadata"
+	PropDecompressedChecksum     = "decompressed_checksum"
+)
+
+// FormatAny marks a property that can be populated for files of any format
+const FormatAny = "any"
+
+// Property is an entry of the registry of property keys
+type Property struct {
+	Key         string
+	Formats     []string // formats from which the property can be populated, or FormatAny
+	Description string
+}
+
+// ErrInvalidPropertyKey is returned by RegisterProperty for a key without the
+// prefix of its format, or a key that is already registered
+var ErrInvalidPropertyKey = errors.New("invalid property key")
+
+var mzMLFormats = []string{FormatMzML, FormatImzML}
+
+var (
+	propertiesMu sync.Mutex
+	// The canonical keys, followed by the vendor keys in the order in which they were registered
+	properties = []Property{
+		{PropGzipCRCValid, []string{FormatGzip}, `Whether the CRC32 and size of all gzip members are correct ("true" or "false")`},
+		{PropGzipError, []string{FormatGzip}, "Error message when a gzip stream is corrupt"},
+		{PropGzipErrorOffset, []string{FormatGzip}, "Offset in the compressed file where decompression of a corrupt gzip stream failed"},
+		{PropImmutable, []string{FormatAny}, `Whether the file is immutable (chattr +i on Linux, ReadOnly attribute on Windows): "true", "false" or "unknown"`},
+		{PropAppendOnly, []string{FormatAny}, `Whether the file is append-only (chattr +a, Linux only): "true" or "false"`},
+		{PropSplitParts, []string{"split"}, "Number of parts of a file split into numbered parts (.partNNN or .NNN)"},
+		{PropReassembledChecksumValid, []string{"split"}, `Whether the checksum of the joined parts matches the .sha256 sidecar ("true" or "false")`},
+		{PropSplitError, []string{"split"}, "Missing parts, inconsistent part sizes, or checksum mismatch of a split file"},
+		{PropNameChecksum, []string{FormatAny}, `Result of -checksum-from-name: "match", "mismatch", or "no-pattern" if the name contains no digest`},
+		{PropNameChecksumAlgorithm, []string{FormatAny}, `Hash algorithm of the digest in the file name: "md5", "sha1" or "sha256"`},
+		{PropRegistryMatch, []string{FormatAny}, `Whether -registry holds content with the same full checksum: "known", "unknown" or "lookup-unavailable"`},
+		{PropRegistryInfo, []string{FormatAny}, "Location or other metadata returned by -registry for known content, or why the lookup failed"},
+		{PropPartialChecksumHead, []string{FormatAny}, "With -compare and the partial method, the checksum of the first chunk of files larger than -full-threshold"},
+		{PropPartialChecksumMiddle, []string{FormatAny}, "With -compare and the partial method, the checksum of the middle chunk of files larger than -full-threshold"},
+		{PropPartialChecksumTail, []string{FormatAny}, "With -compare and the partial method, the checksum of the last chunk of files larger than -full-threshold (e.g. an mzML index)"},
+		{PropFormat, []string{FormatAny}, `Format detected from the start of the file, or from the extension if the content is ambiguous: "mzML", "imzML", "mzXML", "mzIdentML", "pepXML", "protXML", "mgf", "fasta", "ms1", "ms2", "thermo-raw", "sciex-wiff", "gzip", "zstd" or "unknown"`},
+		{PropInstrumentModel, mzMLFormats, "Names of the instrument models of the instrument configurations, separated by a comma"},
+		{PropSoftware, mzMLFormats, `Name and version of the software that acquired and processed the data, separated by a comma, e.g. "Xcalibur 2.1, pwiz 3.0.1"`},
+		{PropRunStartTime, mzMLFormats, "Start time of the run (startTimeStamp), as in the file"},
+		{PropSpectrumCount, []string{FormatMzML, FormatImzML, FormatMzXML, FormatMGF}, "With -scan-count, the number of spectra (BEGIN IONS blocks in MGF)"},
+		{PropMS1Count, []string{FormatMzML, FormatImzML, FormatMzXML}, "With -scan-count, the number of spectra with MS level 1"},
+		{PropMS2Count, []string{FormatMzML, FormatImzML, FormatMzXML}, "With -scan-count, the number of spectra with MS level 2"},
+		{PropEncoding, []string{FormatMGF, FormatFASTA, FormatMzML, FormatMzXML, FormatImzML, FormatMzIdentML, FormatMS1, FormatMS2, FormatPepXML, FormatProtXML}, `Character encoding: "ascii", "utf-8", "utf-8-bom", "utf-16le-bom", "utf-16be-bom", "utf-32le-bom", "utf-32be-bom", "utf-16le", "utf-16be" or "unknown-8bit"`},
+		{PropContentFormat, []string{FormatGzip, FormatZstd}, "Format of the decompressed content, detected like format from its start, or from the name without .gz or .zst"},
+		{PropZstdChecksumValid, []string{FormatZstd}, `Whether all zstd frames decompress, and their content checksums (if present) are correct ("true" or "false")`},
+		{PropZstdError, []string{FormatZstd}, "Error message when a zstd stream is corrupt"},
+		{PropZstdMetadata, []string{FormatZstd}, "Content of the skippable frames at the start of a zstd file: as text if it is UTF-8, otherwise in hex (at most 4 KB)"},
+		{PropDecompressedChecksum, []string{FormatAny}, "With -decompressed-checksum, the checksum of the decompressed content of a gzip or zstd file, or of the file itself otherwise, so a compressed file has the same one as its original"},
+	}
+)
+
+// Properties returns the registry of property keys: the canonical keys, followed
+// by the vendor keys in the order in which they were registered
+func Properties() []Property {
+	propertiesMu.Lock()
+	defer propertiesMu.Unlock()
+	return slices.Clone(properties)
+}
+
+// LookupProperty returns the registry entry of a key
+func LookupProperty(key string) (Property, bool) {
+	propertiesMu.Lock()
+	defer propertiesMu.Unlock()
+	i := slices.IndexFunc(properties, func(p Property) bool { return p.Key == key })
+	if i < 0 {
+		return Property{}, false
+	}
+	return properties[i], true
+}
+
+// RegisterProperty adds a vendor-specific key to the registry. The key must be
+// the name of one of the formats of p, a dot, and a name, e.g. "thermo.method_name"
+// for a property of the format "thermo".
+func RegisterProperty(p Property) error {
+	format, name, ok := strings.Cut(p.Key, ".")
+	if !ok || name == "" || format == FormatAny || !slices.Contains(p.Formats, format) {
+		return fmt.Errorf("%w %q: must be the name of one of its formats %q, a dot, and a name", ErrInvalidPropertyKey, p.Key, p.Formats)
+	}
+	propertiesMu.Lock()
+	defer propertiesMu.Unlock()
+	if slices.ContainsFunc(properties, func(q Property) bool { return q.Key == p.Key }) {
+		return fmt.Errorf("%w %q: already registered", ErrInvalidPropertyKey, p.Key)
+	}
+	p.Formats = slices.Clone(p.Formats)
+	properties = append(properties, p)
+	return nil
+}
+
+// WriteProperties writes the registry as a markdown table, as in PROPERTIES.md
+func WriteProperties(w io.Writer) {
+	fmt.Fprintln(w, "| Property | Formats | Description |")
+	fmt.Fprintln(w, "|----------|---------|-------------|")
+	for _, p := range Properties() {
+		fmt.Fprintf(w, "| %s | %s | %s |\n", p.Key, strings.Join(p.Formats, ", "), p.Description)
+	}
+}
@@ -0,0 +1,73 @@
+package msinfo
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"regexp"
+	"testing"
+)
+
+var snakeCase = regexp.MustCompile(`^[a-z][a-z0-9]*(_[a-z0-9]+)*$`)
+
+func TestCanonicalProperties(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, p := range Properties() {
+		if !snakeCase.MatchString(p.Key) {
+			t.Errorf("key %q is not snake_case", p.Key)
+		}
+		if seen[p.Key] {
+			t.Errorf("key %q is registered twice", p.Key)
+		}
+		seen[p.Key] = true
+		if p.Description == "" || len(p.Formats) == 0 {
+			t.Errorf("key %q has no description or formats", p.Key)
+		}
+	}
+	if p, ok := LookupProperty(PropMS1Count); !ok || p.Key != "ms1_count" {
+		t.Errorf("LookupProperty(PropMS1Count) = %+v, %v", p, ok)
+	}
+}
+
+// PROPERTIES.md must be regenerated with go generate when the registry changes
+func TestPropertiesMarkdown(t *testing.T) {
+	want, err := os.ReadFile("../PROPERTIES.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got bytes.Buffer
+	WriteProperties(&got)
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Errorf("PROPERTIES.md is out of date, run go generate ./msinfo")
+	}
+}
+
+func TestRegisterProperty(t *testing.T) {
+	saved := Properties()
+	t.Cleanup(func() { properties = saved })
+
+	tests := []struct {
+		p  Property
+		ok bool
+	}{
+		{Property{"thermo.method_name", []string{"thermo"}, "Name of the instrument method"}, true},
+		{Property{"thermo.method_name", []string{"thermo"}, "Registered twice"}, false},
+		{Property{"method_name", []string{"thermo"}, "Without prefix"}, false},
+		{Property{"bruker.method_name", []string{"thermo"}, "Prefix of another format"}, false},
+		{Property{"any.method_name", []string{FormatAny}, "Prefix of all formats"}, false},
+		{Property{"thermo.", []string{"thermo"}, "Without name"}, false},
+		{Property{PropFormat, []string{FormatAny}, "A canonical key"}, false},
+	}
+	for _, tt := range tests {
+		err := RegisterProperty(tt.p)
+		if (err == nil) != tt.ok || (err != nil && !errors.Is(err, ErrInvalidPropertyKey)) {
+			t.Errorf("RegisterProperty(%q) = %v", tt.p.Key, err)
+		}
+	}
+	if p, ok := LookupProperty("thermo.method_name"); !ok || p.Description != "Name of the instrument method" {
+		t.Errorf("registered property %+v, %v", p, ok)
+	}
+	if last := Properties()[len(Properties())-1]; last.Key != "thermo.method_name" {
+		t.Errorf("vendor key is not listed after the canonical keys: %+v", last)
+	}
+}
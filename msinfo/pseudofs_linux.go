@@ -0,0 +1,31 @@
+package msinfo
+
+import "syscall"
+
+// Filesystem magic numbers of pseudo-filesystems, from linux/magic.h
+var pseudoFSMagic = map[uint32]string{
+	0x9fa0:     "proc",
+	0x62656572: "sysfs",
+	0x1cd1:     "devpts",
+	0x27e0eb:   "cgroup",
+	0x63677270: "cgroup2",
+	0x64626720: "debugfs",
+	0x74726163: "tracefs",
+	0x73636673: "securityfs",
+	0xcafe4a11: "bpf",
+	0x42494e4d: "binfmt_misc",
+	0x6e736673: "nsfs",
+	0x50495045: "pipefs",
+	0x534f434b: "sockfs",
+	0x1373:     "devfs",
+}
+
+// isPseudoFS reports whether a directory is on a pseudo-filesystem such as /proc or /sys
+func isPseudoFS(dir string) bool {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return false
+	}
+	_, ok := pseudoFSMagic[uint32(st.Type)]
+	return ok
+}
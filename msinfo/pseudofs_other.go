@@ -0,0 +1,8 @@
+//go:build !linux
+
+package msinfo
+
+// isPseudoFS is not implemented on this platform
+func isPseudoFS(dir string) bool {
+	return false
+}
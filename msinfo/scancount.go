@@ -0,0 +1,301 @@
+package msinfo
+
+// scancount.go - Count the spectra in mzML, mzXML and MGF files
+// Counting normally reads the whole file. Indexed mzML files have the offset of
+// each spectrum in an index at the end, so only the start of each spectrum is
+// read to get its MS level, and the binary data in between is skipped.
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+
+	"github.com/524D/msfile/fcompare"
+)
+
+// Accession of the MS level of a spectrum in mzML
+const accessionMSLevel = "MS:1000511"
+
+// Number of bytes read at the start of each spectrum of an indexed mzML file
+// to find its MS level
+const spectrumHeadSize = 4096
+
+// ScanCounts is the number of spectra in a file
+type ScanCounts struct {
+	Spectra int
+	// Number of spectra by MS level (mzML and mzXML); spectra without
+	// an MS level are only in Spectra
+	ByLevel map[int]int
+	// The spectra were found with the index of an indexed mzML file
+	FromIndex bool
+}
+
+var errNoIndex = errors.New("no usable spectrum index")
+
+// CountScans counts the spectra in a file of the given format (mzML, imzML, mzXML
+// or mgf, as returned by DetectFormat or FormatFromName). The file may be
+// gzip- or zstd-compressed; the index of a compressed mzML file isn't used.
+func CountScans(path string, format string) (ScanCounts, error) {
+	return CountScansCtx(context.Background(), path, format)
+}
+
+// CountScansCtx is CountScans, which reports what it reads to the FileObserver of ctx
+func CountScansCtx(ctx context.Context, path string, format string) (ScanCounts, error) {
+	if format == FormatMzML || format == FormatImzML {
+		c, err := countIndexedMzML(ctx, path)
+		if err == nil {
+			return c, nil
+		}
+		if !errors.Is(err, errNoIndex) {
+			return c, err
+		}
+	}
+	r, err := openDecompressed(ctx, path)
+	if err != nil {
+		return ScanCounts{}, err
+	}
+	defer r.Close()
+	switch format {
+	case FormatMzML, FormatImzML:
+		return countMzML(r)
+	case FormatMzXML:
+		return countMzXML(r)
+	case FormatMGF:
+		return countMGF(r)
+	}
+	return ScanCounts{}, fmt.Errorf("can't count spectra in %s files", format)
+}
+
+// countMzML counts the spectra of an mzML file by parsing all of it
+func countMzML(r io.Reader) (ScanCounts, error) {
+	c := ScanCounts{ByLevel: make(map[int]int)}
+	d := newXMLDecoder(r)
+	// The MS level can be set in a referenceableParamGroup that spectra refer to
+	groupLevels := make(map[string]int)
+	var groupID string
+	var path []string // local names of the open elements
+	level := 0
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF && len(path) > 0 {
+			return c, io.ErrUnexpectedEOF
+		}
+		if err == io.EOF {
+			return c, nil
+		}
+		if err != nil {
+			return c, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			parent := ""
+			if len(path) > 0 {
+				parent = path[len(path)-1]
+			}
+			if len(path) == maxXMLDepth {
+				return c, errXMLTooDeep
+			}
+			path = append(path, t.Name.Local)
+			switch t.Name.Local {
+			case "referenceableParamGroup":
+				groupID = attr(t, "id")
+			case "spectrum":
+				level = 0
+			case "referenceableParamGroupRef":
+				if l, ok := groupLevels[attr(t, "ref")]; ok && parent == "spectrum" {
+					level = l
+				}
+			case "cvParam":
+				if attr(t, "accession") != accessionMSLevel {
+					break
+				}
+				l, _ := strconv.Atoi(attr(t, "value"))
+				switch parent {
+				case "referenceableParamGroup":
+					groupLevels[groupID] = l
+				case "spectrum":
+					level = l
+				}
+			}
+		case xml.EndElement:
+			if len(path) > 0 {
+				path = path[:len(path)-1]
+			}
+			if t.Name.Local == "spectrum" {
+				c.Spectra++
+				if level > 0 {
+					c.ByLevel[level]++
+				}
+			}
+		}
+	}
+}
+
+var (
+	indexListOffsetPattern = regexp.MustCompile(`<indexListOffset>\s*(\d+)\s*</indexListOffset>`)
+	msLevelPattern         = regexp.MustCompile(`accession="` + accessionMSLevel + `"[^>]*?value="(\d+)"|value="(\d+)"[^>]*?accession="` + accessionMSLevel + `"`)
+)
+
+// countIndexedMzML counts the spectra of an indexed mzML file with its index.
+// It returns errNoIndex if the file has no index, or one that doesn't point at spectra
+// with an MS level in their first spectrumHeadSize bytes.
+func countIndexedMzML(ctx context.Context, path string) (ScanCounts, error) {
+	f, err := fcompare.OpenObserved(ctx, path)
+	if err != nil {
+		return ScanCounts{}, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return ScanCounts{}, err
+	}
+	// The offset of the index is in the last lines of the file
+	tailSize := min(fi.Size(), 1024)
+	tail := make([]byte, tailSize)
+	if _, err := f.ReadAt(tail, fi.Size()-tailSize); err != nil {
+		return ScanCounts{}, err
+	}
+	m := indexListOffsetPattern.FindSubmatch(tail)
+	if m == nil {
+		return ScanCounts{}, errNoIndex
+	}
+	indexOffset, err := strconv.ParseInt(string(m[1]), 10, 64)
+	if err != nil || indexOffset <= 0 || indexOffset >= fi.Size() {
+		return ScanCounts{}, errNoIndex
+	}
+
+	offsets, err := spectrumOffsets(io.NewSectionReader(f, indexOffset, fi.Size()-indexOffset))
+	if err != nil {
+		return ScanCounts{}, errNoIndex
+	}
+	c := ScanCounts{ByLevel: make(map[int]int), FromIndex: true}
+	head := make([]byte, spectrumHeadSize)
+	prev := int64(-1)
+	for _, off := range offsets {
+		// Spectra are in the order of the index, before it. Anything else could
+		// make a crafted index read the same data over and over.
+		if off <= prev || off >= indexOffset {
+			return ScanCounts{}, errNoIndex
+		}
+		prev = off
+		n, err := f.ReadAt(head, off)
+		if err != nil && err != io.EOF {
+			return ScanCounts{}, err
+		}
+		h := head[:n]
+		if !bytes.HasPrefix(h, []byte("<spectrum")) {
+			return ScanCounts{}, errNoIndex
+		}
+		// Only the start tag and the cvParams before the binary data
+		if end := bytes.Index(h, []byte("<binaryDataArrayList")); end >= 0 {
+			h = h[:end]
+		}
+		m := msLevelPattern.FindSubmatch(h)
+		if m == nil {
+			return ScanCounts{}, errNoIndex
+		}
+		level, _ := strconv.Atoi(string(m[1]) + string(m[2]))
+		c.Spectra++
+		c.ByLevel[level]++
+	}
+	return c, nil
+}
+
+// spectrumOffsets returns the offsets in the spectrum index of an indexed mzML file
+func spectrumOffsets(r io.Reader) ([]int64, error) {
+	d := newXMLDecoder(r)
+	var offsets []int64
+	inSpectrumIndex, inOffset := false, false
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			return nil, errNoIndex
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "index":
+				inSpectrumIndex = attr(t, "name") == "spectrum"
+			case "offset":
+				inOffset = inSpectrumIndex
+			}
+		case xml.CharData:
+			if inOffset {
+				off, err := strconv.ParseInt(string(bytes.TrimSpace(t)), 10, 64)
+				if err != nil {
+					return nil, err
+				}
+				offsets = append(offsets, off)
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "offset":
+				inOffset = false
+			case "indexList":
+				return offsets, nil
+			}
+		}
+	}
+}
+
+// countMzXML counts the scans of an mzXML file by their msLevel attribute
+func countMzXML(r io.Reader) (ScanCounts, error) {
+	c := ScanCounts{ByLevel: make(map[int]int)}
+	d := newXMLDecoder(r)
+	depth := 0 // number of open elements, to detect truncated files
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF && depth > 0 {
+			return c, io.ErrUnexpectedEOF
+		}
+		if err == io.EOF {
+			return c, nil
+		}
+		if err != nil {
+			return c, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if depth == maxXMLDepth {
+				return c, errXMLTooDeep
+			}
+			depth++
+			if t.Name.Local == "scan" {
+				c.Spectra++
+				if level, err := strconv.Atoi(attr(t, "msLevel")); err == nil {
+					c.ByLevel[level]++
+				}
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+}
+
+// countMGF counts the BEGIN IONS lines of an MGF file
+func countMGF(r io.Reader) (ScanCounts, error) {
+	var c ScanCounts
+	br := bufio.NewReaderSize(r, 64*1024)
+	for {
+		line, err := br.ReadSlice('\n')
+		if bytes.Equal(bytes.TrimSpace(line), []byte("BEGIN IONS")) {
+			c.Spectra++
+		}
+		if err == io.EOF {
+			return c, nil
+		}
+		// Lines longer than the buffer are never BEGIN IONS
+		if err != nil && err != bufio.ErrBufferFull {
+			return c, err
+		}
+	}
+}
@@ -0,0 +1,367 @@
+package msinfo
+
+// scanner.go - Scan files from another Go program, instead of running msfile
+// A Scanner processes the files and directory trees that are added to it in the
+// background. Each priority has its own bounded queue, and higher priorities are
+// served first, so that a request to check one file now doesn't wait for the rest
+// of a crawl of an archive. Directories are walked with WalkFiles, like msfile -r.
+//
+// Example:
+//
+//	s, err := msinfo.NewScanner(msinfo.ScannerConfig{Method: fcompare.CmpPartial, KeepATime: true})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	go func() {
+//		s.Add("/archive", msinfo.PriorityBackground)
+//		s.Add("/incoming/run42.raw", msinfo.PriorityInteractive)
+//		s.Close()
+//	}()
+//	for rec := range s.Results() {
+//		if rec.Err != nil {
+//			log.Println(rec.Path, rec.Err)
+//			continue
+//		}
+//		fmt.Println(rec.Path, rec.File.Properties[msinfo.PropFormat], rec.File.PartialChecksum)
+//	}
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/524D/msfile/fcompare"
+	"github.com/524D/msfile/msfileio"
+	"github.com/djherbis/atime"
+)
+
+// Priority of the files added to a Scanner
+type Priority int
+
+const (
+	PriorityBackground Priority = iota
+	PriorityNormal
+	PriorityInteractive
+	numPriorities
+)
+
+// A non-empty queue is served after this many files of higher priorities in a row,
+// so a steady stream of interactive requests can't stall a background crawl
+const fairnessSkips = 8
+
+// Defaults of ScannerConfig
+const (
+	defaultScanWorkers   = 4
+	defaultScanQueueSize = 1024
+)
+
+// ErrScannerClosed is returned by Add after Close
+var ErrScannerClosed = errors.New("scanner is closed")
+
+// ScannerConfig holds the settings of a Scanner
+type ScannerConfig struct {
+	Workers   int        // number of files processed at the same time (default 4)
+	QueueSize int        // maximum number of queued files per priority (default 1024)
+	Walk      WalkConfig // settings of the walk of directories
+	// Method of the checksum of each file. CmpSize (the zero value) computes
+	// no checksum, like msfile without -compare. CmpAuto and CmpBytes compare
+	// files with each other, so they can't be used.
+	Method   fcompare.CompareMethod
+	HashAlgo fcompare.HashAlgo
+	Partial  fcompare.PartialChecksumConfig // the zero value is fcompare.DefaultPartialChecksumConfig
+	// Restore the access time of files that are read
+	KeepATime bool
+}
+
+// Record is the result of the scan of a file, or the error of the walk of a directory
+type Record struct {
+	Path     string
+	Priority Priority
+	File     msfileio.FileInfo
+	Err      error
+}
+
+// ScanStats are the numbers of files in each state
+type ScanStats struct {
+	Queued     int   // files waiting in a queue
+	Processing int   // files being scanned
+	Done       int   // files scanned without error
+	Failed     int   // files and directories with an error
+	Bytes      int64 // total size of the files scanned without error
+	Walking    int   // directories being walked
+	Paused     bool
+}
+
+type queuedFile struct {
+	path     string
+	priority Priority
+}
+
+// Scanner scans files in the background; see NewScanner
+type Scanner struct {
+	cfg     ScannerConfig
+	results chan Record
+
+	mu      sync.Mutex
+	cond    *sync.Cond // signalled for each change of the queues, paused, closed or walking
+	queues  [numPriorities][]queuedFile
+	skipped [numPriorities]int // number of files taken from higher priorities in a row
+	closed  bool
+	stats   ScanStats
+}
+
+// NewScanner starts the workers of a Scanner.
+// The results must be read from Results, or the workers stop, and Add blocks when
+// a queue is full. After Close, Results is closed when all files are done.
+func NewScanner(cfg ScannerConfig) (*Scanner, error) {
+	switch cfg.Method {
+	case fcompare.CmpSize, fcompare.CmpPartial, fcompare.CmpPartialAdaptive, fcompare.CmpFull:
+	default:
+		return nil, fcompare.ErrInvalidMethod
+	}
+	if cfg.Partial == (fcompare.PartialChecksumConfig{}) {
+		cfg.Partial = fcompare.DefaultPartialChecksumConfig
+	}
+	if err := cfg.Partial.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = defaultScanWorkers
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = defaultScanQueueSize
+	}
+	s := &Scanner{cfg: cfg, results: make(chan Record)}
+	s.cond = sync.NewCond(&s.mu)
+	var wg sync.WaitGroup
+	for w := 0; w < cfg.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.work()
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(s.results)
+	}()
+	return s, nil
+}
+
+// Add queues a file, or all regular files under a directory, with the given priority.
+// It blocks while the queue of the priority is full. A directory is walked in the
+// background, so Add returns before all its files are queued.
+func (s *Scanner) Add(path string, priority Priority) error {
+	if priority < PriorityBackground || priority >= numPriorities {
+		return fmt.Errorf("invalid priority %d", priority)
+	}
+	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.closed {
+			return ErrScannerClosed
+		}
+		s.stats.Walking++
+		go s.walk(path, priority)
+		return nil
+	}
+	// Files that can't be stat'ed are queued too, so their error is in the results
+	return s.enqueue(queuedFile{path, priority}, true)
+}
+
+// Results returns the channel on which the result of each file is sent
+func (s *Scanner) Results() <-chan Record {
+	return s.results
+}
+
+// Pause stops the workers from starting on more files; files in progress are finished
+func (s *Scanner) Pause() {
+	s.mu.Lock()
+	s.stats.Paused = true
+	s.mu.Unlock()
+}
+
+// Resume continues after Pause
+func (s *Scanner) Resume() {
+	s.mu.Lock()
+	s.stats.Paused = false
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+// Stats returns the number of files in each state
+func (s *Scanner) Stats() ScanStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// Close stops Add from accepting files. Files that are already queued, and
+// those in directories that are being walked, are still scanned.
+func (s *Scanner) Close() {
+	s.mu.Lock()
+	s.closed = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+// enqueue waits until there is room in the queue, and adds the file.
+// Files from Add are refused after Close, files from walks are not.
+func (s *Scanner) enqueue(f queuedFile, fromAdd bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.queues[f.priority]) >= s.cfg.QueueSize && !(fromAdd && s.closed) {
+		s.cond.Wait()
+	}
+	if fromAdd && s.closed {
+		return ErrScannerClosed
+	}
+	s.queues[f.priority] = append(s.queues[f.priority], f)
+	s.stats.Queued++
+	s.cond.Broadcast()
+	return nil
+}
+
+func (s *Scanner) walk(root string, priority Priority) {
+	err := WalkFiles(root, s.cfg.Walk, func(path string) error {
+		return s.enqueue(queuedFile{path, priority}, false)
+	})
+	if err != nil {
+		s.mu.Lock()
+		s.stats.Failed++
+		s.mu.Unlock()
+		s.results <- Record{Path: root, Priority: priority, Err: err}
+	}
+	s.mu.Lock()
+	s.stats.Walking--
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+// next waits for the next file to scan. It returns false when the scanner is
+// closed, and all files are taken.
+func (s *Scanner) next() (queuedFile, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		if !s.stats.Paused {
+			if p, ok := s.pick(); ok {
+				f := s.queues[p][0]
+				s.queues[p] = s.queues[p][1:]
+				s.stats.Queued--
+				s.stats.Processing++
+				s.cond.Broadcast()
+				return f, true
+			}
+		}
+		if s.closed && s.stats.Walking == 0 && s.stats.Queued == 0 {
+			return queuedFile{}, false
+		}
+		s.cond.Wait()
+	}
+}
+
+// pick returns the priority of the queue from which the next file is taken:
+// the highest non-empty one, unless a lower one was skipped fairnessSkips times
+func (s *Scanner) pick() (Priority, bool) {
+	chosen := Priority(-1)
+	for p := numPriorities - 1; p >= PriorityBackground; p-- {
+		if len(s.queues[p]) == 0 {
+			continue
+		}
+		if chosen < 0 || s.skipped[p] >= fairnessSkips {
+			chosen = p
+		}
+	}
+	if chosen < 0 {
+		return 0, false
+	}
+	for p := range s.queues {
+		if Priority(p) != chosen && len(s.queues[p]) > 0 && Priority(p) < chosen {
+			s.skipped[p]++
+		}
+	}
+	s.skipped[chosen] = 0
+	return chosen, true
+}
+
+func (s *Scanner) work() {
+	for {
+		f, ok := s.next()
+		if !ok {
+			return
+		}
+		inf, err := s.scanFile(f.path)
+		s.mu.Lock()
+		s.stats.Processing--
+		if err != nil {
+			s.stats.Failed++
+		} else {
+			s.stats.Done++
+			s.stats.Bytes += inf.Size
+		}
+		s.mu.Unlock()
+		s.results <- Record{Path: f.path, Priority: f.priority, File: inf, Err: err}
+	}
+}
+
+// scanFile returns the file information, format and checksum of a file
+func (s *Scanner) scanFile(path string) (inf msfileio.FileInfo, err error) {
+	inf.Filename = path
+	inf.Properties = make(map[string]string)
+	fi, err := os.Stat(path)
+	if err != nil {
+		return inf, err
+	}
+	// Reading a device can hang, or never end
+	if !fi.Mode().IsRegular() {
+		return inf, fmt.Errorf("%s is not a regular file", path)
+	}
+	at := atime.Get(fi)
+	mt := fi.ModTime()
+	inf.Atime, inf.AtimeNs = at.Unix(), at.UnixNano()
+	inf.Mtime, inf.MtimeNs = mt.Unix(), mt.UnixNano()
+	inf.Size = fi.Size()
+	inf.AllocatedSize = inf.Size
+	if s.cfg.KeepATime {
+		defer func() {
+			if rerr := fcompare.RestoreTimes(path, at, mt); rerr != nil && err == nil {
+				err = rerr
+			}
+		}()
+	}
+
+	format, err := DetectFormat(path)
+	if err != nil {
+		return inf, err
+	}
+	inf.Properties[PropFormat] = format
+
+	algo := s.cfg.HashAlgo
+	isFull := false
+	switch s.cfg.Method {
+	case fcompare.CmpPartial:
+		inf.CompareMethod = "partial"
+		inf.PartialChecksum, isFull, err = fcompare.GetPartialChecksumConfig(path, algo, s.cfg.Partial)
+	case fcompare.CmpPartialAdaptive:
+		inf.CompareMethod = "partial-adaptive"
+		inf.PartialChecksum, isFull, err = fcompare.GetAdaptivePartialChecksumConfig(path, algo, s.cfg.Partial)
+	case fcompare.CmpFull:
+		inf.CompareMethod = "full"
+		inf.FullChecksum, err = fcompare.GetChecksumHash(path, algo)
+	}
+	if err != nil {
+		return inf, err
+	}
+	if isFull {
+		inf.FullChecksum = inf.PartialChecksum
+	}
+	if inf.PartialChecksum != "" || inf.FullChecksum != "" {
+		inf.ChecksumSource = msfileio.ChecksumSourceFresh
+		if algo != fcompare.HashSHA256 {
+			inf.HashAlgo = algo.String()
+		}
+	}
+	return inf, nil
+}
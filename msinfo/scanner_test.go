@@ -0,0 +1,161 @@
+package msinfo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/524D/msfile/fcompare"
+)
+
+func TestNewScannerConfig(t *testing.T) {
+	for _, m := range []fcompare.CompareMethod{fcompare.CmpAuto, fcompare.CmpBytes, -1} {
+		if _, err := NewScanner(ScannerConfig{Method: m}); !errors.Is(err, fcompare.ErrInvalidMethod) {
+			t.Errorf("method %d: error %v, want ErrInvalidMethod", m, err)
+		}
+	}
+	if _, err := NewScanner(ScannerConfig{Partial: fcompare.PartialChecksumConfig{ChunkSize: 10, FullThreshold: 20}}); err == nil {
+		t.Error("invalid partial config: no error")
+	}
+	s, err := NewScanner(ScannerConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add("x", numPriorities); err == nil {
+		t.Error("invalid priority: no error")
+	}
+	s.Close()
+	if err := s.Add("x", PriorityNormal); !errors.Is(err, ErrScannerClosed) {
+		t.Errorf("Add after Close: error %v, want ErrScannerClosed", err)
+	}
+	for range s.Results() {
+		t.Error("result without files")
+	}
+}
+
+// A steady stream of higher priority files doesn't stall a lower priority
+func TestScannerFairness(t *testing.T) {
+	s := &Scanner{}
+	for i := 0; i < 100; i++ {
+		s.queues[PriorityInteractive] = append(s.queues[PriorityInteractive], queuedFile{fmt.Sprint(i), PriorityInteractive})
+	}
+	s.queues[PriorityBackground] = []queuedFile{{"bg", PriorityBackground}}
+	var order []Priority
+	for len(order) < 2*fairnessSkips+2 {
+		p, ok := s.pick()
+		if !ok {
+			t.Fatal("nothing picked")
+		}
+		order = append(order, p)
+		s.queues[p] = s.queues[p][1:]
+	}
+	// The background file comes after fairnessSkips interactive files
+	for i, p := range order {
+		want := PriorityInteractive
+		if i == fairnessSkips {
+			want = PriorityBackground
+		}
+		if p != want {
+			t.Fatalf("pick %d: priority %d, want %d: %v", i, p, want, order)
+		}
+	}
+}
+
+func TestScannerResults(t *testing.T) {
+	dir := t.TempDir()
+	var want []string
+	for i := 0; i < 20; i++ {
+		name := filepath.Join(dir, "sub", fmt.Sprintf("f%02d.mgf", i))
+		if i == 0 {
+			if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.WriteFile(name, []byte(fmt.Sprintf("BEGIN IONS\nTITLE=%d\nEND IONS\n", i)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, name)
+	}
+	missing := filepath.Join(dir, "missing.mzML")
+	s, err := NewScanner(ScannerConfig{Workers: 3, QueueSize: 2, Method: fcompare.CmpPartial, HashAlgo: fcompare.HashMD5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		s.Add(dir, PriorityBackground)
+		s.Add(missing, PriorityInteractive)
+		s.Close()
+	}()
+	var got []string
+	var failed []string
+	for rec := range s.Results() {
+		if rec.Err != nil {
+			failed = append(failed, rec.Path)
+			continue
+		}
+		got = append(got, rec.Path)
+		inf := rec.File
+		if inf.Properties[PropFormat] != FormatMGF || inf.HashAlgo != "md5" || len(inf.PartialChecksum) != 32 || inf.FullChecksum != inf.PartialChecksum {
+			t.Errorf("%s: %+v", rec.Path, inf)
+		}
+	}
+	sort.Strings(got)
+	if fmt.Sprint(got) != fmt.Sprint(want) || len(failed) != 1 || failed[0] != missing {
+		t.Errorf("scanned %v, failed %v, want %v, and %s failed", got, failed, want, missing)
+	}
+	st := s.Stats()
+	if st.Done != 20 || st.Failed != 1 || st.Queued != 0 || st.Processing != 0 || st.Walking != 0 {
+		t.Errorf("stats %+v", st)
+	}
+}
+
+// While paused, no file is started, and Add blocks when the queue is full
+func TestScannerPauseAndBound(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "a.mgf")
+	if err := os.WriteFile(fn, []byte("BEGIN IONS\nEND IONS\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewScanner(ScannerConfig{Workers: 2, QueueSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Pause()
+	for i := 0; i < 2; i++ {
+		if err := s.Add(fn, PriorityNormal); err != nil {
+			t.Fatal(err)
+		}
+	}
+	added := make(chan error)
+	go func() { added <- s.Add(fn, PriorityNormal) }()
+	select {
+	case err := <-added:
+		t.Fatalf("Add to a full queue returned %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if st := s.Stats(); st.Queued != 2 || st.Processing != 0 || !st.Paused {
+		t.Errorf("stats while paused %+v", st)
+	}
+	// Another priority has its own queue
+	if err := s.Add(fn, PriorityInteractive); err != nil {
+		t.Fatal(err)
+	}
+	s.Resume()
+	if err := <-added; err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	n := 0
+	for rec := range s.Results() {
+		if rec.Err != nil {
+			t.Error(rec.Err)
+		}
+		n++
+	}
+	if n != 4 {
+		t.Errorf("%d results, want 4", n)
+	}
+}
@@ -0,0 +1,253 @@
+package msinfo
+
+// sink.go - Compute checksums while data is copied
+// A transfer tool can write the data that it copies to a ChecksumSink, and get
+// the same checksums as from the files afterwards, without reading them again.
+//
+// A sink from NewChecksumSinkSize knows the size of the data up front, so it hashes
+// the 3 blocks of the partial checksum as they pass, and keeps no data at all: its
+// memory is the state of each hash, well below the 3 blocks
+// (PartialChecksumConfig.ChunkSize) that the partial checksum reads. Use it
+// whenever the size is known, e.g. when copying a file.
+//
+// A sink from NewChecksumSink doesn't know the size until the data ends, and the
+// middle block of the partial checksum is in the middle of the data. So the sink
+// can't hash that block when it passes; instead, each block of the second half of
+// the data so far is a candidate, and is hashed after the first block into a hash
+// of its own. Finalize picks the candidate for the final size, and adds the last
+// block. Any of these blocks can still become the middle block, so no sink
+// without the size can stay within a fixed number of blocks. This one keeps 2
+// blocks, the first block and the last block so far, and for each candidate block
+// the state of each hash, of about 100 bytes (SHA256) to 2 KB (BLAKE3); for 1 TB
+// of data with 1 MB blocks, that are 500,000 states.
+
+import (
+	"encoding"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+
+	"github.com/524D/msfile/fcompare"
+	"github.com/zeebo/blake3"
+)
+
+// ChecksumSink is an io.Writer that computes the full and partial checksums of
+// the data written to it, see NewChecksumSink
+type ChecksumSink struct {
+	algos   []fcompare.HashAlgo
+	cfg     fcompare.PartialChecksumConfig
+	size    int64 // the size from NewChecksumSinkSize, or -1
+	written int64
+	full    []hash.Hash
+	first   []hash.Hash // the partial checksums, after the first block; with the size, all blocks
+	// The first block, to start a candidate with a hash that can't be copied
+	firstBlock []byte
+	// Candidates for the middle block, in order
+	candidates []candidateBlock
+	// The last block so far; the byte at offset x is at last[x % ChunkSize]
+	last []byte
+}
+
+type candidateBlock struct {
+	start   int64
+	partial []hash.Hash
+}
+
+// SinkChecksum holds the checksums of the data written to a ChecksumSink, with one algorithm
+type SinkChecksum struct {
+	Algo    fcompare.HashAlgo
+	Full    string
+	Partial string
+	IsFull  bool // the partial checksum is the full checksum, as for small files
+}
+
+// ErrSizeMismatch is returned by ChecksumSink.Finalize for a size that differs
+// from the amount of data that was written
+var ErrSizeMismatch = errors.New("size doesn't match the data written to the checksum sink")
+
+// NewChecksumSink returns a sink for data of any size, with a partial checksum
+// like fcompare.GetPartialChecksumConfig with DefaultPartialChecksumConfig.
+// Without algorithms, only SHA256 is computed.
+func NewChecksumSink(algos ...fcompare.HashAlgo) *ChecksumSink {
+	s, _ := NewChecksumSinkConfig(fcompare.DefaultPartialChecksumConfig, algos...)
+	return s
+}
+
+// NewChecksumSinkConfig is NewChecksumSink with the chunks of cfg
+func NewChecksumSinkConfig(cfg fcompare.PartialChecksumConfig, algos ...fcompare.HashAlgo) (*ChecksumSink, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	s := newChecksumSink(cfg, -1, algos)
+	s.last = make([]byte, cfg.ChunkSize)
+	return s, nil
+}
+
+// NewChecksumSinkSize returns a sink for size bytes of data, with the chunks of
+// cfg, which keeps no data in memory. Without algorithms, only SHA256 is computed.
+func NewChecksumSinkSize(size int64, cfg fcompare.PartialChecksumConfig, algos ...fcompare.HashAlgo) (*ChecksumSink, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if size < 0 {
+		return nil, fmt.Errorf("checksum sink: negative size %d", size)
+	}
+	return newChecksumSink(cfg, size, algos), nil
+}
+
+func newChecksumSink(cfg fcompare.PartialChecksumConfig, size int64, algos []fcompare.HashAlgo) *ChecksumSink {
+	if len(algos) == 0 {
+		algos = []fcompare.HashAlgo{fcompare.HashSHA256}
+	}
+	s := &ChecksumSink{algos: algos, cfg: cfg, size: size}
+	for _, algo := range algos {
+		s.full = append(s.full, algo.New())
+		s.first = append(s.first, algo.New())
+	}
+	return s
+}
+
+// Write adds data to the checksums. It never returns an error.
+func (s *ChecksumSink) Write(p []byte) (int, error) {
+	n := len(p)
+	for _, h := range s.full {
+		h.Write(p)
+	}
+	if s.size >= 0 {
+		s.writeBlocks(p)
+		return n, nil
+	}
+	chunk := s.cfg.ChunkSize
+	for len(p) > 0 {
+		// Write up to the end of the current block
+		off := s.written % chunk
+		seg := p[:min(int64(len(p)), chunk-off)]
+		block := s.written / chunk
+		if block == 0 {
+			for _, h := range s.first {
+				h.Write(seg)
+			}
+			s.firstBlock = append(s.firstBlock, seg...)
+		} else {
+			if off == 0 && s.mayBeMiddle(block) {
+				s.candidates = append(s.candidates, candidateBlock{start: s.written, partial: s.afterFirst()})
+			}
+			if c := len(s.candidates) - 1; c >= 0 && s.candidates[c].start == block*chunk {
+				for _, h := range s.candidates[c].partial {
+					h.Write(seg)
+				}
+			}
+		}
+		copy(s.last[off:], seg)
+		s.written += int64(len(seg))
+		p = p[len(seg):]
+	}
+	// Blocks before the middle of the data so far are never the middle block
+	mid := s.written / 2 / chunk * chunk
+	drop := 0
+	for drop < len(s.candidates) && s.candidates[drop].start < mid {
+		drop++
+	}
+	s.candidates = s.candidates[drop:]
+	return n, nil
+}
+
+// writeBlocks adds the parts of p in the blocks of the partial checksum, for a sink with a size
+func (s *ChecksumSink) writeBlocks(p []byte) {
+	start := s.written
+	s.written += int64(len(p))
+	if s.size <= s.cfg.FullThreshold {
+		return
+	}
+	chunk := s.cfg.ChunkSize
+	mid := s.size / 2 / chunk * chunk
+	for _, block := range []int64{0, mid, s.size - chunk} {
+		lo, hi := max(block, start), min(block+chunk, s.written)
+		if lo >= hi {
+			continue
+		}
+		for _, h := range s.first {
+			h.Write(p[lo-start : hi-start])
+		}
+	}
+}
+
+// mayBeMiddle reports whether a block is the middle block of data of some size
+// above the threshold
+func (s *ChecksumSink) mayBeMiddle(block int64) bool {
+	// The middle block of data of size n starts at n/2, rounded down to a multiple of the chunk size
+	largest := 2*(block+1)*s.cfg.ChunkSize - 1
+	return largest > s.cfg.FullThreshold
+}
+
+// afterFirst returns new partial checksums that have been fed the first block
+func (s *ChecksumSink) afterFirst() []hash.Hash {
+	hs := make([]hash.Hash, len(s.algos))
+	for i, algo := range s.algos {
+		hs[i] = cloneHash(algo, s.first[i])
+		if hs[i] == nil {
+			hs[i] = algo.New()
+			hs[i].Write(s.firstBlock)
+		}
+	}
+	return hs
+}
+
+// cloneHash returns a copy of h, or nil if it can't be copied
+func cloneHash(algo fcompare.HashAlgo, h hash.Hash) hash.Hash {
+	if b, ok := h.(*blake3.Hasher); ok {
+		return b.Clone()
+	}
+	m, ok := h.(encoding.BinaryMarshaler)
+	if !ok {
+		return nil
+	}
+	state, err := m.MarshalBinary()
+	if err != nil {
+		return nil
+	}
+	c := algo.New()
+	if u, ok := c.(encoding.BinaryUnmarshaler); ok && u.UnmarshalBinary(state) == nil {
+		return c
+	}
+	return nil
+}
+
+// Finalize returns the checksums of the data, in the order of the algorithms of
+// the sink. size is the final size of the data, which must be the amount written.
+// The sink can't be used afterwards.
+func (s *ChecksumSink) Finalize(size int64) ([]SinkChecksum, error) {
+	if size != s.written || s.size >= 0 && size != s.size {
+		return nil, fmt.Errorf("%w: size %d, written %d", ErrSizeMismatch, size, s.written)
+	}
+	sums := make([]SinkChecksum, len(s.algos))
+	for i, algo := range s.algos {
+		sums[i] = SinkChecksum{Algo: algo, Full: hex.EncodeToString(s.full[i].Sum(nil))}
+	}
+	if size <= s.cfg.FullThreshold {
+		for i := range sums {
+			sums[i].Partial, sums[i].IsFull = sums[i].Full, true
+		}
+		return sums, nil
+	}
+	if s.size >= 0 {
+		for i, h := range s.first {
+			sums[i].Partial = hex.EncodeToString(h.Sum(nil))
+		}
+		return sums, nil
+	}
+	chunk := s.cfg.ChunkSize
+	mid := size / 2 / chunk * chunk
+	if len(s.candidates) == 0 || s.candidates[0].start != mid {
+		return nil, fmt.Errorf("checksum sink: no middle block at %d", mid)
+	}
+	// The last block, from the ring buffer
+	at := (size - chunk) % chunk
+	for i, h := range s.candidates[0].partial {
+		h.Write(s.last[at:])
+		h.Write(s.last[:at])
+		sums[i].Partial = hex.EncodeToString(h.Sum(nil))
+	}
+	return sums, nil
+}
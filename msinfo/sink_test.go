@@ -0,0 +1,159 @@
+package msinfo
+
+import (
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/524D/msfile/fcompare"
+)
+
+// writeInPieces writes data to s in pieces of random size, up to max bytes
+func writeInPieces(t *testing.T, s *ChecksumSink, data []byte, rng *rand.Rand, max int) {
+	t.Helper()
+	for len(data) > 0 {
+		n := min(len(data), 1+rng.Intn(max))
+		if w, err := s.Write(data[:n]); w != n || err != nil {
+			t.Fatalf("Write = %d, %v", w, err)
+		}
+		data = data[n:]
+	}
+}
+
+// The checksums of the sink, with and without the size up front, must be those of
+// a file with the same content, for sizes around the chunk size and the threshold,
+// however the data is written
+func TestChecksumSinkMatchFiles(t *testing.T) {
+	cfg := fcompare.PartialChecksumConfig{ChunkSize: 4096, FullThreshold: 3 * 4096}
+	sizes := []int64{0, 1, 4095, 4096, 3*4096 - 1, 3 * 4096, 3*4096 + 1, 4 * 4096, 4*4096 + 1,
+		5*4096 - 1, 8 * 4096, 8*4096 + 4095, 8*4096 + 4097, 100003, 1 << 20}
+	dir := t.TempDir()
+	rng := rand.New(rand.NewSource(1))
+	for _, size := range sizes {
+		data := make([]byte, size)
+		rng.Read(data)
+		fn := filepath.Join(dir, strconv.FormatInt(size, 10))
+		if err := os.WriteFile(fn, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		for i, max := range []int{1, 1000, 4096, 10000, 1 << 20, 1, 1000, 10000} {
+			if max == 1 && size > 100003 {
+				continue
+			}
+			s, err := NewChecksumSinkConfig(cfg, testAlgos...)
+			if i >= 5 {
+				s, err = NewChecksumSinkSize(size, cfg, testAlgos...)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeInPieces(t, s, data, rng, max)
+			sums, err := s.Finalize(size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, algo := range testAlgos {
+				full, err := fcompare.GetChecksumHash(fn, algo)
+				if err != nil {
+					t.Fatal(err)
+				}
+				partial, isFull, err := fcompare.GetPartialChecksumConfig(fn, algo, cfg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := sums[i]
+				if got.Algo != algo || got.Full != full || got.Partial != partial || got.IsFull != isFull {
+					t.Errorf("size %d, pieces up to %d, %v: sink %+v, file %s %s %v", size, max, algo, got, full, partial, isFull)
+				}
+			}
+		}
+	}
+}
+
+func TestChecksumSinkDefault(t *testing.T) {
+	def := fcompare.DefaultPartialChecksumConfig
+	size := def.FullThreshold + def.ChunkSize/2
+	data := randomTestData(2, int(size))
+	fn := filepath.Join(t.TempDir(), "f")
+	if err := os.WriteFile(fn, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := NewChecksumSink()
+	writeInPieces(t, s, data, rand.New(rand.NewSource(3)), 1<<16)
+	sums, err := s.Finalize(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partial, _, err := fcompare.GetPartialChecksum(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sums) != 1 || sums[0].Algo != fcompare.HashSHA256 || sums[0].Partial != partial || sums[0].IsFull {
+		t.Errorf("sink %+v, file %s", sums, partial)
+	}
+}
+
+// Only 2 blocks of data are kept, and only the candidates in the second half
+func TestChecksumSinkMemory(t *testing.T) {
+	cfg := fcompare.PartialChecksumConfig{ChunkSize: 4096, FullThreshold: 3 * 4096}
+	s, err := NewChecksumSinkConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := randomTestData(4, 1<<20)
+	for written := 0; written < len(data); written += 1000 {
+		s.Write(data[written:min(written+1000, len(data))])
+		if int64(len(s.firstBlock)) > cfg.ChunkSize || int64(len(s.last)) != cfg.ChunkSize {
+			t.Fatalf("%d bytes kept of the first block, %d of the last", len(s.firstBlock), len(s.last))
+		}
+		if max := s.written/2/cfg.ChunkSize + 2; int64(len(s.candidates)) > max {
+			t.Fatalf("after %d bytes: %d candidates, want at most %d", s.written, len(s.candidates), max)
+		}
+	}
+}
+
+// With the size up front, no data is kept at all
+func TestChecksumSinkSizeMemory(t *testing.T) {
+	cfg := fcompare.PartialChecksumConfig{ChunkSize: 4096, FullThreshold: 3 * 4096}
+	data := randomTestData(5, 1<<20)
+	s, err := NewChecksumSinkSize(int64(len(data)), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for written := 0; written < len(data); written += 1000 {
+		s.Write(data[written:min(written+1000, len(data))])
+		if len(s.firstBlock) > 0 || len(s.last) > 0 || len(s.candidates) > 0 {
+			t.Fatalf("after %d bytes: %d bytes of the first block, %d of the last, %d candidates",
+				s.written, len(s.firstBlock), len(s.last), len(s.candidates))
+		}
+	}
+}
+
+func TestChecksumSinkSizeMismatch(t *testing.T) {
+	s := NewChecksumSink(fcompare.HashMD5)
+	s.Write([]byte("abc"))
+	if _, err := s.Finalize(4); !errors.Is(err, ErrSizeMismatch) {
+		t.Errorf("Finalize with the wrong size: %v", err)
+	}
+	if _, err := NewChecksumSinkConfig(fcompare.PartialChecksumConfig{ChunkSize: 10, FullThreshold: 20}); err == nil {
+		t.Error("no error for a threshold below 3 chunks")
+	}
+	s, err := NewChecksumSinkSize(3, fcompare.DefaultPartialChecksumConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Write([]byte("abcd"))
+	if _, err := s.Finalize(4); !errors.Is(err, ErrSizeMismatch) {
+		t.Errorf("Finalize after more data than the size: %v", err)
+	}
+}
+
+// randomTestData returns n pseudo-random bytes, the same for the same seed
+func randomTestData(seed int64, n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
@@ -0,0 +1,179 @@
+package msinfo
+
+// walk.go - Walk directory trees, with guard rails against unintended scopes
+// A typo like '/ archive' instead of '/archive' can start a walk of the whole
+// root filesystem, including /proc and /sys, where reading pseudo-files can hang.
+// So every walk:
+//   - skips pseudo-filesystems (proc, sysfs, devfs, cgroup, ...), unless AllowPseudoFS is set
+//   - refuses to walk / or a home directory with more than WalkGuardFiles files, unless YesReally is set
+//   - only returns regular files, so devices are never read
+//   - skips directories that are too deep or have too many entries, and paths that
+//     are too long, so a runaway directory tree can't stop or stall the walk
+//
+// Symlinks to directories are not followed, so a walk can't loop.
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Reasons that WalkFiles passes to WalkConfig.Skip
+const (
+	SkipPseudoFS   = "pseudo-fs"       // a directory on a pseudo-filesystem, without AllowPseudoFS
+	SkipMaxDepth   = "max-depth"       // a directory more than MaxDepth levels below the root
+	SkipPathLength = "max-path-length" // a file or directory with a path longer than MaxPathLength
+	SkipDirEntries = "max-dir-entries" // a directory with more than MaxDirEntries entries
+)
+
+// WalkGuardFiles is the number of files in / or a home directory above which
+// WalkConfig.YesReally is needed
+const WalkGuardFiles = 100000
+
+// WalkConfig holds the settings of WalkFiles
+type WalkConfig struct {
+	AllowPseudoFS bool // walk pseudo-filesystems such as /proc
+	YesReally     bool // walk / or a home directory, however many files it has
+	// If KeepGoing is set, directories below the root that can't be read are
+	// passed to SkipDir (if not nil) and skipped, instead of stopping the walk
+	KeepGoing bool
+	SkipDir   func(path string, err error)
+	// Skip, if not nil, is called for each directory or file that is skipped on
+	// purpose, with the reason, e.g. SkipPseudoFS
+	Skip func(path string, reason string)
+	// Limits of the walk; 0 is no limit. The root is at depth 0, and the length
+	// of a path is in bytes.
+	MaxDepth      int
+	MaxPathLength int
+	MaxDirEntries int
+}
+
+// DefaultMaxDepth is the MaxDepth of msfile -r
+const DefaultMaxDepth = 128
+
+// BroadRootError is returned by WalkFiles for / or a home directory with
+// more than WalkGuardFiles files
+type BroadRootError struct {
+	Root string
+}
+
+func (e *BroadRootError) Error() string {
+	return fmt.Sprintf("%s contains more than %d files", e.Root, WalkGuardFiles)
+}
+
+// isBroadRoot reports whether root is / or a home directory
+func isBroadRoot(root string) bool {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return false
+	}
+	if abs == filepath.VolumeName(abs)+string(filepath.Separator) {
+		return true
+	}
+	if home, err := os.UserHomeDir(); err == nil && abs == filepath.Clean(home) {
+		return true
+	}
+	return false
+}
+
+// countFilesUpTo counts the files under root, but stops counting at limit
+func countFilesUpTo(root string, limit int, allowPseudoFS bool) int {
+	n := 0
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() && path != root && !allowPseudoFS && isPseudoFS(path) {
+			return filepath.SkipDir
+		}
+		n++
+		if n >= limit {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return n
+}
+
+// WalkFiles calls fn for each regular file under root, in lexical order
+func WalkFiles(root string, cfg WalkConfig, fn func(path string) error) error {
+	if !cfg.YesReally && isBroadRoot(root) && countFilesUpTo(root, WalkGuardFiles, cfg.AllowPseudoFS) >= WalkGuardFiles {
+		return &BroadRootError{Root: root}
+	}
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if cfg.KeepGoing && path != root && d != nil && d.IsDir() {
+				if cfg.SkipDir != nil {
+					cfg.SkipDir(path, err)
+				}
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if reason := cfg.skipReason(root, path, d); reason != "" {
+			if cfg.Skip != nil {
+				cfg.Skip(path, reason)
+			}
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		return fn(path)
+	})
+}
+
+// skipReason returns why path is skipped, or "" if it isn't
+func (cfg WalkConfig) skipReason(root, path string, d fs.DirEntry) string {
+	if cfg.MaxPathLength > 0 && len(path) > cfg.MaxPathLength {
+		return SkipPathLength
+	}
+	if !d.IsDir() {
+		return ""
+	}
+	if !cfg.AllowPseudoFS && isPseudoFS(path) {
+		return SkipPseudoFS
+	}
+	if cfg.MaxDepth > 0 && depth(root, path) > cfg.MaxDepth {
+		return SkipMaxDepth
+	}
+	if cfg.MaxDirEntries > 0 && hasMoreEntries(path, cfg.MaxDirEntries) {
+		return SkipDirEntries
+	}
+	return ""
+}
+
+// depth returns the number of directory levels of path below root
+func depth(root, path string) int {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." {
+		return 0
+	}
+	return strings.Count(rel, string(filepath.Separator)) + 1
+}
+
+// hasMoreEntries reports whether directory dir has more than limit entries,
+// without reading more than needed to tell. A directory that can't be read is
+// left to the walk, which reports the error.
+func hasMoreEntries(dir string, limit int) bool {
+	f, err := os.Open(dir)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	n := 0
+	for n <= limit {
+		names, err := f.Readdirnames(min(limit+1-n, 1024))
+		n += len(names)
+		if err == io.EOF || (err != nil && len(names) == 0) {
+			break
+		}
+	}
+	return n > limit
+}
@@ -0,0 +1,78 @@
+package msinfo
+
+// zstd.go - Read zstd-compressed files
+// Archived mzML is often recompressed with zstd, which compresses better than gzip.
+// A zstd file is a sequence of frames; skippable frames hold metadata of the
+// application that wrote them, and are ignored by the decoder.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"unicode/utf8"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// Skippable frames have a magic number from 0x184D2A50 to 0x184D2A5F
+const (
+	zstdSkippableMagic = 0x184d2a50
+	zstdSkippableMask  = 0xfffffff0
+)
+
+// Maximum size of the metadata from skippable frames that is kept
+const maxZstdMetadata = 4096
+
+// IsZstd reports whether head is the start of a zstd file, with a compressed
+// or a skippable frame
+func IsZstd(head []byte) bool {
+	return bytes.HasPrefix(head, zstdMagic) || isZstdSkippable(head)
+}
+
+func isZstdSkippable(head []byte) bool {
+	return len(head) >= 4 && binary.LittleEndian.Uint32(head)&zstdSkippableMask == zstdSkippableMagic
+}
+
+// NewZstdReader returns a decoder of the frames of a zstd file. It checks the
+// content checksums of the frames that have one, and skips skippable frames.
+// The decoder must be closed.
+func NewZstdReader(r io.Reader) (*zstd.Decoder, error) {
+	return zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(maxZstdWindow))
+}
+
+// ReadZstdMetadata reads the skippable frames at the start of a zstd file, and
+// returns their content: as text if it is UTF-8, in hex otherwise. At most 4 KB
+// is returned; the rest is skipped.
+func ReadZstdMetadata(r io.Reader) (string, error) {
+	var meta []byte
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return "", err
+		}
+		if !isZstdSkippable(header) {
+			break
+		}
+		size := int64(binary.LittleEndian.Uint32(header[4:]))
+		keep := min(size, int64(maxZstdMetadata-len(meta)))
+		start := len(meta)
+		meta = append(meta, make([]byte, keep)...)
+		if _, err := io.ReadFull(r, meta[start:]); err != nil {
+			return "", fmt.Errorf("truncated skippable frame: %w", err)
+		}
+		if n, err := io.CopyN(io.Discard, r, size-keep); err != nil {
+			return "", fmt.Errorf("truncated skippable frame after %d bytes: %w", keep+n, err)
+		}
+	}
+	if utf8.Valid(meta) {
+		return string(meta), nil
+	}
+	return hex.EncodeToString(meta), nil
+}
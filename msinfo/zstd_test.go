@@ -0,0 +1,109 @@
+package msinfo
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdData compresses data into one zstd frame with a content checksum,
+// after a skippable frame with meta if it isn't empty
+func zstdData(t testing.TB, meta string, data []byte) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if meta != "" {
+		b.Write(binary.LittleEndian.AppendUint32(nil, zstdSkippableMagic+3))
+		b.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(meta))))
+		b.WriteString(meta)
+	}
+	zw, err := zstd.NewWriter(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestZstdFormat(t *testing.T) {
+	dir := t.TempDir()
+	mzml := testMzML(true, 1, 2, 2)
+	for _, meta := range []string{"", `{"source":"a.mzML"}`} {
+		fn := writeTestFile(t, dir, "a.mzML.zst", zstdData(t, meta, mzml))
+		if format, err := DetectFormat(fn); err != nil || format != FormatZstd {
+			t.Errorf("meta %q: DetectFormat = %s, %v, want %s", meta, format, err, FormatZstd)
+		}
+		if format, err := DetectContentFormatCtx(context.Background(), fn); err != nil || format != FormatMzML {
+			t.Errorf("meta %q: DetectContentFormatCtx = %s, %v, want %s", meta, format, err, FormatMzML)
+		}
+		h, err := ReadMzMLHeader(fn)
+		if err != nil || h.RunID != "run1" {
+			t.Errorf("meta %q: ReadMzMLHeader = %+v, %v", meta, h, err)
+		}
+		c, err := CountScans(fn, FormatMzML)
+		if err != nil || c.Spectra != 3 || c.ByLevel[2] != 2 {
+			t.Errorf("meta %q: CountScans = %+v, %v, want 3 spectra", meta, c, err)
+		}
+	}
+}
+
+// The content format is sniffed from the decompressed data, not only the name
+func TestDetectContentFormat(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"x.gz", gzipData([]byte(testMGF)), FormatMGF},
+		{"x.zst", zstdData(t, "", []byte(testMzXML)), FormatMzXML},
+		{"x.fasta.zst", zstdData(t, "", []byte("12345\n")), FormatFASTA},
+		{"x.mgf", []byte(testMGF), FormatMGF},
+	} {
+		fn := writeTestFile(t, dir, tc.name, tc.data)
+		if format, err := DetectContentFormatCtx(context.Background(), fn); err != nil || format != tc.want {
+			t.Errorf("%s: DetectContentFormatCtx = %s, %v, want %s", tc.name, format, err, tc.want)
+		}
+	}
+}
+
+func TestReadZstdMetadata(t *testing.T) {
+	for _, tc := range []struct {
+		meta string
+		want string
+	}{
+		{"", ""},
+		{"instrument=Orbitrap", "instrument=Orbitrap"},
+		{"\xff\x00", "ff00"},
+		{string(bytes.Repeat([]byte("m"), 2*maxZstdMetadata)), string(bytes.Repeat([]byte("m"), maxZstdMetadata))},
+	} {
+		data := zstdData(t, tc.meta, []byte("content"))
+		got, err := ReadZstdMetadata(bytes.NewReader(data))
+		if err != nil || got != tc.want {
+			t.Errorf("ReadZstdMetadata of %d bytes = %d bytes, %v, want %d bytes", len(tc.meta), len(got), err, len(tc.want))
+		}
+	}
+	// A skippable frame that is shorter than its size
+	data := zstdData(t, "metadata", nil)
+	if _, err := ReadZstdMetadata(bytes.NewReader(data[:12])); err == nil {
+		t.Error("ReadZstdMetadata of a truncated frame: no error")
+	}
+}
+
+func TestContentName(t *testing.T) {
+	for name, want := range map[string]string{
+		"a.mzML.zst": "a.mzML",
+		"a.mgf.GZ":   "a.mgf",
+		"a.mzML":     "a.mzML",
+		"zst":        "zst",
+	} {
+		if got := ContentName(name); got != want {
+			t.Errorf("ContentName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+// mzml.go - Report the instrument, software and run start time of mzML files
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/524D/msfile/msinfo"
+)
+
+// contentFormat returns the detected format of a file, or for a compressed
+// file, the format of its content
+func contentFormat(inf FileInfo) string {
+	if format, ok := inf.Properties[msinfo.PropContentFormat]; ok {
+		return format
+	}
+	return inf.Properties[msinfo.PropFormat]
+}
+
+// hasMzMLHeader reports whether a file is mzML or imzML, possibly compressed
+func hasMzMLHeader(inf FileInfo) bool {
+	format := contentFormat(inf)
+	return format == msinfo.FormatMzML || format == msinfo.FormatImzML
+}
+
+// setMzMLProperties reads the header of an mzML file, and sets the properties
+// for the metadata that it contains. Invalid XML is reported as an error of the
+// file, but doesn't stop the run.
+func setMzMLProperties(inf *FileInfo) error {
+	h, err := msinfo.ReadMzMLHeaderCtx(readCtx, inf.Filename)
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		return err
+	}
+	if err != nil {
+		setFileError(inf, ErrCodeParse, "invalid mzML header: "+err.Error())
+	}
+	if len(h.InstrumentModels) > 0 {
+		inf.Properties[msinfo.PropInstrumentModel] = strings.Join(h.InstrumentModels, ", ")
+	}
+	if len(h.Software) > 0 {
+		inf.Properties[msinfo.PropSoftware] = strings.Join(h.Software, ", ")
+	}
+	if h.RunStartTime != "" {
+		inf.Properties[msinfo.PropRunStartTime] = h.RunStartTime
+	}
+	return nil
+}
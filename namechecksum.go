@@ -0,0 +1,114 @@
+package main
+
+// namechecksum.go - Verify files against a checksum embedded in their name
+// Some public datasets put the digest of each file in its name, e.g.
+// sample_A_md5-9e107d9d372bb6826bd81d3542a419d6.mzML. With -checksum-from-name,
+// the digest is extracted from the base name of each file with a regular
+// expression, and the content of the file is verified against it.
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/524D/msfile/fcompare"
+	"github.com/524D/msfile/msinfo"
+)
+
+// Values of msinfo.PropNameChecksum
+const (
+	nameChecksumMatch     = "match"
+	nameChecksumMismatch  = "mismatch"
+	nameChecksumNoPattern = "no-pattern"
+)
+
+// Hash algorithms for -checksum-from-name, by name and by digest length in hex
+var nameChecksumAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
+var nameChecksumAlgorithmsByLength = map[int]string{
+	32: "md5",
+	40: "sha1",
+	64: "sha256",
+}
+
+// The compiled -checksum-from-name pattern, and the index of the group with the digest
+var nameChecksumPattern *regexp.Regexp
+var nameChecksumGroup int
+
+// Number of files per outcome of -checksum-from-name
+var nameChecksumCounts = make(map[string]int)
+
+// compileNameChecksumPattern compiles the -checksum-from-name pattern.
+// The digest is taken from the group named "digest", or from the only group.
+func compileNameChecksumPattern(pattern string, algorithm string) error {
+	if algorithm != "auto" && nameChecksumAlgorithms[algorithm] == nil {
+		return usageError("Invalid -checksum-from-name-algo %q, must be auto, md5, sha1 or sha256", algorithm)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return usageError("Invalid -checksum-from-name pattern: %v", err)
+	}
+	group := re.SubexpIndex("digest")
+	if group < 0 {
+		if re.NumSubexp() != 1 {
+			return usageError("-checksum-from-name pattern needs a group named 'digest', e.g. (?P<digest>[0-9a-f]{32})")
+		}
+		group = 1
+	}
+	nameChecksumPattern, nameChecksumGroup = re, group
+	return nil
+}
+
+// verifyNameChecksum verifies a file against the digest in its name,
+// and adds the result to fileinfo. It returns false on a mismatch.
+func verifyNameChecksum(fileinfo *FileInfo, algorithm string) (bool, error) {
+	m := nameChecksumPattern.FindStringSubmatch(filepath.Base(fileinfo.Filename))
+	if m == nil || m[nameChecksumGroup] == "" {
+		fileinfo.Properties[msinfo.PropNameChecksum] = nameChecksumNoPattern
+		nameChecksumCounts[nameChecksumNoPattern]++
+		return true, nil
+	}
+	expected := strings.ToLower(m[nameChecksumGroup])
+	if !isHex(expected) {
+		return false, &FileError{Code: ErrCodeParse, Message: "digest in file name is not hexadecimal: " + expected, Path: fileinfo.Filename}
+	}
+	if algorithm == "auto" {
+		algorithm = nameChecksumAlgorithmsByLength[len(expected)]
+		if algorithm == "" {
+			return false, &FileError{Code: ErrCodeParse, Message: fmt.Sprintf("can't tell the algorithm of a digest of %d hex digits in the file name; use -checksum-from-name-algo", len(expected)), Path: fileinfo.Filename}
+		}
+	}
+	h := nameChecksumAlgorithms[algorithm]()
+	if h.Size()*2 != len(expected) {
+		return false, &FileError{Code: ErrCodeParse, Message: fmt.Sprintf("digest in file name has %d hex digits, expected %d for %s", len(expected), h.Size()*2, algorithm), Path: fileinfo.Filename}
+	}
+
+	f, err := fcompare.OpenObserved(readCtx, fileinfo.Filename)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return false, err
+	}
+	fileinfo.Properties[msinfo.PropNameChecksumAlgorithm] = algorithm
+	if hex.EncodeToString(h.Sum(nil)) != expected {
+		fileinfo.Properties[msinfo.PropNameChecksum] = nameChecksumMismatch
+		nameChecksumCounts[nameChecksumMismatch]++
+		setFileError(fileinfo, ErrCodeChecksumMismatch, algorithm+" checksum doesn't match "+expected+" from the file name")
+		return false, nil
+	}
+	fileinfo.Properties[msinfo.PropNameChecksum] = nameChecksumMatch
+	nameChecksumCounts[nameChecksumMatch]++
+	return true, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/524D/msfile/msfileio"
+	"github.com/524D/msfile/msinfo"
+)
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestChecksumFromName(t *testing.T) {
+	dir := t.TempDir()
+	match := "sample_A_md5-" + md5Hex("A") + ".mzML"
+	mismatch := "sample_B_md5-" + md5Hex("not B") + ".mzML"
+	sha := "sample_C_sha256-" + sha256Hex("C") + ".mzML"
+	writeTestFile(t, dir, match, []byte("A"))
+	writeTestFile(t, dir, mismatch, []byte("B"))
+	writeTestFile(t, dir, sha, []byte("C"))
+	writeTestFile(t, dir, "sample_D.mzML", []byte("D"))
+
+	stdout, stderr, code := runMsfile(t, dir, nil, "-checksum-from-name", `_(?:md5|sha256)-(?P<digest>[0-9a-f]+)\.`, "-format", "ndjson",
+		match, mismatch, sha, "sample_D.mzML")
+	if code != 1 {
+		t.Errorf("exit code %d, want 1: %s", code, stderr)
+	}
+	if !strings.Contains(stderr, "Checksums from file names: 2 match, 1 mismatch, 1 without digest in name") {
+		t.Errorf("summary:\n%s", stderr)
+	}
+	want := map[string]string{match: "match md5", mismatch: "mismatch md5", sha: "match sha256", "sample_D.mzML": "no-pattern "}
+	for _, inf := range readLines[msfileio.FileInfo](t, "ndjson", stdout) {
+		got := inf.Properties[msinfo.PropNameChecksum] + " " + inf.Properties[msinfo.PropNameChecksumAlgorithm]
+		if got != want[inf.Filename] {
+			t.Errorf("%s: %q, want %q", inf.Filename, got, want[inf.Filename])
+		}
+		if (inf.Error != nil && inf.Error.Code == ErrCodeChecksumMismatch) != (inf.Filename == mismatch) {
+			t.Errorf("%s: error %v", inf.Filename, inf.Error)
+		}
+	}
+
+	// Another name of the same file is checked against its own digest
+	link := "link_md5-" + md5Hex("B") + ".mzML"
+	if os.Link(filepath.Join(dir, mismatch), filepath.Join(dir, link)) == nil {
+		_, stderr, _ := runMsfile(t, dir, nil, "-checksum-from-name", `_md5-([0-9a-f]{32})\.`, mismatch, link)
+		if !strings.Contains(stderr, "Checksums from file names: 1 match, 1 mismatch, 0 without digest in name") {
+			t.Errorf("hard link, summary:\n%s", stderr)
+		}
+	}
+}
+
+func TestChecksumFromNameInvalid(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "a.mzML", []byte("a"))
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-checksum-from-name", `_(md5-[0-9a-f`}, "Invalid -checksum-from-name pattern"},
+		{[]string{"-checksum-from-name", `_(md5)-([0-9a-f]{32})`}, "needs a group named 'digest'"},
+		{[]string{"-checksum-from-name", `_([0-9a-f]{32})`, "-checksum-from-name-algo", "crc32"}, "Invalid -checksum-from-name-algo"},
+	} {
+		_, stderr, code := runMsfile(t, dir, nil, append(tc.args, "a.mzML")...)
+		if code == 0 || !strings.Contains(stderr, tc.want) {
+			t.Errorf("%v: exit code %d, %s", tc.args, code, stderr)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+// output.go - Write the records of files in the -format that was asked for
+//   human:  Go syntax, one record per line (the default)
+//   ndjson: one JSON object per line, written as soon as a file is done (also -json)
+//   json:   a single JSON array of all records, complete when the run ends
+//   csv/tsv: a table with a header row, quoted with the rules of encoding/csv;
+//           the properties are a JSON object in a single cell
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+var outputFormats = []string{"human", "ndjson", "json", "csv", "tsv"}
+
+// Columns of -format csv and tsv
+var tableHeader = []string{"filename", "size", "atime", "mtime", "partial_checksum", "full_checksum", "properties", "error", "skip_reason"}
+
+// Writer of -format csv and tsv, created with the header row
+var tableWriter *csv.Writer
+
+// Number of records written so far
+var recordsWritten int
+
+// Set when the output is complete, see closeOutput
+var outputClosed bool
+
+// writeRecord writes the record of a file, or of a skipped file
+func writeRecord(v any) {
+	switch par.format {
+	case "ndjson", "json":
+		j, err := json.Marshal(v)
+		if err != nil {
+			fatal(codedError("", err))
+		}
+		if par.format == "json" {
+			if recordsWritten == 0 {
+				fmt.Print("[\n")
+			} else {
+				fmt.Print(",\n")
+			}
+			fmt.Print(string(j))
+		} else {
+			fmt.Println(string(j))
+		}
+	case "csv", "tsv":
+		writeTableRow(v)
+	default:
+		fmt.Printf("%+v\n", v)
+	}
+	recordsWritten++
+}
+
+// startTable creates the writer of -format csv or tsv, and writes the header row
+func startTable() {
+	tableWriter = csv.NewWriter(os.Stdout)
+	if par.format == "tsv" {
+		tableWriter.Comma = '\t'
+	}
+	tableWriter.Write(tableHeader)
+}
+
+// writeTableRow writes a record as a row of -format csv or tsv
+func writeTableRow(v any) {
+	if tableWriter == nil {
+		startTable()
+	}
+	var row []string
+	switch r := v.(type) {
+	case FileInfo:
+		props, err := json.Marshal(r.Properties)
+		if err != nil {
+			fatal(codedError("", err))
+		}
+		code := ""
+		if r.Error != nil {
+			code = r.Error.Code
+		}
+		row = []string{r.Filename, strconv.FormatInt(r.Size, 10), strconv.FormatInt(r.Atime, 10), strconv.FormatInt(r.Mtime, 10),
+			r.PartialChecksum, r.FullChecksum, string(props), code, ""}
+	case skippedRecord:
+		row = []string{r.Filename, "", "", "", "", "", "", "", r.SkipReason}
+	default:
+		fatal(&FileError{Code: ErrCodeInternal, Message: fmt.Sprintf("no table row for %T", v)})
+	}
+	// Rows are flushed right away, so the table can be read while msfile runs
+	tableWriter.Write(row)
+	tableWriter.Flush()
+	if err := tableWriter.Error(); err != nil {
+		fatal(codedError(ErrCodeIOWrite, err))
+	}
+}
+
+// closeOutput ends the JSON array of -format json, and writes the header of an empty table.
+// It is also called by fatal, so it does nothing the second time.
+func closeOutput() {
+	if outputClosed {
+		return
+	}
+	outputClosed = true
+	if (par.format == "csv" || par.format == "tsv") && tableWriter == nil {
+		startTable()
+		tableWriter.Flush()
+	}
+	if par.format != "json" {
+		return
+	}
+	if recordsWritten == 0 {
+		fmt.Println("[]")
+	} else {
+		fmt.Print("\n]\n")
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/524D/msfile/msinfo"
+)
+
+// The JSON array of -format json is closed when the run stops with an error
+func TestJSONArrayClosedOnFatal(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "a.mgf", []byte("BEGIN IONS\nEND IONS\n"))
+	stdout, stderr, code := runMsfile(t, dir, nil, "-format", "json", "a.mgf", "missing.mzML")
+	if code == 0 {
+		t.Fatalf("exit code 0 for a missing file: %s", stderr)
+	}
+	var infs []FileInfo
+	if err := json.Unmarshal([]byte(stdout), &infs); err != nil {
+		t.Fatalf("output isn't a JSON array: %v\n%s", err, stdout)
+	}
+	if len(infs) != 1 || infs[0].Filename != "a.mgf" {
+		t.Errorf("records %+v, want a.mgf", infs)
+	}
+	// Without records, there is no array to close
+	stdout, _, _ = runMsfile(t, dir, nil, "-format", "json", "missing.mzML")
+	if stdout != "" {
+		t.Errorf("output %q, want none", stdout)
+	}
+}
+
+// With the partial method, the chunk checksums are recorded, and -compare tells which differ
+func TestPartialChecksumChunks(t *testing.T) {
+	dir := t.TempDir()
+	const size = 20 << 20
+	for _, name := range []string{"a.mzML", "b.mzML"} {
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Truncate(size); err == nil && name == "b.mzML" {
+			_, err = f.WriteAt([]byte("index"), size-1000)
+		}
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	stdout, stderr, code := runMsfile(t, dir, nil, "-checksums", "-comparemethod", "partial", "-format", "ndjson", "a.mzML", "b.mzML")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	var infs [2]FileInfo
+	for i, line := range strings.SplitN(strings.TrimSpace(stdout), "\n", 2) {
+		if err := json.Unmarshal([]byte(line), &infs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range []string{msinfo.PropPartialChecksumHead, msinfo.PropPartialChecksumMiddle, msinfo.PropPartialChecksumTail} {
+		v1, v2 := infs[0].Properties[key], infs[1].Properties[key]
+		if v1 == "" || (v1 != v2) != (key == msinfo.PropPartialChecksumTail) {
+			t.Errorf("%s: %q and %q", key, v1, v2)
+		}
+	}
+	stdout, _, _ = runMsfile(t, dir, nil, "-compare", "-comparemethod", "partial", "a.mzML", "b.mzML")
+	if !strings.Contains(stdout, "Files are different (differing chunks: tail)") {
+		t.Errorf("-compare: %s", stdout)
+	}
+}
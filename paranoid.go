@@ -0,0 +1,22 @@
+package main
+
+// paranoid.go - Guard the readers of file content against crafted files
+// With -paranoid, a panic while extracting information from the content of a
+// file (encoding detection, gzip verification) becomes a per-file E_PARSE error,
+// so a single malicious upload can't stop the processing of all other files.
+
+import "fmt"
+
+// guarded runs extract, an extractor of information from the content of a file.
+// With -paranoid, a panic in extract is recorded as a parse error of the file.
+func guarded(fileinfo *FileInfo, what string, extract func() error) (err error) {
+	if par.paranoid {
+		defer func() {
+			if r := recover(); r != nil {
+				setFileError(fileinfo, ErrCodeParse, fmt.Sprintf("%s failed: %v", what, r))
+				err = nil
+			}
+		}()
+	}
+	return extract()
+}
@@ -0,0 +1,116 @@
+package main
+
+// policy.go - Choose the compare method per file, based on its name
+// Rules are given with the repeatable -method-for flag as 'pattern=method',
+// e.g. -method-for '*.tdf_bin=partial-adaptive' -method-for '*.mzML=full'.
+// The pattern 'default' sets the method for files that match no other rule.
+// Patterns use filepath.Match syntax, and are matched against the base name of
+// the file, unless they contain a path separator, in which case they are
+// matched against the whole path.
+// When more than one pattern matches, the most specific one wins: the pattern
+// with the most literal (non-wildcard) characters. If that is still a tie,
+// the rule given last wins.
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+var validMethods = []string{"partial", "partial-adaptive", "size", "full", "bytes"}
+
+type methodRule struct {
+	pattern string
+	method  string
+}
+
+// methodPolicy is a flag.Value, so that -method-for can be repeated
+type methodPolicy struct {
+	rules []methodRule
+}
+
+func (p *methodPolicy) String() string {
+	if p == nil {
+		return ""
+	}
+	var s []string
+	for _, r := range p.rules {
+		s = append(s, r.pattern+"="+r.method)
+	}
+	return strings.Join(s, ",")
+}
+
+func (p *methodPolicy) Set(value string) error {
+	pattern, method, ok := strings.Cut(value, "=")
+	if !ok || pattern == "" {
+		return errors.New("rule must have the form 'pattern=method'")
+	}
+	if !isValidMethod(method) {
+		return fmt.Errorf("invalid method %q, must be one of %s", method, strings.Join(validMethods, ", "))
+	}
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+	}
+	p.rules = append(p.rules, methodRule{pattern, method})
+	return nil
+}
+
+func isValidMethod(method string) bool {
+	for _, m := range validMethods {
+		if method == m {
+			return true
+		}
+	}
+	return false
+}
+
+// specificity returns the number of literal characters in a pattern
+func specificity(pattern string) int {
+	n := 0
+	escaped := false
+	inClass := false
+	for _, c := range pattern {
+		switch {
+		case escaped:
+			escaped = false
+			n++
+		case c == '\\':
+			escaped = true
+		case inClass:
+			inClass = c != ']'
+		case c == '[':
+			inClass = true
+		case c != '*' && c != '?':
+			n++
+		}
+	}
+	return n
+}
+
+// methodFor returns the compare method for a file, and the rule that selected it.
+// Without a matching rule, the -comparemethod method is used.
+func (p *methodPolicy) methodFor(path string) (method string, rule string) {
+	method, rule = par.method, "-comparemethod"
+	best := -1
+	for _, r := range p.rules {
+		if r.pattern == "default" {
+			if best < 0 {
+				method, rule = r.method, r.pattern+"="+r.method
+			}
+			continue
+		}
+		name := filepath.Base(path)
+		if strings.ContainsRune(r.pattern, filepath.Separator) {
+			name = path
+		}
+		if ok, _ := filepath.Match(r.pattern, name); !ok {
+			continue
+		}
+		if s := specificity(r.pattern); s >= best {
+			best = s
+			method, rule = r.method, r.pattern+"="+r.method
+		}
+	}
+	return method, rule
+}
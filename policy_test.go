@@ -0,0 +1,101 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSpecificity(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		want    int
+	}{
+		{"*", 0},
+		{"*.raw", 4},
+		{"*.tdf_bin", 8},
+		{"sample_??.raw", 11},
+		{"[abc]*.mzML", 5},
+		{`\*.raw`, 5},
+		{"data/*.mzML", 10},
+	} {
+		if got := specificity(tc.pattern); got != tc.want {
+			t.Errorf("specificity(%q) = %d, want %d", tc.pattern, got, tc.want)
+		}
+	}
+}
+
+// The most specific matching rule wins, whatever the order of the rules;
+// of equally specific rules, the last one
+func TestMethodFor(t *testing.T) {
+	withPar(t, func(p *params) { p.method = "partial" })
+	rules := []string{
+		"*.mzML=full",
+		"default=size",
+		"*.d=bytes",
+		"*_QC.mzML=partial-adaptive",
+		filepath.FromSlash("archive/*.mzML") + "=bytes",
+		"run0?.raw=full",
+		"run*.raw=partial",
+		"*.wiff=full",
+		"*.wiff=bytes",
+	}
+	for _, tc := range []struct {
+		path   string
+		method string
+		rule   string
+	}{
+		{"sample.mzML", "full", "*.mzML=full"},
+		{"sample_QC.mzML", "partial-adaptive", "*_QC.mzML=partial-adaptive"},
+		{filepath.FromSlash("archive/sample.mzML"), "bytes", filepath.FromSlash("archive/*.mzML") + "=bytes"},
+		{filepath.FromSlash("other/archive/sample.mzML"), "full", "*.mzML=full"},
+		{"run01.raw", "full", "run0?.raw=full"},
+		{"run001.raw", "partial", "run*.raw=partial"},
+		{"a.wiff", "bytes", "*.wiff=bytes"},
+		{"notes.txt", "size", "default=size"},
+	} {
+		for _, order := range []string{"given", "reversed"} {
+			var p methodPolicy
+			for i := range rules {
+				r := rules[i]
+				if order == "reversed" {
+					r = rules[len(rules)-1-i]
+				}
+				if err := p.Set(r); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want := tc.rule
+			// Of the equally specific *.wiff rules, the last one wins
+			if order == "reversed" && tc.path == "a.wiff" {
+				want = "*.wiff=full"
+			}
+			method, rule := p.methodFor(tc.path)
+			if rule != want || !strings.HasSuffix(want, "="+method) {
+				t.Errorf("rules %s, %s: %s by %s, want %s", order, tc.path, method, rule, want)
+			}
+		}
+	}
+
+	// Without a default rule, -comparemethod applies
+	var p methodPolicy
+	p.Set("*.mzML=full")
+	if method, rule := p.methodFor("notes.txt"); method != "partial" || rule != "-comparemethod" {
+		t.Errorf("no matching rule: %s by %s", method, rule)
+	}
+}
+
+func TestMethodPolicySet(t *testing.T) {
+	for _, value := range []string{"*.mzML", "=full", "*.mzML=fast", "[*.mzML=full"} {
+		var p methodPolicy
+		if err := p.Set(value); err == nil {
+			t.Errorf("Set(%q): no error", value)
+		}
+	}
+	var p methodPolicy
+	p.Set("*.mzML=full")
+	p.Set("default=size")
+	if got := p.String(); got != "*.mzML=full,default=size" {
+		t.Errorf("String() = %q", got)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+// precomputed.go - Reuse checksums that were computed by other tools
+// Checksums can be read from sha256sum output, or from msfile's own JSON output.
+// JSON records contain the size and mtime of the file at the time the checksum
+// was computed, so they are only trusted if the file still has the same size and mtime.
+// sha256sum output has no such snapshot, so it is only used with -precomputed-trust-always.
+// Checksums are only used if they were computed with the hash algorithm of the run,
+// and partial checksums only if they were computed with the same chunks.
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/524D/msfile/fcompare"
+)
+
+// Label that marks adaptive partial checksums after the name of the hash algorithm,
+// see fcompare.GetAdaptivePartialChecksum
+const adaptiveChecksumLabel = "-adaptive-v"
+
+type precomputedEntry struct {
+	size            int64
+	mtime           int64
+	hasSnapshot     bool // size and mtime are known
+	partialChecksum string
+	fullChecksum    string
+	algo            string                         // name of the hash algorithm
+	partialConfig   fcompare.PartialChecksumConfig // zero if not known
+}
+
+// precomputedSums holds precomputed checksums, keyed by absolute path
+type precomputedSums map[string]precomputedEntry
+
+// The precomputed checksums of the current run, nil if none were given
+var precomputed precomputedSums
+
+// readPrecomputed reads a file with precomputed checksums.
+// Each line is either a JSON record as printed by msfile -json,
+// or a line in sha256sum format ("<hex digest>  <path>", or "<hex digest> *<path>").
+// Paths may use the GNU coreutils escaping, be percent-encoded, or be file:// URLs.
+// Relative paths are interpreted relative to the current directory, like sha256sum -c does.
+func readPrecomputed(fn string) (precomputedSums, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sums := make(precomputedSums)
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var path string
+		var entry precomputedEntry
+		if strings.HasPrefix(text, "{") {
+			var inf FileInfo
+			if err := json.Unmarshal([]byte(text), &inf); err != nil {
+				return nil, &FileError{Code: ErrCodeParse, Message: fmt.Sprintf("line %d: %v", line, err), Path: fn}
+			}
+			path = inf.Filename
+			entry = precomputedEntry{size: inf.Size, mtime: inf.Mtime, hasSnapshot: true,
+				partialChecksum: inf.PartialChecksum, fullChecksum: inf.FullChecksum, algo: inf.HashAlgo,
+				partialConfig: fcompare.PartialChecksumConfig{ChunkSize: inf.ChunkSize, FullThreshold: inf.FullThreshold}}
+			// Records only have a hash algorithm if it isn't sha256
+			if entry.algo == "" {
+				entry.algo = fcompare.HashSHA256.String()
+			}
+		} else {
+			// GNU coreutils marks lines with escaped file names with a leading backslash
+			escaped := strings.HasPrefix(text, "\\")
+			if escaped {
+				text = text[1:]
+			}
+			sum, name, ok := strings.Cut(text, " ")
+			if !ok || len(sum) != 64 || !isHex(sum) {
+				return nil, &FileError{Code: ErrCodeParse, Message: fmt.Sprintf("line %d: not in sha256sum format", line), Path: fn}
+			}
+			// A space or '*' (binary mode) separates the digest from the path
+			if len(name) == 0 || (name[0] != ' ' && name[0] != '*') {
+				return nil, &FileError{Code: ErrCodeParse, Message: fmt.Sprintf("line %d: not in sha256sum format", line), Path: fn}
+			}
+			path = name[1:]
+			if escaped {
+				path, err = unescapeCoreutils(path)
+				if err != nil {
+					return nil, &FileError{Code: ErrCodeParse, Message: fmt.Sprintf("line %d: %v", line, err), Path: fn}
+				}
+			}
+			entry = precomputedEntry{fullChecksum: strings.ToLower(sum), algo: fcompare.HashSHA256.String()}
+		}
+		for _, p := range pathVariants(path) {
+			abs, err := filepath.Abs(p)
+			if err != nil {
+				return nil, err
+			}
+			// An exact match of the path as written takes precedence over a decoded one
+			if _, exists := sums[abs]; !exists || p == path {
+				sums[abs] = entry
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sums, nil
+}
+
+// unescapeCoreutils undoes the escaping that GNU coreutils applies to file names
+// with a backslash, newline or carriage return in checksum files
+func unescapeCoreutils(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		if i == len(s) {
+			return "", errors.New("file name ends with a backslash")
+		}
+		switch s[i] {
+		case '\\':
+			b.WriteByte('\\')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		default:
+			return "", fmt.Errorf("unknown escape sequence \\%c in file name", s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// pathVariants returns the ways in which a path from a checksum file can be read.
+// Some repository tools write file:// URLs or percent-encode spaces and non-ASCII
+// characters, but a file name can also contain a literal '%', so the path is
+// returned as written as well as decoded.
+func pathVariants(path string) []string {
+	variants := []string{path}
+	if strings.HasPrefix(path, "file://") {
+		if u, err := url.Parse(path); err == nil && u.Path != "" {
+			return append(variants, filepath.FromSlash(u.Path))
+		}
+	}
+	if strings.Contains(path, "%") {
+		if decoded, err := url.PathUnescape(path); err == nil && decoded != path {
+			variants = append(variants, decoded)
+		}
+	}
+	return variants
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// lookup returns the precomputed checksums for a file, if they can be trusted.
+// The size and mtime of fileinfo must already be filled in.
+func (p precomputedSums) lookup(fileinfo FileInfo) (precomputedEntry, bool) {
+	if p == nil {
+		return precomputedEntry{}, false
+	}
+	abs, err := filepath.Abs(fileinfo.Filename)
+	if err != nil {
+		return precomputedEntry{}, false
+	}
+	entry, ok := p[abs]
+	if !ok {
+		return precomputedEntry{}, false
+	}
+	if par.precomputedTrustAlways {
+		return entry, true
+	}
+	if !entry.hasSnapshot || entry.size != fileinfo.Size || entry.mtime != fileinfo.Mtime {
+		return precomputedEntry{}, false
+	}
+	return entry, true
+}
+
+// applyPrecomputed fills in the checksums needed for the compare method from
+// the precomputed checksums, and returns false if they are not available
+func applyPrecomputed(fileinfo *FileInfo) bool {
+	entry, ok := precomputed.lookup(*fileinfo)
+	if !ok || !strings.EqualFold(entry.algo, hashAlgo.String()) {
+		return false
+	}
+	// For small files, the partial checksum is the full checksum
+	smallFile := fileinfo.Size <= partialConfig.FullThreshold && entry.fullChecksum != ""
+	adaptive := strings.HasPrefix(entry.partialChecksum, entry.algo+adaptiveChecksumLabel)
+
+	switch fileinfo.CompareMethod {
+	case "partial":
+		if smallFile {
+			fileinfo.PartialChecksum = entry.fullChecksum
+		} else if entry.partialChecksum != "" && !adaptive && entry.partialConfig == partialConfig {
+			// Records without chunks are from older versions, and not used
+			fileinfo.PartialChecksum = entry.partialChecksum
+		} else {
+			return false
+		}
+	case "partial-adaptive":
+		if smallFile {
+			fileinfo.PartialChecksum = entry.fullChecksum
+		} else if adaptive && strings.HasPrefix(entry.partialChecksum, fcompare.AdaptivePartialChecksumLabel(hashAlgo, partialConfig)) {
+			// The label tells the chunk size of the regions
+			fileinfo.PartialChecksum = entry.partialChecksum
+		} else {
+			return false
+		}
+	case "full":
+		if entry.fullChecksum == "" {
+			return false
+		}
+	default:
+		return false
+	}
+	fileinfo.FullChecksum = entry.fullChecksum
+	fileinfo.ChecksumSource = checksumSourceExternal
+	if hashAlgo != fcompare.HashSHA256 {
+		fileinfo.HashAlgo = hashAlgo.String()
+	}
+	return true
+}
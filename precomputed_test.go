@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/524D/msfile/fcompare"
+)
+
+// File names that break naive checksum file readers and writers
+func hostileNames() []string {
+	names := []string{
+		"with space.mzML",
+		"umlaut-Müller.raw",
+		"literal%41percent.mgf",
+		"*star.mgf",
+		"  leading spaces.mgf",
+		"tab\tname.mgf",
+		"日本語.mzML",
+	}
+	if runtime.GOOS != "windows" {
+		names = append(names, "new\nline.mgf", "back\\slash.mgf", "carriage\rreturn.mgf")
+	}
+	return names
+}
+
+// Ways in which other tools write paths in sha256sum files
+var manifestEncodings = map[string]func(sum, path string) string{
+	"raw": func(sum, path string) string {
+		return sum + "  " + path
+	},
+	// GNU coreutils escapes names with a backslash, newline or carriage return
+	"escape": func(sum, path string) string {
+		if !strings.ContainsAny(path, "\\\n\r") {
+			return sum + "  " + path
+		}
+		r := strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\r", "\\r")
+		return "\\" + sum + "  " + r.Replace(path)
+	},
+	"percent": func(sum, path string) string {
+		return sum + " *" + (&url.URL{Path: filepath.ToSlash(path)}).EscapedPath()
+	},
+	"file-url": func(sum, path string) string {
+		return sum + "  " + (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+	},
+}
+
+// Checksums of files with hostile names are found with every encoding of their path
+func TestPrecomputedHostileNames(t *testing.T) {
+	dir := t.TempDir()
+	want := make(map[string]string)
+	for i, name := range hostileNames() {
+		fn := writeTestFile(t, dir, name, []byte(fmt.Sprint("content ", i)))
+		sum, err := fcompare.GetChecksum(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[fn] = sum
+	}
+	for enc, encode := range manifestEncodings {
+		var lines []string
+		for fn, sum := range want {
+			// A raw newline splits the line, which is why coreutils escapes it
+			if enc == "raw" && strings.ContainsAny(fn, "\n\r") {
+				continue
+			}
+			lines = append(lines, encode(sum, fn))
+		}
+		manifest := writeTestFile(t, t.TempDir(), "SHA256SUMS", []byte(strings.Join(lines, "\n")+"\n"))
+		sums, err := readPrecomputed(manifest)
+		if err != nil {
+			t.Fatalf("%s: %v", enc, err)
+		}
+		for fn, sum := range want {
+			if enc == "raw" && strings.ContainsAny(fn, "\n\r") {
+				continue
+			}
+			if got := sums[fn].fullChecksum; got != sum {
+				t.Errorf("%s: %q: checksum %q, want %q", enc, filepath.Base(fn), got, sum)
+			}
+		}
+	}
+}
+
+// The JSON lines of -tee-manifest are read back by -precomputed with the same name
+func TestTeeManifestRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	manifest := filepath.Join(dir, "manifest.ndjson")
+	for i, name := range hostileNames() {
+		fn := filepath.Join(dir, name)
+		if err := appendTeeManifest(manifest, fn, fmt.Sprintf("%064x", i), int64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sums, err := readPrecomputed(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range hostileNames() {
+		e := sums[filepath.Join(dir, name)]
+		if e.fullChecksum != fmt.Sprintf("%064x", i) || e.size != int64(i) {
+			t.Errorf("%q: read %+v", name, e)
+		}
+	}
+}
+
+func TestUnescapeCoreutils(t *testing.T) {
+	for in, want := range map[string]string{
+		`a\\b`:  `a\b`,
+		`a\nb`:  "a\nb",
+		`a\rb`:  "a\rb",
+		`plain`: "plain",
+	} {
+		if got, err := unescapeCoreutils(in); err != nil || got != want {
+			t.Errorf("unescapeCoreutils(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{`a\`, `a\x`} {
+		if _, err := unescapeCoreutils(in); err == nil {
+			t.Errorf("unescapeCoreutils(%q): no error", in)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+// redact.go - Keep secrets out of what msfile records and prints
+// The command line is recorded in the header of the audit log. Values of flags
+// that are registered as sensitive are recorded as "***", and are also replaced
+// in messages on stderr, e.g. the error of the flag package for an invalid value.
+// Options that are taken from environment variables are recorded by the name of
+// the variable only.
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// The replacement of a sensitive value
+const redacted = "***"
+
+// Flags whose values are never recorded or printed, by name
+var sensitiveFlags = make(map[string]bool)
+
+// registerSensitiveFlag marks a flag whose value is a secret. It must be called
+// before the command line is parsed.
+func registerSensitiveFlag(name string) {
+	sensitiveFlags[name] = true
+}
+
+// The command line as it is recorded, with the values of sensitive flags replaced
+var recordedArgs []string
+
+// The environment variables from which options were taken, in the order in which they were read
+var envOptions []string
+
+// getenvOption returns the value of an environment variable that sets an option,
+// and records that the run used the variable; its value is never recorded
+func getenvOption(name string) (string, bool) {
+	v, ok := os.LookupEnv(name)
+	if ok {
+		envOptions = append(envOptions, name)
+	}
+	return v, ok
+}
+
+// redactArgs returns a copy of the command line args with the values of sensitive
+// flags replaced, and the values that were replaced. Like the flag package, it
+// stops at the first argument that isn't a flag, or after "--".
+func redactArgs(args []string) (out []string, secrets []string) {
+	out = append([]string(nil), args...)
+	for i := 1; i < len(out); i++ {
+		arg := out[i]
+		if arg == "--" || len(arg) < 2 || arg[0] != '-' {
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(arg[1:], "-"), "=")
+		if hasValue {
+			if sensitiveFlags[name] {
+				out[i] = arg[:len(arg)-len(value)] + redacted
+				secrets = append(secrets, value)
+			}
+			continue
+		}
+		// The value of a flag that isn't a bool is the next argument
+		f := flag.CommandLine.Lookup(name)
+		if f == nil || isBoolFlag(f) || i+1 == len(out) {
+			continue
+		}
+		i++
+		if sensitiveFlags[name] {
+			secrets = append(secrets, out[i])
+			out[i] = redacted
+		}
+	}
+	return out, secrets
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// redactWriter replaces secrets in what is written to w
+type redactWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	secrets [][]byte
+}
+
+// newRedactWriter returns a writer to w that replaces the non-empty secrets
+func newRedactWriter(w io.Writer, secrets []string) *redactWriter {
+	rw := &redactWriter{w: w}
+	for _, s := range secrets {
+		if s != "" {
+			rw.secrets = append(rw.secrets, []byte(s))
+		}
+	}
+	return rw
+}
+
+// Write writes p with the secrets replaced. Callers like the log and flag
+// packages write a message at a time, so a secret is never split over writes.
+func (rw *redactWriter) Write(p []byte) (int, error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	b := p
+	for _, s := range rw.secrets {
+		b = bytes.ReplaceAll(b, s, []byte(redacted))
+	}
+	if _, err := rw.w.Write(b); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRedactArgs(t *testing.T) {
+	fs := flag.CommandLine
+	flag.CommandLine = flag.NewFlagSet("msfile", flag.ContinueOnError)
+	savedSensitive := sensitiveFlags
+	t.Cleanup(func() { flag.CommandLine, sensitiveFlags = fs, savedSensitive })
+	sensitiveFlags = make(map[string]bool)
+	flag.String("token", "", "")
+	flag.String("name", "", "")
+	flag.Bool("v", false, "")
+	registerSensitiveFlag("token")
+
+	tests := []struct {
+		args, want, secrets []string
+	}{
+		{[]string{"msfile", "-token", "abc", "f"}, []string{"msfile", "-token", "***", "f"}, []string{"abc"}},
+		{[]string{"msfile", "--token=abc", "f"}, []string{"msfile", "--token=***", "f"}, []string{"abc"}},
+		{[]string{"msfile", "-v", "-name", "-token", "-token=x"}, []string{"msfile", "-v", "-name", "-token", "-token=***"}, []string{"x"}},
+		// After the first argument that isn't a flag, everything is a file name
+		{[]string{"msfile", "f", "-token", "abc"}, []string{"msfile", "f", "-token", "abc"}, nil},
+		{[]string{"msfile", "--", "-token", "abc"}, []string{"msfile", "--", "-token", "abc"}, nil},
+		{[]string{"msfile", "-token"}, []string{"msfile", "-token"}, nil},
+	}
+	for _, tt := range tests {
+		got, secrets := redactArgs(tt.args)
+		if !reflect.DeepEqual(got, tt.want) || !reflect.DeepEqual(secrets, tt.secrets) {
+			t.Errorf("redactArgs(%q) = %q, %q, want %q, %q", tt.args, got, secrets, tt.want, tt.secrets)
+		}
+	}
+}
+
+func TestRedactWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := newRedactWriter(&buf, []string{"", "s3cr3t"})
+	msg := "invalid value \"s3cr3t\" for flag -x: s3cr3t is not valid\n"
+	n, err := w.Write([]byte(msg))
+	if err != nil || n != len(msg) {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	if want := "invalid value \"***\" for flag -x: *** is not valid\n"; buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+// A value of a sensitive flag appears in no output format, in no audit log, and in
+// no error message; an option from the environment is recorded by name only
+func TestSensitiveFlagNotRecorded(t *testing.T) {
+	const secret = "s3cr3t-value-1234"
+	dir := t.TempDir()
+	writeTestFile(t, dir, "a.txt", []byte("hello\n"))
+	env := []string{"MSFILE_TEST_ENV_SECRET=" + secret}
+	for _, format := range outputFormats {
+		for _, args := range [][]string{{"-test-secret", secret}, {"-test-secret=" + secret}} {
+			log := filepath.Join(dir, format+".log")
+			args = append(args, "-fast", "-checksums", "-format", format, "-audit-log", log, "a.txt", "missing.txt")
+			stdout, stderr, code := runMsfile(t, dir, env, args...)
+			if code != 1 || !strings.Contains(stdout, "a.txt") {
+				t.Fatalf("%s: exit code %d, stdout %q, stderr %q", format, code, stdout, stderr)
+			}
+			audit := readTestFile(t, log)
+			checkNoSecret(t, format, secret, stdout, stderr, audit)
+			var hdr auditRecord
+			if err := json.Unmarshal([]byte(strings.SplitN(audit, "\n", 2)[0]), &hdr); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(strings.Join(hdr.Args, " "), "***") {
+				t.Errorf("%s: header args %q", format, hdr.Args)
+			}
+			if !reflect.DeepEqual(hdr.Env, []string{"MSFILE_TEST_ENV_SECRET"}) {
+				t.Errorf("%s: header env %q", format, hdr.Env)
+			}
+		}
+	}
+
+	// The flag package echoes an invalid value
+	_, stderr, code := runMsfile(t, dir, env, "-test-secret-checked", secret, "a.txt")
+	if code != 2 || !strings.Contains(stderr, `invalid value "***"`) {
+		t.Errorf("exit code %d, stderr %q", code, stderr)
+	}
+	checkNoSecret(t, "invalid value", secret, stderr)
+}
@@ -0,0 +1,156 @@
+package main
+
+// registry.go - Look up full checksums in a registry of content that is already held
+// Before ingesting data, this tells if the same content exists elsewhere in the
+// organization. The registry is either an HTTP service, queried with a URL template
+// like https://registry/api/sha256/{hash}, or a local file with lines
+// '<sha256> <location>'. A registry that can't be reached never fails a file;
+// the result is then "lookup-unavailable".
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/524D/msfile/msinfo"
+)
+
+// Values of msinfo.PropRegistryMatch
+const (
+	registryKnown       = "known"
+	registryUnknown     = "unknown"
+	registryUnavailable = "lookup-unavailable"
+)
+
+// Maximum size of the response of a registry that is kept as location/metadata
+const registryMaxResponse = 4096
+
+// checksumRegistry looks up a SHA256 checksum.
+// It returns whether the content is known, with the location or other metadata
+// that the registry returned.
+type checksumRegistry interface {
+	lookup(sum string) (known bool, info string, err error)
+}
+
+// httpRegistry queries an HTTP service. A 200 response means the content is known,
+// 404 that it is unknown. Requests are rate limited.
+type httpRegistry struct {
+	template string
+	client   *http.Client
+	tick     <-chan time.Time
+}
+
+func (r *httpRegistry) lookup(sum string) (bool, string, error) {
+	if r.tick != nil {
+		<-r.tick
+	}
+	resp, err := r.client.Get(strings.ReplaceAll(r.template, "{hash}", sum))
+	if err != nil {
+		return false, "", err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		b, err := io.ReadAll(io.LimitReader(resp.Body, registryMaxResponse))
+		if err != nil {
+			return false, "", err
+		}
+		return true, strings.TrimSpace(string(b)), nil
+	case http.StatusNotFound:
+		return false, "", nil
+	default:
+		return false, "", fmt.Errorf("registry returned %s", resp.Status)
+	}
+}
+
+// fileRegistry is a local lookup file
+type fileRegistry map[string]string
+
+func (r fileRegistry) lookup(sum string) (bool, string, error) {
+	info, known := r[sum]
+	return known, info, nil
+}
+
+func readFileRegistry(fn string) (fileRegistry, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := make(fileRegistry)
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		sum, location, _ := strings.Cut(text, " ")
+		if len(sum) != 64 || !isHex(sum) {
+			return nil, &FileError{Code: ErrCodeParse, Message: fmt.Sprintf("line %d: doesn't start with a SHA256 checksum", line), Path: fn}
+		}
+		r[strings.ToLower(sum)] = strings.TrimLeft(strings.TrimSpace(location), "*")
+	}
+	return r, scanner.Err()
+}
+
+// openRegistry returns the registry for the -registry flag, with at most rate lookups per second
+func openRegistry(s string, rate float64) (checksumRegistry, error) {
+	if strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://") {
+		if !strings.Contains(s, "{hash}") {
+			return nil, usageError("-registry URL must contain {hash}")
+		}
+		r := &httpRegistry{template: s, client: &http.Client{Timeout: 10 * time.Second}}
+		if rate > 0 {
+			r.tick = time.Tick(time.Duration(float64(time.Second) / rate))
+		}
+		return r, nil
+	}
+	return readFileRegistry(s)
+}
+
+// The registry of the current run, nil if none is configured
+var registry checksumRegistry
+
+// Results of earlier lookups, so identical content is looked up only once
+var registryCache = make(map[string]registryResult)
+
+type registryResult struct {
+	match string
+	info  string
+}
+
+// checkRegistry looks up the full checksum of a file, and adds the result to its properties
+func checkRegistry(fileinfo *FileInfo) {
+	if registry == nil || fileinfo.FullChecksum == "" {
+		return
+	}
+	res, ok := registryCache[fileinfo.FullChecksum]
+	if !ok {
+		known, info, err := registry.lookup(fileinfo.FullChecksum)
+		switch {
+		case err != nil:
+			res = registryResult{match: registryUnavailable, info: err.Error()}
+		case known:
+			res = registryResult{match: registryKnown, info: info}
+		default:
+			res = registryResult{match: registryUnknown}
+		}
+		// Unavailable lookups are retried for the next file with the same content
+		if err == nil {
+			registryCache[fileinfo.FullChecksum] = res
+		}
+	}
+	fileinfo.Properties[msinfo.PropRegistryMatch] = res.match
+	if res.info != "" {
+		fileinfo.Properties[msinfo.PropRegistryInfo] = res.info
+	}
+	if res.match == registryKnown && par.requireUnknown {
+		setFileError(fileinfo, ErrCodeAlreadyHeld, "content is already held: "+res.info)
+	}
+}
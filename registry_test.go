@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/524D/msfile/msinfo"
+)
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestHTTPRegistry(t *testing.T) {
+	known, broken := sha256Hex("known"), sha256Hex("broken")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/sha256/" + known:
+			w.Write([]byte("tape 7, slot 3\n"))
+		case "/sha256/" + broken:
+			http.Error(w, "down", http.StatusServiceUnavailable)
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer srv.Close()
+	if _, err := openRegistry(srv.URL+"/sha256/", 0); err == nil {
+		t.Error("no error for a URL without {hash}")
+	}
+	r, err := openRegistry(srv.URL+"/sha256/{hash}", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		sum   string
+		known bool
+		info  string
+		err   bool
+	}{
+		{known, true, "tape 7, slot 3", false},
+		{sha256Hex("unknown"), false, "", false},
+		{broken, false, "", true},
+	} {
+		known, info, err := r.lookup(tc.sum)
+		if known != tc.known || info != tc.info || (err != nil) != tc.err {
+			t.Errorf("lookup(%s) = %v, %q, %v", tc.sum, known, info, err)
+		}
+	}
+}
+
+func TestReadFileRegistry(t *testing.T) {
+	dir := t.TempDir()
+	known := sha256Hex("known")
+	fn := writeTestFile(t, dir, "registry.txt", []byte("# held content\n\n"+strings.ToUpper(known)+" *archive/a.raw\n"))
+	r, err := openRegistry(fn, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, info, err := r.lookup(known); !ok || info != "archive/a.raw" || err != nil {
+		t.Errorf("hit: %v, %q, %v", ok, info, err)
+	}
+	if ok, info, err := r.lookup(sha256Hex("unknown")); ok || info != "" || err != nil {
+		t.Errorf("miss: %v, %q, %v", ok, info, err)
+	}
+
+	fn = writeTestFile(t, dir, "bad.txt", []byte(known+" a\nd41d8cd98f00b204e9800998ecf8427e b\n"))
+	_, err = readFileRegistry(fn)
+	var fe *FileError
+	if !errors.As(err, &fe) || fe.Code != ErrCodeParse || !strings.Contains(fe.Message, "line 2") {
+		t.Errorf("registry with an MD5 checksum: %v", err)
+	}
+}
+
+// countingRegistry knows one checksum, and counts the lookups
+type countingRegistry struct {
+	known   string
+	lookups int
+	err     error
+}
+
+func (r *countingRegistry) lookup(sum string) (bool, string, error) {
+	r.lookups++
+	return sum == r.known, "somewhere", r.err
+}
+
+func TestCheckRegistry(t *testing.T) {
+	known := sha256Hex("known")
+	r := &countingRegistry{known: known}
+	saved, savedCache := registry, registryCache
+	registry, registryCache = r, make(map[string]registryResult)
+	t.Cleanup(func() { registry, registryCache = saved, savedCache })
+	withPar(t, func(p *params) { p.requireUnknown = true })
+
+	for _, tc := range []struct {
+		sum   string
+		match string
+		held  bool
+	}{
+		{known, registryKnown, true},
+		{sha256Hex("unknown"), registryUnknown, false},
+		{known, registryKnown, true},
+		{"", "", false},
+	} {
+		inf := FileInfo{FullChecksum: tc.sum, Properties: make(map[string]string)}
+		checkRegistry(&inf)
+		if inf.Properties[msinfo.PropRegistryMatch] != tc.match || (inf.Error != nil && inf.Error.Code == ErrCodeAlreadyHeld) != tc.held {
+			t.Errorf("%q: match %q, error %v", tc.sum, inf.Properties[msinfo.PropRegistryMatch], inf.Error)
+		}
+	}
+	// The same content is looked up once
+	if r.lookups != 2 {
+		t.Errorf("%d lookups, want 2", r.lookups)
+	}
+
+	// An unavailable registry never fails a file, and is asked again
+	r.err = errors.New("timeout")
+	for range 2 {
+		inf := FileInfo{FullChecksum: sha256Hex("other"), Properties: make(map[string]string)}
+		checkRegistry(&inf)
+		if inf.Properties[msinfo.PropRegistryMatch] != registryUnavailable || inf.Properties[msinfo.PropRegistryInfo] != "timeout" || inf.Error != nil {
+			t.Errorf("unavailable: %v, error %v", inf.Properties, inf.Error)
+		}
+	}
+	if r.lookups != 4 {
+		t.Errorf("%d lookups, want 4", r.lookups)
+	}
+}
+
+// With -require-unknown, content that the registry holds fails the run
+func TestRequireUnknown(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "a.mgf", []byte("known"))
+	writeTestFile(t, dir, "b.mgf", []byte("unknown"))
+	writeTestFile(t, dir, "registry.txt", []byte(sha256Hex("known")+" archive/a.mgf\n"))
+	args := []string{"-checksums", "-comparemethod", "full", "-registry", filepath.Join(dir, "registry.txt"), "-format", "ndjson", "a.mgf", "b.mgf"}
+	_, stderr, code := runMsfile(t, dir, nil, args...)
+	if code != 0 {
+		t.Errorf("without -require-unknown: exit code %d: %s", code, stderr)
+	}
+	stdout, stderr, code := runMsfile(t, dir, nil, append([]string{"-require-unknown"}, args...)...)
+	if code != 1 || !strings.Contains(stderr, "1 file(s) are already held") || !strings.Contains(stdout, ErrCodeAlreadyHeld) {
+		t.Errorf("exit code %d:\n%s%s", code, stdout, stderr)
+	}
+}
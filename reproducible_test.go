@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFixtureTree writes a small tree of MS files in dir/data
+func writeFixtureTree(t *testing.T, dir string) {
+	t.Helper()
+	for _, sub := range []string{"data/run1", "data/run2/raw"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mgf := []byte("BEGIN IONS\nTITLE=1\nEND IONS\n")
+	writeTestFile(t, dir, "data/run1/a.mgf", mgf)
+	writeTestFile(t, dir, "data/run2/a-copy.mgf", mgf)
+	writeTestFile(t, dir, "data/run2/raw/b.bin", []byte("binary"))
+	writeTestFile(t, dir, "data/run2/c.mgf.gz", gzipMembers(string(mgf)))
+	writeTestFile(t, dir, "data/z.fasta", []byte(">p\nMKV\n"))
+}
+
+// Two runs with -reproducible over the same data give byte-identical artifacts
+func TestReproducibleRunTwice(t *testing.T) {
+	dir := t.TempDir()
+	writeFixtureTree(t, dir)
+	for _, format := range []string{"json", "ndjson", "csv", "tsv", "human"} {
+		var outs, logs [2]string
+		for i := range outs {
+			os.Remove(filepath.Join(dir, "audit.log"))
+			stdout, stderr, code := runMsfile(t, dir, nil, "-reproducible", "-r", "-checksums", "-verify-embedded",
+				"-format", format, "-audit-log", "audit.log", "data")
+			if code != 0 {
+				t.Fatalf("%s: exit code %d: %s", format, code, stderr)
+			}
+			outs[i], logs[i] = stdout, readTestFile(t, filepath.Join(dir, "audit.log"))
+		}
+		if outs[0] != outs[1] {
+			t.Errorf("%s: the output of the runs differs:\n%s\n%s", format, outs[0], outs[1])
+		}
+		if logs[0] != logs[1] {
+			t.Errorf("%s: the audit logs of the runs differ:\n%s\n%s", format, logs[0], logs[1])
+		}
+	}
+}
+
+// Without -reproducible, runs differ by their run ID, so the test above can fail
+func TestNotReproducible(t *testing.T) {
+	dir := t.TempDir()
+	writeFixtureTree(t, dir)
+	first, _, _ := runMsfile(t, dir, nil, "-r", "-format", "json", "data")
+	second, _, _ := runMsfile(t, dir, nil, "-r", "-format", "json", "data")
+	if first == second {
+		t.Error("runs without -reproducible gave identical output")
+	}
+}
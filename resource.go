@@ -0,0 +1,79 @@
+package main
+
+// resource.go - Report what a run cost: CPU time, memory, and I/O
+// Useful to size the machine that verifies a full archive.
+// The platform dependent parts are in resource_<os>.go.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"time"
+
+	"github.com/524D/msfile/msfileio"
+)
+
+// ResourceUsage is the resource usage of the current process, see package msfileio
+type ResourceUsage = msfileio.ResourceUsage
+
+// getResourceUsage returns the resource usage since start
+func getResourceUsage(start time.Time) ResourceUsage {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	ru := ResourceUsage{
+		WallSeconds:    time.Since(start).Seconds(),
+		NumGC:          ms.NumGC,
+		GCPauseSeconds: time.Duration(ms.PauseTotalNs).Seconds(),
+	}
+	osResourceUsage(&ru)
+	return ru
+}
+
+// writeResourceUsage writes the resource usage as a line of text, or as a JSON object
+func writeResourceUsage(w io.Writer, ru ResourceUsage, asJSON bool) error {
+	if asJSON {
+		j, err := json.Marshal(struct{ ResourceUsage ResourceUsage }{ru})
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(j))
+		return err
+	}
+	_, err := fmt.Fprintf(w, "Resources: %.1fs wall, %.1fs user CPU, %.1fs system CPU, %d MiB peak RSS, %d GCs (%.3fs pause), %d read syscalls, %d bytes read\n",
+		ru.WallSeconds, ru.UserCPUSeconds, ru.SystemCPUSeconds, ru.PeakRSSBytes>>20, ru.NumGC, ru.GCPauseSeconds, ru.ReadSyscalls, ru.ReadBytes)
+	return err
+}
+
+// startProfile starts writing a CPU profile to dir, and returns a function that
+// stops it and writes a heap profile
+func startProfile(dir string) (func() error, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	cpu, err := os.Create(filepath.Join(dir, "cpu.pprof"))
+	if err != nil {
+		return nil, err
+	}
+	if err := pprof.StartCPUProfile(cpu); err != nil {
+		cpu.Close()
+		return nil, err
+	}
+	return func() error {
+		pprof.StopCPUProfile()
+		if err := cpu.Close(); err != nil {
+			return err
+		}
+		heap, err := os.Create(filepath.Join(dir, "heap.pprof"))
+		if err != nil {
+			return err
+		}
+		defer heap.Close()
+		// Get up-to-date statistics
+		runtime.GC()
+		return pprof.WriteHeapProfile(heap)
+	}, nil
+}
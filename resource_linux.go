@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// osResourceUsage adds CPU time and peak RSS from getrusage,
+// and read syscalls and bytes from /proc/self/io
+func osResourceUsage(ru *ResourceUsage) {
+	var r syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &r); err == nil {
+		ru.UserCPUSeconds = time.Duration(r.Utime.Nano()).Seconds()
+		ru.SystemCPUSeconds = time.Duration(r.Stime.Nano()).Seconds()
+		// Maxrss is in kilobytes on Linux
+		ru.PeakRSSBytes = int64(r.Maxrss) * 1024
+	}
+
+	// /proc/self/io isn't available in all containers
+	f, err := os.Open("/proc/self/io")
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			continue
+		}
+		switch key {
+		case "syscr":
+			ru.ReadSyscalls = n
+		case "rchar":
+			ru.ReadBytes = n
+		}
+	}
+}
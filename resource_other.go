@@ -0,0 +1,8 @@
+//go:build !linux && !windows
+
+package main
+
+// osResourceUsage is not implemented on this platform;
+// only the statistics of the Go runtime are reported
+func osResourceUsage(ru *ResourceUsage) {
+}
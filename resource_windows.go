@@ -0,0 +1,69 @@
+package main
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var (
+	psapi                    = syscall.NewLazyDLL("psapi.dll")
+	procGetProcessMemoryInfo = psapi.NewProc("GetProcessMemoryInfo")
+	kernel32                 = syscall.NewLazyDLL("kernel32.dll")
+	procGetProcessIoCounters = kernel32.NewProc("GetProcessIoCounters")
+)
+
+// PROCESS_MEMORY_COUNTERS
+type processMemoryCounters struct {
+	cb                         uint32
+	PageFaultCount             uint32
+	PeakWorkingSetSize         uintptr
+	WorkingSetSize             uintptr
+	QuotaPeakPagedPoolUsage    uintptr
+	QuotaPagedPoolUsage        uintptr
+	QuotaPeakNonPagedPoolUsage uintptr
+	QuotaNonPagedPoolUsage     uintptr
+	PagefileUsage              uintptr
+	PeakPagefileUsage          uintptr
+}
+
+// IO_COUNTERS
+type ioCounters struct {
+	ReadOperationCount  uint64
+	WriteOperationCount uint64
+	OtherOperationCount uint64
+	ReadTransferCount   uint64
+	WriteTransferCount  uint64
+	OtherTransferCount  uint64
+}
+
+// filetimeDuration converts a FILETIME that holds a duration (in 100 ns units)
+func filetimeDuration(ft syscall.Filetime) time.Duration {
+	return time.Duration(int64(ft.HighDateTime)<<32|int64(ft.LowDateTime)) * 100
+}
+
+// osResourceUsage adds CPU time from GetProcessTimes, the peak working set from
+// GetProcessMemoryInfo, and read operations and bytes from GetProcessIoCounters
+func osResourceUsage(ru *ResourceUsage) {
+	h, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return
+	}
+	var creation, exit, kernel, user syscall.Filetime
+	if err := syscall.GetProcessTimes(h, &creation, &exit, &kernel, &user); err == nil {
+		ru.UserCPUSeconds = filetimeDuration(user).Seconds()
+		ru.SystemCPUSeconds = filetimeDuration(kernel).Seconds()
+	}
+
+	var mc processMemoryCounters
+	mc.cb = uint32(unsafe.Sizeof(mc))
+	if r, _, _ := procGetProcessMemoryInfo.Call(uintptr(h), uintptr(unsafe.Pointer(&mc)), uintptr(mc.cb)); r != 0 {
+		ru.PeakRSSBytes = int64(mc.PeakWorkingSetSize)
+	}
+
+	var io ioCounters
+	if r, _, _ := procGetProcessIoCounters.Call(uintptr(h), uintptr(unsafe.Pointer(&io))); r != 0 {
+		ru.ReadSyscalls = int64(io.ReadOperationCount)
+		ru.ReadBytes = int64(io.ReadTransferCount)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+// roots.go - Scan roots with labels
+// With -root LABEL=PATH (repeatable), all regular files under each PATH are
+// processed, and every FileInfo records the label of its root and its path
+// relative to the root. This keeps the origin of each file clear when several
+// trees, e.g. /instrumentA and /archive, are scanned in a single run. A file
+// under overlapping roots is recorded once under each root it was found under.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/524D/msfile/msinfo"
+)
+
+type scanRoot struct {
+	label string
+	path  string
+	file  bool // a positional file argument, which is its own root
+}
+
+// rootList is the value of the repeatable -root flag
+type rootList struct {
+	roots []scanRoot
+}
+
+func (l *rootList) String() string {
+	if l == nil {
+		return ""
+	}
+	var s []string
+	for _, r := range l.roots {
+		s = append(s, r.label+"="+r.path)
+	}
+	return strings.Join(s, ",")
+}
+
+func (l *rootList) Set(value string) error {
+	label, path, ok := strings.Cut(value, "=")
+	if !ok || label == "" || path == "" {
+		return errors.New("root must have the form 'LABEL=PATH'")
+	}
+	for _, r := range l.roots {
+		if r.label == label {
+			return fmt.Errorf("duplicate root label %q", label)
+		}
+	}
+	l.roots = append(l.roots, scanRoot{label: label, path: filepath.Clean(path)})
+	return nil
+}
+
+// Per-root totals, for the summary at the end of a run
+type rootStats struct {
+	files     int
+	bytes     int64
+	allocated int64
+	errors    int
+	contents  []string // content keys of the files with checksums, see contentKey
+}
+
+var rootTotals = make(map[string]*rootStats)
+
+// rootFile is the root that a file to process was found under
+type rootFile struct {
+	label string
+	rel   string // path relative to the root, with forward slashes
+}
+
+// Roots of the files to process, by path. A file under overlapping roots has one
+// entry for each root, in the order in which the roots were given, and is processed
+// once for each.
+var pendingRoots = make(map[string][]rootFile)
+
+// expandRoots returns the files under all -root directories, after the positional
+// arguments. Positional arguments get the labels arg1, arg2, ...
+func expandRoots(args []string) ([]string, error) {
+	for i, arg := range args {
+		r := scanRoot{label: "arg" + strconv.Itoa(i+1), path: filepath.Clean(arg), file: true}
+		par.roots.roots = append(par.roots.roots, r)
+		pendingRoots[r.path] = append(pendingRoots[r.path], rootFile{r.label, filepath.Base(r.path)})
+	}
+	for _, r := range par.roots.roots {
+		rootTotals[r.label] = &rootStats{}
+		if r.file {
+			continue
+		}
+		err := walkFiles(r.path, false, func(path string) error {
+			rel, err := filepath.Rel(r.path, path)
+			if err != nil {
+				return err
+			}
+			args = append(args, path)
+			path = filepath.Clean(path)
+			pendingRoots[path] = append(pendingRoots[path], rootFile{r.label, filepath.ToSlash(rel)})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return args, nil
+}
+
+// moveRoots makes the roots of a file the roots of another name of it, e.g. the
+// target of a symlink
+func moveRoots(from, to string) {
+	from, to = filepath.Clean(from), filepath.Clean(to)
+	if refs, ok := pendingRoots[from]; ok {
+		pendingRoots[to] = append(pendingRoots[to], refs...)
+		delete(pendingRoots, from)
+	}
+}
+
+// setRoot fills in the root that the file arg was found under, and adds the file
+// to the totals of the root
+func setRoot(arg string, inf *FileInfo) {
+	fn := filepath.Clean(arg)
+	refs := pendingRoots[fn]
+	if len(refs) == 0 {
+		return
+	}
+	r := refs[0]
+	pendingRoots[fn] = refs[1:]
+	inf.Root, inf.RootPath = r.label, r.rel
+	t := rootTotals[r.label]
+	t.files++
+	t.bytes += inf.Size
+	t.allocated += inf.AllocatedSize
+	if inf.Error != nil {
+		t.errors++
+	}
+	if key := contentKey(*inf); key != "" {
+		t.contents = append(t.contents, key)
+	}
+}
+
+// contentKey returns a key that is the same for files with the same content, as
+// -compare with the compare method of the file would find, or "" if the file has
+// no checksum. With -decompressed-checksum, a compressed file has the same key as
+// its original.
+func contentKey(inf FileInfo) string {
+	if inf.Error != nil {
+		return ""
+	}
+	if sum := inf.Properties[msinfo.PropDecompressedChecksum]; sum != "" {
+		return "decompressed " + sum
+	}
+	sum := inf.FullChecksum
+	switch inf.CompareMethod {
+	case "size":
+		sum = "size"
+	case "partial", "partial-adaptive":
+		sum = inf.PartialChecksum
+	}
+	if sum == "" {
+		return ""
+	}
+	return inf.CompareMethod + " " + strconv.FormatInt(inf.Size, 10) + " " + sum
+}
+
+// writeRootSummary writes the number of files, bytes and errors of each root.
+// Both the apparent size and the disk space used are reported. When the checksums
+// of the files are known, the number of duplicates within each root, and of files
+// whose content is also in another root, is written too; the latter tells whether
+// e.g. an archive holds all files of an instrument.
+func writeRootSummary(w io.Writer) {
+	// Number of files with each content, by root
+	inRoot := make(map[string]map[string]int)
+	for _, r := range par.roots.roots {
+		for _, key := range rootTotals[r.label].contents {
+			if inRoot[key] == nil {
+				inRoot[key] = make(map[string]int)
+			}
+			inRoot[key][r.label]++
+		}
+	}
+	for _, r := range par.roots.roots {
+		t := rootTotals[r.label]
+		fmt.Fprintf(w, "Root %s (%s): %d files, %d bytes (%d allocated), %d errors\n", r.label, r.path, t.files, t.bytes, t.allocated, t.errors)
+		if len(t.contents) == 0 {
+			continue
+		}
+		within, across := 0, 0
+		for _, key := range t.contents {
+			if len(inRoot[key]) > 1 {
+				across++
+			}
+		}
+		for _, counts := range inRoot {
+			within += max(counts[r.label]-1, 0)
+		}
+		fmt.Fprintf(w, "Root %s duplicates: %d within the root, %d file(s) also in another root\n", r.label, within, across)
+	}
+}
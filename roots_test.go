@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/524D/msfile/msfileio"
+)
+
+// A file under overlapping roots is recorded once under each root, with its path
+// relative to that root
+func TestOverlappingRoots(t *testing.T) {
+	dir := t.TempDir()
+	for _, d := range []string{"x/sub", "y"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestFile(t, dir, "x/a.mgf", []byte("a"))
+	writeTestFile(t, dir, "x/sub/b.mgf", []byte("b"))
+	writeTestFile(t, dir, "x/sub/a-copy.mgf", []byte("a"))
+	writeTestFile(t, dir, "y/c.mgf", []byte("c"))
+	writeTestFile(t, dir, "y/b-copy.mgf", []byte("b"))
+	stdout, stderr, code := runMsfile(t, dir, nil, "-checksums", "-format", "ndjson", "-root", "A=x", "-root", "B=x/sub", "-root", "C=y")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	var got []string
+	for _, inf := range readLines[msfileio.FileInfo](t, "ndjson", stdout) {
+		got = append(got, inf.Root+":"+inf.RootPath)
+	}
+	sort.Strings(got)
+	want := []string{"A:a.mgf", "A:sub/a-copy.mgf", "A:sub/b.mgf", "B:a-copy.mgf", "B:b.mgf", "C:b-copy.mgf", "C:c.mgf"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("roots %v, want %v", got, want)
+	}
+	for _, line := range []string{
+		"Root A (x): 3 files, 3 bytes",
+		"Root B (x/sub): 2 files, 2 bytes",
+		"Root C (y): 2 files, 2 bytes",
+		// a.mgf and sub/a-copy.mgf are the same; every file of A is also in B or C
+		"Root A duplicates: 1 within the root, 3 file(s) also in another root",
+		"Root B duplicates: 0 within the root, 2 file(s) also in another root",
+		"Root C duplicates: 0 within the root, 1 file(s) also in another root",
+	} {
+		if !strings.Contains(stderr, line) {
+			t.Errorf("no %q in the summary:\n%s", line, stderr)
+		}
+	}
+
+	// Without checksums, duplicates are unknown
+	_, stderr, code = runMsfile(t, dir, nil, "-root", "A=x", "-root", "C=y")
+	if code != 0 || strings.Contains(stderr, "duplicates") || !strings.Contains(stderr, "Root C (y): 2 files") {
+		t.Errorf("exit code %d, summary without checksums:\n%s", code, stderr)
+	}
+}
+
+// A file under overlapping roots is read once; the second record reuses the
+// checksum of the first
+func TestOverlappingRootsReadOnce(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "x", "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, dir, "x/a.mgf", []byte("a"))
+	writeTestFile(t, dir, "x/sub/b.mgf", []byte("b"))
+	stdout, stderr, code := runMsfile(t, dir, nil, "-r", "-checksums", "-no-cache", "-format", "ndjson", "-audit-log", "audit.log", "x", "x/sub")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	sources := make(map[string][]string)
+	for _, inf := range readLines[msfileio.FileInfo](t, "ndjson", stdout) {
+		sources[filepath.ToSlash(inf.Filename)] = append(sources[filepath.ToSlash(inf.Filename)], inf.ChecksumSource)
+	}
+	if got := strings.Join(sources["x/a.mgf"], " "); got != "fresh" {
+		t.Errorf("checksum sources of x/a.mgf: %s", got)
+	}
+	if got := strings.Join(sources["x/sub/b.mgf"], " "); got != "fresh in-run" {
+		t.Errorf("checksum sources of x/sub/b.mgf: %s", got)
+	}
+	if !strings.Contains(stderr, "Checksums: 2 computed from files, 0 from precomputed input, 0 from the cache, 1 reused within the run") {
+		t.Errorf("summary:\n%s", stderr)
+	}
+
+	reads := make(map[string]int)
+	for _, rec := range readLines[msfileio.AuditRecord](t, "audit log", readTestFile(t, filepath.Join(dir, "audit.log"))) {
+		if rec.Type == auditTypeFile && len(rec.Ranges) > 0 {
+			reads[filepath.ToSlash(rec.Filename)]++
+		}
+	}
+	if reads["x/a.mgf"] != 1 || reads["x/sub/b.mgf"] != 1 {
+		t.Errorf("files read %v, want each once", reads)
+	}
+}
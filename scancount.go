@@ -0,0 +1,47 @@
+package main
+
+// scancount.go - Count the spectra of mzML, mzXML and MGF files with -scan-count
+// Counting reads the whole file (except for indexed mzML), so it is not done by default.
+
+import (
+	"errors"
+	"os"
+	"strconv"
+
+	"github.com/524D/msfile/msinfo"
+)
+
+// Formats whose spectra can be counted
+var scanCountFormats = map[string]bool{
+	msinfo.FormatMzML:  true,
+	msinfo.FormatImzML: true,
+	msinfo.FormatMzXML: true,
+	msinfo.FormatMGF:   true,
+}
+
+// hasScanCount reports whether -scan-count counts the spectra of a file
+func hasScanCount(inf FileInfo) bool {
+	return par.scanCount && scanCountFormats[contentFormat(inf)]
+}
+
+// setScanCounts counts the spectra of a file, and sets the properties for the counts.
+// Invalid or truncated content is reported as an error of the file, but doesn't stop the run.
+func setScanCounts(inf *FileInfo) error {
+	format := contentFormat(*inf)
+	c, err := msinfo.CountScansCtx(readCtx, inf.Filename, format)
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		return err
+	}
+	if err != nil {
+		setFileError(inf, ErrCodeParse, "can't count spectra: "+err.Error())
+		return nil
+	}
+	inf.Properties[msinfo.PropSpectrumCount] = strconv.Itoa(c.Spectra)
+	// MGF files don't have MS levels
+	if format != msinfo.FormatMGF {
+		inf.Properties[msinfo.PropMS1Count] = strconv.Itoa(c.ByLevel[1])
+		inf.Properties[msinfo.PropMS2Count] = strconv.Itoa(c.ByLevel[2])
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+// skipped.go - Records for files that were considered, but not processed
+// Without them, a file that is missing from the output could have been skipped,
+// or could never have existed. With -emit-skipped, each skipped file gets a
+// record with the reason, and no checksums.
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/524D/msfile/msfileio"
+	"github.com/524D/msfile/msinfo"
+)
+
+// Reasons for skipping a file
+const (
+	skipCompleted       = "completed-in-checkpoint" // processed by the run that wrote the -resume checkpoint
+	skipDeadline        = "deadline"                // not started before the -max-runtime/-stop-at deadline
+	skipSpecialFile     = "special-file"            // character or block device
+	skipSymlink         = "symlink"                 // symlink with -follow-symlinks no
+	skipDuplicateTarget = "duplicate-target"        // with -follow-symlinks target, an argument with the same target as an earlier one
+	skipPseudoFS        = msinfo.SkipPseudoFS       // directory on a pseudo-filesystem such as /proc, without -allow-pseudo-fs
+	skipMaxDepth        = msinfo.SkipMaxDepth       // directory more than -max-depth levels deep
+	skipPathLength      = msinfo.SkipPathLength     // file or directory with a path longer than -max-path-length
+	skipDirEntries      = msinfo.SkipDirEntries     // directory with more than -max-dir-entries entries
+)
+
+// skippedRecord has Type "skipped", to tell these records from FileInfo records
+type skippedRecord = msfileio.SkippedRecord
+
+// Number of skipped files by reason
+var skipCounts = make(map[string]int)
+
+// skipFile counts a skipped file or directory, and writes its record if -emit-skipped is set
+func skipFile(fn string, reason string) {
+	skipCounts[reason]++
+	junit.skip(fn, reason)
+	if !par.emitSkipped {
+		return
+	}
+	rec := skippedRecord{Type: msfileio.RecordTypeSkipped, Filename: fn, RunID: runID, RecordID: newRecordID(), SkipReason: reason}
+	writeRecord(rec)
+}
+
+// skipSummary returns the number of skipped files by reason, e.g. "deadline: 3, special-file: 1"
+func skipSummary() string {
+	var reasons []string
+	for reason := range skipCounts {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	var s []string
+	for _, reason := range reasons {
+		s = append(s, fmt.Sprintf("%s: %d", reason, skipCounts[reason]))
+	}
+	return strings.Join(s, ", ")
+}
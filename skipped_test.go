@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/524D/msfile/msfileio"
+)
+
+// skippedCounts returns the number of skipped records by reason in the output of a
+// run, and the counts of the "Skipped files:" summary
+func skippedCounts(t *testing.T, stdout, stderr string) (records, summary map[string]int) {
+	t.Helper()
+	records = make(map[string]int)
+	r := msfileio.NewRecords(strings.NewReader(stdout))
+	for {
+		rec, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Skipped != nil {
+			records[rec.Skipped.SkipReason]++
+		}
+	}
+	summary = make(map[string]int)
+	_, line, ok := strings.Cut(stderr, "Skipped files: ")
+	if !ok {
+		return records, summary
+	}
+	line, _, _ = strings.Cut(line, "\n")
+	for _, part := range strings.Split(line, ", ") {
+		reason, n, _ := strings.Cut(part, ": ")
+		count, err := strconv.Atoi(n)
+		if err != nil {
+			t.Fatalf("summary %q: %v", line, err)
+		}
+		summary[reason] = count
+	}
+	return records, summary
+}
+
+// The skip counts of the summary are the numbers of skipped records, for every reason
+func TestSkippedCountsMatchRecords(t *testing.T) {
+	dir := t.TempDir()
+	writeFixtureTree(t, dir)
+	files := []string{"data"}
+	want := map[string]int{}
+	if os.Symlink(filepath.Join("data", "z.fasta"), filepath.Join(dir, "link.fasta")) == nil {
+		files = append(files, "link.fasta")
+		want[skipSymlink] = 1
+	}
+	if fi, err := os.Stat(os.DevNull); err == nil && isDevice(fi) {
+		files = append(files, os.DevNull)
+		want[skipSpecialFile] = 1
+	}
+	if runtime.GOOS == "linux" {
+		files = append(files, "/proc")
+		want[skipPseudoFS] = 1
+	}
+
+	for _, tc := range []struct {
+		name  string
+		extra []string
+		want  map[string]int
+	}{
+		{"symlinks", []string{"-follow-symlinks", "no"}, want},
+		{"duplicate targets", []string{"-follow-symlinks", "target"}, map[string]int{skipDuplicateTarget: 1}},
+		{"deadline", []string{"-max-runtime", "1ns", "-checkpoint", "cp.json"}, nil},
+		{"resume", []string{"-resume", "cp.json"}, nil},
+	} {
+		args := append([]string{"-r", "-emit-skipped", "-format", "ndjson"}, tc.extra...)
+		stdout, stderr, code := runMsfile(t, dir, nil, append(args, files...)...)
+		if code != 0 && code != exitIncomplete {
+			t.Fatalf("%s: exit code %d: %s", tc.name, code, stderr)
+		}
+		records, summary := skippedCounts(t, stdout, stderr)
+		if len(records) == 0 {
+			t.Errorf("%s: no skipped records", tc.name)
+		}
+		if !maps.Equal(records, summary) {
+			t.Errorf("%s: %v skipped records, but the summary counts %v", tc.name, records, summary)
+		}
+		for reason, n := range tc.want {
+			if records[reason] != n {
+				t.Errorf("%s: %d records for %s, want %d", tc.name, records[reason], reason, n)
+			}
+		}
+	}
+}
+
+// Directories and files beyond the limits of the walk are skipped, reported and counted
+func TestWalkLimits(t *testing.T) {
+	dir := t.TempDir()
+	for _, d := range []string{"top/a/b/c", "top/wide", "top/" + strings.Repeat("n", 40)} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, fn := range []string{"top/x.mgf", "top/a/x.mgf", "top/a/b/x.mgf", "top/a/b/c/x.mgf", "top/wide/1.mgf", "top/wide/2.mgf", "top/wide/3.mgf", "top/wide/4.mgf", "top/wide/5.mgf", "top/" + strings.Repeat("n", 40) + "/x.mgf"} {
+		writeTestFile(t, dir, fn, []byte(fn))
+	}
+	for _, tc := range []struct {
+		name   string
+		args   []string
+		files  int
+		reason string
+		n      int
+	}{
+		{"depth", []string{"-max-depth", "2"}, 9, skipMaxDepth, 1},
+		{"path length", []string{"-max-path-length", "20"}, 9, skipPathLength, 1},
+		{"entries", []string{"-max-dir-entries", "4"}, 5, skipDirEntries, 1},
+		{"no limit", []string{"-max-depth", "0"}, 10, "", 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			args := append([]string{"-r", "-emit-skipped", "-format", "ndjson"}, tc.args...)
+			stdout, stderr, code := runMsfile(t, dir, nil, append(args, "top")...)
+			if code != 0 {
+				t.Fatalf("exit code %d: %s", code, stderr)
+			}
+			records, summary := skippedCounts(t, stdout, stderr)
+			if tc.n == 0 && len(records) > 0 || tc.n > 0 && (records[tc.reason] != tc.n || len(records) != 1) {
+				t.Errorf("skipped records %v, want %d %s", records, tc.n, tc.reason)
+			}
+			if !maps.Equal(records, summary) {
+				t.Errorf("summary %v, records %v", summary, records)
+			}
+			if got := strings.Count(stdout, `"filename"`) - len(records); got != tc.files {
+				t.Errorf("%d files, want %d:\n%s", got, tc.files, stdout)
+			}
+			if tc.n > 0 && !strings.Contains(stderr, "exceeds -"+tc.reason) {
+				t.Errorf("no warning:\n%s", stderr)
+			}
+		})
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+// split.go - Verify files that were split into numbered parts
+// Large datasets are sometimes transferred as file.raw.part001 ... file.raw.partNNN,
+// or file.raw.001 ... file.raw.NNN, often with a sidecar file that contains the
+// checksum of the joined file. msfile treats such a set of parts as a single logical
+// file: it checks that no parts are missing and that their sizes are consistent,
+// and hashes the parts in order, so that the checksum of the joined file can be
+// verified without joining the parts on disk.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/524D/msfile/fcompare"
+	"github.com/524D/msfile/msinfo"
+	"github.com/djherbis/atime"
+)
+
+// Naming conventions for split files; the first group is the name of the joined
+// file, the second the part number
+var splitPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`^(.+)\.part(\d+)$`),
+	splitNumberedPattern,
+}
+
+// Names like file.001 are also used for other things than parts, e.g. rotated
+// logs or numbered runs, so such files are only parts of a split file if it has a
+// sidecar, or with -split-numbered
+var splitNumberedPattern = regexp.MustCompile(`^(.+)\.(\d{3})$`)
+
+// Extensions of sidecar files with the checksum of the joined file
+var splitSidecarExtensions = []string{".sha256", ".sha256sum"}
+
+type splitPart struct {
+	path  string
+	index int
+}
+
+// splitSet is a set of parts that make up one logical file
+type splitSet struct {
+	base    string
+	parts   []string
+	missing []int // part numbers missing from the sequence
+}
+
+// findSplitSets finds the arguments that are parts of split files,
+// and replaces them with the name of the joined file.
+// All parts of each split file are found in its directory, even if only one was given.
+// A set with only one part is not a split file, and is left alone.
+func findSplitSets(fns []string) ([]string, map[string]*splitSet, error) {
+	sets := make(map[string]*splitSet)
+	var result []string
+	for _, fn := range fns {
+		base, pattern := splitBase(fn)
+		if pattern == splitNumberedPattern && !par.splitNumbered && !hasSplitSidecar(base) {
+			pattern = nil
+		}
+		if pattern == nil {
+			result = append(result, fn)
+			continue
+		}
+		if _, ok := sets[base]; ok {
+			continue
+		}
+		set, err := findSplitParts(base, pattern)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(set.parts) < 2 {
+			result = append(result, fn)
+			continue
+		}
+		sets[base] = set
+		result = append(result, base)
+	}
+	return result, sets, nil
+}
+
+// splitBase returns the name of the joined file, and the pattern that matched,
+// or nil if fn is not named like a part of a split file
+func splitBase(fn string) (string, *regexp.Regexp) {
+	for _, p := range splitPatterns {
+		if m := p.FindStringSubmatch(fn); m != nil {
+			return m[1], p
+		}
+	}
+	return "", nil
+}
+
+// findSplitParts finds all parts of a split file in its directory
+func findSplitParts(base string, pattern *regexp.Regexp) (*splitSet, error) {
+	dir := filepath.Dir(base)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var parts []splitPart
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		m := pattern.FindStringSubmatch(path)
+		if m == nil || filepath.Clean(m[1]) != filepath.Clean(base) {
+			continue
+		}
+		index, err := strconv.Atoi(m[2])
+		if err != nil {
+			continue
+		}
+		parts = append(parts, splitPart{path, index})
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].index < parts[j].index })
+
+	set := &splitSet{base: base}
+	// Numbering starts at 0 or 1, depending on the tool that split the file
+	next := 1
+	if len(parts) > 0 && parts[0].index == 0 {
+		next = 0
+	}
+	for _, p := range parts {
+		for ; next < p.index; next++ {
+			set.missing = append(set.missing, next)
+		}
+		set.parts = append(set.parts, p.path)
+		next = p.index + 1
+	}
+	return set, nil
+}
+
+// hasSplitSidecar reports whether a split file has a sidecar file
+func hasSplitSidecar(base string) bool {
+	for _, ext := range splitSidecarExtensions {
+		if _, err := os.Stat(base + ext); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// readSplitSidecar returns the checksum from the sidecar file of a split file,
+// or "" if there is none
+func readSplitSidecar(base string) (string, error) {
+	for _, ext := range splitSidecarExtensions {
+		b, err := os.ReadFile(base + ext)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		// The sidecar can be in sha256sum format, or contain only the digest
+		fields := strings.Fields(string(b))
+		if len(fields) == 0 || len(fields[0]) != 64 || !isHex(fields[0]) {
+			return "", &FileError{Code: ErrCodeParse, Message: "doesn't contain a SHA256 checksum", Path: base + ext}
+		}
+		return strings.ToLower(fields[0]), nil
+	}
+	return "", nil
+}
+
+// processSplit verifies a split file, and returns it as a single logical file.
+// It returns false if a part is missing, the part sizes are inconsistent, or the
+// checksum doesn't match the sidecar.
+func processSplit(set *splitSet) (FileInfo, bool, error) {
+	fileinfo := FileInfo{Filename: set.base, RunID: runID, RecordID: newRecordID(), Properties: make(map[string]string)}
+	fileinfo.Properties[msinfo.PropSplitParts] = strconv.Itoa(len(set.parts))
+
+	valid := true
+	var problems []string
+	code := ErrCodeSplitIncomplete
+	if len(set.missing) > 0 {
+		valid = false
+		problems = append(problems, fmt.Sprint("missing parts ", set.missing))
+	}
+
+	// All parts except the last must have the same size, and the last can't be larger
+	var partSize int64
+	for i, part := range set.parts {
+		fi, err := os.Stat(part)
+		if err != nil {
+			return fileinfo, false, err
+		}
+		if i == 0 {
+			partSize = fi.Size()
+		} else if fi.Size() > partSize || (i < len(set.parts)-1 && fi.Size() != partSize) {
+			valid = false
+			problems = append(problems, fmt.Sprintf("%s has size %d, expected %d", part, fi.Size(), partSize))
+		}
+		fileinfo.Size += fi.Size()
+		if n, ok := allocatedSize(part, fi); ok {
+			fileinfo.AllocatedSize += n
+		} else {
+			fileinfo.AllocatedSize += fi.Size()
+		}
+		if fi.ModTime().UnixNano() > fileinfo.MtimeNs {
+			fileinfo.Mtime = fi.ModTime().Unix()
+			fileinfo.MtimeNs = fi.ModTime().UnixNano()
+		}
+	}
+
+	if valid {
+		sum, err := hashParts(set.parts)
+		if err != nil {
+			return fileinfo, false, err
+		}
+		fileinfo.FullChecksum = sum
+		fileinfo.ChecksumSource = checksumSourceFresh
+		expected, err := readSplitSidecar(set.base)
+		if err != nil {
+			return fileinfo, false, err
+		}
+		if expected != "" {
+			fileinfo.Properties[msinfo.PropReassembledChecksumValid] = strconv.FormatBool(expected == sum)
+			if expected != sum {
+				valid = false
+				code = ErrCodeChecksumMismatch
+				problems = append(problems, "checksum of joined parts doesn't match "+expected)
+			}
+		}
+	}
+	if len(problems) > 0 {
+		fileinfo.Properties[msinfo.PropSplitError] = strings.Join(problems, "; ")
+		setFileError(&fileinfo, code, fileinfo.Properties[msinfo.PropSplitError])
+	}
+	return fileinfo, valid, nil
+}
+
+// hashParts computes the SHA256 checksum of the concatenation of the parts,
+// restoring the file times of each part
+func hashParts(parts []string) (string, error) {
+	h := sha256.New()
+	for _, part := range parts {
+		if err := hashPart(h, part); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashPart(w io.Writer, part string) (err error) {
+	fi, err := os.Stat(part)
+	if err != nil {
+		return err
+	}
+	// Restore file times before we return, and report if that didn't work.
+	// The audit record comes last, so it includes the restore.
+	defer func() {
+		if !par.fast {
+			if rerr := restoreTimes(part, atime.Get(fi), fi.ModTime()); rerr != nil && err == nil {
+				err = rerr
+			}
+		}
+		audit.read(part, fi.Size(), err)
+	}()
+	f, err := fcompare.OpenObserved(readCtx, part)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
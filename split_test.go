@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/524D/msfile/msinfo"
+)
+
+// writeParts writes the parts of a split file, and its sidecar, in dir
+func writeParts(t *testing.T, dir string, parts map[string]string) {
+	t.Helper()
+	for name, data := range parts {
+		writeTestFile(t, dir, name, []byte(data))
+	}
+}
+
+func TestFindSplitSets(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		files    []string
+		numbered bool   // -split-numbered
+		want     string // the joined file, or "" if the parts are files of their own
+	}{
+		{"part", []string{"f.raw.part001", "f.raw.part002"}, false, "f.raw"},
+		{"part from 0", []string{"f.raw.part0", "f.raw.part1"}, false, "f.raw"},
+		{"numbered", []string{"f.raw.001", "f.raw.002"}, false, ""},
+		{"numbered with sidecar", []string{"f.raw.001", "f.raw.002", "f.raw.sha256"}, false, "f.raw"},
+		{"numbered with -split-numbered", []string{"f.raw.001", "f.raw.002"}, true, "f.raw"},
+		{"single part", []string{"f.raw.part001"}, false, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			withPar(t, func(p *params) { p.splitNumbered = tc.numbered })
+			dir := t.TempDir()
+			var args []string
+			for _, fn := range tc.files {
+				args = append(args, writeTestFile(t, dir, fn, []byte("x")))
+			}
+			args = args[:min(len(args), 2)]
+			got, sets, err := findSplitSets(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.want == "" {
+				if len(sets) != 0 || !slices.Equal(got, args) {
+					t.Errorf("got %v, sets %v, want the files themselves", got, sets)
+				}
+				return
+			}
+			want := filepath.Join(dir, tc.want)
+			if !slices.Equal(got, []string{want}) || sets[want] == nil || len(sets[want].parts) != 2 {
+				t.Errorf("got %v, sets %v, want %s", got, sets, want)
+			}
+		})
+	}
+}
+
+func TestProcessSplit(t *testing.T) {
+	joined := sha256.Sum256([]byte("0123456789abcdefghij0123"))
+	for _, tc := range []struct {
+		name    string
+		parts   map[string]string
+		valid   bool
+		code    string // of the FileInfo error, if any
+		matches string // PropReassembledChecksumValid
+	}{
+		{"complete", map[string]string{"f.part1": "0123456789", "f.part2": "abcdefghij", "f.part3": "0123"}, true, "", ""},
+		{"missing middle part", map[string]string{"f.part1": "0123456789", "f.part3": "0123"}, false, ErrCodeSplitIncomplete, ""},
+		{"inconsistent sizes", map[string]string{"f.part1": "0123456789", "f.part2": "abcde", "f.part3": "0123"}, false, ErrCodeSplitIncomplete, ""},
+		{"last part too large", map[string]string{"f.part1": "0123456789", "f.part2": "abcdefghij0123"}, false, ErrCodeSplitIncomplete, ""},
+		{"sidecar match", map[string]string{"f.part1": "0123456789", "f.part2": "abcdefghij", "f.part3": "0123",
+			"f.sha256": hex.EncodeToString(joined[:]) + "  f\n"}, true, "", "true"},
+		{"sidecar mismatch", map[string]string{"f.part1": "0123456789", "f.part2": "abcdefghij", "f.part3": "0124",
+			"f.sha256sum": hex.EncodeToString(joined[:]) + "\n"}, false, ErrCodeChecksumMismatch, "false"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeParts(t, dir, tc.parts)
+			_, sets, err := findSplitSets([]string{filepath.Join(dir, "f.part1")})
+			if err != nil {
+				t.Fatal(err)
+			}
+			set := sets[filepath.Join(dir, "f")]
+			if set == nil {
+				t.Fatalf("no split file in %v", sets)
+			}
+			inf, valid, err := processSplit(set)
+			if err != nil {
+				t.Fatal(err)
+			}
+			code := ""
+			if inf.Error != nil {
+				code = inf.Error.Code
+			}
+			if valid != tc.valid || code != tc.code || inf.Properties[msinfo.PropReassembledChecksumValid] != tc.matches {
+				t.Errorf("valid %v, error %v, reassembled_checksum_valid %q, want %v, %q, %q",
+					valid, inf.Error, inf.Properties[msinfo.PropReassembledChecksumValid], tc.valid, tc.code, tc.matches)
+			}
+			if tc.valid && tc.matches == "true" && inf.FullChecksum != hex.EncodeToString(joined[:]) {
+				t.Errorf("checksum %s, want that of the joined parts", inf.FullChecksum)
+			}
+			if want := "3"; tc.name == "complete" && inf.Properties[msinfo.PropSplitParts] != want {
+				t.Errorf("split_parts %q, want %s", inf.Properties[msinfo.PropSplitParts], want)
+			}
+		})
+	}
+}
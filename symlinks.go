@@ -0,0 +1,65 @@
+package main
+
+// symlinks.go - Handling of arguments that are symlinks, set with -follow-symlinks
+// Walks with -r and -root never return symlinks, so this only applies to files
+// that are named on the command line. Reading a file through a symlink restores
+// the times of the target, which is the file that was read.
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Values of -follow-symlinks
+const (
+	symlinksFollow = "yes"    // read the target through the symlink
+	symlinksSkip   = "no"     // skip symlinks
+	symlinksTarget = "target" // replace symlinks by their target, and skip arguments with the same target
+)
+
+var symlinkPolicies = []string{symlinksFollow, symlinksSkip, symlinksTarget}
+
+// applySymlinkPolicy returns the arguments after applying -follow-symlinks.
+// Broken symlinks are kept, so they fail like any file that can't be read.
+func applySymlinkPolicy(args []string, policy string) []string {
+	if policy == symlinksFollow {
+		return args
+	}
+	var result []string
+	seen := make(map[string]string) // argument by target
+	for _, arg := range args {
+		fi, err := os.Lstat(arg)
+		isLink := err == nil && fi.Mode()&os.ModeSymlink != 0
+		if isLink && policy == symlinksSkip {
+			fmt.Fprintln(os.Stderr, "Skipping symlink", arg)
+			if !par.compare {
+				skipFile(arg, skipSymlink)
+			}
+			continue
+		}
+		if policy == symlinksSkip {
+			result = append(result, arg)
+			continue
+		}
+		target, err := filepath.EvalSymlinks(arg)
+		if err != nil {
+			result = append(result, arg)
+			continue
+		}
+		if first, ok := seen[target]; ok {
+			fmt.Fprintln(os.Stderr, arg, "refers to the same file as", first+", skipped")
+			if !par.compare {
+				skipFile(arg, skipDuplicateTarget)
+			}
+			continue
+		}
+		seen[target] = arg
+		if isLink {
+			moveRoots(arg, target)
+			arg = target
+		}
+		result = append(result, arg)
+	}
+	return result
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/524D/msfile/msfileio"
+)
+
+func TestFollowSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "a.mgf", []byte("BEGIN IONS\nEND IONS\n"))
+	for _, l := range [][2]string{{"a.mgf", "link.mgf"}, {"missing.mgf", "broken.mgf"}, {"loop2", "loop1"}, {"loop1", "loop2"}} {
+		if err := os.Symlink(l[0], filepath.Join(dir, l[1])); err != nil {
+			t.Skip("can't create symlinks:", err)
+		}
+	}
+	for _, tc := range []struct {
+		policy  string
+		args    []string
+		files   []string
+		skipped map[string]string
+		fail    bool
+	}{
+		{"yes", []string{"a.mgf", "link.mgf"}, []string{"a.mgf", "link.mgf"}, nil, false},
+		{"no", []string{"a.mgf", "link.mgf", "broken.mgf", "loop1"}, []string{"a.mgf"},
+			map[string]string{"link.mgf": skipSymlink, "broken.mgf": skipSymlink, "loop1": skipSymlink}, false},
+		{"target", []string{"a.mgf", "link.mgf"}, []string{"a.mgf"}, map[string]string{"link.mgf": skipDuplicateTarget}, false},
+		{"target", []string{"link.mgf"}, []string{"a.mgf"}, nil, false},
+		// Broken symlinks and loops fail like files that can't be read
+		{"yes", []string{"broken.mgf"}, nil, nil, true},
+		{"target", []string{"loop1"}, nil, nil, true},
+	} {
+		args := append([]string{"-follow-symlinks", tc.policy, "-emit-skipped", "-format", "ndjson"}, tc.args...)
+		stdout, stderr, code := runMsfile(t, dir, nil, args...)
+		if (code != 0) != tc.fail {
+			t.Errorf("-follow-symlinks %s %v: exit code %d: %s", tc.policy, tc.args, code, stderr)
+			continue
+		}
+		var files []string
+		skipped := make(map[string]string)
+		r := msfileio.NewRecords(strings.NewReader(stdout))
+		for {
+			rec, err := r.Next()
+			if err != nil {
+				break
+			}
+			if rec.File != nil {
+				files = append(files, rec.File.Filename)
+			} else {
+				skipped[rec.Skipped.Filename] = rec.Skipped.SkipReason
+			}
+		}
+		if strings.Join(files, ",") != strings.Join(tc.files, ",") || len(skipped) != len(tc.skipped) {
+			t.Errorf("-follow-symlinks %s %v: files %v, skipped %v, want %v, %v", tc.policy, tc.args, files, skipped, tc.files, tc.skipped)
+		}
+		for fn, reason := range tc.skipped {
+			if skipped[fn] != reason {
+				t.Errorf("-follow-symlinks %s: %s skipped for %q, want %q", tc.policy, fn, skipped[fn], reason)
+			}
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+// teeverify.go - Verify data while it streams through a pipe
+// With -tee-verify sha256:<digest>, msfile copies stdin to stdout unchanged,
+// hashes the data on the way, and exits with status 1 after the stream has
+// ended if the digest doesn't match. This allows verification as a pipeline
+// element without storing the data twice, e.g.
+//   zcat a.mzML.gz | msfile -tee-verify sha256:... | msconvert ...
+// Writes to stdout block when the next process doesn't keep up, so the
+// pipeline keeps its backpressure.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+)
+
+// Size of the copy buffer; large enough that the hash doesn't add per-call overhead
+const teeBufferSize = 1 << 20
+
+// parseTeeExpected parses the -tee-verify argument, which must have the form sha256:<hex digest>
+func parseTeeExpected(s string) (string, error) {
+	algorithm, digest, ok := strings.Cut(s, ":")
+	if !ok || algorithm != "sha256" {
+		return "", usageError("-tee-verify must have the form sha256:<digest>")
+	}
+	digest = strings.ToLower(digest)
+	if len(digest) != 64 || !isHex(digest) {
+		return "", usageError("-tee-verify digest must be 64 hex digits")
+	}
+	return digest, nil
+}
+
+// teeVerify copies r to w, and returns the SHA256 checksum and size of the data
+func teeVerify(r io.Reader, w io.Writer) (string, int64, error) {
+	h := sha256.New()
+	// io.MultiWriter fails on short writes, so no data can get lost
+	n, err := io.CopyBuffer(io.MultiWriter(w, h), r, make([]byte, teeBufferSize))
+	if err != nil {
+		return "", n, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// appendTeeManifest appends the observed checksum and size to a manifest,
+// as a JSON line that can be read back with -precomputed
+func appendTeeManifest(fn string, name string, sum string, size int64) error {
+	j, err := json.Marshal(FileInfo{Filename: name, RunID: runID, RecordID: newRecordID(), Size: size, FullChecksum: sum, ChecksumSource: checksumSourceFresh})
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(fn, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	// A single write, so that lines of concurrent pipelines don't interleave
+	if _, err := f.Write(append(j, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
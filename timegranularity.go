@@ -0,0 +1,100 @@
+package main
+
+// timegranularity.go - Detect the resolution of file times on a filesystem
+// Filesystems store file times with different resolutions: 1 ns on ext4 and
+// tmpfs, 100 ns on NTFS, 2 s for the mtime and a day for the atime on FAT.
+// When file times are compared, differences below the resolution of the
+// filesystem are not real differences.
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/djherbis/atime"
+)
+
+// Resolutions that filesystems use, from fine to coarse
+var timeGranularities = []time.Duration{
+	time.Nanosecond,
+	100 * time.Nanosecond,
+	time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	time.Second,
+	2 * time.Second,
+	24 * time.Hour,
+}
+
+// Detected resolution of file times per directory
+var dirTimeGranularity = make(map[string]time.Duration)
+
+// detectTimeGranularity sets file times with a fractional odd second on a temporary file in dir,
+// and returns the smallest resolution that explains the times that are read back
+func detectTimeGranularity(dir string) (time.Duration, error) {
+	f, err := os.CreateTemp(dir, "msfile-granularity")
+	if err != nil {
+		return 0, err
+	}
+	tfn := f.Name()
+	f.Close()
+	defer os.Remove(tfn)
+
+	t := time.Date(2001, 2, 3, 4, 5, 7, 123456789, time.UTC)
+	if err := os.Chtimes(tfn, t, t); err != nil {
+		return 0, err
+	}
+	fi, err := os.Stat(tfn)
+	if err != nil {
+		return 0, err
+	}
+	diff := max(absDuration(fi.ModTime().Sub(t)), absDuration(atime.Get(fi).Sub(t)))
+	for _, g := range timeGranularities {
+		if diff < g {
+			return g, nil
+		}
+	}
+	return timeGranularities[len(timeGranularities)-1], nil
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// timeTolerance returns the largest difference between file times of fn that is not a real difference
+func timeTolerance(fn string) time.Duration {
+	if par.timeTolerance > 0 {
+		return par.timeTolerance
+	}
+	// Subdirectories are assumed to be on the filesystem of the nearest directory that was probed
+	for dir := filepath.Dir(fn); ; dir = filepath.Dir(dir) {
+		if g, ok := dirTimeGranularity[dir]; ok {
+			if g > time.Nanosecond {
+				return g
+			}
+			return 0
+		}
+		if dir == filepath.Dir(dir) {
+			return 0
+		}
+	}
+}
+
+// sameFileTime reports whether two file times of fn are equal, within the resolution of the filesystem
+func sameFileTime(fn string, t1, t2 time.Time) bool {
+	return absDuration(t1.Sub(t2)) <= timeTolerance(fn)
+}
+
+// probeTimeGranularity detects the resolution of file times in dir, once per directory.
+// If that fails, times in dir are compared exactly.
+func probeTimeGranularity(dir string) {
+	if _, ok := dirTimeGranularity[dir]; ok {
+		return
+	}
+	g, err := detectTimeGranularity(dir)
+	audit.probe(dir, err)
+	dirTimeGranularity[dir] = g
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func withGranularity(t *testing.T, dir string, g time.Duration) {
+	t.Helper()
+	saved, ok := dirTimeGranularity[dir]
+	t.Cleanup(func() {
+		if ok {
+			dirTimeGranularity[dir] = saved
+		} else {
+			delete(dirTimeGranularity, dir)
+		}
+	})
+	dirTimeGranularity[dir] = g
+}
+
+func TestDetectTimeGranularity(t *testing.T) {
+	dir := t.TempDir()
+	g, err := detectTimeGranularity(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The temporary directories of all test platforms store times to at least 10 ms
+	if g > 10*time.Millisecond {
+		t.Errorf("granularity %v in %s", g, dir)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("the probe left %d files", len(entries))
+	}
+}
+
+func TestTimeTolerance(t *testing.T) {
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "a", "b")
+	withGranularity(t, dir, 2*time.Second)
+	fn := filepath.Join(sub, "f")
+	if got := timeTolerance(fn); got != 2*time.Second {
+		t.Errorf("tolerance in a subdirectory of a probed directory: %v, want 2s", got)
+	}
+	withGranularity(t, sub, time.Nanosecond)
+	if got := timeTolerance(fn); got != 0 {
+		t.Errorf("tolerance on a filesystem with ns resolution: %v, want 0", got)
+	}
+	withPar(t, func(p *params) { p.timeTolerance = 5 * time.Second })
+	if got := timeTolerance(fn); got != 5*time.Second {
+		t.Errorf("tolerance with -time-tolerance 5s: %v, want 5s", got)
+	}
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	if !sameFileTime(fn, base, base.Add(-5*time.Second)) || sameFileTime(fn, base, base.Add(6*time.Second)) {
+		t.Error("sameFileTime doesn't use the -time-tolerance")
+	}
+}
+
+// fatFS reads back modification times with the 2 second resolution of FAT
+type fatFS struct{ osReadBack }
+
+type fatInfo struct{ os.FileInfo }
+
+func (fi fatInfo) ModTime() time.Time { return fi.FileInfo.ModTime().Truncate(2 * time.Second) }
+
+func (fatFS) Stat(fn string) (os.FileInfo, error) {
+	fi, err := os.Stat(fn)
+	if err != nil {
+		return nil, err
+	}
+	return fatInfo{fi}, nil
+}
+
+// Restoring the times of a file copied from a filesystem with ns resolution to FAT
+// isn't reported as a write verification failure, once the resolution is known
+func TestRestoreTimesCoarseFilesystem(t *testing.T) {
+	dir := t.TempDir()
+	fn := writeTestFile(t, dir, "f", []byte("x"))
+	mTime := time.Date(2020, 1, 2, 3, 4, 5, 123456789, time.UTC)
+	if err := os.Chtimes(fn, mTime, mTime); err != nil {
+		t.Fatal(err)
+	}
+	withReadBack(t, fatFS{})
+	aTime := mTime.Add(time.Hour)
+
+	withGranularity(t, dir, time.Nanosecond)
+	checkWriteVerifyError(t, "restoreTimes with ns resolution", restoreTimes(fn, aTime, mTime), fn)
+
+	withGranularity(t, dir, 2*time.Second)
+	if err := restoreTimes(fn, aTime.Add(time.Second), mTime); err != nil {
+		t.Errorf("restoreTimes with 2 s resolution: %v", err)
+	}
+}
+
+// Copies to a real FAT filesystem, e.g. a loopback image mounted by CI,
+// are verified without differences. Set MSFILE_TEST_FAT_DIR to run it.
+func TestVerifyCopyFAT(t *testing.T) {
+	fatDir := os.Getenv("MSFILE_TEST_FAT_DIR")
+	if fatDir == "" {
+		t.Skip("MSFILE_TEST_FAT_DIR is not set")
+	}
+	src := t.TempDir()
+	writeTestFile(t, src, "a.mgf", []byte("BEGIN IONS\nEND IONS\n"))
+	dst, err := os.MkdirTemp(fatDir, "msfile-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+	// Copy with the source times, which FAT stores with a coarser resolution
+	fi, err := os.Stat(filepath.Join(src, "a.mgf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := writeTestFile(t, dst, "a.mgf", []byte(readTestFile(t, filepath.Join(src, "a.mgf"))))
+	if err := os.Chtimes(fn, fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, code := runMsfile(t, src, nil, "-verify-copy", "a.mgf", filepath.Join(dst, "a.mgf"))
+	if code != 0 || strings.Contains(stderr, ErrCodeWriteVerify) {
+		t.Errorf("exit code %d: %s%s", code, stdout, stderr)
+	}
+}
+
+// JSON records have the file times in ns next to the Unix seconds
+func TestFileTimesNs(t *testing.T) {
+	dir := t.TempDir()
+	fn := writeTestFile(t, dir, "f", []byte("x"))
+	mTime := time.Date(2020, 1, 2, 3, 4, 5, 123456789, time.UTC)
+	if err := os.Chtimes(fn, mTime, mTime); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, code := runMsfile(t, dir, nil, "-format", "ndjson", "f")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	var inf FileInfo
+	if err := json.Unmarshal([]byte(stdout), &inf); err != nil {
+		t.Fatal(err)
+	}
+	if inf.MtimeNs != mTime.UnixNano() || inf.Mtime != mTime.Unix() || inf.AtimeNs != mTime.UnixNano() {
+		t.Errorf("times %d, %d ns, atime %d ns, want %d, %d ns", inf.Mtime, inf.MtimeNs, inf.AtimeNs, mTime.Unix(), mTime.UnixNano())
+	}
+}
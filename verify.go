@@ -0,0 +1,172 @@
+package main
+
+// verify.go - Check files against the checksums of an earlier run, to detect bit rot
+// With -verify, the JSON records of an earlier msfile -json run are read from the
+// -from file or stdin, and the full checksum of each file is computed again, with
+// the hash algorithm of the record. Like sha256sum -c, but the file times are
+// restored, and the size is checked before the file is read. Records without a
+// full checksum (e.g. from a run without -compare, or with the partial method
+// for a large file) can't be verified, and are only counted. Files or directories
+// given as arguments are the scope of the earlier run: files under them without a
+// record are reported as new, but don't fail the verification, since an archive
+// can grow.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/524D/msfile/fcompare"
+	"github.com/524D/msfile/msfileio"
+)
+
+// Outcomes of -verify per file
+const (
+	verifyOK         = "ok"
+	verifyFailed     = "failed"
+	verifyMissing    = "missing"
+	verifyNoChecksum = "no-checksum"
+	verifyError      = "error"
+	verifyNew        = "new"
+)
+
+type verifyEntry struct {
+	fn       string
+	status   string
+	problem  string
+	algo     string
+	expected string
+	actual   string
+	size     int64 // expected size
+	gotSize  int64
+	took     time.Duration
+}
+
+// readVerifyRecords reads the file records of msfile -json output from the -from file, or stdin
+func readVerifyRecords() ([]FileInfo, error) {
+	var r io.Reader = os.Stdin
+	if par.from != "" && par.from != "-" {
+		f, err := os.Open(par.from)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	var records []FileInfo
+	recs := msfileio.NewRecords(r)
+	for {
+		rec, err := recs.Next()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, &FileError{Code: ErrCodeParse, Message: err.Error(), Path: par.from}
+		}
+		if rec.File != nil {
+			records = append(records, *rec.File)
+		}
+	}
+}
+
+// verifyFiles computes the full checksum of the file of each record again
+func verifyFiles(records []FileInfo) []verifyEntry {
+	var entries []verifyEntry
+	for _, inf := range records {
+		e := verifyEntry{fn: inf.Filename, size: inf.Size, expected: inf.FullChecksum, algo: inf.HashAlgo}
+		if e.algo == "" {
+			e.algo = fcompare.HashSHA256.String()
+		}
+		entries = append(entries, e)
+		ep := &entries[len(entries)-1]
+		if inf.FullChecksum == "" {
+			ep.status = verifyNoChecksum
+			continue
+		}
+		algo, err := fcompare.ParseHashAlgo(ep.algo)
+		if err != nil {
+			ep.status, ep.problem = verifyError, err.Error()
+			continue
+		}
+		fi, err := os.Stat(inf.Filename)
+		if errors.Is(err, os.ErrNotExist) {
+			ep.status = verifyMissing
+			continue
+		}
+		if err != nil {
+			ep.status, ep.problem = verifyError, err.Error()
+			continue
+		}
+		ep.gotSize = fi.Size()
+		if fi.Size() != inf.Size {
+			ep.status, ep.problem = verifyFailed, "size changed"
+			continue
+		}
+		start := time.Now()
+		ep.actual, err = hashKeepTimes(inf.Filename, algo)
+		ep.took = time.Since(start)
+		audit.read(inf.Filename, fi.Size(), err)
+		switch {
+		case err != nil:
+			ep.status, ep.problem = verifyError, err.Error()
+		case ep.actual != inf.FullChecksum:
+			ep.status, ep.problem = verifyFailed, "checksum doesn't match"
+		default:
+			ep.status = verifyOK
+		}
+	}
+	return entries
+}
+
+// newFiles returns an entry for each file under scope that has no record
+func newFiles(records []FileInfo, scope []string) ([]verifyEntry, error) {
+	known := make(map[string]bool, len(records))
+	for _, inf := range records {
+		known[filepath.Clean(inf.Filename)] = true
+	}
+	files, err := expandDirs(scope)
+	if err != nil {
+		return nil, err
+	}
+	var entries []verifyEntry
+	for _, fn := range files {
+		if !known[filepath.Clean(fn)] {
+			entries = append(entries, verifyEntry{fn: fn, status: verifyNew})
+		}
+	}
+	return entries, nil
+}
+
+// writeVerifyReport writes the files that failed verification, with the expected
+// and actual size and checksum, and a summary. It returns false if a file failed,
+// is missing or can't be read, or if no file could be verified.
+func writeVerifyReport(w io.Writer, entries []verifyEntry) bool {
+	counts := make(map[string]int)
+	for _, e := range entries {
+		counts[e.status]++
+		switch e.status {
+		case verifyOK, verifyNoChecksum:
+			continue
+		case verifyFailed:
+			fmt.Fprintf(w, "FAILED %s: %s\n", e.fn, e.problem)
+			fmt.Fprintf(w, "  expected: %s:%s (%d bytes)\n", e.algo, e.expected, e.size)
+			if e.actual != "" {
+				fmt.Fprintf(w, "  actual:   %s:%s (%d bytes)\n", e.algo, e.actual, e.gotSize)
+			} else {
+				fmt.Fprintf(w, "  actual:   %d bytes\n", e.gotSize)
+			}
+		case verifyMissing:
+			fmt.Fprintf(w, "MISSING %s\n", e.fn)
+		case verifyNew:
+			fmt.Fprintf(w, "NEW %s\n", e.fn)
+		default:
+			fmt.Fprintf(w, "ERROR %s: %s\n", e.fn, e.problem)
+		}
+	}
+	fmt.Fprintf(w, "Verified: %d ok, %d failed, %d missing, %d errors, %d without full checksum, %d new\n",
+		counts[verifyOK], counts[verifyFailed], counts[verifyMissing], counts[verifyError], counts[verifyNoChecksum], counts[verifyNew])
+	return counts[verifyOK] > 0 && counts[verifyOK]+counts[verifyNoChecksum]+counts[verifyNew] == len(entries)
+}
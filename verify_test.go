@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A stored run is verified against the files as they are now: a file that is
+// unchanged is ok, a changed one fails, a removed one is missing, and a file that
+// was added under the arguments is new
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "data"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"ok.mzML", "rot.mzML", "gone.mzML", "grown.mzML"} {
+		writeTestFile(t, dir, filepath.Join("data", name), []byte("<mzML>"+name+"</mzML>\n"))
+	}
+	stdout, stderr, code := runMsfile(t, dir, nil, "-r", "-checksums", "-comparemethod", "full", "-format", "ndjson", "data")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	writeTestFile(t, dir, "run.json", []byte(stdout))
+
+	// Unchanged, the run verifies
+	stdout, stderr, code = runMsfile(t, dir, nil, "-verify", "-from", "run.json", "data")
+	if code != 0 || !strings.Contains(stdout, "Verified: 4 ok, 0 failed, 0 missing, 0 errors, 0 without full checksum, 0 new") {
+		t.Fatalf("exit code %d, report:\n%s%s", code, stdout, stderr)
+	}
+
+	// The same size, but another byte
+	writeTestFile(t, dir, filepath.Join("data", "rot.mzML"), []byte("<mzML>rot.mzMl</mzML>\n"))
+	writeTestFile(t, dir, filepath.Join("data", "grown.mzML"), []byte("<mzML>grown.mzML</mzML>\n\n"))
+	writeTestFile(t, dir, filepath.Join("data", "new.mzML"), []byte("<mzML/>\n"))
+	if err := os.Remove(filepath.Join(dir, "data", "gone.mzML")); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, code = runMsfile(t, dir, nil, "-verify", "-from", "run.json", "data")
+	if code != 1 {
+		t.Errorf("exit code %d, want 1: %s", code, stderr)
+	}
+	for _, line := range []string{
+		"FAILED " + filepath.Join("data", "rot.mzML") + ": checksum doesn't match",
+		"FAILED " + filepath.Join("data", "grown.mzML") + ": size changed",
+		"  actual:   25 bytes",
+		"MISSING " + filepath.Join("data", "gone.mzML"),
+		"NEW " + filepath.Join("data", "new.mzML"),
+		"Verified: 1 ok, 2 failed, 1 missing, 0 errors, 0 without full checksum, 1 new",
+	} {
+		if !strings.Contains(stdout, line) {
+			t.Errorf("no %q in the report:\n%s", line, stdout)
+		}
+	}
+	if !strings.Contains(stdout, "  expected: sha256:") || !strings.Contains(stdout, "  actual:   sha256:") {
+		t.Errorf("no expected and actual checksum in the report:\n%s", stdout)
+	}
+
+	// The records can also come from stdin; without arguments, no file is new
+	f, err := os.Open(filepath.Join(dir, "run.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	stdin := os.Stdin
+	os.Stdin = f
+	records, err := readVerifyRecords()
+	os.Stdin = stdin
+	if err != nil || len(records) != 4 {
+		t.Fatalf("%d records from stdin, %v", len(records), err)
+	}
+	added, err := newFiles(records, nil)
+	if err != nil || len(added) != 0 {
+		t.Errorf("new files without a scope: %v, %v", added, err)
+	}
+}
+
+// Only new files don't fail a verification, but records without a full checksum
+// can't make it succeed
+func TestWriteVerifyReport(t *testing.T) {
+	for _, tc := range []struct {
+		statuses []string
+		ok       bool
+	}{
+		{[]string{verifyOK, verifyNew, verifyNoChecksum}, true},
+		{[]string{verifyNoChecksum, verifyNew}, false},
+		{[]string{verifyOK, verifyMissing}, false},
+		{[]string{verifyOK, verifyError}, false},
+		{nil, false},
+	} {
+		var entries []verifyEntry
+		for _, s := range tc.statuses {
+			entries = append(entries, verifyEntry{fn: s, status: s})
+		}
+		var b strings.Builder
+		if ok := writeVerifyReport(&b, entries); ok != tc.ok {
+			t.Errorf("%v: %v, want %v:\n%s", tc.statuses, ok, tc.ok, b.String())
+		}
+	}
+}
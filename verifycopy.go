@@ -0,0 +1,240 @@
+package main
+
+// verifycopy.go - Prove that a copy of a file or directory tree matches its source
+// Every regular file under the source must exist at the same relative path under
+// the destination, with the same size and full checksum. Files that only exist
+// in the destination are reported too. Partial checksums are never used, since
+// a copy that is only probably correct isn't good enough for a transfer record.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/524D/msfile/fcompare"
+	"github.com/djherbis/atime"
+)
+
+// Number of files that are hashed at the same time
+const verifyCopyWorkers = 4
+
+// Outcomes of -verify-copy per file
+const (
+	copyOK               = "ok"
+	copyMissing          = "missing"
+	copyExtra            = "extra"
+	copySizeMismatch     = "size-mismatch"
+	copyChecksumMismatch = "checksum-mismatch"
+	copyError            = "error"
+)
+
+type copyEntry struct {
+	rel     string
+	status  string
+	size    int64
+	sum     string
+	problem string
+	took    time.Duration
+}
+
+// copyFiles returns the relative paths of all regular files under root.
+// If root is a file, its base name is returned.
+func copyFiles(root string) (map[string]bool, error) {
+	files := make(map[string]bool)
+	fi, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		files[filepath.Base(root)] = true
+		return files, nil
+	}
+	err = walkFiles(root, false, func(path string) error {
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files[rel] = true
+		return nil
+	})
+	return files, err
+}
+
+// copyPath returns the path of a file from copyFiles
+func copyPath(root string, rel string) string {
+	if fi, err := os.Stat(root); err == nil && !fi.IsDir() {
+		return root
+	}
+	return filepath.Join(root, rel)
+}
+
+// hashKeepTimes returns the checksum of a file, and restores its file times
+func hashKeepTimes(fn string, algo fcompare.HashAlgo) (sum string, err error) {
+	fi, err := os.Stat(fn)
+	if err != nil {
+		return "", err
+	}
+	if !par.fast {
+		defer func() {
+			if rerr := restoreTimes(fn, atime.Get(fi), fi.ModTime()); rerr != nil && err == nil {
+				err = rerr
+			}
+		}()
+	}
+	return fcompare.GetChecksumHashCtx(readCtx, fn, algo)
+}
+
+// verifyCopy compares the files under src with those under dst
+func verifyCopy(src, dst string) ([]copyEntry, error) {
+	srcFiles, err := copyFiles(src)
+	if err != nil {
+		return nil, err
+	}
+	dstFiles, err := copyFiles(dst)
+	if err != nil {
+		return nil, err
+	}
+	// A single source file is compared to a destination file with any name,
+	// or to the file with the same name in a destination directory
+	if fi, err := os.Stat(src); err == nil && !fi.IsDir() {
+		rel := filepath.Base(src)
+		if fi, err := os.Stat(dst); err == nil && !fi.IsDir() {
+			dstFiles = srcFiles
+		} else {
+			dstFiles = map[string]bool{rel: dstFiles[rel]}
+		}
+	}
+
+	var entries []copyEntry
+	for rel := range srcFiles {
+		entries = append(entries, copyEntry{rel: rel})
+	}
+	for rel := range dstFiles {
+		if !srcFiles[rel] {
+			entries = append(entries, copyEntry{rel: rel, status: copyExtra})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].rel < entries[j].rel })
+
+	// Only files that exist on both sides with equal size are hashed
+	var jobs []int
+	for i := range entries {
+		e := &entries[i]
+		if e.status != "" {
+			continue
+		}
+		if !dstFiles[e.rel] {
+			e.status = copyMissing
+			continue
+		}
+		srcInfo, err1 := os.Stat(copyPath(src, e.rel))
+		dstInfo, err2 := os.Stat(copyPath(dst, e.rel))
+		if err1 != nil || err2 != nil {
+			e.status, e.problem = copyError, fmt.Sprint(firstError(err1, err2))
+			continue
+		}
+		e.size = srcInfo.Size()
+		if srcInfo.Size() != dstInfo.Size() {
+			e.status = copySizeMismatch
+			e.problem = fmt.Sprintf("size %d, copy has %d", srcInfo.Size(), dstInfo.Size())
+			continue
+		}
+		jobs = append(jobs, i)
+	}
+
+	// Each worker only writes the entries it took from the queue
+	queue := make(chan int)
+	done := make(chan int)
+	for w := 0; w < verifyCopyWorkers; w++ {
+		go func() {
+			for i := range queue {
+				e := &entries[i]
+				start := time.Now()
+				srcSum, err1 := hashKeepTimes(copyPath(src, e.rel), fcompare.HashSHA256)
+				dstSum, err2 := hashKeepTimes(copyPath(dst, e.rel), fcompare.HashSHA256)
+				e.took = time.Since(start)
+				switch {
+				case err1 != nil || err2 != nil:
+					e.status, e.problem = copyError, fmt.Sprint(firstError(err1, err2))
+				case srcSum != dstSum:
+					e.status, e.problem = copyChecksumMismatch, "checksum "+srcSum+", copy has "+dstSum
+				default:
+					e.status, e.sum = copyOK, srcSum
+				}
+				done <- i
+			}
+		}()
+	}
+	go func() {
+		for _, i := range jobs {
+			queue <- i
+		}
+		close(queue)
+	}()
+	for range jobs {
+		e := entries[<-done]
+		// The audit log is written from this goroutine only
+		if e.status != copyError {
+			audit.read(copyPath(src, e.rel), e.size, nil)
+			audit.read(copyPath(dst, e.rel), e.size, nil)
+		}
+	}
+	return entries, nil
+}
+
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeCopyReport writes the problems found by verifyCopy, and a summary that
+// can be pasted into a transfer record. It returns false if the copy doesn't match.
+// The digest in the summary covers the relative path and checksum of all files,
+// so a later check can tell if it still describes the same data.
+func writeCopyReport(w io.Writer, src, dst string, entries []copyEntry) bool {
+	counts := make(map[string]int)
+	var bytes int64
+	h := sha256.New()
+	for _, e := range entries {
+		counts[e.status]++
+		if e.status == copyOK {
+			bytes += e.size
+			fmt.Fprintf(h, "%s  %s\n", e.sum, filepath.ToSlash(e.rel))
+			continue
+		}
+		line := strings.ToUpper(e.status) + " " + e.rel
+		if e.problem != "" {
+			line += ": " + e.problem
+		}
+		fmt.Fprintln(w, line)
+	}
+	ok := len(entries) > 0 && counts[copyOK] == len(entries)
+
+	fmt.Fprintln(w, "--- msfile copy verification ---")
+	fmt.Fprintln(w, "Source:      ", src)
+	fmt.Fprintln(w, "Destination: ", dst)
+	if !par.reproducible {
+		host, _ := os.Hostname()
+		fmt.Fprintln(w, "Verified at: ", time.Now().Format(time.RFC3339), "on", host)
+	}
+	fmt.Fprintln(w, "Method:       full SHA256 of source and destination")
+	fmt.Fprintf(w, "Files:        %d identical (%d bytes), %d missing, %d extra, %d size mismatch, %d checksum mismatch, %d errors\n",
+		counts[copyOK], bytes, counts[copyMissing], counts[copyExtra], counts[copySizeMismatch], counts[copyChecksumMismatch], counts[copyError])
+	fmt.Fprintln(w, "File list:   ", "sha256:"+hex.EncodeToString(h.Sum(nil)))
+	if ok {
+		fmt.Fprintln(w, "Result:       COPY VERIFIED")
+	} else {
+		fmt.Fprintln(w, "Result:       COPY DOES NOT MATCH")
+	}
+	return ok
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeCopyTree writes the same small tree under src and dst
+func writeCopyTree(t *testing.T, dir string) {
+	t.Helper()
+	for _, root := range []string{"src", "dst"} {
+		if err := os.MkdirAll(filepath.Join(dir, root, "sub"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, dir, filepath.Join(root, "a.mzML"), []byte("<mzML/>\n"))
+		writeTestFile(t, dir, filepath.Join(root, "sub", "b.mgf"), []byte("BEGIN IONS\nEND IONS\n"))
+	}
+}
+
+func TestVerifyCopy(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		change func(t *testing.T, dir string)
+		code   int
+		lines  []string
+	}{
+		{"matching copy", func(t *testing.T, dir string) {}, 0, []string{
+			"Files:        2 identical (28 bytes), 0 missing, 0 extra, 0 size mismatch, 0 checksum mismatch, 0 errors",
+			"Result:       COPY VERIFIED",
+		}},
+		{"corrupted copy", func(t *testing.T, dir string) {
+			writeTestFile(t, dir, "dst/a.mzML", []byte("<mzML?>\n"))
+		}, 1, []string{
+			"CHECKSUM-MISMATCH a.mzML: checksum ",
+			"Files:        1 identical (20 bytes), 0 missing, 0 extra, 0 size mismatch, 1 checksum mismatch, 0 errors",
+			"Result:       COPY DOES NOT MATCH",
+		}},
+		{"truncated copy", func(t *testing.T, dir string) {
+			writeTestFile(t, dir, "dst/a.mzML", []byte("<mzML/>"))
+		}, 1, []string{
+			"SIZE-MISMATCH a.mzML: size 8, copy has 7",
+			"Result:       COPY DOES NOT MATCH",
+		}},
+		{"missing and extra files", func(t *testing.T, dir string) {
+			if err := os.Rename(filepath.Join(dir, "dst", "sub", "b.mgf"), filepath.Join(dir, "dst", "b.mgf")); err != nil {
+				t.Fatal(err)
+			}
+		}, 1, []string{
+			"EXTRA b.mgf",
+			"MISSING " + filepath.Join("sub", "b.mgf"),
+			"Files:        1 identical (8 bytes), 1 missing, 1 extra, 0 size mismatch, 0 checksum mismatch, 0 errors",
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeCopyTree(t, dir)
+			tc.change(t, dir)
+			stdout, stderr, code := runMsfile(t, dir, nil, "-verify-copy", "-reproducible", "src", "dst")
+			if code != tc.code {
+				t.Errorf("exit code %d, want %d: %s", code, tc.code, stderr)
+			}
+			for _, line := range tc.lines {
+				if !strings.Contains(stdout, line) {
+					t.Errorf("no %q in the report:\n%s", line, stdout)
+				}
+			}
+		})
+	}
+}
+
+// A destination that doesn't exist is an error, not a report
+func TestVerifyCopyMissingDestination(t *testing.T) {
+	dir := t.TempDir()
+	writeCopyTree(t, dir)
+	stdout, stderr, code := runMsfile(t, dir, nil, "-verify-copy", "src", "nowhere")
+	if code == 0 || strings.Contains(stdout, "COPY VERIFIED") || !strings.Contains(stderr, "nowhere") {
+		t.Errorf("exit code %d, stdout %q, stderr %q", code, stdout, stderr)
+	}
+
+	// A single file is compared to the file with the same name in a directory
+	stdout, _, code = runMsfile(t, dir, nil, "-verify-copy", "-reproducible", filepath.Join("src", "a.mzML"), filepath.Join("dst", "sub"))
+	if code != 1 || !strings.Contains(stdout, "MISSING a.mzML") {
+		t.Errorf("exit code %d, report:\n%s", code, stdout)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+// walk.go - Walk directory trees for -r and -root
+// The walk itself, with its guard rails against unintended scopes, is msinfo.WalkFiles.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/524D/msfile/msinfo"
+)
+
+// Number of directories and files skipped because they exceed -max-depth,
+// -max-path-length or -max-dir-entries
+var walkLimitSkips int
+
+// walkConfig returns the settings of msinfo.WalkFiles from the command line
+func walkConfig(keepGoing bool) msinfo.WalkConfig {
+	return msinfo.WalkConfig{
+		AllowPseudoFS: par.allowPseudoFS,
+		YesReally:     par.yesReally,
+		KeepGoing:     keepGoing,
+		MaxDepth:      par.maxDepth,
+		MaxPathLength: par.maxPathLength,
+		MaxDirEntries: par.maxDirEntries,
+		SkipDir: func(path string, err error) {
+			fe := codedError("", err)
+			errorCounts[fe.Code]++
+			fmt.Fprintln(os.Stderr, "Skipping directory:", fe)
+		},
+		// Skipped directories are counted and recorded like skipped files.
+		// Those that exceed a limit of the walk are also reported right away.
+		Skip: func(path string, reason string) {
+			if reason != skipPseudoFS {
+				walkLimitSkips++
+				fmt.Fprintln(os.Stderr, "Skipping", path+":", "exceeds", "-"+reason)
+			}
+			if !par.compare {
+				skipFile(path, reason)
+			}
+		},
+	}
+}
+
+// walkFiles calls fn for each regular file under root.
+// With keepGoing, directories below root that can't be read are reported and skipped,
+// instead of stopping the walk.
+func walkFiles(root string, keepGoing bool, fn func(path string) error) error {
+	err := msinfo.WalkFiles(root, walkConfig(keepGoing), fn)
+	var broad *msinfo.BroadRootError
+	if errors.As(err, &broad) {
+		return usageError("%v; use -yes-really if you really want to process all of them", err)
+	}
+	return err
+}
+
+// expandDirs replaces the directories in args by the regular files under them
+func expandDirs(args []string) ([]string, error) {
+	var files []string
+	for _, arg := range args {
+		fi, err := os.Stat(arg)
+		if err != nil || !fi.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		err = walkFiles(arg, true, func(path string) error {
+			files = append(files, path)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// isDevice reports whether a file is a character or block device
+func isDevice(fi os.FileInfo) bool {
+	return fi.Mode()&(os.ModeDevice|os.ModeCharDevice) != 0
+}
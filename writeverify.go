@@ -0,0 +1,94 @@
+package main
+
+// writeverify.go - Read back what msfile writes, and check that it arrived
+// On some network storage, metadata reads back differently than it was written,
+// because of caching bugs. After each write, the result is read back through a
+// fresh stat or file handle and compared with what was intended. A mismatch is
+// retried once, and then reported with the path of the artifact.
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/djherbis/atime"
+)
+
+// Number of times a write is retried when its read-back doesn't match
+const writeVerifyRetries = 1
+
+// readBackFS reads back what msfile wrote. Tests replace it with one that
+// corrupts what is read, like flaky storage.
+type readBackFS interface {
+	Stat(fn string) (os.FileInfo, error)
+	ReadFile(fn string) ([]byte, error)
+}
+
+type osReadBack struct{}
+
+func (osReadBack) Stat(fn string) (os.FileInfo, error) { return os.Stat(fn) }
+func (osReadBack) ReadFile(fn string) ([]byte, error)  { return os.ReadFile(fn) }
+
+var readBack readBackFS = osReadBack{}
+
+// restoreTimes sets the access and modification time of a file after it was read,
+// and checks that a new stat returns those times.
+// A file that was modified while it was read, e.g. by acquisition software that
+// still had it open, keeps its new times, with a warning: restoring them would
+// hide the modification. Times that didn't change, e.g. on filesystems mounted
+// with noatime, are not set again.
+func restoreTimes(fn string, aTime, mTime time.Time) error {
+	fi, err := os.Stat(fn)
+	if err != nil {
+		return err
+	}
+	if !fi.ModTime().Equal(mTime) {
+		fmt.Fprintln(os.Stderr, "Warning:", fn, "was modified while it was read; its file times are not restored")
+		return nil
+	}
+	if atime.Get(fi).Equal(aTime) {
+		return nil
+	}
+	var problem string
+	for try := 0; try <= writeVerifyRetries; try++ {
+		audit.setTimes(fn)
+		if err := os.Chtimes(fn, aTime, mTime); err != nil {
+			return err
+		}
+		fi, err := readBack.Stat(fn)
+		if err != nil {
+			return err
+		}
+		gotA, gotM := atime.Get(fi), fi.ModTime()
+		if sameFileTime(fn, gotA, aTime) && sameFileTime(fn, gotM, mTime) {
+			return nil
+		}
+		problem = fmt.Sprintf("file times read back as atime %v, mtime %v, expected %v, %v", gotA, gotM, aTime, mTime)
+	}
+	return &FileError{Code: ErrCodeWriteVerify, Message: problem, Path: fn}
+}
+
+// writeFileVerified writes data to a file, and checks that reading the file gives the same data.
+// The data is written to a temporary file first, so that an existing file is never left half-written.
+func writeFileVerified(fn string, data []byte) error {
+	var problem string
+	for try := 0; try <= writeVerifyRetries; try++ {
+		tmp := fn + ".tmp"
+		if err := os.WriteFile(tmp, data, 0644); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, fn); err != nil {
+			return err
+		}
+		got, err := readBack.ReadFile(fn)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(got, data) {
+			return nil
+		}
+		problem = fmt.Sprintf("read back %d bytes that differ from the %d bytes written", len(got), len(data))
+	}
+	return &FileError{Code: ErrCodeWriteVerify, Message: problem, Path: fn}
+}
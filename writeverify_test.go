@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// flakyFS corrupts the first bad read-backs, like storage with a caching bug
+type flakyFS struct {
+	bad   int // number of read-backs that are still corrupted
+	reads int
+}
+
+// shiftedInfo is file information with another modification time
+type shiftedInfo struct {
+	os.FileInfo
+}
+
+func (fi shiftedInfo) ModTime() time.Time { return fi.FileInfo.ModTime().Add(time.Hour) }
+
+func (fs *flakyFS) Stat(fn string) (os.FileInfo, error) {
+	fs.reads++
+	fi, err := os.Stat(fn)
+	if err != nil || fs.reads > fs.bad {
+		return fi, err
+	}
+	return shiftedInfo{fi}, nil
+}
+
+func (fs *flakyFS) ReadFile(fn string) ([]byte, error) {
+	fs.reads++
+	data, err := os.ReadFile(fn)
+	if err != nil || fs.reads > fs.bad || len(data) == 0 {
+		return data, err
+	}
+	data[0] ^= 0xff
+	return data, nil
+}
+
+func withReadBack(t *testing.T, fs readBackFS) {
+	saved := readBack
+	t.Cleanup(func() { readBack = saved })
+	readBack = fs
+}
+
+func checkWriteVerifyError(t *testing.T, what string, err error, fn string) {
+	t.Helper()
+	var fe *FileError
+	if !errors.As(err, &fe) || fe.Code != ErrCodeWriteVerify || fe.Path != fn {
+		t.Errorf("%s: error %v, want %s for %s", what, err, ErrCodeWriteVerify, fn)
+	}
+}
+
+func TestWriteFileVerifiedFaults(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "checkpoint")
+	data := []byte("checkpoint data")
+	for _, tc := range []struct {
+		bad   int
+		reads int
+		fail  bool
+	}{
+		{0, 1, false},
+		{1, 2, false}, // the retry reads back the right data
+		{2, 2, true},
+	} {
+		fs := &flakyFS{bad: tc.bad}
+		withReadBack(t, fs)
+		err := writeFileVerified(fn, data)
+		if tc.fail {
+			checkWriteVerifyError(t, "writeFileVerified", err, fn)
+		} else if err != nil {
+			t.Errorf("%d bad read-backs: %v", tc.bad, err)
+		}
+		if fs.reads != tc.reads {
+			t.Errorf("%d bad read-backs: read back %d times, want %d", tc.bad, fs.reads, tc.reads)
+		}
+		// The file itself is always written right
+		if got := readTestFile(t, fn); got != string(data) {
+			t.Errorf("%d bad read-backs: file has %q", tc.bad, got)
+		}
+	}
+}
+
+func TestRestoreTimesFaults(t *testing.T) {
+	fn := writeTestFile(t, t.TempDir(), "f", []byte("x"))
+	for _, tc := range []struct {
+		bad   int
+		reads int
+		fail  bool
+	}{
+		{0, 1, false},
+		{1, 2, false},
+		{2, 2, true},
+	} {
+		fi, err := os.Stat(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Another access time each time, so that the times are set again
+		aTime := time.Date(2020, 1, 2, 3, 4, 5+tc.bad, 0, time.UTC)
+		fs := &flakyFS{bad: tc.bad}
+		withReadBack(t, fs)
+		err = restoreTimes(fn, aTime, fi.ModTime())
+		if tc.fail {
+			checkWriteVerifyError(t, "restoreTimes", err, fn)
+		} else if err != nil {
+			t.Errorf("%d bad read-backs: %v", tc.bad, err)
+		}
+		if fs.reads != tc.reads {
+			t.Errorf("%d bad read-backs: read back %d times, want %d", tc.bad, fs.reads, tc.reads)
+		}
+	}
+}
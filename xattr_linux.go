@@ -0,0 +1,28 @@
+package main
+
+import (
+	"syscall"
+)
+
+// Largest checksum cache entry that is read
+const maxXattrSize = 4096
+
+// getXattr returns the value of an extended attribute of a file.
+// ok is false if the file doesn't have the attribute, or the filesystem doesn't
+// support extended attributes.
+func getXattr(fn, name string) (value []byte, ok bool, err error) {
+	buf := make([]byte, maxXattrSize)
+	n, err := syscall.Getxattr(fn, name, buf)
+	switch err {
+	case nil:
+		return buf[:n], true, nil
+	case syscall.ENODATA, syscall.ENOTSUP, syscall.ERANGE:
+		return nil, false, nil
+	}
+	return nil, false, err
+}
+
+// setXattr sets an extended attribute of a file. It doesn't change the file times.
+func setXattr(fn, name string, value []byte) error {
+	return syscall.Setxattr(fn, name, value, 0)
+}
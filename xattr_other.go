@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// getXattr is not implemented on this platform, so nothing is cached
+func getXattr(fn, name string) (value []byte, ok bool, err error) {
+	return nil, false, nil
+}
+
+func setXattr(fn, name string, value []byte) error {
+	return errors.ErrUnsupported
+}